@@ -1,6 +1,8 @@
 package main
 
 import (
+    "context"
+    "errors"
     "flag"
     "fmt"
     "math/rand"
@@ -8,44 +10,158 @@ import (
     "strings"
     "time"
 
+    "udp/internal/capture"
     "udp/internal/clientudp"
+    "udp/internal/codec"
+    "udp/internal/impair"
+    "udp/internal/logger"
     "udp/internal/protocol"
+    "udp/internal/secure"
 )
 
 func main() {
     target := flag.String("t", "", "Target IP:PORT/file (@ prefix optional)")
-    list := flag.Bool("list", false, "List available files on server")
+    list := flag.Bool("list", false, "List files on server (-t IP:PORT/[dir])")
+    recursive := flag.Bool("r", false, "With --list: list the whole tree below the directory")
+    pageSize := flag.Int("page-size", 0, "With --list: max entries per LST page (0 = as many as fit in a datagram)")
     dropRate := flag.Float64("drop-rate", 0.0, "Random drop rate 0..1 (single-shot per seq)")
     timeout := flag.Duration("timeout", 2*time.Second, "Read timeout (e base para NACK rounds)")
     retries := flag.Int("retries", 5, "Retries for timeouts and NACK rounds")
     out := flag.String("o", "", "Output path (default recv_<filename>)")
+    resume := flag.Bool("resume", false, "Resume a partial download (<out>.part + sidecar), fetching only missing segments")
+    maxDatagram := flag.Int("max-datagram", 0, "Largest DATA datagram to accept, proposed in REQ (0 = server default)")
+    probeMTU := flag.Bool("probe-mtu", false, "Probe the path MTU before requesting (bounded by --max-datagram)")
+    fecSpec := flag.String("fec", "", "Forward error correction N:K (K parity segments per N data segments), e.g. 16:4")
+    compress := flag.String("compress", "deflate,gzip", "Compression codecs accepted from v3 servers, in any order (none = disabled)")
+    pskFile := flag.String("psk", "", "Pre-shared key file: encrypt and authenticate all datagrams (server must use the same key)")
+    parallel := flag.Int("parallel", 4, "Batch downloads: files transferred at the same time")
+    sockets := flag.Int("sockets", 1, "Batch downloads: UDP sockets shared by the parallel transfers")
+    put := flag.String("put", "", "Upload this local file to the server's upload dir (-t IP:PORT/name; empty name = local file name)")
+    eventsOut := flag.String("events", "", "Write structured transfer events as JSON lines to this file (- = stdout); empty disables")
+    eventsMax := flag.Int64("events-max-size", 64<<20, "Rotate the --events file when it reaches this many bytes (0 = never)")
+    eventsBackups := flag.Int("events-backups", 5, "Rotated --events files kept (<file>.1 is the newest)")
+    captureOut := flag.String("capture", "", "Record every datagram sent/received (decrypted) to this pcap file; inspect with udp-dump or Wireshark")
+    impairSpec := flag.String("impair", "", "Impair the client socket for testing, e.g. loss=1%,burst=0.02,repeat=2,meta=0.5,dup=0.01,delay=20ms,jitter=5ms,corrupt=0.001,dir=recv")
+    impairSeed := flag.Uint64("impair-seed", 0, "Seed for --impair decisions; the same seed replays the same impairments (0 = random, printed)")
     flag.Parse()
 
     if *target == "" {
         fmt.Println("Usage:")
         fmt.Println("  cli-client -t IP:PORT/file [--drop-rate 0.05 --timeout 2s --retries 5 -o out.bin]")
         fmt.Println("  cli-client -t @IP:PORT/file [--drop-rate 0.05 --timeout 2s --retries 5 -o out.bin]")
-        fmt.Println("  cli-client --list IP:PORT")
+        fmt.Println("  cli-client -t IP:PORT/file --resume [-o out.bin]")
+        fmt.Println("  cli-client -t IP:PORT/file --probe-mtu [--max-datagram 9000]")
+        fmt.Println("  cli-client -t IP:PORT/file --fec 16:4 --drop-rate 0.05")
+        fmt.Println("  cli-client -t IP:PORT/file --psk secret.key")
+        fmt.Println("  cli-client [-o outdir --parallel 4 --sockets 1] -t 'IP:PORT/logs/*.gz' [more/paths ...]")
+        fmt.Println("  cli-client [-o outdir] -t IP:PORT/dir/   (mirror a whole directory)")
+        fmt.Println("  cli-client --put local.bin -t IP:PORT/remote.bin")
+        fmt.Println("  cli-client --list -t IP:PORT/[dir] [-r]")
+        fmt.Println("  cli-client -t IP:PORT/file --capture trace.pcap")
+        fmt.Println("  cli-client -t IP:PORT/file --impair loss=2%,burst=0.01,repeat=1,eof=0.5 [--impair-seed 42]")
         os.Exit(2)
     }
 
+    var psk []byte
+    if *pskFile != "" {
+        k, err := secure.LoadPSK(*pskFile)
+        if err != nil { fmt.Println("invalid --psk:", err); os.Exit(2) }
+        psk = k
+    }
+    var events *logger.EventLog
+    if *eventsOut != "" {
+        l, err := logger.OpenEventLog(*eventsOut, "client", *eventsMax, *eventsBackups)
+        if err != nil { fmt.Println("invalid --events:", err); os.Exit(2) }
+        defer l.Close()
+        events = l
+    }
+    var pcap *capture.Writer
+    if *captureOut != "" {
+        w, err := capture.Create(*captureOut)
+        if err != nil { fmt.Println("invalid --capture:", err); os.Exit(2) }
+        defer w.Close()
+        pcap = w
+    }
+    var imp *impair.Impairer
+    if *impairSpec != "" {
+        icfg, err := impair.Parse(*impairSpec)
+        if err != nil { fmt.Println("invalid --impair:", err); os.Exit(2) }
+        icfg.Seed = *impairSeed
+        if icfg.Seed == 0 { icfg.Seed = rand.Uint64() }
+        imp = impair.New(icfg)
+        fmt.Printf("IMPAIR: %s seed=%d\n", imp.Config(), imp.Config().Seed)
+    }
+    // resumo das degradações aplicadas, ao sair
+    report := func() { if imp != nil { fmt.Println("IMPAIR:", imp.Summary()) } }
+    defer report()
+    // os.Exit não executa os defers: a captura é gravada antes de sair
+    exit := func(code int) { report(); pcap.Close(); os.Exit(code) }
+
     if *list {
-        host, port, _, err := protocol.ParseTarget(*target)
-        if err != nil { fmt.Println("parse error:", err); os.Exit(1) }
-        names, err := clientudp.ListFiles(host, port, *timeout)
-        if err != nil { fmt.Println("list error:", err); os.Exit(1) }
-        fmt.Printf("Available files on %s:%d:\n", host, port)
-        if len(names) == 0 { fmt.Println("  (no files)") } else { for _, n := range names { fmt.Println("  "+n) } }
+        host, port, dir, err := protocol.ParseTarget(*target)
+        if err != nil { fmt.Println("parse error:", err); exit(1) }
+        c, err := clientudp.DialWith(host, port, clientudp.DialOptions{PSK: psk, Timeout: *timeout, Attempts: *retries, Capture: pcap, Impair: imp})
+        if err != nil { fmt.Println("list error:", err); exit(1) }
+        defer c.Close()
+        fmt.Printf("Files on %s:%d/%s:\n", host, port, dir)
+        n := 0
+        for e, err := range c.List(clientudp.ListConfig{Dir: dir, Recursive: *recursive, PageSize: *pageSize, Timeout: *timeout, Retries: *retries}) {
+            if err != nil { fmt.Println("list error:", err); exit(1) }
+            n++
+            mtime := e.ModTime.Format("2006-01-02 15:04:05")
+            if e.IsDir() { fmt.Printf("  %-19s %12s  %s/\n", mtime, "<dir>", e.Name); continue }
+            fmt.Printf("  %-19s %12d  %s", mtime, e.Size, e.Name)
+            if e.SHA256 != "" { fmt.Printf("  sha256=%s", e.SHA256) }
+            fmt.Println()
+        }
+        if n == 0 { fmt.Println("  (no files)") }
         return
     }
 
     host, port, path, err := protocol.ParseTarget(*target)
-    if err != nil { fmt.Println("parse error:", err); os.Exit(1) }
+    if err != nil { fmt.Println("parse error:", err); exit(1) }
+
+    onLog := func(s string) { fmt.Println(s) }
+    if *put != "" {
+        onMeta := func(m protocol.Meta) {
+            fmt.Printf("ACCEPTED: file=%s size=%d total=%d chunk=%d session=%08x\n", m.Filename, m.Size, m.Total, m.Chunk, m.Session)
+        }
+        onDone := func(name string, ok bool) { fmt.Printf("DONE: remote=%s stored=%t\n", name, ok) }
+        ucfg := clientudp.UploadConfig{Host: host, Port: port, LocalPath: *put, RemoteName: path, Timeout: *timeout, Retries: *retries, MaxDatagram: *maxDatagram, PSK: psk, Events: events, Capture: pcap, Impair: imp}
+        clientudp.RunUpload(ucfg, clientudp.Callbacks{OnMeta: onMeta, OnLog: onLog, OnDone: onDone})
+        return
+    }
 
     var dp *clientudp.DropPolicy
     if *dropRate > 0 { dp = clientudp.NewDrop(*dropRate, rand.Int63()) }
 
-    cfg := clientudp.Config{Host: host, Port: port, Path: path, Drop: dp, Timeout: *timeout, Retries: *retries, OutputPath: *out}
+    fecData, fecParity, err := clientudp.ParseFEC(*fecSpec)
+    if err != nil { fmt.Println("invalid --fec:", err); exit(2) }
+    codecs, err := codec.Parse(*compress)
+    if err != nil { fmt.Println("invalid --compress:", err); exit(2) }
+    cfg := clientudp.Config{Host: host, Port: port, Path: path, Drop: dp, Timeout: *timeout, Retries: *retries, OutputPath: *out, Resume: *resume, MaxDatagram: *maxDatagram, ProbeMTU: *probeMTU, FECData: fecData, FECParity: fecParity, PSK: psk, Codecs: codecs, Events: events, Capture: pcap, Impair: imp}
+
+    // lote: padrões (logs/*.gz), diretórios (dir/) ou vários caminhos do mesmo servidor
+    if path == "" || strings.HasSuffix(path, "/") || strings.ContainsAny(path, "*?[") || flag.NArg() > 0 {
+        cfg.Drop = nil
+        bcfg := clientudp.BatchConfig{Config: cfg, Paths: append([]string{path}, flag.Args()...), OutputDir: *out, Parallel: *parallel, Sockets: *sockets, DropRate: *dropRate}
+        lastTick := time.Now()
+        var lastBytes uint64
+        onBatch := func(p clientudp.BatchProgress) {
+            now := time.Now()
+            if now.Sub(lastTick) < time.Second { return }
+            rate := float64(p.Bytes-lastBytes) / now.Sub(lastTick).Seconds()
+            fmt.Printf("PROG: files=%d/%d failed=%d bytes=%d/%d rate=%.0f B/s\n", p.Done, p.Files, p.Failed, p.Bytes, p.TotalBytes, rate)
+            lastBytes, lastTick = p.Bytes, now
+        }
+        onFileDone := func(remote, outPath string, ok bool) {
+            if strings.TrimSpace(outPath) == "" { outPath = "(no file)" }
+            fmt.Printf("FILE: %s -> %s sha_ok=%t\n", remote, outPath, ok)
+        }
+        bcb := clientudp.Callbacks{OnLog: onLog, OnBatchProgress: onBatch, OnFileDone: onFileDone}
+        if err := clientudp.RunBatch(bcfg, bcb); err != nil { exit(1) }
+        return
+    }
 
     var total uint64
     onMeta := func(m protocol.Meta) {
@@ -67,12 +183,43 @@ func main() {
             lastBytes = b; lastTick = now
         }
     }
-    onLog := func(s string) { fmt.Println(s) }
     onDone := func(outPath string, ok bool) {
         if strings.TrimSpace(outPath) == "" { outPath = "(no file)" }
         fmt.Printf("DONE: out=%s sha_ok=%t\n", outPath, ok)
     }
 
-    cbs := clientudp.Callbacks{OnMeta: onMeta, OnProgress: onProgress, OnLog: onLog, OnDone: onDone}
-    clientudp.RunTransfer(cfg, cbs)
+    c, err := clientudp.DialWith(host, port, clientudp.DialOptions{PSK: psk, Timeout: *timeout, Attempts: *retries, Capture: pcap, Impair: imp})
+    if err != nil { fmt.Println("ERRO:", err); exit(exitCode(err)) }
+    defer c.Close()
+    cbs := clientudp.Callbacks{OnMeta: onMeta, OnProgress: onProgress, OnLog: onLog}
+    res, err := c.Download(context.Background(), cfg, cbs)
+    onDone(res.Path, err == nil)
+    var loss, peak float64 // perda (%) e pico de velocidade (bytes/s) da recepção
+    if m := res.Metrics; m != nil { loss, peak = m.PacketLoss, m.PeakSpeed }
+    fmt.Printf("RESULT: bytes=%d duration=%v nack_rounds=%d retransmitted=%d fec_recovered=%d resumed=%t protocol=v%d codec=%s loss=%.2f%% peak=%.0fB/s\n", res.Bytes, res.Duration.Round(time.Millisecond), res.NackRounds, res.Retransmissions, res.FECRecovered, res.Resumed, res.Version, res.Codec, loss, peak)
+    if err != nil {
+        fmt.Println("ERRO:", err)
+        c.Close()
+        exit(exitCode(err))
+    }
+}
+
+// código de saída de um download que falhou, pelo tipo do erro.
+func exitCode(err error) int {
+    var se *clientudp.ServerError
+    switch {
+    case errors.Is(err, clientudp.ErrNotFound):
+        return 3
+    case errors.Is(err, clientudp.ErrIntegrityMismatch):
+        return 4
+    case errors.Is(err, clientudp.ErrTimeout):
+        return 5
+    case errors.Is(err, clientudp.ErrCancelled):
+        return 6
+    case errors.As(err, &se) && se.Temporary():
+        return 8
+    case errors.As(err, &se):
+        return 7
+    }
+    return 1
 }
@@ -1,103 +1,178 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net"
+	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"udp/internal/capture"
 	"udp/internal/config"
-	"udp/internal/protocol"
+	"udp/internal/impair"
+	"udp/internal/logger"
+	"udp/internal/ratelimit"
+	"udp/internal/secure"
+	"udp/internal/serverudp"
 )
 
-// Servidor UDP linha de comando que atende requisições de transferência de arquivos.
+// Servidor UDP linha de comando: front-end do mesmo motor da GUI do servidor
+// (internal/serverudp), configurado por flags.
 func main() {
-	host := flag.String("host", "127.0.0.1", "Host/IP to bind")
+	host := flag.String("host", "127.0.0.1", "Bind address (IP/host; 0.0.0.0 = all interfaces)")
 	port := flag.Int("port", 19000, "UDP port to bind (>1024)")
+	dir := flag.String("dir", ".", "Base directory with the files served (REQ/LIST)")
+	pskFile := flag.String("psk", "", "Pre-shared key file: accept only encrypted, authenticated datagrams")
+	uploadDir := flag.String("upload-dir", "", "Directory that receives client uploads (PUT); empty disables uploads")
+	quota := flag.Int64("quota", 0, "Upload quota in bytes for --upload-dir (0 = unlimited)")
+	compress := flag.Bool("compress", true, "Compress compressible files per segment for v3 clients that accept it")
+	maxClients := flag.Int("max-clients", 0, "Max clients (addresses) served at once; others get a BUSY error (0 = unlimited)")
+	maxTransfers := flag.Int("max-transfers", 0, "Max concurrent transfers (REQ/PUT); excess requests get a BUSY error (0 = unlimited)")
+	maxPerIP := flag.Int("max-per-ip", 0, "Max concurrent transfers per client IP; excess requests get a BUSY error (0 = unlimited)")
+	retryAfter := flag.Duration("busy-retry-after", config.BusyRetryAfter, "Retry-after hint sent with BUSY errors")
+	rate := flag.String("rate", "", "Global send bandwidth limit in bytes/s, e.g. 10M or 512K (K/M/G = 1024 multiples; empty = unlimited)")
+	clientRate := flag.String("client-rate", "", "Send bandwidth limit per client IP in bytes/s (empty = unlimited)")
+	subnetRate := flag.String("subnet-rate", "", "Aggregate send bandwidth limits per subnet, e.g. 10.0.0.0/8=5M,192.168.1.7=1M")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn, error")
+	metricsOut := flag.String("metrics", "", "Append a JSON metrics snapshot per line to this file (- = stdout); empty disables")
+	metricsAddr := flag.String("metrics-addr", "", "Serve OpenMetrics/Prometheus metrics over HTTP at this address (e.g. :9100, path /metrics); empty disables")
+	metricsEvery := flag.Duration("metrics-interval", 10*time.Second, "Interval between metrics snapshots")
+	eventsOut := flag.String("events", "", "Write structured transfer events as JSON lines to this file (- = stdout); empty disables")
+	eventsMax := flag.Int64("events-max-size", 64<<20, "Rotate the --events file when it reaches this many bytes (0 = never)")
+	eventsBackups := flag.Int("events-backups", 5, "Rotated --events files kept (<file>.1 is the newest)")
+	captureOut := flag.String("capture", "", "Record every datagram sent/received (decrypted) to this pcap file; inspect with udp-dump or Wireshark")
+	impairSpec := flag.String("impair", "", "Impair the server socket for testing, e.g. loss=1%,burst=0.02,repeat=2,meta=0.5,dup=0.01,delay=20ms,jitter=5ms,corrupt=0.001,dir=send")
+	impairSeed := flag.Uint64("impair-seed", 0, "Seed for --impair decisions; the same seed replays the same impairments (0 = random, logged)")
+	grace := flag.Duration("shutdown-timeout", 5*time.Second, "On SIGINT/SIGTERM, wait this long for transfers in progress before stopping")
 	flag.Parse()
 
+	level, err := logger.ParseLevel(*logLevel)
+	if err != nil { fmt.Println("invalid --log-level:", err); os.Exit(2) }
+	log := logger.NewLogger(level, os.Stdout, "")
+	if st, err := os.Stdout.Stat(); err != nil || st.Mode()&os.ModeCharDevice == 0 { log.SetColor(false) }
+	if st, err := os.Stat(*dir); err != nil || !st.IsDir() { fmt.Printf("invalid --dir %q: not a directory\n", *dir); os.Exit(2) }
+	opts := serverudp.Options{BaseDir: *dir, UploadDir: *uploadDir, Quota: *quota, MaxClients: *maxClients, NoCompress: !*compress,
+		MaxTransfers: *maxTransfers, MaxPerIP: *maxPerIP, RetryAfter: *retryAfter,
+		Log: func(msg string) { log.Logf(levelOf(msg), "%s", msg) }}
+	if opts.RateLimit.Global, err = ratelimit.ParseRate(*rate); err != nil { fmt.Println("invalid --rate:", err); os.Exit(2) }
+	if opts.RateLimit.PerClient, err = ratelimit.ParseRate(*clientRate); err != nil { fmt.Println("invalid --client-rate:", err); os.Exit(2) }
+	if opts.RateLimit.Subnets, err = ratelimit.ParseRules(*subnetRate); err != nil { fmt.Println("invalid --subnet-rate:", err); os.Exit(2) }
+	if *pskFile != "" {
+		if opts.PSK, err = secure.LoadPSK(*pskFile); err != nil { fmt.Println("invalid --psk:", err); os.Exit(2) }
+	}
+	if *eventsOut != "" {
+		if opts.Events, err = logger.OpenEventLog(*eventsOut, "server", *eventsMax, *eventsBackups); err != nil { fmt.Println("invalid --events:", err); os.Exit(2) }
+		defer opts.Events.Close()
+	}
+	if *captureOut != "" {
+		if opts.Capture, err = capture.Create(*captureOut); err != nil { fmt.Println("invalid --capture:", err); os.Exit(2) }
+		defer opts.Capture.Close()
+	}
+	if *impairSpec != "" {
+		cfg, err := impair.Parse(*impairSpec)
+		if err != nil { fmt.Println("invalid --impair:", err); os.Exit(2) }
+		cfg.Seed = *impairSeed
+		if cfg.Seed == 0 { cfg.Seed = rand.Uint64() }
+		opts.Impair = impair.New(cfg)
+	}
+	var metrics io.Writer
+	switch *metricsOut {
+	case "":
+	case "-":
+		metrics = os.Stdout
+	default:
+		f, err := os.OpenFile(*metricsOut, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil { fmt.Println("invalid --metrics:", err); os.Exit(2) }
+		defer f.Close()
+		metrics = f
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", *host, *port))
 	if err != nil { fmt.Println("resolve error:", err); os.Exit(1) }
 	conn, err := net.ListenUDP("udp", addr)
 	if err != nil { fmt.Println("listen error:", err); os.Exit(1) }
-	defer conn.Close()
-	_ = conn.SetReadBuffer(4 << 20)
-	_ = conn.SetWriteBuffer(4 << 20)
-	fmt.Printf("CLI UDP server listening on %s:%d\n", *host, *port)
-
-	active := map[string]struct{ meta protocol.Meta; chunks [][]byte }{}
-
-	loadFile := func(path string) (protocol.Meta, [][]byte, error) {
-		st, err := os.Stat(path)
-		if err != nil { return protocol.Meta{}, nil, err }
-		if st.IsDir() { return protocol.Meta{}, nil, fmt.Errorf("is directory") }
-		f, err := os.Open(path)
-		if err != nil { return protocol.Meta{}, nil, err }
-		defer f.Close()
-		var chunks [][]byte
-		for {
-			buf := make([]byte, config.ChunkSize)
-			n, err := f.Read(buf)
-			if n > 0 { chunks = append(chunks, append([]byte(nil), buf[:n]...)) }
-			if err != nil { if err == os.ErrClosed || err.Error() == "EOF" { break }; if err.Error() == "EOF" { break }; if err == nil { continue }; if err.Error() == "EOF" { break }; if err != nil { break } }
-			if err != nil { break }
-			if n == 0 { break }
-		}
-		sha := protocol.SHA256FileChunks(chunks)
-		meta := protocol.Meta{Filename: filepath.Base(path), Total: uint32(len(chunks)), Size: st.Size(), SHA256: sha, Chunk: config.ChunkSize}
-		return meta, chunks, nil
+	srv := serverudp.New(opts)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(context.Background(), conn) }()
+	if *metricsAddr != "" {
+		ln, err := net.Listen("tcp", *metricsAddr)
+		if err != nil { fmt.Println("invalid --metrics-addr:", err); os.Exit(2) }
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", srv.MetricsHandler())
+		hs := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() { _ = hs.Serve(ln) }()
+		defer hs.Close()
+		log.Info("STATUS: métricas OpenMetrics em http://%s/metrics", ln.Addr())
+	}
+	log.Info("STATUS: servidor UDP em %s:%d servindo %s", *host, *port, *dir)
+	if *uploadDir != "" { log.Info("STATUS: envios aceitos em %s (cota=%d bytes)", *uploadDir, *quota) }
+	if *maxClients > 0 { log.Info("STATUS: até %d clientes simultâneos", *maxClients) }
+	if opts.RateLimit.Enabled() { log.Info("STATUS: limite de banda: %s", opts.RateLimit) }
+	if opts.Impair != nil { log.Warn("WARN: degradação ativa: %s seed=%d", opts.Impair.Config(), opts.Impair.Config().Seed) }
+	if *maxTransfers > 0 || *maxPerIP > 0 {
+		log.Info("STATUS: até %d transferências simultâneas, %d por IP (0 = sem limite)", *maxTransfers, *maxPerIP)
 	}
 
-	buf := make([]byte, 4096)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	var tick <-chan time.Time
+	if metrics != nil && *metricsEvery > 0 {
+		t := time.NewTicker(*metricsEvery)
+		defer t.Stop()
+		tick = t.C
+	}
 	for {
-		n, addr, err := conn.ReadFromUDP(buf)
-		if err != nil { continue }
-		b := append([]byte(nil), buf[:n]...)
-		if !protocol.IsCtrl(b) { continue }
-		typ, val, err := protocol.DecodeCtrl(b)
-		if err != nil { continue }
-		switch typ {
-		case protocol.TypeREQ:
-			r := val.(protocol.Req)
-			safe := filepath.Clean(r.Path)
-			if safe == "." || safe == ".." || strings.HasPrefix(safe, "..") {
-				conn.WriteToUDP(protocol.CtrlERR("caminho inválido"), addr)
-				continue
-			}
-			abs := filepath.Join(".", safe)
-			meta, chunks, err := loadFile(abs)
-			if err != nil {
-				conn.WriteToUDP(protocol.CtrlERR("arquivo não encontrado"), addr)
-				continue
-			}
-			active[addr.String()] = struct{ meta protocol.Meta; chunks [][]byte }{meta: meta, chunks: chunks}
-			conn.WriteToUDP(protocol.CtrlMETA(meta), addr)
-			for i, c := range chunks {
-				h := protocol.DataHeader{Seq: uint32(i), Total: uint32(len(chunks)), Size: uint16(len(c)), CRC32: protocol.CRC32(c)}
-				pkt := append(protocol.PackHeader(h), c...)
-				conn.WriteToUDP(pkt, addr)
-			}
-			conn.WriteToUDP(protocol.CtrlEOF(), addr)
-			fmt.Printf("META+DATA+EOF -> %s file=%s total=%d size=%d\n", addr, meta.Filename, meta.Total, meta.Size)
-		case protocol.TypeNACK:
-			n := val.(protocol.Nack)
-			en := active[addr.String()]
-			for _, seq := range n.Missing {
-				if int(seq) < len(en.chunks) {
-					c := en.chunks[int(seq)]
-					h := protocol.DataHeader{Seq: seq, Total: uint32(len(en.chunks)), Size: uint16(len(c)), CRC32: protocol.CRC32(c)}
-					pkt := append(protocol.PackHeader(h), c...)
-					conn.WriteToUDP(pkt, addr)
-				}
-			}
-			fmt.Printf("NACK <- %s missing=%d\n", addr, len(n.Missing))
-		case protocol.TypeLIST:
-			entries, _ := os.ReadDir(".")
-			names := make([]string, 0)
-			for _, e := range entries { if !e.IsDir() { names = append(names, e.Name()) } }
-			conn.WriteToUDP(protocol.CtrlLST(names), addr)
+		select {
+		case <-tick:
+			writeMetrics(metrics, srv)
+		case err := <-served:
+			log.Error("ERRO: %v", err)
+			if metrics != nil { writeMetrics(metrics, srv) }
+			if opts.Impair != nil { log.Info("STATUS: degradação: %s", opts.Impair.Summary()) }
+			opts.Capture.Close() // os.Exit não executa os defers
+			os.Exit(1)
+		case s := <-sig:
+			// encerramento gradual: novos pedidos recebem ERR e as transferências em
+			// andamento têm até --shutdown-timeout (um segundo sinal interrompe já)
+			log.Info("STATUS: sinal %v, encerrando (até %v)", s, *grace)
+			ctx, cancel := context.WithTimeout(context.Background(), *grace)
+			go func() { <-sig; cancel() }()
+			if err := srv.Shutdown(ctx); err != nil { log.Warn("WARN: transferências interrompidas: %v", err) }
+			cancel()
+			if metrics != nil { writeMetrics(metrics, srv) }
+			if opts.Impair != nil { log.Info("STATUS: degradação: %s", opts.Impair.Summary()) }
+			return
 		}
 	}
 }
+
+// nível de uma mensagem do servidor, pelo prefixo usado em internal/serverudp.
+func levelOf(msg string) logger.LogLevel {
+	switch {
+	case strings.HasPrefix(msg, "ERRO"):
+		return logger.ERROR
+	case strings.HasPrefix(msg, "WARN"), strings.HasPrefix(msg, "ABORT"):
+		return logger.WARN
+	case strings.HasPrefix(msg, "NACK"), strings.HasPrefix(msg, "MIGRATE"), strings.HasPrefix(msg, "EXPIRE"), strings.HasPrefix(msg, "HELLO"):
+		return logger.DEBUG
+	}
+	return logger.INFO
+}
+
+// escreve uma linha JSON com o instante e as métricas atuais do servidor.
+func writeMetrics(w io.Writer, srv *serverudp.Server) {
+	b, err := json.Marshal(struct {
+		Time time.Time `json:"time"`
+		serverudp.Metrics
+	}{time.Now(), srv.Snapshot()})
+	if err != nil { return }
+	_, _ = w.Write(append(b, '\n'))
+}
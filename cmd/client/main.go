@@ -5,6 +5,7 @@ import (
     "image"
     "image/color"
     "os"
+    "path"
     "path/filepath"
     "runtime"
     "strconv"
@@ -20,6 +21,7 @@ import (
 	"fyne.io/fyne/v2/theme"
 
 	"udp/internal/clientudp"
+	"udp/internal/codec"
 	"udp/internal/config"
 	"udp/internal/logging"
 	"udp/internal/protocol"
@@ -63,6 +65,21 @@ func drawSpark(rates []float64, w, h int) image.Image {
 	return img
 }
 
+// Formata um tamanho em bytes em unidades humanas.
+func formatSize(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v := float64(n)
+	u := 0
+	for v >= 1024 && u < len(units)-1 {
+		v /= 1024
+		u++
+	}
+	if u == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[u])
+}
+
 // Interface gráfica do cliente com coleta de parâmetros e iniciação de transferência.
 // Exibe progresso, taxa instantânea e logs de eventos durante transferência de arquivos.
 func main() {
@@ -102,6 +119,11 @@ func main() {
 	timeoutEntry.SetText(clientSettings.Timeout) // duração de ociosidade
 	retriesEntry := widget.NewEntry()
 	retriesEntry.SetText(fmt.Sprintf("%d", clientSettings.Retries)) // rodadas de NACK
+	fecEntry := widget.NewEntry()
+	fecEntry.SetPlaceHolder("N:K, ex.: 16:4 (vazio = sem FEC)") // razão de paridade por transferência
+	probeCheck := widget.NewCheck("Sondar MTU do caminho (segmentos maiores em loopback/jumbo)", nil) // negociação de chunk
+	compressCheck := widget.NewCheck("Aceitar compressão (deflate/gzip) de servidores v3", nil)       // codecs anunciados no REQ
+	compressCheck.SetChecked(true)
 
 	prog := widget.NewProgressBar()                              // barra de progresso global
 	stats := widget.NewLabel("Bytes: 0 | Segs: 0 | Rate: 0 B/s") // resumo numérico
@@ -109,7 +131,9 @@ func main() {
 
 	// Botões
 	var startBtn *widget.Button
+	var resumeBtn *widget.Button
 	var stopBtn *widget.Button
+	var uploadBtn *widget.Button
 
 	// sparkline simples
 	var rates []float64 // histórico de taxas instantâneas
@@ -162,14 +186,52 @@ func main() {
 		}
 	}
 
+	// árvore de arquivos do servidor, preenchida pela listagem recursiva;
+	// os IDs dos nós são os caminhos relativos ao diretório base ("" = raiz)
+	treeKids := map[string][]string{}        // diretório -> filhos
+	treeInfo := map[string]protocol.Entry{}   // caminho -> entrada do LST
+	var fileTree *widget.Tree
+	fileTree = widget.NewTree(
+		func(id widget.TreeNodeID) []widget.TreeNodeID { return treeKids[id] },
+		func(id widget.TreeNodeID) bool { return id == "" || treeInfo[id].IsDir() },
+		func(branch bool) fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.TreeNodeID, branch bool, o fyne.CanvasObject) {
+			e := treeInfo[id]
+			text := path.Base(e.Name)
+			if !e.IsDir() {
+				text += fmt.Sprintf("  (%s, %s)", formatSize(e.Size), e.ModTime.Format("2006-01-02 15:04"))
+			}
+			o.(*widget.Label).SetText(text)
+		},
+	)
+	fileTree.OnSelected = func(id widget.TreeNodeID) {
+		if e, ok := treeInfo[id]; ok && !e.IsDir() {
+			fileSelect.SetText(id) // arquivo: vira o alvo do download
+			return
+		}
+		fileTree.ToggleBranch(id) // diretório: abre/fecha
+		fileTree.UnselectAll()
+	}
+
 	listBtn := widget.NewButton("Listar arquivos no servidor", func() {
 		host := strings.TrimSpace(hostEntry.Text)
 		p, _ := strconv.Atoi(strings.TrimSpace(portEntry.Text))
-		names, err := clientudp.ListFiles(host, p, 2*time.Second)
+		entries, err := clientudp.ListDir(host, p, "", true, 2*time.Second)
 		if err != nil {
 			dialog.ShowError(err, w)
 			return
 		}
+		clear(treeKids)
+		clear(treeInfo)
+		for _, e := range entries {
+			parent := path.Dir(e.Name)
+			if parent == "." { parent = "" }
+			treeKids[parent] = append(treeKids[parent], e.Name)
+			treeInfo[e.Name] = e
+		}
+		fileTree.CloseAllBranches()
+		fileTree.Refresh()
+		names := protocol.Lst{Entries: entries}.Names()
 		fileSelect.SetOptions(names)
 		if len(names) > 0 {
 			fileSelect.SetText(names[0])
@@ -186,13 +248,21 @@ func main() {
 		defer func(){ _ = recover() }()
 		close(*ch)
 	}
-	startBtn = widget.NewButton("Iniciar", func() { // inicia a transferência em goroutine
+	// inicia a transferência em goroutine; resume retoma um download parcial
+	startTransfer := func(resume bool) {
 		if transferRunning { return }
+		fecData, fecParity, err := clientudp.ParseFEC(fecEntry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("FEC inválido: %v", err), w)
+			return
+		}
 		// cria novo canal de cancelamento
 		cancelCh = make(chan struct{})
 		canceled = false
 		transferRunning = true
 		startBtn.Disable()
+		resumeBtn.Disable()
+		uploadBtn.Disable()
 		stopBtn.Enable()
 		// Valida todos os campos antes de iniciar
 		params := config.ValidationParams{
@@ -234,7 +304,8 @@ func main() {
 				outPath = gen
 			}
 		}
-		cfg := clientudp.Config{Host: host, Port: p, Path: path, Drop: dp, Timeout: to, Retries: retr, OutputPath: outPath, Cancel: cancelCh}
+		cfg := clientudp.Config{Host: host, Port: p, Path: path, Drop: dp, Timeout: to, Retries: retr, OutputPath: outPath, Cancel: cancelCh, Resume: resume, ProbeMTU: probeCheck.Checked, FECData: fecData, FECParity: fecParity}
+		if compressCheck.Checked { cfg.Codecs = codec.All }
 		cbs := clientudp.Callbacks{OnMeta: onMeta, OnProgress: onProgress, OnLog: onLog, OnDone: onDone}
 		go func(){
 			clientudp.RunTransfer(cfg, cbs)
@@ -243,10 +314,60 @@ func main() {
 				cancelCh = nil
 				canceled = true
 				startBtn.Enable()
+				resumeBtn.Enable()
+				uploadBtn.Enable()
 				stopBtn.Disable()
 			})
 		}()
+	}
+	// envia um arquivo local ao diretório de upload do servidor (PUT)
+	startUpload := func(local string) {
+		if transferRunning { return }
+		host := strings.TrimSpace(hostEntry.Text)
+		p, err := strconv.Atoi(strings.TrimSpace(portEntry.Text))
+		if host == "" || err != nil {
+			dialog.ShowError(fmt.Errorf("host/porta inválidos"), w)
+			return
+		}
+		retr, _ := strconv.Atoi(strings.TrimSpace(retriesEntry.Text))
+		to, _ := time.ParseDuration(strings.TrimSpace(timeoutEntry.Text))
+		cancelCh = make(chan struct{})
+		canceled = false
+		transferRunning = true
+		startBtn.Disable()
+		resumeBtn.Disable()
+		uploadBtn.Disable()
+		stopBtn.Enable()
+		cfg := clientudp.UploadConfig{Host: host, Port: p, LocalPath: local, Timeout: to, Retries: retr, Cancel: cancelCh}
+		cbs := clientudp.Callbacks{OnMeta: onMeta, OnProgress: onProgress, OnLog: onLog, OnDone: func(name string, ok bool) {
+			if ok {
+				onLog("Enviado: " + name + " (confirmado pelo servidor)")
+			}
+		}}
+		onLog("Enviando " + local + " ao servidor")
+		go func(){
+			clientudp.RunUpload(cfg, cbs)
+			runUI(func(){
+				transferRunning = false
+				cancelCh = nil
+				canceled = true
+				startBtn.Enable()
+				resumeBtn.Enable()
+				uploadBtn.Enable()
+				stopBtn.Disable()
+			})
+		}()
+	}
+	uploadBtn = widget.NewButton("Enviar arquivo...", func() {
+		dialog.ShowFileOpen(func(r fyne.URIReadCloser, err error) {
+			if err != nil || r == nil { return }
+			path := r.URI().Path()
+			_ = r.Close()
+			startUpload(path)
+		}, w)
 	})
+	startBtn = widget.NewButton("Iniciar", func() { startTransfer(false) })
+	resumeBtn = widget.NewButton("Continuar", func() { startTransfer(true) }) // retoma download interrompido
 	stopBtn = widget.NewButton("Interromper", func(){
 		if !transferRunning || cancelCh == nil || canceled { return }
 		canceled = true
@@ -265,15 +386,20 @@ func main() {
 		&widget.FormItem{Text: "Drop rate", Widget: rateEntry},
 		&widget.FormItem{Text: "Timeout", Widget: timeoutEntry},
 		&widget.FormItem{Text: "Retries", Widget: retriesEntry},
+		&widget.FormItem{Text: "FEC", Widget: fecEntry},
+		&widget.FormItem{Text: "MTU", Widget: probeCheck},
+		&widget.FormItem{Text: "Compressão", Widget: compressCheck},
 	)
 	form.SubmitText = ""
 	form.OnSubmit = nil
 
 	// Adiciona ícones aos botões para ficar mais "bonito"
 	startBtn.SetIcon(theme.ConfirmIcon())
+	resumeBtn.SetIcon(theme.MediaPlayIcon())
 	stopBtn.SetIcon(theme.CancelIcon())
+	uploadBtn.SetIcon(theme.UploadIcon())
 
-	buttons := container.NewHBox(startBtn, stopBtn)
+	buttons := container.NewHBox(startBtn, resumeBtn, stopBtn, uploadBtn)
 	topControls := container.NewVBox(form, buttons)
 
 	// Função para formatar taxa em unidades humanas
@@ -300,11 +426,16 @@ func main() {
 		container.NewVBox(widget.NewLabel("Logs:"), logView.CanvasObject()),
 	)
 
-	// Layout principal usando Border com seção de métricas no topo e logs dominando o centro
+	// Árvore de arquivos do servidor ao lado dos logs
+	treeSection := container.NewBorder(widget.NewLabel("Arquivos no servidor:"), nil, nil, nil, fileTree)
+	center := container.NewHSplit(treeSection, logSection)
+	center.SetOffset(0.35)
+
+	// Layout principal usando Border com seção de métricas no topo; árvore e logs dominam o centro
 	w.SetContent(container.NewBorder(
 		container.NewVBox(topControls, metricsSection), // top
 		nil, nil, nil,
-		logSection,
+		center,
 	))
 	// Ticker de UI: atualiza a cada ~200ms, reduzindo carga e evitando concorrência
 	go func() {
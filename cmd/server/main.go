@@ -16,6 +16,7 @@ import (
 
 	"udp/internal/config"
 	"udp/internal/logging"
+	"udp/internal/ratelimit"
 	"udp/internal/serverudp"
 )
 
@@ -41,12 +42,40 @@ func main() {
 	portEntry.SetText(serverSettings.Port)
 	baseDirEntry := widget.NewEntry() // diretório base de arquivos
 	baseDirEntry.SetText(serverSettings.BaseDir)
+	uploadDirEntry := widget.NewEntry() // diretório que recebe envios dos clientes
+	uploadDirEntry.SetText(serverSettings.UploadDir)
+	uploadDirEntry.SetPlaceHolder("vazio = upload desabilitado")
+	quotaEntry := widget.NewEntry() // cota de upload em MB
+	quotaEntry.SetText(strconv.FormatInt(serverSettings.UploadQuota, 10))
+	// limites de admissão: clientes, transferências e transferências por IP (0 = sem limite)
+	maxClientsEntry := widget.NewEntry()
+	maxClientsEntry.SetText(strconv.Itoa(serverSettings.MaxClients))
+	maxTransfersEntry := widget.NewEntry()
+	maxTransfersEntry.SetText(strconv.Itoa(serverSettings.MaxTransfers))
+	maxPerIPEntry := widget.NewEntry()
+	maxPerIPEntry.SetText(strconv.Itoa(serverSettings.MaxPerIP))
+	// limites de banda (ajustáveis com o servidor rodando)
+	rateEntry := widget.NewEntry()
+	rateEntry.SetText(serverSettings.RateGlobal)
+	rateEntry.SetPlaceHolder("global, ex.: 10M")
+	clientRateEntry := widget.NewEntry()
+	clientRateEntry.SetText(serverSettings.RateClient)
+	clientRateEntry.SetPlaceHolder("por cliente, ex.: 1M")
+	subnetRateEntry := widget.NewEntry()
+	subnetRateEntry.SetText(serverSettings.RateSubnets)
+	subnetRateEntry.SetPlaceHolder("sub-redes, ex.: 10.0.0.0/8=5M")
 	status := widget.NewLabel("Parado")                 // estado atual
 	bytesLab := widget.NewLabel("Bytes: 0")             // total enviado
 	segsLab := widget.NewLabel("Segmentos: 0")          // segmentos enviados
 	nacksLab := widget.NewLabel("NACKs: 0")             // NACKs recebidos
 	retrLab := widget.NewLabel("Retransm.: 0")          // pacotes retransmitidos
-	clientsLab := widget.NewLabel("Clientes ativos: 0") // conectados recentemente
+	fecLab := widget.NewLabel("FEC: 0 par. / 0 rec.")   // paridades enviadas / recuperados nos clientes
+	clientsLab := widget.NewLabel("Clientes ativos: 0") // conectados recentemente (e pedidos recusados por ocupação)
+	rateLab := widget.NewLabel("Taxa: 0 KB/s")          // taxa de envio agregada
+	cwndLab := widget.NewLabel("Janela: 0")             // soma das janelas de congestionamento
+	rttLab := widget.NewLabel("RTT: -")                 // RTT médio das sessões
+	uploadLab := widget.NewLabel("Recebidos: 0 B / 0 envios") // envios dos clientes (PUT)
+	throttleLab := widget.NewLabel("Banda: sem limite")        // limites de banda e baldes segurando envios
 	logView := logging.NewLogView()                     // novo visor de logs coloridos/rolável
 	runUI := func(fn func()) { fyne.Do(fn) }            // executa no thread de UI
 	logAppend := func(s string) {
@@ -79,10 +108,57 @@ func main() {
 		d.Show()
 	})
 
+	// Seletor de pasta para o diretório de upload
+	pickUploadBtn := widget.NewButton("Escolher pasta...", func() {
+		d := dialog.NewFolderOpen(func(u fyne.ListableURI, err error) {
+			if err != nil || u == nil {
+				return
+			}
+			uploadDirEntry.SetText(u.Path())
+		}, w)
+		d.Show()
+	})
+
+	// lê e aplica os limites de banda; vale também com o servidor rodando
+	applyRate := func() bool {
+		var rl ratelimit.Config
+		var err error
+		if rl.Global, err = ratelimit.ParseRate(rateEntry.Text); err == nil {
+			if rl.PerClient, err = ratelimit.ParseRate(clientRateEntry.Text); err == nil {
+				rl.Subnets, err = ratelimit.ParseRules(subnetRateEntry.Text)
+			}
+		}
+		if err != nil {
+			status.SetText("Erro: " + err.Error())
+			return false
+		}
+		serverudp.SetRateLimit(rl)
+		return true
+	}
+	rateBtn := widget.NewButton("Aplicar", func() {
+		if applyRate() { status.SetText("Limites de banda aplicados") }
+	})
+
 	startBtn := widget.NewButton("Iniciar", func() {
 		host := hostEntry.Text
 		p, _ := strconv.Atoi(strings.TrimSpace(portEntry.Text))
+		quotaMB, err := strconv.ParseInt(strings.TrimSpace(quotaEntry.Text), 10, 64)
+		if err != nil || quotaMB < 0 {
+			status.SetText("Erro: cota de upload inválida (MB, 0 = sem limite)")
+			return
+		}
+		var limits [3]int // clientes, transferências, por IP
+		for i, e := range []*widget.Entry{maxClientsEntry, maxTransfersEntry, maxPerIPEntry} {
+			if limits[i], err = strconv.Atoi(strings.TrimSpace(e.Text)); err != nil || limits[i] < 0 {
+				status.SetText("Erro: limites inválidos (inteiros, 0 = sem limite)")
+				return
+			}
+		}
+		if !applyRate() { return }
+		serverudp.SetMaxClients(limits[0])
+		serverudp.SetMaxTransfers(limits[1], limits[2])
 		serverudp.SetBaseDir(strings.TrimSpace(baseDirEntry.Text))
+		serverudp.SetUploadDir(strings.TrimSpace(uploadDirEntry.Text), quotaMB<<20)
 		if err := serverudp.Start(host, p, logAppend); err != nil {
 			status.SetText("Erro: " + err.Error())
 			return
@@ -105,7 +181,13 @@ func main() {
 				segsLab.SetText(fmt.Sprintf("Segmentos: %d", snap.SegmentsSent))
 				nacksLab.SetText(fmt.Sprintf("NACKs: %d", snap.NacksReceived))
 				retrLab.SetText(fmt.Sprintf("Retransm.: %d", snap.Retransmissions))
-				clientsLab.SetText(fmt.Sprintf("Clientes ativos: %d", snap.ActiveClients))
+				fecLab.SetText(fmt.Sprintf("FEC: %d par. / %d rec.", snap.ParitySent, snap.FECRecovered))
+				clientsLab.SetText(fmt.Sprintf("Clientes ativos: %d (recusados por ocupação: %d)", snap.ActiveClients, snap.Busy))
+				rateLab.SetText(fmt.Sprintf("Taxa: %.0f KB/s", snap.SendRate/1024))
+				cwndLab.SetText(fmt.Sprintf("Janela: %.0f", snap.Cwnd))
+				uploadLab.SetText(fmt.Sprintf("Recebidos: %d B / %d envios", snap.BytesReceived, snap.Uploads))
+				throttleLab.SetText(throttleText(snap))
+				if snap.RTT > 0 { rttLab.SetText(fmt.Sprintf("RTT: %v", snap.RTT.Round(time.Microsecond))) } else { rttLab.SetText("RTT: -") }
 			})
 		}
 	}()
@@ -115,13 +197,18 @@ func main() {
         &widget.FormItem{Text: "Host", Widget: hostEntry},
         &widget.FormItem{Text: "Porta", Widget: portEntry},
         &widget.FormItem{Text: "Diretório base", Widget: container.NewBorder(nil, nil, nil, pickDirBtn, baseDirEntry)},
+        &widget.FormItem{Text: "Diretório de upload", Widget: container.NewBorder(nil, nil, nil, pickUploadBtn, uploadDirEntry)},
+        &widget.FormItem{Text: "Cota de upload (MB)", Widget: quotaEntry},
+        &widget.FormItem{Text: "Banda (global / cliente / sub-redes)", Widget: container.NewBorder(nil, nil, nil, rateBtn, container.NewGridWithColumns(3, rateEntry, clientRateEntry, subnetRateEntry))},
+        &widget.FormItem{Text: "Limites (clientes / transf. / por IP)", Widget: container.NewGridWithColumns(3, maxClientsEntry, maxTransfersEntry, maxPerIPEntry)},
     )
     buttons := container.NewHBox(startBtn, stopBtn)
-    metrics := container.NewGridWithColumns(2,
+    metrics := container.NewGridWithColumns(3,
         container.NewVBox(bytesLab, segsLab),
-        container.NewVBox(nacksLab, retrLab),
+        container.NewVBox(nacksLab, retrLab, fecLab),
+        container.NewVBox(rateLab, cwndLab, rttLab),
     )
-    statsBox := container.NewVBox(status, metrics, clientsLab, widget.NewLabel("Logs:"))
+    statsBox := container.NewVBox(status, metrics, clientsLab, uploadLab, throttleLab, widget.NewLabel("Logs:"))
     top := container.NewVBox(form, buttons, statsBox)
     w.SetContent(container.NewBorder(top, nil, nil, nil, logView.CanvasObject()))
 	w.Resize(fyne.NewSize(float32(serverSettings.WindowWidth), float32(serverSettings.WindowHeight)))
@@ -135,6 +222,17 @@ func main() {
 			portEntry.Text,
 			baseDirEntry.Text,
 		)
+		serverSettings.UploadDir = strings.TrimSpace(uploadDirEntry.Text)
+		if q, err := strconv.ParseInt(strings.TrimSpace(quotaEntry.Text), 10, 64); err == nil && q >= 0 { serverSettings.UploadQuota = q }
+		for _, l := range []struct {
+			e   *widget.Entry
+			dst *int
+		}{{maxClientsEntry, &serverSettings.MaxClients}, {maxTransfersEntry, &serverSettings.MaxTransfers}, {maxPerIPEntry, &serverSettings.MaxPerIP}} {
+			if n, err := strconv.Atoi(strings.TrimSpace(l.e.Text)); err == nil && n >= 0 { *l.dst = n }
+		}
+		serverSettings.RateGlobal = strings.TrimSpace(rateEntry.Text)
+		serverSettings.RateClient = strings.TrimSpace(clientRateEntry.Text)
+		serverSettings.RateSubnets = strings.TrimSpace(subnetRateEntry.Text)
 
 		// Salva tamanho da janela
 		size := w.Content().Size()
@@ -151,3 +249,17 @@ func main() {
 
 	w.ShowAndRun()
 }
+
+// resume os limites de banda e os baldes que estão segurando envios.
+func throttleText(snap serverudp.Metrics) string {
+	if len(snap.Throttle) == 0 { return "Banda: " + snap.RateLimit }
+	var held []string
+	var wait time.Duration
+	for _, b := range snap.Throttle {
+		wait += b.Wait
+		if b.Throttled() { held = append(held, b.Name) }
+	}
+	txt := fmt.Sprintf("Banda: %s | espera acumulada %v", snap.RateLimit, wait.Round(time.Millisecond))
+	if len(held) > 0 { txt += " | limitando: " + strings.Join(held, ", ") }
+	return txt
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"udp/internal/capture"
+	"udp/internal/protocol"
+)
+
+// decode imprime a linha do tempo de uma captura e um resumo por sessão.
+func decode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "Print every DATA, PARITY and FBK datagram instead of collapsing them into runs")
+	sessionID := fs.String("session", "", "Only show this session (hex, as in the logs and events)")
+	serverAddr := fs.String("server", "", "Server endpoint ip:port in the capture (default: detected from requests and responses)")
+	summaryOnly := fs.Bool("summary", false, "Print only the per-session summary")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: udp-dump decode [flags] trace.pcap")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 { fs.Usage(); os.Exit(2) }
+
+	recs, err := capture.ReadFile(fs.Arg(0))
+	if err != nil && len(recs) == 0 { return err }
+	if err != nil { fmt.Fprintln(os.Stderr, "WARN:", err) } // captura truncada: decodifica o que foi lido
+	if len(recs) == 0 { return errors.New("nenhum datagrama UDP na captura") }
+	server, err := serverEndpoint(recs, *serverAddr)
+	if err != nil { return err }
+	var filter uint32
+	if *sessionID != "" {
+		v, err := strconv.ParseUint(*sessionID, 16, 32)
+		if err != nil { return fmt.Errorf("invalid -session: %w", err) }
+		filter = uint32(v)
+	}
+
+	t := newTimeline(server, recs[0].Time, *verbose || *summaryOnly, *summaryOnly)
+	tokens := tokenSessions(recs, server)
+	for _, rec := range recs {
+		p := parse(rec.Data)
+		if filter != 0 {
+			s := p.session
+			if s == 0 && p.token != 0 { s = tokens[tokenKey(clientOf(rec, server), p.token)] }
+			if s != filter { continue }
+		}
+		t.add(rec, p)
+	}
+	t.flush()
+	if !*summaryOnly { fmt.Println() }
+	t.summary()
+	return nil
+}
+
+// identifica o endpoint do servidor na captura: o destino dos pedidos
+// (REQ, PUT, LIST, HELLO, PROBE) e a origem das respostas mais frequentes.
+func serverEndpoint(recs []capture.Record, override string) (netip.AddrPort, error) {
+	if override != "" {
+		ap, err := netip.ParseAddrPort(override)
+		if err != nil { return netip.AddrPort{}, fmt.Errorf("invalid -server: %w", err) }
+		return ap, nil
+	}
+	votes := map[netip.AddrPort]int{}
+	for _, rec := range recs {
+		p := parse(rec.Data)
+		if p.fromClient() { votes[rec.Dst]++ }
+		if p.fromServer() { votes[rec.Src]++ }
+	}
+	var best netip.AddrPort
+	for ap, n := range votes {
+		if n > votes[best] { best = ap }
+	}
+	if !best.IsValid() {
+		return best, errors.New("servidor não identificado (captura sem pedidos nem respostas): informe -server")
+	}
+	return best, nil
+}
+
+// compara endpoints tolerando o endereço não especificado de um socket do
+// servidor ligado a todas as interfaces (0.0.0.0 ou [::])
+func sameEndpoint(a, b netip.AddrPort) bool {
+	if a.Port() != b.Port() { return false }
+	return a.Addr() == b.Addr() || a.Addr().IsUnspecified() || b.Addr().IsUnspecified()
+}
+
+// cliente (o lado que não é o servidor) de um registro
+func clientOf(rec capture.Record, server netip.AddrPort) netip.AddrPort {
+	if sameEndpoint(rec.Src, server) { return rec.Dst }
+	return rec.Src
+}
+
+// chave de um token de pedido: tokens são escolhidos por cliente
+func tokenKey(client netip.AddrPort, token uint32) string {
+	return client.String() + "/" + strconv.FormatUint(uint64(token), 16)
+}
+
+// associa o token de cada pedido à sessão aberta pelo META de resposta
+func tokenSessions(recs []capture.Record, server netip.AddrPort) map[string]uint32 {
+	m := map[string]uint32{}
+	for _, rec := range recs {
+		if p := parse(rec.Data); p.kind == protocol.TypeMETA {
+			m[tokenKey(clientOf(rec, server), p.token)] = p.session
+		}
+	}
+	return m
+}
+
+// sequência de DATA/PARITY de uma sessão num sentido, impressa numa linha
+type run struct {
+	start, end  time.Time
+	dir         string
+	src, dst    netip.AddrPort
+	session     uint32
+	first, last uint32 // menor e maior seq dos DATA
+	data        int    // datagramas DATA
+	parity      int    // datagramas PARITY
+	fbk         int    // FBKs no sentido oposto, omitidos durante a sequência
+	bytes       int
+}
+
+// acumula a linha do tempo e as estatísticas de cada sessão.
+type timeline struct {
+	server   netip.AddrPort
+	t0       time.Time
+	verbose  bool // imprime cada DATA/PARITY/FBK
+	quiet    bool // só o resumo
+	runs     map[string]*run
+	order    []string // chaves de runs na ordem de abertura
+	sessions map[uint32]*sessionStats
+	ids      []uint32 // sessões na ordem em que apareceram
+	requests map[string]*request
+	reqs     []string // pedidos na ordem em que apareceram
+}
+
+// estatísticas de uma sessão
+type sessionStats struct {
+	id          uint32
+	client      netip.AddrPort
+	upload      bool // DATA do cliente para o servidor
+	name        string
+	size        int64
+	total       uint32
+	first, last time.Time
+	seen        []bool // segmentos recebidos, por seq
+	unique      int
+	dup         int
+	parity      int
+	bytes       int
+	nacks       int
+	missing     int // entradas somadas dos NACKs
+	fbk         int
+	eof         int
+	result      string // DONE/ERR final, se houver
+}
+
+// pedido (REQ/PUT/LIST/HELLO/PROBE) e sua resposta
+type request struct {
+	client netip.AddrPort
+	line   string
+	reply  string
+	session bool // respondido com META
+	count  int  // repetições do pedido
+}
+
+func newTimeline(server netip.AddrPort, t0 time.Time, verbose, quiet bool) *timeline {
+	return &timeline{server: server, t0: t0, verbose: verbose, quiet: quiet, runs: map[string]*run{},
+		sessions: map[uint32]*sessionStats{}, requests: map[string]*request{}}
+}
+
+// sentido de um registro em relação ao servidor
+func (t *timeline) dir(rec capture.Record) string {
+	switch {
+	case sameEndpoint(rec.Dst, t.server):
+		return "C->S"
+	case sameEndpoint(rec.Src, t.server):
+		return "S->C"
+	}
+	return "?->?"
+}
+
+func (t *timeline) print(at time.Time, dir string, src, dst netip.AddrPort, text string) {
+	if t.quiet { return }
+	fmt.Printf("%11.6f %s %s > %s  %s\n", at.Sub(t.t0).Seconds(), dir, src, dst, text)
+}
+
+// registra um datagrama: DATA/PARITY (e FBK) entram na sequência da sessão,
+// os demais encerram as sequências abertas e são impressos.
+func (t *timeline) add(rec capture.Record, p packet) {
+	dir := t.dir(rec)
+	t.account(rec, p, dir)
+	if !t.verbose {
+		switch p.kind {
+		case kindDATA, kindPARITY:
+			key := dir + "/" + strconv.FormatUint(uint64(p.session), 16)
+			r := t.runs[key]
+			if r == nil {
+				r = &run{start: rec.Time, dir: dir, src: rec.Src, dst: rec.Dst, session: p.session}
+				t.runs[key] = r
+				t.order = append(t.order, key)
+			}
+			r.end = rec.Time
+			r.bytes += len(rec.Data)
+			if p.kind == kindPARITY { r.parity++; return }
+			if r.data == 0 { r.first, r.last = p.data.Seq, p.data.Seq }
+			r.data++
+			r.first, r.last = min(r.first, p.data.Seq), max(r.last, p.data.Seq)
+			return
+		case protocol.TypeFBK:
+			// FBK do receptor durante o envio: contado na sequência do outro sentido
+			other := "S->C"
+			if dir == "S->C" { other = "C->S" }
+			if r := t.runs[other+"/"+strconv.FormatUint(uint64(p.session), 16)]; r != nil { r.fbk++; return }
+		}
+	}
+	t.flush()
+	t.print(rec.Time, dir, rec.Src, rec.Dst, p.String())
+}
+
+// imprime e fecha as sequências abertas
+func (t *timeline) flush() {
+	for _, key := range t.order {
+		r := t.runs[key]
+		text := fmt.Sprintf("DATA s=%08x seq=%d..%d datagrams=%d bytes=%d duration=%v", r.session, r.first, r.last, r.data, r.bytes, r.end.Sub(r.start).Round(time.Microsecond))
+		if r.data == 0 { text = fmt.Sprintf("PARITY s=%08x datagrams=%d bytes=%d", r.session, r.parity, r.bytes) } else if r.parity > 0 { text += fmt.Sprintf(" parity=%d", r.parity) }
+		if r.fbk > 0 { text += fmt.Sprintf(" (fbk=%d)", r.fbk) }
+		t.print(r.start, r.dir, r.src, r.dst, text)
+		delete(t.runs, key)
+	}
+	t.order = t.order[:0]
+}
+
+// atualiza as estatísticas da sessão e dos pedidos
+func (t *timeline) account(rec capture.Record, p packet, dir string) {
+	client := clientOf(rec, t.server)
+	if p.token != 0 && p.kind != protocol.TypeMETA {
+		key := tokenKey(client, p.token)
+		if p.fromClient() {
+			r := t.requests[key]
+			if r == nil {
+				r = &request{client: client, line: p.String()}
+				t.requests[key] = r
+				t.reqs = append(t.reqs, key)
+			}
+			r.count++
+		} else if r := t.requests[key]; r != nil {
+			r.reply = p.String()
+		}
+	}
+	if p.session == 0 { return }
+	s := t.sessions[p.session]
+	if s == nil {
+		s = &sessionStats{id: p.session, client: client, first: rec.Time}
+		t.sessions[p.session] = s
+		t.ids = append(t.ids, p.session)
+	}
+	s.last = rec.Time
+	switch m := p.msg.(type) {
+	case protocol.Meta:
+		s.name, s.size, s.total = m.Filename, m.Size, m.Total
+		if r := t.requests[tokenKey(client, m.Token)]; r != nil { r.reply, r.session = p.String(), true }
+	case protocol.Nack:
+		s.nacks++
+		s.missing += len(m.Missing)
+	case protocol.Feedback:
+		s.fbk++
+	case protocol.EOFMsg:
+		s.eof++
+	case protocol.Done:
+		s.result = p.String()
+	}
+	switch p.kind {
+	case kindPARITY:
+		s.parity++
+		s.bytes += len(rec.Data)
+	case kindDATA:
+		s.upload = dir == "C->S"
+		s.total = max(s.total, p.data.Total)
+		s.bytes += len(rec.Data)
+		if n := int(p.data.Seq) + 1; n > len(s.seen) {
+			if n > 1<<26 { return } // seq absurda: não aloca o mapa de recebidos
+			s.seen = append(s.seen, make([]bool, max(n, int(s.total))-len(s.seen))...)
+		}
+		if s.seen[p.data.Seq] { s.dup++ } else { s.seen[p.data.Seq] = true; s.unique++ }
+	}
+}
+
+// imprime o resumo por sessão e os pedidos sem sessão
+func (t *timeline) summary() {
+	fmt.Printf("Sessões: %d\n", len(t.ids))
+	for _, id := range t.ids {
+		s := t.sessions[id]
+		dir := "download"
+		if s.upload { dir = "upload" }
+		fmt.Printf("  %08x %s %q cliente=%s duração=%v\n", s.id, dir, s.name, s.client, s.last.Sub(s.first).Round(time.Millisecond))
+		fmt.Printf("    DATA únicos=%d/%d duplicados=%d paridade=%d bytes=%d size=%d\n", s.unique, s.total, s.dup, s.parity, s.bytes, s.size)
+		fmt.Printf("    NACK=%d (faltantes=%d) FBK=%d EOF=%d", s.nacks, s.missing, s.fbk, s.eof)
+		if s.result != "" { fmt.Printf(" fim: %s", s.result) }
+		fmt.Println()
+	}
+	var orphans []*request
+	for _, key := range t.reqs {
+		r := t.requests[key]
+		if !r.session { orphans = append(orphans, r) }
+	}
+	if len(orphans) == 0 { return }
+	fmt.Printf("Pedidos sem sessão: %d\n", len(orphans))
+	for _, r := range orphans {
+		reply := r.reply
+		if reply == "" { reply = "(sem resposta)" }
+		fmt.Printf("  %s x%d: %s -> %s\n", r.client, r.count, r.line, reply)
+	}
+}
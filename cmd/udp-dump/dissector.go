@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"udp/internal/wireshark"
+)
+
+// dissector grava o dissector Lua do Wireshark gerado a partir de
+// internal/protocol (go generate ./internal/wireshark o regenera).
+func dissector(args []string) error {
+	fs := flag.NewFlagSet("dissector", flag.ExitOnError)
+	out := fs.String("o", "", "Output file (default: stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: udp-dump dissector [-o udpft.lua]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 { fs.Usage(); os.Exit(2) }
+
+	src, err := wireshark.Dissector()
+	if err != nil { return err }
+	if *out == "" { _, err = os.Stdout.Write(src); return err }
+	if err := os.WriteFile(*out, src, 0o644); err != nil { return err }
+	fmt.Fprintf(os.Stderr, "STATUS: dissector gravado em %s\n", *out)
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// udp-dump: captura, decodificação e reprodução offline do protocolo UD/UC.
+// As capturas são arquivos pcap (internal/capture), gravados pelo próprio
+// udp-dump (record), pelo --capture do cli-server/cli-client ou pelo tcpdump;
+// para abri-las no Wireshark, o subcomando dissector gera o plugin Lua.
+func main() {
+	if len(os.Args) < 2 { usage() }
+	args := os.Args[2:]
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = record(args)
+	case "decode":
+		err = decode(args)
+	case "replay":
+		err = replay(args)
+	case "dissector":
+		err = dissector(args)
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+	}
+	if err != nil { fmt.Fprintln(os.Stderr, "ERRO:", err); os.Exit(1) }
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  udp-dump record -listen :19001 -server 127.0.0.1:19000 -w trace.pcap")
+	fmt.Fprintln(os.Stderr, "  udp-dump decode [-v] [-session id] [-server ip:port] trace.pcap")
+	fmt.Fprintln(os.Stderr, "  udp-dump replay -server 127.0.0.1:19000 [-speed 1] [-client ip:port] [-psk key] trace.pcap")
+	fmt.Fprintln(os.Stderr, "  udp-dump dissector [-o udpft.lua]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'udp-dump <command> -h' for the flags of each command.")
+	os.Exit(2)
+}
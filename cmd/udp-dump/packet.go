@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"udp/internal/protocol"
+	"udp/internal/secure"
+)
+
+// tipos de datagrama além dos de controle (protocol.Type*)
+const (
+	kindDATA     = "DATA"
+	kindPARITY   = "PARITY"   // DATA com DataFlagParity (FEC)
+	kindEnvelope = "US"       // envelope do modo cifrado (captura do tcpdump)
+	kindUnknown  = "?"        // não é UD/UC ou não decodificou
+)
+
+// datagrama decodificado.
+type packet struct {
+	kind    string              // DATA, PARITY, US, ? ou o tipo do controle
+	session uint32              // sessão: DATA, META, EOF, NACK, FBK e DONE (0 nos demais)
+	token   uint32              // token: REQ, PUT, LIST, HELLO, PROBE e respostas (0 nos demais)
+	data    protocol.DataHeader // cabeçalho do DATA/PARITY
+	msg     any                 // mensagem de controle decodificada
+	size    int                 // bytes do datagrama
+	err     error               // falha ao decodificar
+	legacy  bool                // layout v1, sem sessão nem token
+}
+
+// decodifica b como DATA ('UD'), controle ('UC') ou envelope cifrado ('US').
+func parse(b []byte) packet {
+	p := packet{kind: kindUnknown, size: len(b), legacy: protocol.IsLegacy(b)}
+	switch {
+	case len(b) >= 2 && b[0] == 'U' && b[1] == 'D':
+		h, err := protocol.UnpackHeader(b)
+		if err != nil { p.err = err; return p }
+		p.kind, p.session, p.data = kindDATA, h.Session, h
+		if h.Flags&protocol.DataFlagParity != 0 { p.kind = kindPARITY }
+	case len(b) >= 2 && b[0] == 'U' && b[1] == 'C':
+		typ, v, err := protocol.DecodeCtrl(b)
+		if err != nil { p.err = err; return p }
+		p.kind, p.msg = typ, v
+		switch m := v.(type) {
+		case protocol.Req:
+			p.token = m.Token
+		case protocol.Meta:
+			p.session, p.token = m.Session, m.Token
+		case protocol.ErrMsg:
+			p.token = m.Token
+		case protocol.EOFMsg:
+			p.session = m.Session
+		case protocol.Nack:
+			p.session = m.Session
+		case protocol.Feedback:
+			p.session = m.Session
+		case protocol.Probe:
+			p.token = m.Token
+		case protocol.Put:
+			p.token = m.Token
+		case protocol.Done:
+			p.session = m.Session
+		case protocol.List:
+			p.token = m.Token
+		case protocol.Lst:
+			p.token = m.Token
+		case protocol.Hello:
+			p.token = m.Token
+		case protocol.HelloAck:
+			p.token = m.Token
+		}
+	case secure.IsEnvelope(b):
+		p.kind = kindEnvelope
+	}
+	return p
+}
+
+// informa se o datagrama só é enviado pelo cliente (pedidos); DATA, EOF,
+// NACK e FBK vão nos dois sentidos, conforme quem recebe o arquivo.
+func (p packet) fromClient() bool {
+	switch p.kind {
+	case protocol.TypeREQ, protocol.TypePUT, protocol.TypeLIST, protocol.TypeHELLO, protocol.TypePROBE:
+		return true
+	}
+	return false
+}
+
+// informa se o datagrama só é enviado pelo servidor (respostas).
+func (p packet) fromServer() bool {
+	switch p.kind {
+	case protocol.TypeMETA, protocol.TypeERR, protocol.TypeLST, protocol.TypeHELLOACK, protocol.TypePROBEACK, protocol.TypeDONE:
+		return true
+	}
+	return false
+}
+
+// descreve os campos do datagrama numa linha.
+func (p packet) String() string {
+	if p.err != nil { return fmt.Sprintf("%s len=%d inválido: %v", p.kind, p.size, p.err) }
+	switch m := p.msg.(type) {
+	case nil:
+		switch p.kind {
+		case kindDATA, kindPARITY:
+			h := p.data
+			if p.legacy { return fmt.Sprintf("DATA v1 seq=%d total=%d size=%d", h.Seq, h.Total, h.Size) }
+			s := fmt.Sprintf("%s s=%08x seq=%d total=%d size=%d", p.kind, h.Session, h.Seq, h.Total, h.Size)
+			if h.Flags&protocol.DataFlagCompressed != 0 { s += " compressed" }
+			return s
+		case kindEnvelope:
+			return fmt.Sprintf("US len=%d (envelope cifrado: capture com --capture para ver o protocolo)", p.size)
+		}
+		return fmt.Sprintf("? len=%d", p.size)
+	case protocol.Req:
+		s := fmt.Sprintf("REQ token=%08x path=%q v%d", m.Token, m.Path, m.Version)
+		if m.Flags&protocol.ReqFlagResume != 0 { s += " resume" }
+		if m.MaxDatagram > 0 { s += fmt.Sprintf(" max_datagram=%d", m.MaxDatagram) }
+		if m.FECData > 0 { s += fmt.Sprintf(" fec=%d:%d", m.FECData, m.FECParity) }
+		if m.Codecs != 0 { s += " codecs=" + codecList(m.Codecs) }
+		return s
+	case protocol.Meta:
+		s := fmt.Sprintf("META s=%08x token=%08x file=%q size=%d total=%d chunk=%d v%d", m.Session, m.Token, m.Filename, m.Size, m.Total, m.Chunk, m.Version)
+		if m.FECData > 0 { s += fmt.Sprintf(" fec=%d:%d", m.FECData, m.FECParity) }
+		if m.Codec != protocol.CodecNone { s += fmt.Sprintf(" codec=%s estimated_wire=%d", m.Codec, m.EstimatedCompressedSize) }
+		return s
+	case protocol.ErrMsg:
+		if p.legacy { return fmt.Sprintf("ERR v1 code=%d (%s) msg=%q", m.Code, m.Code, m.Message) }
+		s := fmt.Sprintf("ERR token=%08x code=%d (%s) msg=%q", m.Token, m.Code, m.Code, m.Message)
+		if m.RetryAfter > 0 { s += " retry_after=" + m.RetryAfter.String() }
+		return s
+	case protocol.EOFMsg:
+		if p.legacy { return "EOF v1" }
+		return fmt.Sprintf("EOF s=%08x", m.Session)
+	case protocol.Nack:
+		if p.legacy { return fmt.Sprintf("NACK v1 count=%d %s", len(m.Missing), seqList(m.Missing, 8)) }
+		return fmt.Sprintf("NACK s=%08x count=%d %s", m.Session, len(m.Missing), seqList(m.Missing, 8))
+	case protocol.Feedback:
+		return fmt.Sprintf("FBK s=%08x received=%d lost=%d highest=%d echo=%d delay=%v recovered=%d", m.Session, m.Received, m.Lost, m.Highest, m.EchoSeq, time.Duration(m.DelayMicros)*time.Microsecond, m.Recovered)
+	case protocol.Probe:
+		return fmt.Sprintf("%s token=%08x size=%d len=%d", p.kind, m.Token, m.Size, p.size)
+	case protocol.Put:
+		s := fmt.Sprintf("PUT token=%08x name=%q size=%d", m.Token, m.Name, m.Size)
+		if m.MaxDatagram > 0 { s += fmt.Sprintf(" max_datagram=%d", m.MaxDatagram) }
+		return s
+	case protocol.Done:
+		status := "ok"
+		if m.Status != protocol.DoneOK { status = "failed" }
+		s := fmt.Sprintf("DONE s=%08x status=%s", m.Session, status)
+		if m.Message != "" { s += fmt.Sprintf(" msg=%q", m.Message) }
+		return s
+	case protocol.List:
+		s := fmt.Sprintf("LIST token=%08x path=%q", m.Token, m.Path)
+		if m.Flags&protocol.ListFlagRecursive != 0 { s += " recursive" }
+		if m.PageSize > 0 { s += fmt.Sprintf(" page_size=%d", m.PageSize) }
+		if m.Cursor != "" { s += fmt.Sprintf(" cursor=%q", m.Cursor) }
+		return s
+	case protocol.Lst:
+		s := fmt.Sprintf("LST token=%08x entries=%d", m.Token, len(m.Entries))
+		if m.Next != "" { s += fmt.Sprintf(" next=%q", m.Next) }
+		return s
+	case protocol.Hello:
+		return fmt.Sprintf("HELLO token=%08x versions=%d..%d features=%s", m.Token, m.MinVersion, m.MaxVersion, m.Features)
+	case protocol.HelloAck:
+		return fmt.Sprintf("HELLOACK token=%08x version=%d features=%s", m.Token, m.Version, m.Features)
+	}
+	return p.kind
+}
+
+// nomes dos codecs do conjunto, separados por vírgula
+func codecList(set protocol.CodecSet) string {
+	var names []string
+	for c := protocol.Codec(0); c < 8; c++ {
+		if set.Has(c) { names = append(names, c.String()) }
+	}
+	return strings.Join(names, ",")
+}
+
+// lista até n sequências, com reticências se houver mais
+func seqList(seqs []uint32, n int) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, seq := range seqs {
+		if i == n { fmt.Fprintf(&b, " ...+%d", len(seqs)-n); break }
+		if i > 0 { b.WriteByte(' ') }
+		fmt.Fprint(&b, seq)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// troca a sessão de um datagrama do cliente (DATA, EOF, NACK, FBK), usada na
+// reprodução: o servidor atribui sessões novas a cada execução. Datagramas
+// v1 não têm sessão e são copiados sem alteração.
+func rewriteSession(b []byte, p packet, session uint32) []byte {
+	out := append([]byte(nil), b...)
+	if p.legacy { return out }
+	switch p.kind {
+	case kindDATA, kindPARITY:
+		binary.BigEndian.PutUint32(out[4:8], session)
+	case protocol.TypeEOF, protocol.TypeNACK, protocol.TypeFBK, protocol.TypeDONE:
+		binary.BigEndian.PutUint32(out[6:10], session) // primeiro campo do payload, após o cabeçalho de 6 bytes
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"udp/internal/capture"
+)
+
+// record é um proxy UDP entre os clientes e o servidor que grava os
+// datagramas dos dois sentidos, vistos pelo lado dos clientes (origem ou
+// destino é o endereço de escuta do proxy).
+func record(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	listen := fs.String("listen", ":19001", "Address the clients connect to")
+	server := fs.String("server", "127.0.0.1:19000", "Server address the datagrams are forwarded to")
+	out := fs.String("w", "trace.pcap", "Capture file to write (pcap)")
+	idle := fs.Duration("idle", 2*time.Minute, "Forget a client after this long without datagrams in either direction")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: udp-dump record [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	upstream, err := net.ResolveUDPAddr("udp", *server)
+	if err != nil { return fmt.Errorf("invalid -server: %w", err) }
+	laddr, err := net.ResolveUDPAddr("udp", *listen)
+	if err != nil { return fmt.Errorf("invalid -listen: %w", err) }
+	ln, err := net.ListenUDP("udp", laddr)
+	if err != nil { return err }
+	w, err := capture.Create(*out)
+	if err != nil { ln.Close(); return err }
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	p := &proxy{ln: ln, upstream: upstream, w: w, local: ln.LocalAddr().(*net.UDPAddr).AddrPort(), idle: *idle, peers: map[netip.AddrPort]*peer{}}
+	fmt.Printf("STATUS: gravando %s <-> %s em %s (Ctrl+C encerra)\n", ln.LocalAddr(), upstream, *out)
+	go func() { <-ctx.Done(); ln.Close() }()
+	p.serve()
+	p.close()
+	n := p.count.Load()
+	if err := w.Close(); err != nil { return err }
+	fmt.Printf("STATUS: %d datagramas gravados em %s\n", n, *out)
+	return nil
+}
+
+// proxy repassa datagramas entre clientes e servidor, um socket por cliente.
+type proxy struct {
+	ln       *net.UDPConn
+	upstream *net.UDPAddr
+	w        *capture.Writer
+	local    netip.AddrPort // endereço de escuta, gravado como o do servidor
+	idle     time.Duration
+	mu       sync.Mutex
+	peers    map[netip.AddrPort]*peer
+	wg       sync.WaitGroup
+	count    atomic.Uint64 // datagramas gravados
+}
+
+// cliente do proxy e seu socket para o servidor
+type peer struct {
+	addr netip.AddrPort
+	conn *net.UDPConn
+	last time.Time // último datagrama (protegido por proxy.mu)
+}
+
+// lê os datagramas dos clientes até o socket de escuta ser fechado
+func (p *proxy) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := p.ln.ReadFromUDPAddrPort(buf)
+		if err != nil { return }
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		pe, err := p.peer(addr)
+		if err != nil { fmt.Fprintln(os.Stderr, "ERRO:", err); continue }
+		p.save(addr, p.local, buf[:n])
+		if _, err := pe.conn.Write(buf[:n]); err != nil { fmt.Fprintln(os.Stderr, "WARN:", err) }
+	}
+}
+
+// retorna o socket do cliente addr, abrindo-o no primeiro datagrama
+func (p *proxy) peer(addr netip.AddrPort) (*peer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pe := p.peers[addr]; pe != nil { pe.last = time.Now(); return pe, nil }
+	conn, err := net.DialUDP("udp", nil, p.upstream)
+	if err != nil { return nil, err }
+	pe := &peer{addr: addr, conn: conn, last: time.Now()}
+	p.peers[addr] = pe
+	p.wg.Add(1)
+	go p.reply(pe)
+	fmt.Printf("STATUS: cliente %s\n", addr)
+	return pe, nil
+}
+
+// repassa ao cliente as respostas do servidor; encerra após idle sem tráfego
+func (p *proxy) reply(pe *peer) {
+	defer p.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		pe.conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := pe.conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				p.mu.Lock()
+				expired := time.Since(pe.last) > p.idle
+				if expired { delete(p.peers, pe.addr) }
+				p.mu.Unlock()
+				if !expired { continue }
+			}
+			pe.conn.Close()
+			return
+		}
+		p.mu.Lock(); pe.last = time.Now(); p.mu.Unlock()
+		p.save(p.local, pe.addr, buf[:n])
+		if _, err := p.ln.WriteToUDPAddrPort(buf[:n], pe.addr); err != nil { return }
+	}
+}
+
+// grava um datagrama
+func (p *proxy) save(src, dst netip.AddrPort, b []byte) {
+	if err := p.w.Write(capture.Record{Time: time.Now(), Src: src, Dst: dst, Data: b}); err == nil { p.count.Add(1) }
+}
+
+// fecha os sockets dos clientes e espera seus repasses
+func (p *proxy) close() {
+	p.mu.Lock()
+	for _, pe := range p.peers { pe.conn.Close() }
+	p.mu.Unlock()
+	p.wg.Wait()
+}
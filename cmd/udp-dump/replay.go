@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"udp/internal/capture"
+	"udp/internal/config"
+	"udp/internal/protocol"
+	"udp/internal/secure"
+)
+
+// replay reenvia a um servidor os datagramas que os clientes de uma captura
+// enviaram, com o mesmo ritmo, e compara as respostas com as da captura. Cada
+// cliente da captura ganha um socket próprio; as sessões atribuídas pelo
+// servidor são remapeadas pelo token do pedido que as abriu. A reprodução não
+// reage às respostas: NACKs e FBKs são os da captura, e o controle de
+// congestionamento do servidor segue o retorno gravado.
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	server := fs.String("server", "127.0.0.1:19000", "Server to replay the client datagrams against")
+	traceServer := fs.String("trace-server", "", "Server endpoint ip:port in the capture (default: detected from requests and responses)")
+	client := fs.String("client", "", "Only replay this client endpoint ip:port from the capture (default: all clients)")
+	speed := fs.Float64("speed", 1, "Timing factor: 2 = twice as fast, 0 = send as fast as possible")
+	pskFile := fs.String("psk", "", "Pre-shared key file: replay over the encrypted mode (the capture itself is plaintext)")
+	timeout := fs.Duration("timeout", 2*time.Second, "Wait for the META of a session before sending its datagrams (and for the encrypted handshake)")
+	linger := fs.Duration("linger", 2*time.Second, "Keep receiving responses this long after the last datagram is sent")
+	verbose := fs.Bool("v", false, "Print every response, DATA included")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: udp-dump replay [flags] trace.pcap")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 { fs.Usage(); os.Exit(2) }
+	if *speed < 0 { return errors.New("invalid -speed: must be >= 0") }
+
+	recs, err := capture.ReadFile(fs.Arg(0))
+	if err != nil && len(recs) == 0 { return err }
+	if err != nil { fmt.Fprintln(os.Stderr, "WARN:", err) }
+	if len(recs) == 0 { return errors.New("nenhum datagrama UDP na captura") }
+	traced, err := serverEndpoint(recs, *traceServer)
+	if err != nil { return err }
+	var only netip.AddrPort
+	if *client != "" {
+		if only, err = netip.ParseAddrPort(*client); err != nil { return fmt.Errorf("invalid -client: %w", err) }
+	}
+	var psk []byte
+	if *pskFile != "" {
+		if psk, err = secure.LoadPSK(*pskFile); err != nil { return fmt.Errorf("invalid -psk: %w", err) }
+	}
+	addr, err := net.ResolveUDPAddr("udp", *server)
+	if err != nil { return fmt.Errorf("invalid -server: %w", err) }
+
+	// separa a captura por cliente
+	var players []*player
+	byClient := map[netip.AddrPort]*player{}
+	for _, rec := range recs {
+		c := clientOf(rec, traced)
+		if only.IsValid() && c != only { continue }
+		pl := byClient[c]
+		if pl == nil {
+			pl = newPlayer(c)
+			byClient[c] = pl
+			players = append(players, pl)
+		}
+		pl.load(rec, sameEndpoint(rec.Dst, traced))
+	}
+	if len(players) == 0 { return fmt.Errorf("cliente %s não aparece na captura", only) }
+	for _, pl := range players {
+		uc, err := net.DialUDP("udp", nil, addr)
+		if err != nil { return err }
+		_ = uc.SetReadBuffer(config.DefaultReadBuffer)
+		_ = uc.SetWriteBuffer(config.DefaultWriteBuffer)
+		var conn net.Conn = uc
+		if len(psk) > 0 {
+			if conn, err = secure.Client(uc, psk, *timeout, 3); err != nil { uc.Close(); return err }
+		}
+		pl.conn = conn
+	}
+
+	fmt.Printf("STATUS: reproduzindo %d cliente(s) de %s contra %s (speed=%g)\n", len(players), fs.Arg(0), addr, *speed)
+	start := time.Now()
+	t0 := recs[0].Time
+	var wg sync.WaitGroup
+	for _, pl := range players {
+		wg.Add(2)
+		go func() { defer wg.Done(); pl.receive(start, *verbose) }()
+		go func() {
+			defer wg.Done()
+			pl.send(start, t0, *speed, *timeout)
+			time.Sleep(*linger)
+			pl.conn.Close()
+		}()
+	}
+	wg.Wait()
+	fmt.Println()
+	for _, pl := range players { pl.summary() }
+	return nil
+}
+
+// reprodução dos datagramas de um cliente da captura
+type player struct {
+	client   netip.AddrPort        // cliente na captura
+	conn     net.Conn              // socket da reprodução
+	out      []capture.Record      // datagramas do cliente, em ordem
+	oldMeta  map[uint32]uint32     // sessão da captura -> token do pedido que a abriu
+	traced   map[string]int        // respostas da captura, por tipo
+	mu       sync.Mutex
+	sessions map[uint32]uint32        // token -> sessão atribuída na reprodução
+	waiting  map[uint32]chan struct{} // token -> fechado ao receber o META
+	sent     map[string]int           // datagramas enviados, por tipo
+	skipped  int                      // datagramas de sessões sem META na reprodução
+	received map[string]int           // respostas da reprodução, por tipo
+}
+
+func newPlayer(client netip.AddrPort) *player {
+	return &player{client: client, oldMeta: map[uint32]uint32{}, traced: map[string]int{}, sessions: map[uint32]uint32{},
+		waiting: map[uint32]chan struct{}{}, sent: map[string]int{}, received: map[string]int{}}
+}
+
+// acrescenta um registro da captura: os do cliente são reenviados, os do
+// servidor servem de referência
+func (pl *player) load(rec capture.Record, toServer bool) {
+	if toServer { pl.out = append(pl.out, rec); return }
+	p := parse(rec.Data)
+	pl.traced[p.kind]++
+	if p.kind == protocol.TypeMETA { pl.oldMeta[p.session] = p.token }
+}
+
+// canal fechado quando o META do token chegar
+func (pl *player) wait(token uint32) chan struct{} {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	ch := pl.waiting[token]
+	if ch == nil {
+		ch = make(chan struct{})
+		pl.waiting[token] = ch
+	}
+	return ch
+}
+
+// reenvia os datagramas com os intervalos da captura divididos por speed,
+// trocando a sessão da captura pela da reprodução
+func (pl *player) send(start, t0 time.Time, speed float64, timeout time.Duration) {
+	expired := map[uint32]bool{} // tokens cujo META não chegou no timeout: não se espera de novo
+	for _, rec := range pl.out {
+		if speed > 0 {
+			at := start.Add(time.Duration(float64(rec.Time.Sub(t0)) / speed))
+			if d := time.Until(at); d > 0 { time.Sleep(d) }
+		}
+		p := parse(rec.Data)
+		b := rec.Data
+		if p.session != 0 {
+			token, ok := pl.oldMeta[p.session]
+			if !ok { pl.skip(); continue } // sessão sem META na captura: não há como remapear
+			wait := timeout
+			if expired[token] { wait = 0 } // só usa um META que tenha chegado atrasado
+			if !pl.await(token, wait) { expired[token] = true; pl.skip(); continue }
+			pl.mu.Lock(); session := pl.sessions[token]; pl.mu.Unlock()
+			b = rewriteSession(b, p, session)
+		}
+		if _, err := pl.conn.Write(b); err != nil { fmt.Fprintln(os.Stderr, "ERRO:", err); return }
+		pl.mu.Lock(); pl.sent[p.kind]++; pl.mu.Unlock()
+	}
+}
+
+// espera até timeout pelo META do token; com timeout 0 só verifica se já chegou
+func (pl *player) await(token uint32, timeout time.Duration) bool {
+	ch := pl.wait(token)
+	select {
+	case <-ch:
+		return true
+	default:
+	}
+	if timeout <= 0 { return false }
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func (pl *player) skip() { pl.mu.Lock(); pl.skipped++; pl.mu.Unlock() }
+
+// lê as respostas do servidor até o socket ser fechado
+func (pl *player) receive(start time.Time, verbose bool) {
+	buf := make([]byte, 65535)
+	for {
+		n, err := pl.conn.Read(buf)
+		if err != nil { return }
+		p := parse(buf[:n])
+		pl.mu.Lock()
+		pl.received[p.kind]++
+		if p.kind == protocol.TypeMETA {
+			if _, ok := pl.sessions[p.token]; !ok {
+				pl.sessions[p.token] = p.session
+				ch := pl.waiting[p.token]
+				if ch == nil {
+					ch = make(chan struct{})
+					pl.waiting[p.token] = ch
+				}
+				close(ch)
+			}
+		}
+		pl.mu.Unlock()
+		if verbose || (p.kind != kindDATA && p.kind != kindPARITY && p.kind != protocol.TypeFBK) {
+			fmt.Printf("%11.6f %s <- %s\n", time.Since(start).Seconds(), pl.client, p)
+		}
+	}
+}
+
+// compara, por tipo, as respostas da captura com as da reprodução
+func (pl *player) summary() {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	sent := 0
+	for _, n := range pl.sent { sent += n }
+	fmt.Printf("Cliente %s: enviados=%d/%d ignorados=%d (%s)\n", pl.client, sent, len(pl.out), pl.skipped, counts(pl.sent))
+	var kinds []string
+	for k := range pl.traced { kinds = append(kinds, k) }
+	for k := range pl.received {
+		if _, ok := pl.traced[k]; !ok { kinds = append(kinds, k) }
+	}
+	slices.Sort(kinds)
+	fmt.Printf("  %-10s %10s %10s\n", "resposta", "captura", "reprodução")
+	for _, k := range kinds {
+		mark := ""
+		if pl.traced[k] != pl.received[k] { mark = "  *" }
+		fmt.Printf("  %-10s %10d %10d%s\n", k, pl.traced[k], pl.received[k], mark)
+	}
+}
+
+// contagens por tipo, em ordem alfabética
+func counts(m map[string]int) string {
+	var parts []string
+	for k, n := range m { parts = append(parts, fmt.Sprintf("%s=%d", k, n)) }
+	slices.Sort(parts)
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,87 @@
+package capture
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+// PacketConn grava num Writer os datagramas lidos e escritos por um
+// net.PacketConn (o socket do servidor).
+type PacketConn struct {
+	net.PacketConn
+	w     *Writer
+	local netip.AddrPort
+}
+
+// WrapPacketConn passa a gravar em w o tráfego de pc.
+func WrapPacketConn(pc net.PacketConn, w *Writer) *PacketConn {
+	return &PacketConn{PacketConn: pc, w: w, local: addrPort(pc.LocalAddr())}
+}
+
+// ReadFrom lê um datagrama e o grava como recebido de addr.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.w.Write(Record{Time: time.Now(), Src: addrPort(addr), Dst: c.local, Data: b[:n]})
+	}
+	return n, addr, err
+}
+
+// WriteTo escreve um datagrama e, se enviado, o grava como destinado a addr.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.w.Write(Record{Time: time.Now(), Src: c.local, Dst: addrPort(addr), Data: b[:n]})
+	}
+	return n, err
+}
+
+// Unwrap retorna o net.PacketConn gravado.
+func (c *PacketConn) Unwrap() net.PacketConn { return c.PacketConn }
+
+// Conn grava num Writer os datagramas lidos e escritos por um socket UDP
+// conectado (o socket do cliente).
+type Conn struct {
+	net.Conn
+	w             *Writer
+	local, remote netip.AddrPort
+}
+
+// WrapConn passa a gravar em w o tráfego de c.
+func WrapConn(c net.Conn, w *Writer) *Conn {
+	return &Conn{Conn: c, w: w, local: addrPort(c.LocalAddr()), remote: addrPort(c.RemoteAddr())}
+}
+
+// Read lê um datagrama e o grava como recebido do outro lado.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err == nil {
+		c.w.Write(Record{Time: time.Now(), Src: c.remote, Dst: c.local, Data: b[:n]})
+	}
+	return n, err
+}
+
+// Write escreve um datagrama e, se enviado, o grava.
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err == nil {
+		c.w.Write(Record{Time: time.Now(), Src: c.local, Dst: c.remote, Data: b[:n]})
+	}
+	return n, err
+}
+
+// Unwrap retorna o net.Conn gravado.
+func (c *Conn) Unwrap() net.Conn { return c.Conn }
+
+// endereço e porta de um net.Addr (zero se não for ip:porta)
+func addrPort(a net.Addr) netip.AddrPort {
+	if u, ok := a.(*net.UDPAddr); ok {
+		return u.AddrPort()
+	}
+	if a == nil {
+		return netip.AddrPort{}
+	}
+	ap, _ := netip.ParseAddrPort(a.String())
+	return ap
+}
@@ -0,0 +1,391 @@
+// Package capture grava e lê datagramas UDP em arquivos pcap, para depurar
+// o protocolo UD/UC offline (udp-dump) ou no Wireshark. Os registros são
+// gravados com link type RAW e cabeçalhos IPv4/IPv6 e UDP sintetizados a
+// partir dos endereços; a leitura aceita também capturas do tcpdump em
+// Ethernet, loopback (NULL) e Linux cooked (SLL/SLL2).
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// link types do pcap suportados
+const (
+	LinkNull     = 0   // loopback BSD/macOS: família do endereço em 4 bytes
+	LinkEthernet = 1   // Ethernet II (com VLAN 802.1Q)
+	LinkRaw      = 101 // pacote IP sem cabeçalho de enlace (usado na gravação)
+	LinkSLL      = 113 // Linux cooked capture v1 (tcpdump -i any)
+	LinkIPv4     = 228 // IPv4 sem cabeçalho de enlace
+	LinkIPv6     = 229 // IPv6 sem cabeçalho de enlace
+	LinkSLL2     = 276 // Linux cooked capture v2
+)
+
+// números mágicos do cabeçalho global (escritos na ordem nativa de quem gravou)
+const (
+	magicMicros = 0xa1b2c3d4
+	magicNanos  = 0xa1b23c4d
+)
+
+// maior registro aceito: datagrama UDP máximo com cabeçalhos IPv6/UDP e enlace
+const snapLen = 65535 + 40 + 8 + 64
+
+// ErrNotPcap indica um arquivo que não começa com o cabeçalho pcap.
+var ErrNotPcap = errors.New("capture: não é um arquivo pcap (pcapng não é suportado: salve como pcap)")
+
+// Record é um datagrama UDP capturado.
+type Record struct {
+	Time time.Time      // instante da captura
+	Src  netip.AddrPort // origem
+	Dst  netip.AddrPort // destino
+	Data []byte         // payload UDP
+}
+
+// Writer grava registros num arquivo pcap (link type RAW, timestamps em
+// nanossegundos). É seguro para uso concorrente.
+type Writer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	err    error // primeira falha de escrita (as seguintes são descartadas)
+}
+
+// NewWriter escreve o cabeçalho pcap em w e retorna o gravador.
+func NewWriter(w io.Writer) (*Writer, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], magicNanos)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2) // versão 2.4
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], LinkRaw)
+	bw := bufio.NewWriterSize(w, 64<<10)
+	if _, err := bw.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw}, nil
+}
+
+// Create cria (ou trunca) o arquivo pcap path.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+// Write grava o datagrama r. Um Writer nil não grava nada.
+func (w *Writer) Write(r Record) error {
+	if w == nil {
+		return nil
+	}
+	pkt := encodeIP(r.Src, r.Dst, r.Data)
+	rec := make([]byte, 16, 16+len(pkt))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(r.Time.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(r.Time.Nanosecond()))
+	binary.LittleEndian.PutUint32(rec[8:12], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(rec[12:16], uint32(len(pkt)))
+	rec = append(rec, pkt...)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := w.w.Write(rec); err != nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Flush grava em disco os registros pendentes.
+func (w *Writer) Flush() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// Close grava os registros pendentes e fecha o arquivo aberto por Create.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	err := w.Flush()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// monta o pacote IP+UDP de um datagrama; endereços de famílias diferentes
+// (ex.: socket em [::] com cliente IPv4) vão como IPv6 mapeado
+func encodeIP(src, dst netip.AddrPort, data []byte) []byte {
+	sa, da := src.Addr().Unmap(), dst.Addr().Unmap()
+	if !sa.IsValid() {
+		sa = netip.IPv4Unspecified()
+	}
+	if !da.IsValid() {
+		da = netip.IPv4Unspecified()
+	}
+	if sa.Is4() != da.Is4() {
+		sa, da = netip.AddrFrom16(sa.As16()), netip.AddrFrom16(da.As16())
+	}
+	udp := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(udp[0:2], src.Port())
+	binary.BigEndian.PutUint16(udp[2:4], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(data)))
+	udp = append(udp, data...)
+	if sa.Is4() {
+		ip := make([]byte, 20, 20+len(udp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(udp)))
+		ip[6] = 0x40 // DF
+		ip[8], ip[9] = 64, 17
+		s4, d4 := sa.As4(), da.As4()
+		copy(ip[12:16], s4[:])
+		copy(ip[16:20], d4[:])
+		binary.BigEndian.PutUint16(ip[10:12], ^fold(sum(0, ip)))
+		binary.BigEndian.PutUint16(udp[6:8], udpChecksum(s4[:], d4[:], udp))
+		return append(ip, udp...)
+	}
+	ip := make([]byte, 40, 40+len(udp))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(udp)))
+	ip[6], ip[7] = 17, 64
+	s16, d16 := sa.As16(), da.As16()
+	copy(ip[8:24], s16[:])
+	copy(ip[24:40], d16[:])
+	binary.BigEndian.PutUint16(udp[6:8], udpChecksum(s16[:], d16[:], udp))
+	return append(ip, udp...)
+}
+
+// soma de complemento de um em palavras de 16 bits
+func sum(acc uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		acc += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		acc += uint32(b[0]) << 8
+	}
+	return acc
+}
+
+// dobra os transportes da soma em 16 bits
+func fold(acc uint32) uint16 {
+	for acc > 0xffff {
+		acc = acc&0xffff + acc>>16
+	}
+	return uint16(acc)
+}
+
+// checksum UDP com o pseudo-cabeçalho (0 vira 0xffff, como manda a RFC 768)
+func udpChecksum(src, dst, udp []byte) uint16 {
+	acc := sum(sum(0, src), dst)
+	acc += 17 + uint32(len(udp))
+	c := ^fold(sum(acc, udp))
+	if c == 0 {
+		c = 0xffff
+	}
+	return c
+}
+
+// Reader lê os datagramas UDP de um arquivo pcap, ignorando os demais pacotes.
+type Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	nanos bool   // timestamps em nanossegundos (senão microssegundos)
+	link  uint32 // link type do arquivo
+}
+
+// NewReader lê o cabeçalho pcap de r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotPcap
+		}
+		return nil, err
+	}
+	rd := &Reader{r: br}
+	switch {
+	case binary.LittleEndian.Uint32(hdr) == magicMicros:
+		rd.order = binary.LittleEndian
+	case binary.LittleEndian.Uint32(hdr) == magicNanos:
+		rd.order, rd.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr) == magicMicros:
+		rd.order = binary.BigEndian
+	case binary.BigEndian.Uint32(hdr) == magicNanos:
+		rd.order, rd.nanos = binary.BigEndian, true
+	default:
+		return nil, ErrNotPcap
+	}
+	rd.link = rd.order.Uint32(hdr[20:24]) & 0x0fffffff // bits altos: FCS
+	switch rd.link {
+	case LinkNull, LinkEthernet, LinkRaw, LinkSLL, LinkIPv4, LinkIPv6, LinkSLL2:
+	default:
+		return nil, fmt.Errorf("capture: link type %d não suportado", rd.link)
+	}
+	return rd, nil
+}
+
+// Next retorna o próximo datagrama UDP (io.EOF no fim do arquivo).
+func (r *Reader) Next() (Record, error) {
+	hdr := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r.r, hdr); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return Record{}, fmt.Errorf("capture: registro truncado: %w", err)
+			}
+			return Record{}, err
+		}
+		n := r.order.Uint32(hdr[8:12])
+		if n > 1<<24 {
+			return Record{}, fmt.Errorf("capture: registro de %d bytes", n)
+		}
+		pkt := make([]byte, n)
+		if _, err := io.ReadFull(r.r, pkt); err != nil {
+			return Record{}, fmt.Errorf("capture: registro truncado: %w", err)
+		}
+		frac := int64(r.order.Uint32(hdr[4:8]))
+		if !r.nanos {
+			frac *= 1000
+		}
+		ts := time.Unix(int64(r.order.Uint32(hdr[0:4])), frac)
+		if rec, ok := r.decode(pkt); ok {
+			rec.Time = ts
+			return rec, nil
+		}
+	}
+}
+
+// extrai o datagrama UDP de um registro, conforme o link type
+func (r *Reader) decode(pkt []byte) (Record, bool) {
+	switch r.link {
+	case LinkNull:
+		if len(pkt) < 4 {
+			return Record{}, false
+		}
+		// família na ordem de quem capturou: 2 = AF_INET; 24, 28 ou 30 = AF_INET6
+		fam := binary.LittleEndian.Uint32(pkt)
+		if fam > 0xffff {
+			fam = binary.BigEndian.Uint32(pkt)
+		}
+		if fam != 2 && fam != 24 && fam != 28 && fam != 30 {
+			return Record{}, false
+		}
+		return decodeIP(pkt[4:])
+	case LinkEthernet:
+		if len(pkt) < 14 {
+			return Record{}, false
+		}
+		typ, off := binary.BigEndian.Uint16(pkt[12:14]), 14
+		for (typ == 0x8100 || typ == 0x88a8) && len(pkt) >= off+4 {
+			typ, off = binary.BigEndian.Uint16(pkt[off+2:off+4]), off+4
+		}
+		if typ != 0x0800 && typ != 0x86dd {
+			return Record{}, false
+		}
+		return decodeIP(pkt[off:])
+	case LinkSLL:
+		if len(pkt) < 16 || !isIPType(binary.BigEndian.Uint16(pkt[14:16])) {
+			return Record{}, false
+		}
+		return decodeIP(pkt[16:])
+	case LinkSLL2:
+		if len(pkt) < 20 || !isIPType(binary.BigEndian.Uint16(pkt[0:2])) {
+			return Record{}, false
+		}
+		return decodeIP(pkt[20:])
+	}
+	return decodeIP(pkt)
+}
+
+// informa se o ethertype é IPv4 ou IPv6
+func isIPType(t uint16) bool { return t == 0x0800 || t == 0x86dd }
+
+// extrai o datagrama UDP de um pacote IPv4 ou IPv6 (fragmentos além do
+// primeiro e pacotes de outros protocolos são ignorados)
+func decodeIP(p []byte) (Record, bool) {
+	if len(p) < 1 {
+		return Record{}, false
+	}
+	var src, dst netip.Addr
+	var udp []byte
+	switch p[0] >> 4 {
+	case 4:
+		ihl := int(p[0]&0x0f) * 4
+		if len(p) < 20 || ihl < 20 || len(p) < ihl || p[9] != 17 {
+			return Record{}, false
+		}
+		if binary.BigEndian.Uint16(p[6:8])&0x1fff != 0 {
+			return Record{}, false // fragmento sem o cabeçalho UDP
+		}
+		end := min(int(binary.BigEndian.Uint16(p[2:4])), len(p))
+		src, dst = netip.AddrFrom4([4]byte(p[12:16])), netip.AddrFrom4([4]byte(p[16:20]))
+		udp = p[ihl:max(end, ihl)]
+	case 6:
+		if len(p) < 40 || p[6] != 17 {
+			return Record{}, false // extensões do IPv6 não são percorridas
+		}
+		end := min(40+int(binary.BigEndian.Uint16(p[4:6])), len(p))
+		src, dst = netip.AddrFrom16([16]byte(p[8:24])), netip.AddrFrom16([16]byte(p[24:40]))
+		udp = p[40:end]
+	default:
+		return Record{}, false
+	}
+	if len(udp) < 8 {
+		return Record{}, false
+	}
+	end := min(max(int(binary.BigEndian.Uint16(udp[4:6])), 8), len(udp))
+	return Record{
+		Src:  netip.AddrPortFrom(src.Unmap(), binary.BigEndian.Uint16(udp[0:2])),
+		Dst:  netip.AddrPortFrom(dst.Unmap(), binary.BigEndian.Uint16(udp[2:4])),
+		Data: udp[8:end],
+	}, true
+}
+
+// ReadFile lê todos os datagramas UDP do arquivo pcap path.
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
@@ -0,0 +1,230 @@
+package clientudp
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "udp/internal/protocol"
+)
+
+// Define um download em lote: vários arquivos, padrões ou diretórios
+// remotos, com transferências simultâneas sobre um ou mais sockets.
+type BatchConfig struct {
+    Config             // Modelo de cada transferência (Host, Port, Timeout, Retries, FEC, MTU, Resume, Cancel, PSK); Path, OutputPath e Drop são definidos por arquivo
+    Paths     []string // Itens no servidor: arquivo, padrão de path.Match (ex.: logs/*.gz) ou diretório terminado em '/' ("" ou "/" = diretório base), espelhado com toda a árvore
+    OutputDir string   // Diretório local de saída ("" = diretório atual)
+    Parallel  int      // Transferências simultâneas (padrão 4)
+    Sockets   int      // Sockets UDP repartidos entre as transferências (padrão 1)
+    DropRate  float64  // Simulação de perda, com uma política por arquivo (0 = sem perdas)
+}
+
+// BatchProgress resume o andamento de um lote.
+type BatchProgress struct {
+    Files      int    // Files é a quantidade de arquivos do lote
+    Done       int    // Done conta os arquivos concluídos com SHA-256 conferido
+    Failed     int    // Failed conta os arquivos que falharam
+    Bytes      uint64 // Bytes soma os bytes recebidos de todos os arquivos
+    TotalBytes uint64 // TotalBytes soma os tamanhos conhecidos (da listagem ou do META)
+}
+
+// um arquivo do lote
+type batchJob struct {
+    path string // caminho no servidor
+    out  string // caminho local de saída
+    size int64  // tamanho pela listagem (-1 se desconhecido)
+}
+
+// RunBatch baixa os itens de cfg.Paths para cfg.OutputDir com até
+// cfg.Parallel transferências simultâneas. Os Callbacks são chamados de um
+// evento por vez: OnMeta, OnFileProgress e OnFileDone por arquivo, OnLog com
+// o caminho do arquivo como prefixo, OnProgress e OnBatchProgress com os
+// totais do lote e OnDone ao final, com o diretório de saída e se todos os
+// arquivos foram concluídos. Retorna erro se algum arquivo falhou.
+func RunBatch(cfg BatchConfig, cb Callbacks) error {
+    if cfg.Parallel <= 0 { cfg.Parallel = 4 }
+    if cfg.Sockets <= 0 { cfg.Sockets = 1 }
+    if cfg.OutputDir == "" { cfg.OutputDir = "." }
+    var mu sync.Mutex // serializa os callbacks e protege o progresso
+    logf := func(format string, args ...any) {
+        if cb.OnLog != nil { mu.Lock(); cb.OnLog(fmt.Sprintf(format, args...)); mu.Unlock() }
+    }
+    finish := func(err error) error {
+        if err != nil { logf("ERRO: %v", err) }
+        if cb.OnDone != nil { mu.Lock(); cb.OnDone(cfg.OutputDir, err == nil); mu.Unlock() }
+        return err
+    }
+
+    conns := make([]*Conn, 0, cfg.Sockets)
+    defer func() { for _, c := range conns { c.Close() } }()
+    for range cfg.Sockets {
+        c, err := cfg.dial()
+        if err != nil { return finish(err) }
+        conns = append(conns, c)
+    }
+    jobs, err := expandBatch(conns[0], cfg, logf)
+    if err != nil { return finish(err) }
+    if len(jobs) == 0 { return finish(errors.New("nenhum arquivo a baixar")) }
+
+    prog := BatchProgress{Files: len(jobs)}
+    got := make([]uint64, len(jobs)) // bytes recebidos por arquivo
+    for _, j := range jobs { if j.size > 0 { prog.TotalBytes += uint64(j.size) } }
+    var segs uint64
+    report := func() { // com mu adquirido
+        if cb.OnProgress != nil { cb.OnProgress(prog.Bytes, segs) }
+        if cb.OnBatchProgress != nil { cb.OnBatchProgress(prog) }
+    }
+    logf("STATUS: Lote de %d arquivos (%d simultâneos, %d sockets) em %s", len(jobs), cfg.Parallel, len(conns), cfg.OutputDir)
+
+    queue := make(chan int)
+    var wg sync.WaitGroup
+    for range cfg.Parallel {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range queue {
+                j := jobs[i]
+                fcfg := cfg.Config
+                fcfg.Path, fcfg.OutputPath = j.path, j.out
+                fcfg.Drop = NewDrop(cfg.DropRate, time.Now().UnixNano()+int64(i))
+                var lastSegs uint64
+                fcb := Callbacks{
+                    OnLog: func(s string) { logf("[%s] %s", j.path, s) },
+                    OnMeta: func(m protocol.Meta) {
+                        mu.Lock(); defer mu.Unlock()
+                        if j.size < 0 { prog.TotalBytes += uint64(m.Size) }
+                        if cb.OnMeta != nil { cb.OnMeta(m) }
+                    },
+                    OnProgress: func(b, s uint64) {
+                        mu.Lock(); defer mu.Unlock()
+                        prog.Bytes += b - got[i]
+                        segs += s - lastSegs
+                        got[i], lastSegs = b, s
+                        if cb.OnFileProgress != nil { cb.OnFileProgress(j.path, b) }
+                        report()
+                    },
+                    OnDone: func(out string, ok bool) {
+                        mu.Lock(); defer mu.Unlock()
+                        if ok { prog.Done++ } else { prog.Failed++ }
+                        if cb.OnFileDone != nil { cb.OnFileDone(j.path, out, ok) }
+                        report()
+                    },
+                }
+                if err := os.MkdirAll(filepath.Dir(j.out), 0o755); err != nil {
+                    fcb.OnLog("ERRO: " + err.Error())
+                    fcb.OnDone("", false)
+                    continue
+                }
+                runTransfer(conns[i%len(conns)], fcfg, fcb)
+            }
+        }()
+    }
+feed:
+    for i := range jobs {
+        select {
+        case queue <- i:
+        case <-cfg.Cancel:
+            break feed
+        }
+    }
+    close(queue)
+    wg.Wait()
+    canceled := false
+    select {
+    case <-cfg.Cancel:
+        canceled = true
+    default:
+    }
+
+    mu.Lock()
+    p := prog
+    mu.Unlock()
+    logf("STATUS: Lote finalizado: %d concluídos, %d com falha, %d não iniciados, %d bytes", p.Done, p.Failed, p.Files-p.Done-p.Failed, p.Bytes)
+    switch {
+    case canceled:
+        return finish(ErrCancelled)
+    case p.Failed > 0:
+        return finish(fmt.Errorf("%d de %d arquivos falharam", p.Failed, p.Files))
+    }
+    return finish(nil)
+}
+
+// expande os itens do lote em arquivos, listando no servidor os diretórios e
+// os padrões; cada saída fica em OutputDir no caminho relativo ao diretório
+// do item (o próprio diretório espelhado, ou o diretório fixo que antecede o
+// primeiro curinga).
+func expandBatch(c *Conn, cfg BatchConfig, logf func(string, ...any)) ([]batchJob, error) {
+    var jobs []batchJob
+    seen := make(map[string]bool) // saídas já atribuídas
+    add := func(remote, rel string, size int64) {
+        local := filepath.FromSlash(rel)
+        if !filepath.IsLocal(local) {
+            logf("WARN: ignorando %s: caminho de saída inválido", remote)
+            return
+        }
+        out := filepath.Join(cfg.OutputDir, local)
+        if seen[out] {
+            logf("WARN: ignorando %s: saída %s já usada por outro arquivo do lote", remote, out)
+            return
+        }
+        seen[out] = true
+        jobs = append(jobs, batchJob{path: remote, out: out, size: size})
+    }
+    list := func(dir string, recursive bool) ([]protocol.Entry, error) {
+        var entries []protocol.Entry
+        for e, err := range c.List(ListConfig{Dir: dir, Recursive: recursive, Timeout: cfg.Timeout, Retries: cfg.Retries}) {
+            if err != nil { return nil, fmt.Errorf("listagem de %q: %w", dir, err) }
+            entries = append(entries, e)
+        }
+        return entries, nil
+    }
+    for _, item := range cfg.Paths {
+        item = strings.TrimPrefix(strings.TrimSpace(item), "/")
+        switch {
+        case item == "" || strings.HasSuffix(item, "/"):
+            // diretório: espelha a árvore inteira, inclusive subdiretórios vazios
+            dir := strings.TrimSuffix(item, "/")
+            entries, err := list(dir, true)
+            if err != nil { return nil, err }
+            for _, e := range entries {
+                rel := strings.TrimPrefix(e.Name, dir+"/")
+                if dir == "" { rel = e.Name }
+                if e.IsDir() {
+                    if local := filepath.FromSlash(rel); filepath.IsLocal(local) { _ = os.MkdirAll(filepath.Join(cfg.OutputDir, local), 0o755) }
+                    continue
+                }
+                add(e.Name, rel, e.Size)
+            }
+        case hasGlobMeta(item):
+            if _, err := path.Match(item, ""); err != nil { return nil, fmt.Errorf("padrão inválido %q: %w", item, err) }
+            // lista a partir do diretório fixo antes do primeiro curinga; desce
+            // na árvore só se o padrão tiver componentes depois dele
+            parts := strings.Split(item, "/")
+            k := 0
+            for !hasGlobMeta(parts[k]) { k++ }
+            dir := strings.Join(parts[:k], "/")
+            entries, err := list(dir, k < len(parts)-1)
+            if err != nil { return nil, err }
+            n := 0
+            for _, e := range entries {
+                if ok, _ := path.Match(item, e.Name); !ok || e.IsDir() { continue }
+                rel := e.Name
+                if dir != "" { rel = strings.TrimPrefix(e.Name, dir+"/") }
+                add(e.Name, rel, e.Size)
+                n++
+            }
+            if n == 0 { logf("WARN: nenhum arquivo corresponde a %s", item) }
+        default:
+            add(item, path.Base(item), -1)
+        }
+    }
+    return jobs, nil
+}
+
+// informa se s contém curingas de path.Match.
+func hasGlobMeta(s string) bool { return strings.ContainsAny(s, `*?[\`) }
@@ -0,0 +1,49 @@
+package clientudp
+
+import (
+    "errors"
+    "fmt"
+    "math/rand/v2"
+    "time"
+
+    "udp/internal/config"
+    "udp/internal/protocol"
+)
+
+// backoff entre pedidos recusados com ERR BUSY
+const (
+    busyBackoffBase = 500 * time.Millisecond // espera da primeira repetição sem dica do servidor
+    busyBackoffMax  = 30 * time.Second       // maior espera entre repetições
+)
+
+// espera antes da repetição attempt (1, 2, ...) de um pedido recusado com a
+// dica hint: o backoff exponencial, limitado a busyBackoffMax, nunca abaixo
+// da dica, que é um piso (o teto não a corta). Até 20% aleatórios são
+// somados acima disso para que clientes recusados juntos não voltem juntos.
+func busyDelay(hint time.Duration, attempt int) time.Duration {
+    d := busyBackoffBase << min(attempt-1, 16)
+    d = max(min(d, busyBackoffMax), hint)
+    return d + time.Duration(rand.Int64N(int64(d)/5+1))
+}
+
+// executa op e a repete enquanto o servidor recusar o pedido por estar
+// ocupado (ERR BUSY), no máximo retries vezes (<= 0 = config.DefaultRetries),
+// aguardando busyDelay entre as tentativas. Fechar cancel durante uma espera
+// encerra com ErrCancelled.
+func retryBusy(retries int, cancel <-chan struct{}, logf func(string), op func() error) error {
+    if retries <= 0 { retries = config.DefaultRetries }
+    for attempt := 1; ; attempt++ {
+        err := op()
+        var se *ServerError
+        if !errors.As(err, &se) || se.Code != protocol.ErrCodeBusy || attempt > retries { return err }
+        d := busyDelay(se.RetryAfter, attempt)
+        if logf != nil { logf(fmt.Sprintf("WARN: %s; nova tentativa em %v (%d/%d)", se.Message, d.Round(time.Millisecond), attempt, retries)) }
+        t := time.NewTimer(d)
+        select {
+        case <-t.C:
+        case <-cancel:
+            t.Stop()
+            return ErrCancelled
+        }
+    }
+}
@@ -0,0 +1,156 @@
+package clientudp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"udp/internal/protocol"
+	"udp/internal/serverudp"
+)
+
+// A dica do servidor é um piso que o teto do backoff não corta; sem dica
+// vale o backoff exponencial, limitado a busyBackoffMax. O acréscimo
+// aleatório fica entre 0 e 20% acima desse valor.
+func TestBusyDelay(t *testing.T) {
+	tests := []struct {
+		hint    time.Duration
+		attempt int
+		want    time.Duration // espera sem o acréscimo aleatório
+	}{
+		{0, 1, busyBackoffBase},
+		{0, 3, 4 * busyBackoffBase},
+		{0, 40, busyBackoffMax},
+		{2 * time.Second, 1, 2 * time.Second},
+		{2 * time.Second, 4, 4 * time.Second},
+		{45 * time.Second, 1, 45 * time.Second},
+		{45 * time.Second, 40, 45 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			d := busyDelay(tt.hint, tt.attempt)
+			if d < tt.want || d > tt.want+tt.want/5 {
+				t.Fatalf("busyDelay(%v, %d) = %v, esperado entre %v e %v", tt.hint, tt.attempt, d, tt.want, tt.want+tt.want/5)
+			}
+		}
+	}
+}
+
+// servidor em loopback com os limites de opts servindo a.bin; devolve uma
+// conexão do cliente com ele
+func busyServer(t *testing.T, opts serverudp.Options) *Conn {
+	t.Helper()
+	opts.BaseDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(opts.BaseDir, "a.bin"), bytes.Repeat([]byte("ocupado "), 1<<10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serverudp.New(opts).Serve(ctx, uc)
+		close(done)
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+	c, err := Dial("127.0.0.1", uc.LocalAddr().(*net.UDPAddr).Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// pede a.bin num fluxo novo de c, que fica aberto até o fim do teste
+func requestFile(t *testing.T, c *Conn) (*stream, error) {
+	st := c.openStream(nil)
+	t.Cleanup(st.close)
+	_, err := awaitMeta(st, protocol.CtrlREQ(protocol.Req{Token: st.token, Path: "a.bin"}), protocol.TypeREQ, time.Second, 1, Callbacks{})
+	return st, err
+}
+
+// Admissão em loopback: com o limite ocupado, o pedido seguinte recebe ERR
+// BUSY com a espera configurada, que chega a ServerError.RetryAfter e serve
+// de piso para busyDelay; quando as transferências admitidas terminam (EOF
+// enviado e cliente em silêncio), a vaga admite o pedido repetido.
+func TestAdmissionBusy(t *testing.T) {
+	const limit = 2
+	const retryAfter = 700 * time.Millisecond
+	tests := []struct {
+		name string
+		opts serverudp.Options
+		msg  string // trecho da mensagem da recusa
+	}{
+		{"MaxTransfers", serverudp.Options{MaxTransfers: limit, RetryAfter: retryAfter}, "transferências simultâneas"},
+		{"MaxPerIP", serverudp.Options{MaxPerIP: limit, RetryAfter: retryAfter}, "por IP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := busyServer(t, tt.opts)
+			var held []*stream
+			for i := range limit {
+				st, err := requestFile(t, c)
+				if err != nil {
+					t.Fatalf("pedido %d dentro do limite: %v", i+1, err)
+				}
+				held = append(held, st)
+			}
+
+			_, err := requestFile(t, c)
+			var se *ServerError
+			if !errors.As(err, &se) || se.Code != protocol.ErrCodeBusy {
+				t.Fatalf("pedido além do limite: erro %v, esperado ERR BUSY", err)
+			}
+			if !errors.Is(err, ErrBusy) || !strings.Contains(se.Message, tt.msg) {
+				t.Errorf("recusa %q, esperado ErrBusy com %q", se.Message, tt.msg)
+			}
+			if se.RetryAfter != retryAfter {
+				t.Fatalf("RetryAfter = %v, esperado %v", se.RetryAfter, retryAfter)
+			}
+			for attempt := 1; attempt <= 3; attempt++ {
+				if d := busyDelay(se.RetryAfter, attempt); d < retryAfter {
+					t.Errorf("busyDelay(%v, %d) = %v abaixo da dica", se.RetryAfter, attempt, d)
+				}
+			}
+
+			// as transferências admitidas terminam: o servidor envia tudo e o EOF
+			for i, st := range held {
+				for {
+					b, err := st.read(5 * time.Second)
+					if err != nil {
+						t.Fatalf("transferência %d sem EOF: %v", i+1, err)
+					}
+					if typ, _, err := protocol.DecodeCtrl(b); protocol.IsCtrl(b) && err == nil && typ == protocol.TypeEOF {
+						break
+					}
+				}
+			}
+			var tries []time.Time
+			err = retryBusy(10, nil, nil, func() error {
+				tries = append(tries, time.Now())
+				_, err := requestFile(t, c)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("pedido repetido após o fim das transferências: %v", err)
+			}
+			if len(tries) < 2 {
+				t.Fatal("pedido admitido sem recusa: as transferências terminadas não ocupavam a vaga")
+			}
+			for i := 1; i < len(tries); i++ {
+				if gap := tries[i].Sub(tries[i-1]); gap < retryAfter {
+					t.Errorf("repetição %d após %v, antes da dica %v", i, gap, retryAfter)
+				}
+			}
+		})
+	}
+}
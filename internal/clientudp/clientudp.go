@@ -3,18 +3,23 @@
 package clientudp
 
 import (
-    "errors"
+    "context"
     "fmt"
     "math/rand"
-    "net"
     "os"
     "path/filepath"
     "strings"
     "sync/atomic"
     "time"
 
-    "udp/internal/config"
+    "udp/internal/capture"
+    "udp/internal/codec"
+    "udp/internal/congestion"
+    "udp/internal/impair"
+    "udp/internal/logger"
+    "udp/internal/metrics"
     "udp/internal/protocol"
+    "udp/internal/segfile"
 )
 
 // Política de descarte para simulação de perda de segmentos
@@ -48,12 +53,22 @@ func (d *DropPolicy) ShouldDrop(seq uint32) bool {
     return false
 }
 
-// Reúne funções de retorno para eventos da transferência.
+// sorteia o descarte de uma paridade FEC (nunca retransmitida, sem registro).
+func (d *DropPolicy) shouldDropParity() bool {
+    return d != nil && d.rate > 0 && d.rnd.Float64() < d.rate
+}
+
+// Reúne funções de retorno para eventos da transferência. Os campos
+// OnFile* e OnBatchProgress só são usados nos downloads em lote (RunBatch).
 type Callbacks struct {
     OnMeta     func(protocol.Meta)            // OnMeta é chamado ao receber META
     OnProgress func(bytes uint64, segs uint64) // OnProgress reporta bytes/segmentos acumulados
     OnLog      func(string)                    // OnLog registra mensagens do processo
     OnDone     func(string, bool)              // OnDone informa saída e sucesso de SHA-256
+
+    OnFileProgress  func(path string, bytes uint64)         // OnFileProgress reporta os bytes recebidos de um arquivo do lote
+    OnFileDone      func(path, out string, ok bool)         // OnFileDone informa a saída e o sucesso de um arquivo do lote
+    OnBatchProgress func(BatchProgress)                     // OnBatchProgress reporta os totais do lote
 }
 
 // Define parâmetros de uma transferência.
@@ -66,53 +81,63 @@ type Config struct {
     Retries    int           // Número de tentativas (timeouts + rounds NACK)
     OutputPath string        // Caminho de saída opcional; se vazio usa recv_<filename>
     Cancel     <-chan struct{} // Canal opcional para cancelamento assíncrono
+    Resume     bool          // Retoma um download parcial (<saída>.part + sidecar), se existir
+    FECData    int           // FEC: segmentos de dados por bloco (0 = sem FEC)
+    FECParity  int           // FEC: segmentos de paridade por bloco
+    MaxDatagram int          // Maior datagrama DATA aceito, proposto no REQ (0 = padrão do servidor)
+    ProbeMTU   bool          // Descobre o MTU do caminho antes do REQ (limitado por MaxDatagram, se informado)
+    PSK        []byte        // Chave pré-compartilhada do modo cifrado (nil = sem cifra); usada por RunTransfer ao abrir o socket
+    Codecs     protocol.CodecSet // Codecs de compressão aceitos (ex.: codec.All; 0 = sem compressão); só com servidores v3
+    Metrics    *metrics.TransferMetrics // Métricas atualizadas durante a transferência (nil = criadas internamente; ver Result.Metrics)
+    Events     *logger.EventLog // Eventos estruturados da transferência em JSON lines (nil = descartados)
+    Capture    *capture.Writer  // Grava os datagramas do socket aberto por RunTransfer/Download em pcap (nil = sem captura)
+    Impair     *impair.Impairer // Degrada o tráfego do socket aberto por RunTransfer/Download (nil = rede real)
 }
 
-// agrupa os acumuladores e o mapa de recebimento.
+// agrupa os acumuladores e o armazenamento em disco da recepção.
 type recvState struct {
-    recv      map[uint32][]byte // recv armazena payloads recebidos por sequência
-    bytesRecv *uint64           // bytesRecv acumula bytes válidos recebidos
-    segsRecv  *uint64           // segsRecv conta segmentos válidos recebidos
+    sink      *segfile.Sink // sink grava os payloads direto no arquivo temporário
+    bytesRecv *uint64       // bytesRecv acumula bytes válidos recebidos
+    segsRecv  *uint64       // segsRecv conta segmentos válidos recebidos
+    ckpt      *checkpointer // ckpt persiste o estado parcial para retomada
+    fb        *congestion.Reporter // fb relata recepção e perdas ao servidor (FBK)
+    fec       *fecState     // fec reconstrói segmentos a partir da paridade (nil sem FEC)
+    res       *Result       // res acumula os contadores de rounds de NACK e retransmissões
+    codec     protocol.Codec // codec dos segmentos com DataFlagCompressed (META)
+    mtr       *metrics.TransferMetrics    // mtr acumula as métricas da transferência (Result.Metrics)
+    perf      *metrics.PerformanceMonitor // perf amostra a velocidade de recepção em mtr
+    ev        *transferEvents // ev registra EOF e rounds de NACK no log de eventos
 }
 
 func ctrlType(b []byte) string { return "" }
 
-// Retorna as sequências faltantes dado o total esperado.
-func computeMissing(total uint32, recv map[uint32][]byte) []uint32 {
-    // missing acumula as sequências não presentes em recv
-    missing := make([]uint32, 0) // lista de sequências faltantes
-    for i := uint32(0); i < total; i++ { 
-        if _, ok := recv[i]; !ok { 
-            missing = append(missing, i) 
-        }
-    }
-    return missing
-}
-
 // Processa um datagrama recebido, atualizando progresso e
 // retornando true se for um EOF.
-func processPacket(b []byte, cfg Config, cb Callbacks, recv map[uint32][]byte, bytesRecv *uint64, segsRecv *uint64) (isEOF bool) {
+func processPacket(b []byte, cfg Config, cb Callbacks, st recvState) (isEOF bool) {
     if protocol.IsCtrl(b) {
         typ, _, err := protocol.DecodeCtrl(b)
         if err == nil && typ == protocol.TypeEOF { return true }
         return false
     }
-    // h é o cabeçalho DATA extraído do buffer
-        h, err := protocol.UnpackHeader(b[:protocol.HeaderSize()]) // cabeçalho extraído
+    // h é o cabeçalho DATA extraído do buffer (v1 ou atual)
+        h, err := protocol.UnpackHeader(b) // cabeçalho extraído
     if err != nil { return false }
     // payload contém os dados do segmento
-        payload := b[protocol.HeaderSize():] // dados do segmento
+        payload := b[h.Len():] // dados do segmento
     
-    if len(b) < protocol.HeaderSize() + int(h.Size) {
+    if len(b) < h.Len() + int(h.Size) {
         if cb.OnLog != nil { 
             cb.OnLog(fmt.Sprintf("ERRO: buffer insuficiente seq=%d: tem %d, precisa %d+%d", 
-                h.Seq, len(b), protocol.HeaderSize(), h.Size)) 
+                h.Seq, len(b), h.Len(), h.Size)) 
         }
         return false 
     }
+    // sem sessão, um DATA v1 atrasado de uma transferência anterior só se
+    // distingue pelo total de segmentos (o SHA-256 final é a garantia)
+    if h.Version == protocol.LegacyVersion && h.Total != st.sink.Received().Len() { return false }
     
     // Extrair exatamente h.Size bytes como payload
-    payload = b[protocol.HeaderSize():protocol.HeaderSize() + int(h.Size)]
+    payload = b[h.Len():h.Len() + int(h.Size)]
     
     if len(payload) != int(h.Size) { 
         if cb.OnLog != nil {
@@ -121,7 +146,8 @@ func processPacket(b []byte, cfg Config, cb Callbacks, recv map[uint32][]byte, b
         }
         return false 
     }
-    if cfg.Drop != nil && cfg.Drop.ShouldDrop(h.Seq) { if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("DROP seq=%d", h.Seq)) }; return false }
+    if h.Flags&protocol.DataFlagParity != 0 && cfg.Drop.shouldDropParity() { if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("DROP paridade=%d", h.Seq)) }; return false }
+    if h.Flags&protocol.DataFlagParity == 0 && cfg.Drop != nil && cfg.Drop.ShouldDrop(h.Seq) { if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("DROP seq=%d", h.Seq)) }; return false }
     
     computedCRC32 := protocol.CRC32(payload)
     if computedCRC32 != h.CRC32 { 
@@ -129,72 +155,111 @@ func processPacket(b []byte, cfg Config, cb Callbacks, recv map[uint32][]byte, b
             cb.OnLog(fmt.Sprintf("ERRO: CRC32 seq=%d: esperado %08X, computado %08X (size=%d)", 
                 h.Seq, h.CRC32, computedCRC32, len(payload)))
         }
+        st.mtr.AddError()
         return false 
     }
+    if h.Flags&protocol.DataFlagCompressed != 0 && h.Flags&protocol.DataFlagParity == 0 {
+        // o restante da recepção (disco, FEC) trabalha com o segmento original
+        if h.Seq >= st.sink.Received().Len() { return false }
+        if payload, err = codec.Decompress(st.codec, payload, st.sink.SegmentLen(h.Seq)); err != nil {
+            if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("ERRO: descompressão seq=%d: %v", h.Seq, err)) }
+            st.mtr.AddError()
+            return false
+        }
+    }
+    if h.Flags&protocol.DataFlagParity != 0 {
+        st.fb.OnParity(h.Seq)
+        st.fec.onParity(h.Seq, payload, cb, st)
+        return false
+    }
+    st.fb.OnData(h.Seq)
     
-    if _, ok := recv[h.Seq]; ok { 
+    isNew, err := st.sink.WriteChunk(h.Seq, payload)
+    if err != nil {
+        if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("ERRO: gravação seq=%d: %v", h.Seq, err)) }
+        st.mtr.AddError()
+        return false
+    }
+    if !isNew { 
         return false 
     }
-    recv[h.Seq] = append([]byte(nil), payload...)
-    atomic.AddUint64(bytesRecv, uint64(len(payload)))
-    atomic.AddUint64(segsRecv, 1)
+    atomic.AddUint64(st.bytesRecv, uint64(len(payload)))
+    atomic.AddUint64(st.segsRecv, 1)
+    st.mtr.AddBytesReceived(uint64(len(payload)))
+    st.perf.Update()
+    if err := st.checkpoint(false); err != nil && cb.OnLog != nil {
+        cb.OnLog("WARN: falha ao salvar estado parcial: " + err.Error())
+    }
     if cb.OnLog != nil && h.Seq % 500 == 0 { cb.OnLog(fmt.Sprintf("STATUS: progresso seq=%d/%d", h.Seq, h.Total-1)) }
-    if cb.OnProgress != nil { cb.OnProgress(atomic.LoadUint64(bytesRecv), atomic.LoadUint64(segsRecv)) }
+    if cb.OnProgress != nil { cb.OnProgress(atomic.LoadUint64(st.bytesRecv), atomic.LoadUint64(st.segsRecv)) }
     return false
 }
 
 // Envia REQ e aguarda META (ou ERR) com retries.
-func sendREQAndGetMeta(conn *net.UDPConn, cfg Config, cb Callbacks) (protocol.Meta, error) {
+func sendREQAndGetMeta(st *stream, req protocol.Req, cfg Config, cb Callbacks) (protocol.Meta, error) {
+    meta, err := awaitMeta(st, protocol.CtrlREQ(req), protocol.TypeREQ, cfg.Timeout, cfg.Retries, cb)
+    if err != nil { return protocol.Meta{}, err }
+    if cb.OnLog != nil && req.FECData > 0 {
+        if meta.FECData > 0 {
+            cb.OnLog(fmt.Sprintf("STATUS: FEC ativo: %d paridades a cada %d segmentos", meta.FECParity, meta.FECData))
+        } else {
+            cb.OnLog("WARN: servidor não aceitou FEC; perdas serão recuperadas só por NACK")
+        }
+    }
+    if cb.OnMeta != nil { cb.OnMeta(meta) }
+    return meta, nil
+}
+
+// Envia o pedido pkt (REQ ou PUT, conforme kind) e aguarda META (ou ERR)
+// com retries, associando o fluxo à sessão atribuída.
+func awaitMeta(st *stream, pkt []byte, kind string, timeout time.Duration, retries int, cb Callbacks) (protocol.Meta, error) {
     // Número de tentativas: primeira + (Retries-1) reenviando.
-    attempts := cfg.Retries
+    attempts := retries
     if attempts <= 0 { attempts = 3 }
     if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("STATUS: Solicitando META (até %d tentativas)", attempts)) }
-    var meta protocol.Meta
     for try := 1; try <= attempts; try++ {
-        if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("STATUS: Enviando REQ tentativa %d/%d", try, attempts)) }
-        if _, err := conn.Write(protocol.CtrlREQ(cfg.Path)); err != nil {
+        if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("STATUS: Enviando %s tentativa %d/%d", kind, try, attempts)) }
+        if err := st.write(pkt); err != nil {
             return protocol.Meta{}, err
         }
-        _ = conn.SetReadDeadline(time.Now().Add(cfg.Timeout))
+        deadline := time.Now().Add(timeout) // prazo desta tentativa
         for {
-            // Suporte a cancelamento durante espera de META
-            if cfg.Cancel != nil {
-                select {
-                case <-cfg.Cancel:
-                    return protocol.Meta{}, errors.New("transferência cancelada")
-                default:
-                }
-            }
-            buf := make([]byte, 4096)
-            n, _, err := conn.ReadFromUDP(buf)
+            b, err := st.read(time.Until(deadline))
+            if err == ErrCancelled || err == errClosed { return protocol.Meta{}, err }
             if err != nil {
                 // Timeout desta tentativa -> sair do loop interno e partir para próxima tentativa
                 if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("WARN: Timeout aguardando META (tentativa %d)", try)) }
                 break
             }
-            if !protocol.IsCtrl(buf[:n]) { continue }
-            typ, val, e := protocol.DecodeCtrl(buf[:n])
+            if !protocol.IsCtrl(b) { continue }
+            typ, val, e := protocol.DecodeCtrl(b)
             if e != nil { continue }
             switch typ {
             case protocol.TypeMETA:
-                meta = val.(protocol.Meta)
-                if cb.OnMeta != nil { cb.OnMeta(meta) }
+                meta := val.(protocol.Meta)
+                if st.legacy {
+                    if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("STATUS: META v1 recebido, sem sessão (segmentos de %d bytes)", meta.Chunk)) }
+                    return meta, nil
+                }
+                st.bind(meta.Session)
+                if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("STATUS: Sessão %08x atribuída pelo servidor (segmentos de %d bytes)", meta.Session, meta.Chunk)) }
                 return meta, nil
             case protocol.TypeERR:
                 er := val.(protocol.ErrMsg)
-                if cb.OnLog != nil { cb.OnLog("ERRO: Servidor respondeu ERR: "+er.Message) }
-                return protocol.Meta{}, errors.New(er.Message)
+                // recusas por capacidade são relatadas por retryBusy ao repetir o pedido
+                if cb.OnLog != nil && er.Code != protocol.ErrCodeBusy { cb.OnLog("ERRO: Servidor respondeu ERR: "+er.Message) }
+                return protocol.Meta{}, &ServerError{Code: er.Code, Message: er.Message, RetryAfter: er.RetryAfter}
             default:
                 // outro controle não esperado => ignora e continua aguardando META / timeout
             }
         }
     }
-    return protocol.Meta{}, errors.New("falha ao obter META: tentativas esgotadas")
+    return protocol.Meta{}, timeoutf("META não recebido após %d tentativas", attempts)
 }
 
 // Lê pacotes até encontrar EOF ou período de inatividade
 // após ter recebido algum dado, respeitando o limite maxIdle.
-func receiveUntilIdleOrEOF(conn *net.UDPConn, cfg Config, cb Callbacks, st recvState, maxIdle int) (bool, error) {
+func receiveUntilIdleOrEOF(sm *stream, cfg Config, cb Callbacks, st recvState, maxIdle int) (bool, error) {
     // eof indica se EOF foi encontrado
         eof := false       // sinaliza recebimento de EOF
     // idleCount conta timeouts consecutivos
@@ -202,97 +267,128 @@ func receiveUntilIdleOrEOF(conn *net.UDPConn, cfg Config, cb Callbacks, st recvS
         if cb.OnLog != nil { cb.OnLog("STATUS: Recebendo dados iniciais") }
     maxIdleIncreased := maxIdle * 3
     for !eof {
-        select {
-        case <-cfg.Cancel:
-            return eof, errors.New("transferência cancelada")
-        default:
-        }
-        // buf armazena o pacote recebido
-            buf := make([]byte, protocol.HeaderSize()+config.ChunkSize) // buffer de recepção
-        n, _, err := conn.ReadFromUDP(buf)
+        b, err := sm.read(cfg.Timeout) // próximo datagrama da sessão
+        if err == ErrCancelled || err == errClosed { return eof, err }
+        flushFeedback(sm, st.fb)
         if err != nil {
             idleCount++
+            st.mtr.AddTimeout()
             if cb.OnLog != nil && idleCount%5 == 0 { // log menos verbose
                 cb.OnLog(fmt.Sprintf("Timeout durante recepção inicial (%d/%d)", idleCount, maxIdleIncreased))
             }
-            if len(st.recv) > 0 && idleCount >= maxIdleIncreased { // inatividade após algum dado
+            if st.sink.Received().Count() > 0 && idleCount >= maxIdleIncreased { // inatividade após algum dado
                     if cb.OnLog != nil { cb.OnLog("STATUS: Ociosidade detectada; iniciando NACK") }
                 break
             }
-            if idleCount > maxIdleIncreased { return eof, errors.New("timeout aguardando dados iniciais") }
-            _ = conn.SetReadDeadline(time.Now().Add(cfg.Timeout))
+            if idleCount > maxIdleIncreased { return eof, timeoutf("nenhum dado recebido após o META") }
             continue
         }
         idleCount = 0
-        if processPacket(buf[:n], cfg, cb, st.recv, st.bytesRecv, st.segsRecv) { eof = true }
+        if processPacket(b, cfg, cb, st) {
+            eof = true
+            st.ev.log.Emit(st.ev.event(logger.EventEOFReceived))
+        }
     }
     return eof, nil
 }
 
+// máximo de sequências solicitadas por round de NACK; listas maiores (ex.:
+// retomada de um download no início) são pedidas ao longo de vários rounds
+const nackWindow = 16 * protocol.MaxNackEntries
+
+// Resume uma lista de sequências para log (10 primeiras e 10 últimas).
+func fmtSeqs(seqs []uint32) string {
+    if len(seqs) > 20 {
+        // cópia: append sobre seqs[:10] sobrescreveria a própria lista enviada no NACK
+        return fmt.Sprint(append(append([]uint32(nil), seqs[:10]...), seqs[len(seqs)-10:]...))
+    }
+    return fmt.Sprint(seqs)
+}
+
+// Envia a lista de faltantes em tantos NACKs quantos forem necessários para
+// respeitar protocol.MaxNackEntries por datagrama (NACKs v1, sem sessão, se
+// a transferência for v1).
+func sendNACKs(sm *stream, session uint32, missing []uint32) error {
+    for len(missing) > 0 {
+        n := min(len(missing), protocol.MaxNackEntries)
+        pkt := protocol.CtrlNACK(session, missing[:n])
+        if sm.legacy { pkt = protocol.CtrlLegacyNACK(missing[:n]) }
+        if err := sm.write(pkt); err != nil { return err }
+        missing = missing[n:]
+    }
+    return nil
+}
+
 // Executa rounds de NACK até não restarem faltantes ou esgotar
-// maxRounds, processando retransmissões recebidas.
-func runNackRounds(conn *net.UDPConn, meta protocol.Meta, cfg Config, cb Callbacks, st recvState, maxRounds int) error {
+// maxRounds rounds consecutivos sem recuperar nenhum segmento,
+// processando retransmissões recebidas.
+func runNackRounds(sm *stream, meta protocol.Meta, cfg Config, cb Callbacks, st recvState, maxRounds int) error {
     // rounds conta quantos NACKs foram enviados
         rounds := 0 // contador de rounds de NACK
+    fruitless := 0 // rounds consecutivos sem recuperação
     for {
         select {
         case <-cfg.Cancel:
-            return errors.New("transferência cancelada")
+            return ErrCancelled
         default:
         }
         // missing contém as sequências ainda faltantes
-        missing := computeMissing(meta.Total, st.recv) // faltantes atuais
+        missing := st.sink.Received().Missing() // faltantes atuais
         if len(missing) == 0 { return nil }
-        if rounds >= maxRounds { 
+        if fruitless >= maxRounds { 
             if cb.OnLog != nil { 
-                cb.OnLog(fmt.Sprintf("ERRO: esgotado retries de NACK; faltando segmentos: %v de total %d", missing, meta.Total)) 
+                cb.OnLog(fmt.Sprintf("ERRO: esgotado retries de NACK; faltando %d segmentos: %s de total %d", len(missing), fmtSeqs(missing), meta.Total)) 
             }
-            return errors.New("esgotado retries de NACK; arquivo incompleto") 
+            return timeoutf("esgotados os rounds de NACK; faltam %d de %d segmentos", len(missing), meta.Total)
         }
         if cb.OnLog != nil { 
-            missingDisplay := missing
-            if len(missing) > 20 {
-                missingDisplay = append(missing[:10], missing[len(missing)-10:]...)
-            }
-            cb.OnLog(fmt.Sprintf("STATUS: NACK round %d; faltando %d segmentos: %v", rounds+1, len(missing), missingDisplay)) 
+            cb.OnLog(fmt.Sprintf("STATUS: NACK round %d; faltando %d segmentos: %s", rounds+1, len(missing), fmtSeqs(missing))) 
         }
-        _, _ = conn.Write(protocol.CtrlNACK(missing))
+        requested := missing[:min(len(missing), nackWindow)] // pedidos neste round
+        _ = sendNACKs(sm, meta.Session, requested)
         // Timeout mais longo para retransmissões de arquivos grandes
-        timeoutMultiplier := 1 + len(missing)/100 // mais tempo para muitos faltantes
+        timeoutMultiplier := 1 + len(requested)/100 // mais tempo para muitos faltantes
         if timeoutMultiplier > 5 { timeoutMultiplier = 5 }
         extendedTimeout := cfg.Timeout * time.Duration(timeoutMultiplier)
-        _ = conn.SetReadDeadline(time.Now().Add(extendedTimeout))
         rounds++
+        st.res.NackRounds++
+        e := st.ev.event(logger.EventNackSent)
+        e.Round, e.Count, e.Missing, e.Range = rounds, len(requested), len(missing), logger.RangeOf(requested)
+        st.ev.log.Emit(e)
         
         // Processa retransmissões por um período mais longo
         retransmissionReceived := false
         retransmissionDeadline := time.Now().Add(extendedTimeout)
         initialMissingCount := len(missing)
-        for time.Now().Before(retransmissionDeadline) {
-            select {
-            case <-cfg.Cancel:
-                return errors.New("transferência cancelada")
-            default:
-            }
-            // buf armazena pacotes retransmitidos de segmentos faltantes
-                buf := make([]byte, protocol.HeaderSize()+config.ChunkSize) // buffer de recepção
-            n, _, err := conn.ReadFromUDP(buf)
+        target := st.sink.Received().Count() + uint32(len(requested)) // contagem com o round completo
+        for time.Now().Before(retransmissionDeadline) && st.sink.Received().Count() < target {
+            // timeouts menores internos, limitados ao prazo do round
+            wait := min(cfg.Timeout/4, time.Until(retransmissionDeadline))
+            b, err := sm.read(wait)
+            if err == ErrCancelled || err == errClosed { return err }
+            flushFeedback(sm, st.fb)
             if err != nil { 
                 // Timeout parcial - continua tentando até deadline
-                _ = conn.SetReadDeadline(time.Now().Add(cfg.Timeout/4)) // timeouts menores internos
                 continue
             }
-            if processPacket(buf[:n], cfg, cb, st.recv, st.bytesRecv, st.segsRecv) {
+            if processPacket(b, cfg, cb, st) {
                 // EOF recebido - pode continuar ou parar dependendo se ainda faltam
                 continue 
             }
             retransmissionReceived = true
-            _ = conn.SetReadDeadline(time.Now().Add(cfg.Timeout/4))
         }
         
         // Log do resultado do round
-        finalMissingCount := len(computeMissing(meta.Total, st.recv))
+        finalMissingCount := int(meta.Total - st.sink.Received().Count())
         recovered := initialMissingCount - finalMissingCount
+        st.fb.OnLost(len(requested) - recovered) // retransmissões que não chegaram
+        if recovered > 0 { fruitless = 0; st.res.Retransmissions += uint64(recovered) } else { fruitless++; st.mtr.AddTimeout() }
+        atomic.AddUint64(&st.mtr.Retransmissions, uint64(max(recovered, 0)))
+        if recovered > 0 {
+            e := st.ev.event(logger.EventRetransmit)
+            e.Round, e.Count, e.Missing = rounds, recovered, finalMissingCount
+            st.ev.log.Emit(e)
+        }
         if cb.OnLog != nil {
             if recovered > 0 {
                 cb.OnLog(fmt.Sprintf("NACK round %d: recuperados %d segmentos, ainda faltando %d", rounds, recovered, finalMissingCount))
@@ -304,67 +400,51 @@ func runNackRounds(conn *net.UDPConn, meta protocol.Meta, cfg Config, cb Callbac
 }
 
 // Coordena a recepção dos dados, em duas fases: leitura inicial
-// até EOF/ociosidade e rounds de NACK.
-func receiveData(conn *net.UDPConn, meta protocol.Meta, cfg Config, cb Callbacks) (map[uint32][]byte, error) {
-    // recv mapeia sequências para payloads recebidos
-        recv := make(map[uint32][]byte) // armazenamento dos payloads por sequência
-    // bytesRecv acumula bytes válidos
-        var bytesRecv uint64            // total de bytes válidos recebidos
-    // segsRecv acumula quantidade de segmentos válidos
-        var segsRecv uint64             // total de segmentos válidos recebidos
+// até EOF/ociosidade e rounds de NACK. Os segmentos são gravados em st.sink.
+// Na retomada não há envio inicial e só os rounds de NACK são executados.
+func receiveData(sm *stream, meta protocol.Meta, cfg Config, cb Callbacks, st recvState, resuming bool) error {
     // maxRounds define o limite de retries (fallback=3)
         maxRounds := cfg.Retries        // limite de rounds de NACK/timeouts
     if maxRounds <= 0 { maxRounds = 3 }
 
-    st := recvState{recv: recv, bytesRecv: &bytesRecv, segsRecv: &segsRecv}
-    if _, err := receiveUntilIdleOrEOF(conn, cfg, cb, st, maxRounds); err != nil {
-        return recv, err
+    if !resuming {
+        if _, err := receiveUntilIdleOrEOF(sm, cfg, cb, st, maxRounds); err != nil {
+            return err
+        }
+        st.fec.flush(cb, st) // o que a paridade não cobriu vai para o NACK
     }
-    if err := runNackRounds(conn, meta, cfg, cb, st, maxRounds); err != nil {
-        return recv, err
+    return runNackRounds(sm, meta, cfg, cb, st, maxRounds)
+}
+
+// Define o caminho de saída: o informado ou recv_<arquivo> por padrão.
+func outputPathFor(meta protocol.Meta, outputPath string) string {
+    if strings.TrimSpace(outputPath) == "" {
+        return "recv_" + filepath.Base(meta.Filename)
     }
-    return recv, nil
+    return outputPath
 }
 
-// Reagrupa os chunks, grava o arquivo de saída e valida SHA-256.
-func assembleAndVerify(meta protocol.Meta, recv map[uint32][]byte, outputPath string) (string, bool, error) {
+// Valida o SHA-256 relendo o arquivo temporário e o renomeia atomicamente
+// para o caminho de saída (ou <saída>.corrupt em caso de divergência).
+func assembleAndVerify(meta protocol.Meta, sink *segfile.Sink, baseOut string) (string, bool, error) {
     // Verifica se há segmentos faltando
-    miss := computeMissing(meta.Total, recv)
-    if len(miss) > 0 {
-        return "", false, fmt.Errorf("arquivo incompleto: faltam %d segmentos", len(miss))
+    if !sink.Received().Complete() {
+        sink.Abort()
+        return "", false, fmt.Errorf("arquivo incompleto: faltam %d segmentos", meta.Total-sink.Received().Count())
     }
-    // Reconstrói a sequência ordenada para hash/escrita
-    chunks := make([][]byte, meta.Total)
-    for i := uint32(0); i < meta.Total; i++ { chunks[i] = recv[i] }
-
-    computed := protocol.SHA256FileChunks(chunks)
+    computed, err := sink.Hash()
+    if err != nil { sink.Abort(); return "", false, err }
     match := computed == meta.SHA256
 
-    // Define caminho padrão se não fornecido
-    baseOut := outputPath
-    if strings.TrimSpace(baseOut) == "" {
-        baseOut = "recv_" + filepath.Base(meta.Filename)
-    }
-
-    // Se diretório não existir cria (caso inclua subpastas)
-    if err := os.MkdirAll(filepath.Dir(baseOut), 0o755); err != nil {
-        return "", false, err
-    }
-
     finalPath := baseOut
     // Em caso de mismatch salvamos como .corrupt e retornamos erro para fluxo superior tratar
     var mismatchErr error
     if !match {
         finalPath = baseOut + ".corrupt"
-        mismatchErr = fmt.Errorf("sha256 mismatch: esperado %s obtido %s (salvo como %s)", meta.SHA256, computed, filepath.Base(finalPath))
+        mismatchErr = fmt.Errorf("%w: esperado %s obtido %s (salvo como %s)", ErrIntegrityMismatch, meta.SHA256, computed, filepath.Base(finalPath))
     }
 
-    f, err := os.Create(finalPath)
-    if err != nil { return "", false, err }
-    defer f.Close()
-    for i := uint32(0); i < meta.Total; i++ {
-        if _, err := f.Write(chunks[i]); err != nil { return "", false, err }
-    }
+    if err := sink.Commit(finalPath); err != nil { return "", false, err }
 
     if mismatchErr != nil {
         return finalPath, false, mismatchErr
@@ -372,61 +452,171 @@ func assembleAndVerify(meta protocol.Meta, recv map[uint32][]byte, outputPath st
     return finalPath, true, nil
 }
 
-// Executa uma transferência da requisição até a verificação.
-func transferOnce(cfg Config, cb Callbacks) (string, bool, error) {
-	// addr é o endpoint UDP de destino
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)) // resolução do endpoint
-	if err != nil { return "", false, err }
-	// conn é a conexão UDP usada para a sessão
-	conn, err := net.DialUDP("udp", nil, addr) // conexão UDP com o servidor
-	if err != nil { return "", false, err }
-	defer conn.Close()
-	// buffers maiores ajudam a reduzir perdas por estouro de socket
-	_ = conn.SetReadBuffer(config.DefaultReadBuffer)
-	_ = conn.SetWriteBuffer(config.DefaultWriteBuffer)
-	_ = conn.SetReadDeadline(time.Now().Add(cfg.Timeout))
-
-	meta, err := sendREQAndGetMeta(conn, cfg, cb)
-	if err != nil { return "", false, err }
-	recv, err := receiveData(conn, meta, cfg, cb)
-	if err != nil { return "", false, err }
-	out, ok, err := assembleAndVerify(meta, recv, cfg.OutputPath)
-	return out, ok, err
+// Executa uma transferência da requisição até a verificação, preenchendo res
+// e registrando os eventos em ev.
+func transferOnce(c *Conn, cfg Config, cb Callbacks, res *Result, ev *transferEvents) error {
+	// sm é o fluxo desta transferência sobre o socket compartilhado
+	sm := c.openStream(cfg.Cancel)
+	defer sm.close()
+
+	// Retomada: procura o estado parcial da saída prevista a partir do caminho pedido
+	var partial *partialState
+	guessOut := outputPathFor(protocol.Meta{Filename: filepath.Base(cfg.Path)}, cfg.OutputPath)
+	if cfg.Resume {
+		if p, err := loadPartial(guessOut); err == nil {
+			partial = p
+		} else if cb.OnLog != nil {
+			cb.OnLog("WARN: nenhum download parcial encontrado para " + guessOut + "; iniciando do zero")
+		}
+	}
+	// versão e recursos do servidor (uma vez por conexão)
+	proto, err := c.Negotiate(cfg.Timeout, cfg.Retries, cfg.Cancel)
+	if err != nil {
+		if cb.OnLog != nil && err != ErrCancelled && err != errClosed { cb.OnLog("ERRO: negociação falhou: " + err.Error()) }
+		return err
+	}
+	res.Version = proto.Version
+	if proto.Version == protocol.LegacyVersion {
+		// servidor v1 (sem HELLO): sem sessão, uma transferência por vez no socket
+		if cb.OnLog != nil { cb.OnLog("WARN: servidor não respondeu ao HELLO; usando protocolo v1 (sem FEC, compressão nem controle de congestionamento)") }
+		if err := sm.claimLegacy(); err != nil { return err }
+	} else if cb.OnLog != nil {
+		cb.OnLog(fmt.Sprintf("STATUS: protocolo v%d (recursos: %s)", proto.Version, proto.Features))
+	}
+	if cfg.FECData > 0 && !proto.Features.Has(protocol.FeatureFEC) {
+		if cb.OnLog != nil { cb.OnLog("WARN: servidor não oferece FEC; perdas serão recuperadas só por NACK") }
+		cfg.FECData, cfg.FECParity = 0, 0
+	}
+	large := proto.Features.Has(protocol.FeatureLargeChunks)
+	// no modo cifrado o envelope ocupa parte do datagrama informado
+	maxDatagram := cfg.MaxDatagram
+	if !large { maxDatagram = 0 }
+	if maxDatagram > 0 { maxDatagram = max(maxDatagram-c.overhead, 1) }
+	req := protocol.Req{Token: sm.token, Path: cfg.Path, MaxDatagram: maxDatagram, FECData: cfg.FECData, FECParity: cfg.FECParity}
+	if proto.Version == protocol.LegacyVersion {
+		// REQ v1: só o caminho
+		req = protocol.Req{Path: cfg.Path, Version: protocol.LegacyVersion}
+	} else if proto.Version >= protocol.CompressionVersion {
+		// REQ v3: codecs aceitos, se o servidor oferece compressão
+		req.Version = proto.Version
+		if proto.Features.Has(protocol.FeatureCompression) { req.Codecs = cfg.Codecs & codec.All }
+	}
+	if partial != nil && !sm.legacy {
+		// a retomada precisa do mesmo tamanho de segmento do download parcial
+		req.Flags |= protocol.ReqFlagResume
+		req.MaxDatagram = partial.Chunk + protocol.HeaderSize()
+	} else if cfg.ProbeMTU && large {
+		if cb.OnLog != nil { cb.OnLog("STATUS: Sondando MTU do caminho") }
+		if n, err := c.ProbeMTU(maxDatagram, cfg.Timeout/4, cfg.Cancel); err == nil {
+			req.MaxDatagram = n
+			if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("STATUS: MTU do caminho: datagramas de até %d bytes", n)) }
+		} else if err == ErrCancelled || err == errClosed {
+			return err
+		} else if cb.OnLog != nil {
+			cb.OnLog("WARN: sondagem de MTU falhou (" + err.Error() + "); usando tamanho padrão")
+		}
+	}
+
+	meta, err := sendREQAndGetMeta(sm, req, cfg, cb)
+	if err != nil { return err }
+	if meta.Chunk <= 0 || int64(meta.Total) != (meta.Size+int64(meta.Chunk)-1)/int64(meta.Chunk) {
+		return fmt.Errorf("META inconsistente: total=%d size=%d chunk=%d", meta.Total, meta.Size, meta.Chunk)
+	}
+	if meta.Codec != protocol.CodecNone {
+		if !req.Codecs.Has(meta.Codec) { return fmt.Errorf("META com codec não pedido: %v", meta.Codec) }
+		if cb.OnLog != nil && meta.Size > 0 {
+			cb.OnLog(fmt.Sprintf("STATUS: compressão %s: ~%d de %d bytes (%.0f%%)", meta.Codec, meta.EstimatedCompressedSize, meta.Size, 100*float64(meta.EstimatedCompressedSize)/float64(meta.Size)))
+		}
+	}
+	res.Codec, res.EstimatedCompressedSize, res.Session = meta.Codec, meta.EstimatedCompressedSize, meta.Session
+	baseOut := outputPathFor(meta, cfg.OutputPath)
+
+	var sink *segfile.Sink
+	if partial != nil {
+		// o META atual precisa descrever exatamente o mesmo arquivo do download parcial
+		if baseOut == guessOut && partial.matches(cfg.Path, meta) {
+			sink, err = partial.reopen(baseOut)
+		} else {
+			err = errResumeMismatch
+		}
+		if err != nil {
+			// a sessão pedida em modo retomada não fará envio inicial: recomeça com novo REQ
+			if cb.OnLog != nil { cb.OnLog("WARN: não foi possível retomar (" + err.Error() + "); reiniciando download") }
+			sm.close()
+			cfg.Resume = false
+			return transferOnce(c, cfg, cb, res, ev)
+		}
+		res.Resumed = true
+		ev.start(meta, true)
+		if cb.OnLog != nil {
+			cb.OnLog(fmt.Sprintf("STATUS: Retomando download: %d de %d segmentos já recebidos", sink.Received().Count(), meta.Total))
+		}
+	} else {
+		// segmentos vão direto para <saída>.part, pré-alocado com o tamanho do arquivo
+		removePartial(baseOut)
+		sink, err = segfile.Create(baseOut, meta.Size, meta.Chunk)
+		if err != nil { return err }
+		ev.start(meta, false)
+	}
+
+	bytesRecv := uint64(sink.Written())          // total de bytes válidos recebidos
+	segsRecv := uint64(sink.Received().Count())  // total de segmentos válidos recebidos
+	tm := cfg.Metrics
+	if tm == nil { tm = metrics.NewTransferMetrics() }
+	st := recvState{sink: sink, bytesRecv: &bytesRecv, segsRecv: &segsRecv, ckpt: &checkpointer{baseOut: baseOut, path: cfg.Path, meta: meta}, fb: congestion.NewReporter(meta.Session), fec: newFECState(meta), res: res, codec: meta.Codec,
+		mtr: tm, perf: metrics.NewPerformanceMonitor(tm), ev: ev}
+	res.Size = meta.Size
+	already := bytesRecv // bytes de um download retomado, fora da contagem desta execução
+	defer func() {
+		res.Bytes = atomic.LoadUint64(&bytesRecv) - already
+		res.FECRecovered = st.fec.count()
+		// datagramas do servidor segundo o relatório de recepção (FBK): recebidos + perdidos
+		received, lost := st.fb.Counts()
+		atomic.AddUint64(&tm.SegmentsReceived, uint64(received))
+		atomic.AddUint64(&tm.SegmentsSent, uint64(received)+uint64(lost))
+		tm.Finish()
+		res.Metrics = tm
+	}()
+	// o servidor v1 envia o arquivo inteiro mesmo na retomada; os segmentos já
+	// gravados são descartados como repetidos
+	if err := receiveData(sm, meta, cfg, cb, st, partial != nil && !sm.legacy); err != nil {
+		// mantém <saída>.part e o sidecar para uma retomada posterior
+		if ckErr := st.checkpoint(true); ckErr == nil && cb.OnLog != nil {
+			cb.OnLog(fmt.Sprintf("STATUS: Download parcial salvo (%d/%d segmentos); use a retomada para continuar", sink.Received().Count(), meta.Total))
+		}
+		sink.Close()
+		return err
+	}
+	removePartial(baseOut)
+	res.Path, _, err = assembleAndVerify(meta, sink, baseOut)
+	return err
 }
 
-// Inicia a transferência conforme a Config e aciona Callbacks nos eventos.
+// Inicia a transferência conforme a Config e aciona Callbacks nos eventos,
+// usando um socket próprio. Para várias transferências sobre o mesmo socket,
+// use Dial e Conn.RunTransfer; para tratar o resultado, use Download.
 func RunTransfer(cfg Config, cb Callbacks) {
-    out, ok, err := transferOnce(cfg, cb)
-    if err != nil && cb.OnLog != nil {
-        cb.OnLog("ERRO: " + err.Error())
-    }
-    if cb.OnLog != nil && strings.TrimSpace(out) != "" {
-        if st, statErr := os.Stat(out); statErr == nil {
-            cb.OnLog(fmt.Sprintf("STATUS: Arquivo salvo: %s (%d bytes) verificado=%t", out, st.Size(), ok))
-        }
-    }
-    if cb.OnLog != nil {
-        cb.OnLog("SUCCESS: Transferência finalizada")
+    c, err := cfg.dial()
+    if err != nil {
+        if cb.OnLog != nil { cb.OnLog("ERRO: " + err.Error()) }
+        if cb.OnDone != nil { cb.OnDone("", false) }
+        return
     }
-    if cb.OnDone != nil { cb.OnDone(out, ok) }
+    defer c.Close()
+    runTransfer(c, cfg, cb)
 }
 
-// ListFiles solicita ao servidor a lista de arquivos disponíveis (não recursivo).
-func ListFiles(host string, port int, timeout time.Duration) ([]string, error) {
-    addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", host, port))
-    if err != nil { return nil, err }
-    conn, err := net.DialUDP("udp", nil, addr)
-    if err != nil { return nil, err }
-    defer conn.Close()
-    _ = conn.SetReadDeadline(time.Now().Add(timeout))
-    if _, err := conn.Write(protocol.CtrlLIST()); err != nil { return nil, err }
-    buf := make([]byte, 4096)
-    n, _, err := conn.ReadFromUDP(buf)
-    if err != nil { return nil, err }
-    if !protocol.IsCtrl(buf[:n]) { return nil, errors.New("resposta não é controle") }
-    typ, v, e := protocol.DecodeCtrl(buf[:n])
-    if e != nil { return nil, e }
-    if typ != protocol.TypeLST { return nil, errors.New("resposta inesperada") }
-    lst := v.(protocol.Lst)
-    return lst.Names, nil
+// executa a transferência sobre a conexão c, reportando o resultado via Callbacks.
+func runTransfer(c *Conn, cfg Config, cb Callbacks) {
+    res, err := c.Download(context.Background(), cfg, cb)
+    if cb.OnLog != nil {
+        if err != nil { cb.OnLog("ERRO: " + err.Error()) }
+        if st, statErr := os.Stat(res.Path); res.Path != "" && statErr == nil {
+            cb.OnLog(fmt.Sprintf("STATUS: Arquivo salvo: %s (%d bytes) verificado=%t", res.Path, st.Size(), err == nil))
+        }
+        if err == nil {
+            cb.OnLog(fmt.Sprintf("SUCCESS: Transferência finalizada em %v (%d rounds de NACK, %d retransmitidos)", res.Duration.Round(time.Millisecond), res.NackRounds, res.Retransmissions))
+        }
+    }
+    if cb.OnDone != nil { cb.OnDone(res.Path, err == nil) }
 }
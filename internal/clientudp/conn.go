@@ -0,0 +1,268 @@
+package clientudp
+
+import (
+    "errors"
+    "fmt"
+    "math/rand/v2"
+    "net"
+    "sync"
+    "time"
+
+    "udp/internal/capture"
+    "udp/internal/config"
+    "udp/internal/impair"
+    "udp/internal/protocol"
+    "udp/internal/secure"
+)
+
+// Erros devolvidos pela leitura de um fluxo (além de ErrCancelled).
+var (
+    errTimeout = errors.New("timeout aguardando datagrama")
+    errClosed  = errors.New("conexão encerrada")
+)
+
+// Conn é um socket UDP do cliente que pode ser compartilhado por várias
+// transferências simultâneas. Uma goroutine de leitura distribui os
+// datagramas recebidos pelo identificador de sessão (DATA/EOF, ou
+// NACK/FBK/DONE nos envios) ou pelo token do REQ/PUT/LIST (META/LST/ERR), nunca pelo
+// endereço de origem. Com servidores v1, sem sessão nem token, os
+// datagramas v1 vão para a única transferência v1 em andamento.
+type Conn struct {
+    conn      net.Conn           // socket conectado (cifrado, se aberto com DialSecure)
+    overhead  int                // bytes acrescentados a cada datagrama pelo modo cifrado
+    mu        sync.Mutex         // proteção dos mapas de fluxos
+    sessions  map[uint32]*stream // sessão -> fluxo
+    tokens    map[uint32]*stream // token do REQ -> fluxo aguardando META
+    nextToken uint32             // próximo token de REQ
+    done      chan struct{}      // fechado ao encerrar a conexão
+    closeOnce sync.Once
+    hello     sync.Mutex         // serializa a negociação (HELLO)
+    proto     *Protocol          // versão e recursos negociados (nil até Negotiate)
+    legacy    *stream            // transferência v1 em andamento (nil se nenhuma)
+    legacySem chan struct{}      // vaga da transferência v1 (uma por vez)
+}
+
+// stream é a visão de uma transferência sobre a Conn compartilhada.
+type stream struct {
+    c       *Conn
+    token   uint32          // token do REQ deste fluxo
+    session uint32          // sessão atribuída no META (0 até recebê-lo)
+    in      chan []byte     // datagramas roteados para este fluxo
+    cancel  <-chan struct{} // cancelamento da transferência
+    legacy  bool            // transferência v1 (sem sessão), com a vaga c.legacySem
+}
+
+// Dial abre um socket UDP para o servidor host:port e inicia a goroutine
+// de leitura que demultiplexa os datagramas entre as transferências.
+func Dial(host string, port int) (*Conn, error) {
+    return DialSecure(host, port, nil, 0, 0)
+}
+
+// DialSecure é como Dial, mas com psk não vazia executa o handshake do modo
+// cifrado (até attempts tentativas de timeout cada): todos os datagramas da
+// conexão passam a ser cifrados e autenticados, e os forjados ou repetidos
+// são descartados antes de chegar às transferências.
+func DialSecure(host string, port int, psk []byte, timeout time.Duration, attempts int) (*Conn, error) {
+    return DialWith(host, port, DialOptions{PSK: psk, Timeout: timeout, Attempts: attempts})
+}
+
+// DialOptions configura a abertura de uma Conn por DialWith.
+type DialOptions struct {
+    PSK      []byte           // chave do modo cifrado (nil = sem cifra)
+    Timeout  time.Duration    // espera por resposta do handshake cifrado
+    Attempts int              // tentativas do handshake cifrado
+    Capture  *capture.Writer  // grava os datagramas da conexão, já decifrados, em pcap (nil = sem captura)
+    Impair   *impair.Impairer // degrada o tráfego da conexão (nil = rede real)
+}
+
+// DialWith é como DialSecure, com as opções em opts.
+func DialWith(host string, port int, opts DialOptions) (*Conn, error) {
+    addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", host, port)) // resolução do endpoint
+    if err != nil { return nil, err }
+    uc, err := net.DialUDP("udp", nil, addr) // conexão UDP com o servidor
+    if err != nil { return nil, err }
+    // buffers maiores ajudam a reduzir perdas por estouro de socket
+    _ = uc.SetReadBuffer(config.DefaultReadBuffer)
+    _ = uc.SetWriteBuffer(config.DefaultWriteBuffer)
+    var nc net.Conn = uc
+    overhead := 0
+    if len(opts.PSK) > 0 {
+        sc, err := secure.Client(uc, opts.PSK, opts.Timeout, opts.Attempts)
+        if err != nil { uc.Close(); return nil, err }
+        nc, overhead = sc, secure.Overhead
+    }
+    // degradação e captura ficam por fora do envelope: veem o protocolo em
+    // claro, e a captura grava o que a transferência envia e recebe
+    if opts.Impair != nil { nc = opts.Impair.WrapConn(nc) }
+    if opts.Capture != nil { nc = capture.WrapConn(nc, opts.Capture) }
+    c := &Conn{
+        conn:      nc,
+        overhead:  overhead,
+        sessions:  make(map[uint32]*stream),
+        tokens:    make(map[uint32]*stream),
+        nextToken: rand.Uint32(),
+        done:      make(chan struct{}),
+        legacySem: make(chan struct{}, 1),
+    }
+    go c.readLoop()
+    return c, nil
+}
+
+// abre o socket de uma transferência de cfg (cifra, degradação e captura inclusive).
+func (cfg Config) dial() (*Conn, error) {
+    return DialWith(cfg.Host, cfg.Port, DialOptions{PSK: cfg.PSK, Timeout: cfg.Timeout, Attempts: cfg.Retries, Capture: cfg.Capture, Impair: cfg.Impair})
+}
+
+// Close encerra o socket e todas as transferências que o utilizam.
+func (c *Conn) Close() error {
+    var err error
+    c.closeOnce.Do(func() {
+        close(c.done)
+        err = c.conn.Close()
+    })
+    return err
+}
+
+// RunTransfer executa uma transferência sobre esta conexão. Várias chamadas
+// concorrentes compartilham o mesmo socket.
+func (c *Conn) RunTransfer(cfg Config, cb Callbacks) {
+    runTransfer(c, cfg, cb)
+}
+
+// lê datagramas do socket e os entrega ao fluxo correspondente.
+func (c *Conn) readLoop() {
+    buf := make([]byte, 65535) // buffer de recepção (maior datagrama UDP)
+    for {
+        n, err := c.conn.Read(buf)
+        if err != nil {
+            select {
+            case <-c.done:
+                return
+            default:
+            }
+            var ne net.Error
+            if errors.As(err, &ne) && ne.Timeout() { continue }
+            // erros transitórios (ex.: ICMP port unreachable) não encerram a conexão
+            time.Sleep(10 * time.Millisecond)
+            continue
+        }
+        b := append([]byte(nil), buf[:n]...) // cópia: buf é reutilizado
+        if s := c.route(b); s != nil {
+            select {
+            case s.in <- b:
+            default: // fluxo saturado: descarta como perda de rede (recuperável via NACK)
+            }
+        }
+    }
+}
+
+// determina o fluxo destinatário de um datagrama.
+func (c *Conn) route(b []byte) *stream {
+    c.mu.Lock(); defer c.mu.Unlock()
+    if protocol.IsLegacy(b) { return c.legacy }
+    if !protocol.IsCtrl(b) {
+        h, err := protocol.UnpackHeader(b)
+        if err != nil { return nil }
+        return c.sessions[h.Session]
+    }
+    typ, v, err := protocol.DecodeCtrl(b)
+    if err != nil { return nil }
+    switch typ {
+    case protocol.TypeMETA:
+        m := v.(protocol.Meta)
+        if s := c.sessions[m.Session]; s != nil { return s }
+        // associa já aqui: os primeiros DATA chegam logo após o META
+        s := c.tokens[m.Token]
+        if s != nil { s.bindLocked(m.Session) }
+        return s
+    case protocol.TypeERR:
+        return c.tokens[v.(protocol.ErrMsg).Token]
+    case protocol.TypeEOF:
+        return c.sessions[v.(protocol.EOFMsg).Session]
+    case protocol.TypePROBEACK:
+        return c.tokens[v.(protocol.Probe).Token]
+    case protocol.TypeHELLOACK:
+        return c.tokens[v.(protocol.HelloAck).Token]
+    case protocol.TypeLST:
+        return c.tokens[v.(protocol.Lst).Token]
+    // envios (PUT): o servidor é o receptor e responde pela sessão
+    case protocol.TypeNACK:
+        return c.sessions[v.(protocol.Nack).Session]
+    case protocol.TypeFBK:
+        return c.sessions[v.(protocol.Feedback).Session]
+    case protocol.TypeDONE:
+        return c.sessions[v.(protocol.Done).Session]
+    }
+    return nil
+}
+
+// registra um novo fluxo com token de REQ exclusivo.
+func (c *Conn) openStream(cancel <-chan struct{}) *stream {
+    c.mu.Lock(); defer c.mu.Unlock()
+    c.nextToken++
+    if c.nextToken == 0 { c.nextToken++ }
+    s := &stream{c: c, token: c.nextToken, in: make(chan []byte, 8192), cancel: cancel}
+    c.tokens[s.token] = s
+    return s
+}
+
+// associa o fluxo à sessão informada no META.
+func (s *stream) bind(session uint32) {
+    s.c.mu.Lock(); defer s.c.mu.Unlock()
+    s.bindLocked(session)
+}
+
+// bind com s.c.mu já adquirido.
+func (s *stream) bindLocked(session uint32) {
+    if s.session != 0 && s.session != session { delete(s.c.sessions, s.session) }
+    s.session = session
+    s.c.sessions[session] = s
+    delete(s.c.tokens, s.token)
+}
+
+// reserva a conexão para a transferência v1 do fluxo, aguardando o fim de
+// outra em andamento: sem sessão nem token, os datagramas v1 só se associam
+// pelo endereço, e o servidor mantém uma transferência v1 por endereço.
+func (s *stream) claimLegacy() error {
+    select {
+    case s.c.legacySem <- struct{}{}:
+    case <-s.cancel:
+        return ErrCancelled
+    case <-s.c.done:
+        return errClosed
+    }
+    s.c.mu.Lock(); defer s.c.mu.Unlock()
+    s.legacy, s.c.legacy = true, s
+    return nil
+}
+
+// remove o fluxo da conexão.
+func (s *stream) close() {
+    s.c.mu.Lock(); defer s.c.mu.Unlock()
+    delete(s.c.tokens, s.token)
+    if s.session != 0 && s.c.sessions[s.session] == s { delete(s.c.sessions, s.session) }
+    if s.legacy && s.c.legacy == s { s.c.legacy = nil; <-s.c.legacySem }
+}
+
+// envia um datagrama ao servidor.
+func (s *stream) write(b []byte) error {
+    _, err := s.c.conn.Write(b)
+    return err
+}
+
+// aguarda o próximo datagrama do fluxo por até timeout.
+func (s *stream) read(timeout time.Duration) ([]byte, error) {
+    if timeout <= 0 { timeout = time.Millisecond }
+    t := time.NewTimer(timeout)
+    defer t.Stop()
+    select {
+    case b := <-s.in:
+        return b, nil
+    case <-t.C:
+        return nil, errTimeout
+    case <-s.cancel:
+        return nil, ErrCancelled
+    case <-s.c.done:
+        return nil, errClosed
+    }
+}
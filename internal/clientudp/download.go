@@ -0,0 +1,80 @@
+package clientudp
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "udp/internal/logger"
+    "udp/internal/metrics"
+    "udp/internal/protocol"
+)
+
+// Result descreve um download: o arquivo salvo e os contadores da
+// recuperação de perdas. Com erro, traz o que foi obtido até a falha.
+type Result struct {
+    Path            string        // arquivo salvo ("" se nenhum; <saída>.corrupt se o SHA-256 divergiu)
+    Size            int64         // tamanho do arquivo segundo o META
+    Bytes           uint64        // bytes de segmentos recebidos nesta execução (sem os de um download retomado)
+    Duration        time.Duration // duração do REQ à verificação
+    NackRounds      int           // rounds de NACK enviados
+    Retransmissions uint64        // segmentos recuperados por retransmissão nos rounds de NACK
+    FECRecovered    uint64        // segmentos reconstruídos pela paridade FEC
+    Resumed         bool          // continuou um download parcial (Config.Resume)
+    Version         int           // versão do protocolo negociada com o servidor (HELLO)
+    Codec           protocol.Codec // compressão escolhida pelo servidor (CodecNone = sem compressão)
+    EstimatedCompressedSize int64  // estimativa por amostra do servidor (META) dos bytes de payload após a compressão (= Size sem compressão)
+    Metrics         *metrics.TransferMetrics // métricas da recepção (velocidade média e de pico, perda, timeouts); nil se a transferência não começou
+    Session         uint32        // sessão atribuída pelo servidor no META (0 se não houve META)
+}
+
+// Download baixa cfg.Path do servidor por um socket próprio e retorna o
+// resultado. Cancelar ctx (ou fechar cfg.Cancel) interrompe a transferência
+// com ErrCancelled, mantendo o parcial para uma retomada. As falhas podem
+// ser distinguidas com errors.Is (ErrNotFound, ErrIntegrityMismatch,
+// ErrTimeout, ErrCancelled, ErrBusy) ou errors.As (*ServerError). Recusas
+// por servidor ocupado (ERR BUSY) são repetidas até cfg.Retries vezes, com
+// backoff exponencial que respeita a espera sugerida pelo servidor.
+func Download(ctx context.Context, cfg Config) (Result, error) {
+    c, err := cfg.dial()
+    if err != nil { return Result{}, err }
+    defer c.Close()
+    return c.Download(ctx, cfg, Callbacks{})
+}
+
+// Download é como a função Download, mas sobre a conexão c, acionando os
+// eventos OnMeta, OnProgress e OnLog de cb (OnDone não é chamado: o
+// resultado é o retorno).
+func (c *Conn) Download(ctx context.Context, cfg Config, cb Callbacks) (Result, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    if cfg.Cancel != nil {
+        // o canal de cancelamento legado também encerra o contexto
+        go func(ch <-chan struct{}) {
+            select {
+            case <-ch:
+                cancel()
+            case <-ctx.Done():
+            }
+        }(cfg.Cancel)
+    }
+    cfg.Cancel = ctx.Done()
+    var res Result
+    start := time.Now()
+    ev := newTransferEvents(cfg.Events, c, "download", cfg.Path)
+    err := retryBusy(cfg.Retries, cfg.Cancel, cb.OnLog, func() error {
+        res = Result{}
+        return transferOnce(c, cfg, cb, &res, ev)
+    })
+    res.Duration = time.Since(start)
+    if errors.Is(err, ErrCancelled) {
+        // preserva o motivo do contexto (ex.: context.DeadlineExceeded)
+        if cause := context.Cause(ctx); cause != nil && cause != context.Canceled { err = fmt.Errorf("%w: %w", ErrCancelled, cause) }
+    }
+    e := ev.event("")
+    e.Session, e.Size, e.Bytes, e.Duration, e.Round, e.Resumed = logger.SessionID(res.Session), res.Size, res.Bytes, logger.Millis(res.Duration), res.NackRounds, res.Resumed
+    if res.Metrics != nil { e.Loss = res.Metrics.PacketLoss / 100 }
+    ev.finish(err, e)
+    return res, err
+}
@@ -0,0 +1,49 @@
+package clientudp
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "udp/internal/protocol"
+)
+
+// Erros de Download e RunTransfer, comparáveis com errors.Is; as falhas
+// que o servidor informa por ERR chegam como *ServerError.
+var (
+    ErrNotFound          = errors.New("arquivo não encontrado no servidor")
+    ErrIntegrityMismatch = errors.New("sha256 divergente")
+    ErrTimeout           = errors.New("servidor sem resposta")
+    ErrCancelled         = errors.New("transferência cancelada")
+    ErrBusy              = errors.New("servidor ocupado")
+)
+
+// mensagem do ERR com que servidores sem códigos (ErrCodeGeneric) recusam
+// um REQ de arquivo inexistente
+const notFoundMessage = "arquivo não encontrado"
+
+// ServerError é um pedido recusado pelo servidor com ERR.
+type ServerError struct {
+    Code    protocol.ErrCode // código do ERR
+    Message string           // mensagem do servidor
+    RetryAfter time.Duration // espera sugerida antes de repetir (ERR BUSY; 0 = não informada)
+}
+
+func (e *ServerError) Error() string { return e.Message }
+
+// Is faz um ERR de arquivo inexistente corresponder a ErrNotFound e uma
+// recusa por capacidade (ERR BUSY) a ErrBusy.
+func (e *ServerError) Is(target error) bool {
+    if target == ErrBusy { return e.Code == protocol.ErrCodeBusy }
+    if target != ErrNotFound { return false }
+    return e.Code == protocol.ErrCodeNotFound || e.Code == protocol.ErrCodeGeneric && e.Message == notFoundMessage
+}
+
+// Temporary informa se o pedido pode ser atendido se repetido mais tarde
+// (servidor ocupado ou falha interna).
+func (e *ServerError) Temporary() bool { return e.Code.Temporary() }
+
+// erro de tempo esgotado com o contexto da etapa, comparável com ErrTimeout.
+func timeoutf(format string, args ...any) error {
+    return fmt.Errorf("%w: %s", ErrTimeout, fmt.Sprintf(format, args...))
+}
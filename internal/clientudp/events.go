@@ -0,0 +1,52 @@
+package clientudp
+
+import (
+    "errors"
+    "fmt"
+
+    "udp/internal/logger"
+    "udp/internal/protocol"
+)
+
+// eventos de uma transferência do cliente; base identifica o servidor, o
+// sentido, o caminho e, depois do META, a sessão.
+type transferEvents struct {
+    log  *logger.EventLog
+    base logger.Event
+    size int64 // tamanho do arquivo segundo o META (0 antes dele)
+}
+
+// eventos da transferência dir ("download" ou "upload") de path sobre c.
+func newTransferEvents(log *logger.EventLog, c *Conn, dir, path string) *transferEvents {
+    return &transferEvents{log: log, base: logger.Event{Peer: c.conn.RemoteAddr().String(), Direction: dir, Path: path}}
+}
+
+// evento typ com a identificação da transferência preenchida.
+func (t *transferEvents) event(typ logger.EventType) logger.Event {
+    e := t.base
+    e.Type = typ
+    return e
+}
+
+// associa os eventos seguintes à sessão do META e registra o início dela.
+func (t *transferEvents) start(meta protocol.Meta, resumed bool) {
+    t.base.Session, t.size = logger.SessionID(meta.Session), meta.Size
+    e := t.event(logger.EventSessionStart)
+    e.Size, e.Total, e.Chunk, e.Resumed = meta.Size, meta.Total, meta.Chunk, resumed
+    if meta.Codec != protocol.CodecNone { e.Codec, e.EstimatedWire = meta.Codec.String(), meta.EstimatedCompressedSize }
+    if meta.FECData > 0 { e.FEC = fmt.Sprintf("%d:%d", meta.FECData, meta.FECParity) }
+    t.log.Emit(e)
+}
+
+// registra o desfecho: completed, ou failed com o motivo e o código do ERR
+// do servidor, se houver.
+func (t *transferEvents) finish(err error, e logger.Event) {
+    e.Type = logger.EventCompleted
+    if e.Size == 0 { e.Size = t.size }
+    if err != nil {
+        e.Type, e.Reason = logger.EventFailed, err.Error()
+        var se *ServerError
+        if errors.As(err, &se) { e.Code = uint16(se.Code) }
+    }
+    t.log.Emit(e)
+}
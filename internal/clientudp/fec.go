@@ -0,0 +1,148 @@
+package clientudp
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync/atomic"
+
+    "udp/internal/fec"
+    "udp/internal/protocol"
+    "udp/internal/segfile"
+)
+
+// blocos FEC mantidos em memória atrás do bloco mais recente; paridades de
+// blocos mais antigos são descartadas e suas lacunas ficam para o NACK
+const fecPendingBlocks = 8
+
+// fecState guarda as paridades recebidas dos blocos ainda incompletos e
+// reconstrói segmentos perdidos antes de recorrer ao NACK.
+type fecState struct {
+    code      *fec.Code
+    n, k      uint32
+    chunk     int
+    total     uint32
+    parity    map[uint32][][]byte // bloco -> paridades recebidas (índice j)
+    done      *segfile.Bitmap     // blocos completos ou reconstruídos
+    newest    uint32              // bloco mais recente com paridade recebida
+    recovered uint64              // segmentos reconstruídos
+}
+
+// cria o estado de FEC do META, ou nil se a transferência não usa FEC.
+func newFECState(meta protocol.Meta) *fecState {
+    if meta.FECData <= 0 || meta.FECParity <= 0 { return nil }
+    code, err := fec.New(meta.FECData, meta.FECParity)
+    if err != nil { return nil }
+    n := uint32(meta.FECData)
+    blocks := uint32((uint64(meta.Total) + uint64(n) - 1) / uint64(n))
+    return &fecState{code: code, n: n, k: uint32(meta.FECParity), chunk: meta.Chunk, total: meta.Total, parity: make(map[uint32][][]byte), done: segfile.NewBitmap(blocks)}
+}
+
+// registra a paridade seq (= bloco*K + j) e tenta reconstruir o bloco.
+// Paridades atrasadas de blocos já resolvidos ou fora da janela de
+// fecPendingBlocks são descartadas sem cópia.
+func (f *fecState) onParity(seq uint32, payload []byte, cb Callbacks, st recvState) {
+    if f == nil || len(payload) != f.chunk { return }
+    block, j := seq/f.k, seq%f.k
+    if block*f.n >= f.total || f.done.Has(block) || block+fecPendingBlocks < f.newest { return }
+    ps := f.parity[block]
+    if ps == nil {
+        ps = make([][]byte, f.k)
+        f.parity[block] = ps
+    }
+    if ps[j] == nil { ps[j] = append([]byte(nil), payload...) }
+    f.tryBlock(block, cb, st)
+    if block > f.newest {
+        f.newest = block
+        for b := range f.parity {
+            if b+fecPendingBlocks < block { delete(f.parity, b) }
+        }
+    }
+}
+
+// reconstrói os segmentos faltantes do bloco se houver dados+paridades
+// suficientes. A contagem usa só o bitmap de recebidos; os segmentos vão do
+// disco para a memória apenas quando a reconstrução é possível. Blocos
+// completos ou reconstruídos liberam as paridades e entram em f.done.
+func (f *fecState) tryBlock(block uint32, cb Callbacks, st recvState) {
+    first := block * f.n
+    recv := st.sink.Received()
+    have := 0
+    for i := uint32(0); i < f.n; i++ {
+        if seq := first + i; seq >= f.total || recv.Has(seq) { have++ }
+    }
+    if have == int(f.n) { f.complete(block); return }
+    ps := f.parity[block]
+    for _, p := range ps {
+        if p != nil { have++ }
+    }
+    if have < int(f.n) { return }
+    shards := make([][]byte, f.n+f.k)
+    for i := uint32(0); i < f.n; i++ {
+        seq := first + i
+        switch {
+        case seq >= f.total:
+            shards[i] = make([]byte, f.chunk) // além do arquivo: zeros
+        case recv.Has(seq):
+            buf := make([]byte, f.chunk)
+            if _, err := st.sink.ReadChunk(seq, buf); err != nil { return }
+            shards[i] = buf // completado com zeros até chunk
+        }
+    }
+    copy(shards[f.n:], ps)
+    fixed, err := f.code.Reconstruct(shards)
+    if err != nil {
+        if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("WARN: FEC bloco=%d: %v", block, err)) }
+        return
+    }
+    for _, i := range fixed {
+        seq := first + uint32(i)
+        data := shards[i][:st.sink.SegmentLen(seq)]
+        isNew, err := st.sink.WriteChunk(seq, data)
+        if err != nil || !isNew { continue }
+        f.recovered++
+        st.fb.OnRecovered(1)
+        atomic.AddUint64(st.bytesRecv, uint64(len(data)))
+        atomic.AddUint64(st.segsRecv, 1)
+        st.mtr.AddBytesReceived(uint64(len(data)))
+        if cb.OnProgress != nil { cb.OnProgress(atomic.LoadUint64(st.bytesRecv), atomic.LoadUint64(st.segsRecv)) }
+    }
+    f.complete(block)
+}
+
+// marca o bloco como resolvido e libera suas paridades.
+func (f *fecState) complete(block uint32) {
+    delete(f.parity, block)
+    f.done.Set(block)
+}
+
+// última tentativa sobre os blocos pendentes antes dos rounds de NACK;
+// libera as paridades guardadas.
+func (f *fecState) flush(cb Callbacks, st recvState) {
+    if f == nil { return }
+    for b := range f.parity { f.tryBlock(b, cb, st) }
+    clear(f.parity)
+    if f.recovered > 0 && cb.OnLog != nil {
+        cb.OnLog(fmt.Sprintf("STATUS: FEC reconstruiu %d segmentos sem NACK", f.recovered))
+    }
+}
+
+// retorna os segmentos reconstruídos pela paridade (0 sem FEC).
+func (f *fecState) count() uint64 {
+    if f == nil { return 0 }
+    return f.recovered
+}
+
+// ParseFEC interpreta a razão de FEC no formato "N:K" (K paridades a cada N
+// segmentos de dados). Texto vazio desliga o FEC (0, 0).
+func ParseFEC(spec string) (n, k int, err error) {
+    spec = strings.TrimSpace(spec)
+    if spec == "" { return 0, 0, nil }
+    a, b, ok := strings.Cut(spec, ":")
+    if !ok { return 0, 0, errors.New("use o formato N:K, ex.: 16:4") }
+    if n, err = strconv.Atoi(strings.TrimSpace(a)); err != nil { return 0, 0, err }
+    if k, err = strconv.Atoi(strings.TrimSpace(b)); err != nil { return 0, 0, err }
+    if n <= 0 || k <= 0 || n+k > fec.MaxShards { return 0, 0, fmt.Errorf("N e K devem ser positivos e N+K <= %d", fec.MaxShards) }
+    return n, k, nil
+}
@@ -0,0 +1,13 @@
+package clientudp
+
+import (
+    "udp/internal/congestion"
+    "udp/internal/protocol"
+)
+
+// envia ao servidor o FBK do relatório de recepção, se já for hora
+// (o servidor ajusta a janela e o ritmo de envio com ele); servidores v1
+// não o conhecem, e o relatório só alimenta as métricas.
+func flushFeedback(sm *stream, r *congestion.Reporter) {
+    if fb, ok := r.Poll(); ok && !sm.legacy { _ = sm.write(protocol.CtrlFBK(fb)) }
+}
@@ -0,0 +1,60 @@
+package clientudp
+
+import (
+    "time"
+
+    "udp/internal/config"
+    "udp/internal/protocol"
+)
+
+// Protocol é o resultado da negociação com o servidor.
+type Protocol struct {
+    Version  int               // versão escolhida
+    Features protocol.Features // recursos em comum
+}
+
+// recursos anunciados pelo cliente no HELLO (a compressão é pedida por
+// transferência, com Config.Codecs).
+func (c *Conn) features() protocol.Features {
+    f := protocol.FeatureFEC | protocol.FeatureLargeChunks | protocol.FeatureCompression
+    if c.overhead > 0 { f |= protocol.FeatureEncryption }
+    return f
+}
+
+// Negotiate troca HELLO/HELLOACK com o servidor na primeira chamada e guarda
+// o resultado para as seguintes. Cada uma das attempts tentativas espera o
+// HELLOACK por até wait; sem resposta o servidor é tratado como v1 (que
+// descarta o HELLO), sem recursos negociáveis: se estiver fora do ar, o REQ
+// v1 também fica sem resposta. Um ERR (nenhuma versão em comum) é devolvido
+// como *ServerError.
+func (c *Conn) Negotiate(wait time.Duration, attempts int, cancel <-chan struct{}) (Protocol, error) {
+    c.hello.Lock(); defer c.hello.Unlock()
+    if c.proto != nil { return *c.proto, nil }
+    if attempts <= 0 { attempts = 1 }
+    sm := c.openStream(cancel)
+    defer sm.close()
+    pkt := protocol.CtrlHELLO(protocol.Hello{Token: sm.token, MinVersion: config.MinProtocolVersion, MaxVersion: config.ProtocolVersion, Features: c.features()})
+attempt:
+    for range attempts {
+        if err := sm.write(pkt); err != nil { return Protocol{}, err }
+        deadline := time.Now().Add(wait)
+        for {
+            b, err := sm.read(time.Until(deadline))
+            if err == errTimeout { continue attempt }
+            if err != nil { return Protocol{}, err }
+            typ, v, err := protocol.DecodeCtrl(b)
+            if err != nil { continue }
+            switch typ {
+            case protocol.TypeHELLOACK:
+                a := v.(protocol.HelloAck)
+                c.proto = &Protocol{Version: a.Version, Features: a.Features}
+                return *c.proto, nil
+            case protocol.TypeERR:
+                er := v.(protocol.ErrMsg)
+                return Protocol{}, &ServerError{Code: er.Code, Message: er.Message, RetryAfter: er.RetryAfter}
+            }
+        }
+    }
+    c.proto = &Protocol{Version: protocol.LegacyVersion}
+    return *c.proto, nil
+}
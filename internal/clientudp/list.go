@@ -0,0 +1,102 @@
+package clientudp
+
+import (
+    "iter"
+    "time"
+
+    "udp/internal/protocol"
+)
+
+// Define uma listagem de diretório do servidor.
+type ListConfig struct {
+    Dir       string        // Subdiretório relativo ao diretório base ("" = raiz)
+    Recursive bool          // Inclui toda a árvore abaixo de Dir
+    PageSize  int           // Máximo de entradas por LST (0 = quantas couberem no datagrama)
+    Timeout   time.Duration // Espera por cada página
+    Retries   int           // Tentativas por página antes de desistir
+}
+
+// ListFiles solicita ao servidor os nomes dos arquivos do diretório base
+// (não recursivo).
+func ListFiles(host string, port int, timeout time.Duration) ([]string, error) {
+    c, err := Dial(host, port)
+    if err != nil { return nil, err }
+    defer c.Close()
+    return c.ListFiles(timeout)
+}
+
+// ListDir solicita todas as entradas (arquivos e diretórios, com tamanho,
+// mtime e SHA-256 em cache) do subdiretório dir do servidor ("" = diretório
+// base), de toda a árvore abaixo dele se recursive.
+func ListDir(host string, port int, dir string, recursive bool, timeout time.Duration) ([]protocol.Entry, error) {
+    c, err := Dial(host, port)
+    if err != nil { return nil, err }
+    defer c.Close()
+    return c.ListDir(dir, recursive, timeout)
+}
+
+// ListFiles solicita os nomes dos arquivos do diretório base pela conexão c
+// (cifrada, se aberta com DialSecure).
+func (c *Conn) ListFiles(timeout time.Duration) ([]string, error) {
+    entries, err := c.ListDir("", false, timeout)
+    if err != nil { return nil, err }
+    return protocol.Lst{Entries: entries}.Names(), nil
+}
+
+// ListDir percorre todas as páginas da listagem de dir pela conexão c e
+// devolve as entradas reunidas.
+func (c *Conn) ListDir(dir string, recursive bool, timeout time.Duration) ([]protocol.Entry, error) {
+    var entries []protocol.Entry
+    for e, err := range c.List(ListConfig{Dir: dir, Recursive: recursive, Timeout: timeout}) {
+        if err != nil { return entries, err }
+        entries = append(entries, e)
+    }
+    return entries, nil
+}
+
+// List itera as entradas da listagem conforme a ListConfig, pedindo cada
+// página ao consumir a anterior; uma falha (ERR do servidor ou página sem
+// resposta após Retries tentativas) encerra a iteração com o erro.
+func (c *Conn) List(cfg ListConfig) iter.Seq2[protocol.Entry, error] {
+    if cfg.Timeout <= 0 { cfg.Timeout = 2 * time.Second }
+    if cfg.Retries <= 0 { cfg.Retries = 3 }
+    return func(yield func(protocol.Entry, error) bool) {
+        req := protocol.List{PageSize: cfg.PageSize, Path: cfg.Dir}
+        if cfg.Recursive { req.Flags |= protocol.ListFlagRecursive }
+        for {
+            lst, err := c.listPage(req, cfg.Timeout, cfg.Retries)
+            if err != nil { yield(protocol.Entry{}, err); return }
+            for _, e := range lst.Entries {
+                if !yield(e, nil) { return }
+            }
+            if lst.Next == "" { return }
+            req.Cursor = lst.Next
+        }
+    }
+}
+
+// pede uma página (LIST) e aguarda o LST, reenviando o pedido a cada timeout.
+// Cada página usa um token próprio: um LST atrasado de uma página anterior
+// não se confunde com a atual.
+func (c *Conn) listPage(req protocol.List, timeout time.Duration, retries int) (protocol.Lst, error) {
+    s := c.openStream(nil)
+    defer s.close()
+    req.Token = s.token
+    pkt := protocol.CtrlLIST(req)
+    for attempt := 0; attempt < retries; attempt++ {
+        if err := s.write(pkt); err != nil { return protocol.Lst{}, err }
+        b, err := s.read(timeout)
+        if err == errTimeout { continue }
+        if err != nil { return protocol.Lst{}, err }
+        typ, v, err := protocol.DecodeCtrl(b)
+        if err != nil { continue }
+        switch typ {
+        case protocol.TypeLST:
+            return v.(protocol.Lst), nil
+        case protocol.TypeERR:
+            er := v.(protocol.ErrMsg)
+            return protocol.Lst{}, &ServerError{Code: er.Code, Message: er.Message, RetryAfter: er.RetryAfter}
+        }
+    }
+    return protocol.Lst{}, timeoutf("listagem sem resposta após %d tentativas", retries)
+}
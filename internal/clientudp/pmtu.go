@@ -0,0 +1,71 @@
+package clientudp
+
+import (
+    "errors"
+    "sort"
+    "time"
+
+    "udp/internal/config"
+    "udp/internal/pmtu"
+    "udp/internal/protocol"
+)
+
+// rodadas de refinamento após a escada inicial de tamanhos
+const probeRefineRounds = 3
+
+// ProbeMTU descobre o maior datagrama (até limit bytes, 0 = limite do UDP) que
+// chega do servidor sem fragmentação. Cada rodada envia PROBEs de vários
+// tamanhos de uma vez e aguarda os PROBEACKs por até wait: a primeira usa
+// pmtu.Ladder e as seguintes subdividem o intervalo entre o maior tamanho
+// entregue e o menor perdido.
+func (c *Conn) ProbeMTU(limit int, wait time.Duration, cancel <-chan struct{}) (int, error) {
+    if limit <= 0 || limit > config.MaxDatagramSize { limit = config.MaxDatagramSize }
+    sm := c.openStream(cancel)
+    defer sm.close()
+
+    var sizes []int
+    for _, n := range pmtu.Ladder { if n <= limit { sizes = append(sizes, n) } }
+    if len(sizes) == 0 || sizes[0] != limit { sizes = append([]int{limit}, sizes...) }
+
+    best, fail := 0, limit+1 // maior tamanho entregue / menor perdido acima dele
+    for round := 0; round <= probeRefineRounds && len(sizes) > 0; round++ {
+        ok, err := probeRound(sm, sizes, wait)
+        if err != nil { return 0, err }
+        if round == 0 && len(ok) == 0 {
+            ok, err = probeRound(sm, sizes, wait) // perda da rodada inteira: repete uma vez
+            if err != nil { return 0, err }
+        }
+        for _, n := range sizes {
+            if ok[n] { best = max(best, n) }
+        }
+        for _, n := range sizes {
+            if !ok[n] && n > best && n < fail { fail = n }
+        }
+        if best == 0 { return 0, errors.New("servidor não respondeu às sondas de MTU") }
+        // próxima rodada: 7 tamanhos igualmente espaçados em (best, fail)
+        sizes = sizes[:0]
+        if step := (fail - best) / 8; step >= 16 {
+            for i := 1; i < 8; i++ { sizes = append(sizes, best+i*step) }
+        }
+    }
+    return best, nil
+}
+
+// envia um PROBE para cada tamanho e retorna os que foram respondidos em wait.
+func probeRound(sm *stream, sizes []int, wait time.Duration) (map[int]bool, error) {
+    sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+    for _, n := range sizes {
+        if err := sm.write(protocol.CtrlPROBE(sm.token, n)); err != nil { return nil, err }
+    }
+    ok := make(map[int]bool, len(sizes))
+    deadline := time.Now().Add(wait)
+    for len(ok) < len(sizes) {
+        b, err := sm.read(time.Until(deadline))
+        if err == errTimeout { break }
+        if err != nil { return nil, err }
+        typ, v, e := protocol.DecodeCtrl(b)
+        if e != nil || typ != protocol.TypePROBEACK { continue }
+        if p := v.(protocol.Probe); p.Size == len(b) { ok[p.Size] = true }
+    }
+    return ok, nil
+}
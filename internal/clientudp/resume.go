@@ -0,0 +1,86 @@
+package clientudp
+
+import (
+    "encoding/json"
+    "errors"
+    "os"
+    "time"
+
+    "udp/internal/protocol"
+    "udp/internal/segfile"
+)
+
+// intervalo mínimo entre gravações do estado parcial durante a recepção
+const checkpointInterval = 1 * time.Second
+
+// partialState é o sidecar persistido ao lado de <saída>.part, com o que é
+// necessário para retomar o download: META recebido e bitmap de segmentos
+// já gravados no arquivo parcial.
+type partialState struct {
+    Path     string `json:"path"`     // caminho solicitado ao servidor
+    Filename string `json:"filename"` // nome informado no META
+    Size     int64  `json:"size"`     // tamanho do arquivo
+    Total    uint32 `json:"total"`    // quantidade de segmentos
+    Chunk    int    `json:"chunk"`    // tamanho dos segmentos
+    SHA256   string `json:"sha256"`   // hash do arquivo completo
+    Received []byte `json:"received"` // bitmap de segmentos recebidos
+}
+
+// caminho do sidecar de estado parcial para a saída baseOut.
+func sidecarPath(baseOut string) string { return segfile.PartPath(baseOut) + ".json" }
+
+// lê o estado parcial de baseOut, se existir.
+func loadPartial(baseOut string) (*partialState, error) {
+    data, err := os.ReadFile(sidecarPath(baseOut))
+    if err != nil { return nil, err }
+    var p partialState
+    if err := json.Unmarshal(data, &p); err != nil { return nil, err }
+    return &p, nil
+}
+
+// grava atomicamente (arquivo temporário + rename) o estado parcial de baseOut.
+func savePartial(baseOut, path string, meta protocol.Meta, recv *segfile.Bitmap) error {
+    p := partialState{Path: path, Filename: meta.Filename, Size: meta.Size, Total: meta.Total, Chunk: meta.Chunk, SHA256: meta.SHA256, Received: recv.Bytes()}
+    data, err := json.Marshal(p)
+    if err != nil { return err }
+    tmp := sidecarPath(baseOut) + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o644); err != nil { return err }
+    return os.Rename(tmp, sidecarPath(baseOut))
+}
+
+// remove o sidecar de baseOut.
+func removePartial(baseOut string) { _ = os.Remove(sidecarPath(baseOut)) }
+
+// indica se o META atual do servidor descreve o mesmo arquivo do estado salvo.
+func (p *partialState) matches(path string, meta protocol.Meta) bool {
+    return p.Path == path && p.Filename == meta.Filename && p.Size == meta.Size &&
+        p.Total == meta.Total && p.Chunk == meta.Chunk && p.SHA256 == meta.SHA256
+}
+
+// reabre o arquivo parcial descrito pelo estado salvo.
+func (p *partialState) reopen(baseOut string) (*segfile.Sink, error) {
+    recv, err := segfile.BitmapFromBytes(p.Total, p.Received)
+    if err != nil { return nil, err }
+    return segfile.Reopen(baseOut, p.Size, p.Chunk, recv)
+}
+
+// checkpoint sincroniza o arquivo parcial e grava o sidecar, no máximo uma vez
+// por checkpointInterval (ou sempre, se force).
+func (st recvState) checkpoint(force bool) error {
+    if st.ckpt == nil { return nil }
+    if !force && time.Since(st.ckpt.last) < checkpointInterval { return nil }
+    st.ckpt.last = time.Now()
+    if err := st.sink.Sync(); err != nil { return err }
+    return savePartial(st.ckpt.baseOut, st.ckpt.path, st.ckpt.meta, st.sink.Received())
+}
+
+// checkpointer guarda o que é necessário para persistir o estado parcial.
+type checkpointer struct {
+    baseOut string        // caminho de saída final
+    path    string        // caminho solicitado ao servidor
+    meta    protocol.Meta // META da transferência
+    last    time.Time     // última gravação do sidecar
+}
+
+// errResumeMismatch indica que o arquivo mudou no servidor desde o download parcial.
+var errResumeMismatch = errors.New("META do servidor difere do download parcial")
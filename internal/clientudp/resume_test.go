@@ -0,0 +1,78 @@
+package clientudp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"udp/internal/protocol"
+	"udp/internal/segfile"
+)
+
+// O estado salvo retoma o mesmo download: volta igual do sidecar, confere
+// com o META do mesmo arquivo e reabre o .part com os segmentos já gravados.
+// Um META que difere em qualquer campo (outro SHA-256: arquivo alterado no
+// servidor) não confere, e um .part de outro tamanho não reabre.
+func TestPartialState(t *testing.T) {
+	const sha = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	meta := protocol.Meta{Filename: "a.bin", Size: 1000, Total: 10, Chunk: 100, SHA256: sha}
+	out := filepath.Join(t.TempDir(), "a.bin")
+	sink, err := segfile.Create(out, meta.Size, meta.Chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seq := range []uint32{0, 3, 9} {
+		if _, err := sink.WriteChunk(seq, make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+	if err := savePartial(out, "dir/a.bin", meta, sink.Received()); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := loadPartial(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.matches("dir/a.bin", meta) {
+		t.Fatalf("estado %+v não confere com o próprio META", p)
+	}
+	other := meta
+	other.SHA256 = "0" + sha[1:]
+	tests := []struct {
+		name string
+		path string
+		meta protocol.Meta
+	}{
+		{"outro SHA-256", "dir/a.bin", other},
+		{"outro caminho", "dir/b.bin", meta},
+		{"outro tamanho", "dir/a.bin", protocol.Meta{Filename: "a.bin", Size: 1001, Total: 11, Chunk: 100, SHA256: sha}},
+		{"outro chunk", "dir/a.bin", protocol.Meta{Filename: "a.bin", Size: 1000, Total: 5, Chunk: 200, SHA256: sha}},
+	}
+	for _, tt := range tests {
+		if p.matches(tt.path, tt.meta) {
+			t.Errorf("%s: estado confere", tt.name)
+		}
+	}
+
+	r, err := p.reopen(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Received().Missing(); len(got) != 7 || r.Received().Count() != 3 || !r.Received().Has(9) {
+		t.Fatalf("reaberto com faltantes %v, esperado os 7 não gravados", got)
+	}
+	r.Close()
+
+	if err := os.Truncate(segfile.PartPath(out), meta.Size/2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.reopen(out); err == nil {
+		t.Fatal(".part com tamanho errado reaberto")
+	}
+	removePartial(out)
+	if _, err := loadPartial(out); err == nil {
+		t.Fatal("estado carregado após removePartial")
+	}
+}
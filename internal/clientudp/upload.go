@@ -0,0 +1,236 @@
+package clientudp
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "udp/internal/capture"
+    "udp/internal/congestion"
+    "udp/internal/impair"
+    "udp/internal/logger"
+    "udp/internal/protocol"
+    "udp/internal/segfile"
+)
+
+// Define parâmetros de um envio (PUT) de arquivo ao servidor.
+type UploadConfig struct {
+    Host        string          // Host do servidor
+    Port        int             // Porta do servidor
+    LocalPath   string          // Arquivo local a enviar
+    RemoteName  string          // Nome no diretório de upload do servidor (vazio = nome do arquivo local)
+    Timeout     time.Duration   // Timeout base para respostas do servidor
+    Retries     int             // Tentativas do PUT e do EOF sem resposta
+    MaxDatagram int             // Maior datagrama DATA a enviar, proposto no PUT (0 = padrão do servidor)
+    Cancel      <-chan struct{} // Canal opcional para cancelamento assíncrono
+    PSK         []byte          // Chave pré-compartilhada do modo cifrado (nil = sem cifra); usada por RunUpload ao abrir o socket
+    Events      *logger.EventLog // Eventos estruturados do envio em JSON lines (nil = descartados)
+    Capture     *capture.Writer  // Grava os datagramas do socket aberto por RunUpload em pcap (nil = sem captura)
+    Impair      *impair.Impairer // Degrada o tráfego do socket aberto por RunUpload (nil = rede real)
+}
+
+// RunUpload envia um arquivo ao servidor conforme a UploadConfig, usando um
+// socket próprio, e aciona Callbacks nos eventos: OnMeta recebe o META de
+// aceite, OnProgress os bytes/segmentos enviados e OnDone o nome remoto e se
+// o servidor confirmou o arquivo.
+func RunUpload(cfg UploadConfig, cb Callbacks) {
+    c, err := DialWith(cfg.Host, cfg.Port, DialOptions{PSK: cfg.PSK, Timeout: cfg.Timeout, Attempts: cfg.Retries, Capture: cfg.Capture, Impair: cfg.Impair})
+    if err != nil {
+        if cb.OnLog != nil { cb.OnLog("ERRO: " + err.Error()) }
+        if cb.OnDone != nil { cb.OnDone("", false) }
+        return
+    }
+    defer c.Close()
+    runUpload(c, cfg, cb)
+}
+
+// RunUpload executa um envio sobre esta conexão, compartilhando o socket com
+// outras transferências.
+func (c *Conn) RunUpload(cfg UploadConfig, cb Callbacks) {
+    runUpload(c, cfg, cb)
+}
+
+// executa o envio sobre a conexão c, reportando o resultado via Callbacks.
+func runUpload(c *Conn, cfg UploadConfig, cb Callbacks) {
+    var name string
+    ev := newTransferEvents(cfg.Events, c, "upload", cfg.RemoteName)
+    start := time.Now()
+    err := retryBusy(cfg.Retries, cfg.Cancel, cb.OnLog, func() (err error) {
+        name, err = uploadOnce(c, cfg, cb, ev)
+        return err
+    })
+    e := ev.event("")
+    e.Duration = logger.Millis(time.Since(start))
+    if err == nil { e.Bytes = uint64(ev.size) }
+    ev.finish(err, e)
+    if cb.OnLog != nil {
+        if err != nil {
+            cb.OnLog("ERRO: " + err.Error())
+        } else {
+            cb.OnLog("SUCCESS: Envio concluído: " + name + " gravado e verificado pelo servidor")
+        }
+    }
+    if cb.OnDone != nil { cb.OnDone(name, err == nil) }
+}
+
+// Anuncia o arquivo (PUT), envia os segmentos aceitos e aguarda a
+// confirmação do servidor, registrando os eventos em ev. Retorna o nome remoto.
+func uploadOnce(c *Conn, cfg UploadConfig, cb Callbacks, ev *transferEvents) (string, error) {
+    if cfg.Timeout <= 0 { cfg.Timeout = 2 * time.Second }
+    if cfg.Retries <= 0 { cfg.Retries = 3 }
+    st, err := os.Stat(cfg.LocalPath)
+    if err != nil { return "", err }
+    if st.IsDir() { return "", errors.New("é diretório: " + cfg.LocalPath) }
+    name := cfg.RemoteName
+    if strings.TrimSpace(name) == "" { name = filepath.Base(cfg.LocalPath) }
+    ev.base.Path = name
+    if cb.OnLog != nil { cb.OnLog("STATUS: Calculando SHA-256 de " + cfg.LocalPath) }
+    sha, err := segfile.HashFile(cfg.LocalPath, st)
+    if err != nil { return name, err }
+
+    sm := c.openStream(cfg.Cancel)
+    defer sm.close()
+    // no modo cifrado o envelope ocupa parte do datagrama informado
+    maxDatagram := cfg.MaxDatagram
+    if maxDatagram > 0 { maxDatagram = max(maxDatagram-c.overhead, 1) }
+    put := protocol.Put{Token: sm.token, Size: st.Size(), MaxDatagram: maxDatagram, Name: name, SHA256: sha}
+    meta, err := awaitMeta(sm, protocol.CtrlPUT(put), protocol.TypePUT, cfg.Timeout, cfg.Retries, cb)
+    if err != nil { return name, err }
+
+    src, _, err := segfile.Open(cfg.LocalPath, meta.Chunk)
+    if err != nil { return name, err }
+    defer src.Close()
+    if src.Total() != meta.Total || src.Size() != meta.Size {
+        return name, fmt.Errorf("META inconsistente com o arquivo local: total=%d size=%d chunk=%d", meta.Total, meta.Size, meta.Chunk)
+    }
+    if cb.OnMeta != nil { cb.OnMeta(meta) }
+    ev.start(meta, false)
+    return name, sendSegments(sm, src, meta, cfg, cb, ev)
+}
+
+// Envia os segmentos do arquivo com controle de congestionamento (alimentado
+// pelos FBKs do servidor), retransmite os pedidos por NACK e aguarda o DONE.
+func sendSegments(sm *stream, src *segfile.Source, meta protocol.Meta, cfg UploadConfig, cb Callbacks, ev *transferEvents) error {
+    cc := congestion.New()
+    nacks := make(chan []uint32, 64)   // listas de faltantes pedidas pelo servidor
+    halt := make(chan struct{})        // fechado ao receber o DONE
+    var result protocol.Done           // DONE recebido (válido após halt)
+    var haltOnce sync.Once
+    stop := make(chan struct{})        // encerra a leitura das respostas
+    defer close(stop)
+    go func() {
+        for {
+            b, err := sm.read(cfg.Timeout)
+            select {
+            case <-stop:
+                return
+            default:
+            }
+            if err == ErrCancelled || err == errClosed { return }
+            if err != nil || !protocol.IsCtrl(b) { continue }
+            typ, v, err := protocol.DecodeCtrl(b)
+            if err != nil { continue }
+            switch typ {
+            case protocol.TypeFBK:
+                f := v.(protocol.Feedback)
+                cc.OnFeedback(f.Received, f.Lost, f.EchoSeq, time.Duration(f.DelayMicros)*time.Microsecond)
+            case protocol.TypeNACK:
+                select {
+                case nacks <- v.(protocol.Nack).Missing:
+                default: // o servidor repetirá o pedido no próximo round
+                }
+            case protocol.TypeDONE:
+                haltOnce.Do(func() { result = v.(protocol.Done); close(halt) })
+                return
+            }
+        }
+    }()
+    // resultado informado pelo servidor no DONE
+    outcome := func() error {
+        if result.Status == protocol.DoneOK { return nil }
+        return errors.New("servidor recusou o arquivo: " + result.Message)
+    }
+
+    buf := make([]byte, meta.Chunk)
+    sentBytes := 0 // tamanho do último datagrama enviado (eventos)
+    send := func(seq uint32) error {
+        if err := cc.Wait(halt); err != nil { return err }
+        chunk, err := src.ReadChunk(seq, buf)
+        if err != nil { return err }
+        h := protocol.DataHeader{Session: meta.Session, Seq: seq, Total: meta.Total, Size: uint16(len(chunk)), CRC32: protocol.CRC32(chunk)}
+        pkt := append(protocol.PackHeader(h), chunk...)
+        if err := sm.write(pkt); err != nil { return err }
+        cc.OnSent(seq, len(pkt))
+        sentBytes = len(pkt)
+        return nil
+    }
+    // interrupção do envio: DONE antecipado (falha no servidor) ou erro local
+    abort := func(err error) error {
+        if errors.Is(err, congestion.ErrStopped) { return outcome() }
+        return err
+    }
+
+    if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("STATUS: Enviando %d segmentos", meta.Total)) }
+    var bytesSent uint64
+    batch := &logger.SegmentBatcher{Log: ev.log, Base: ev.event("")} // faixas do evento segments_sent
+    for seq := uint32(0); seq < meta.Total; seq++ {
+        select {
+        case <-cfg.Cancel:
+            batch.Flush()
+            return ErrCancelled
+        default:
+        }
+        if err := send(seq); err != nil { batch.Flush(); return abort(err) }
+        batch.Add(seq, sentBytes)
+        bytesSent += uint64(src.Chunk())
+        if seq == meta.Total-1 { bytesSent = uint64(src.Size()) }
+        if cb.OnLog != nil && seq%500 == 0 { cb.OnLog(fmt.Sprintf("STATUS: progresso seq=%d/%d", seq, meta.Total-1)) }
+        if cb.OnProgress != nil { cb.OnProgress(bytesSent, uint64(seq)+1) }
+    }
+
+    // EOF e retransmissões pedidas pelo servidor até o DONE
+    batch.Flush()
+    if err := sm.write(protocol.CtrlEOF(meta.Session)); err != nil { return err }
+    eof := ev.event(logger.EventEOFSent)
+    eof.Total = meta.Total
+    ev.log.Emit(eof)
+    if cb.OnLog != nil { cb.OnLog("STATUS: EOF enviado; aguardando confirmação do servidor") }
+    idle := 0 // esperas seguidas sem resposta do servidor
+    for {
+        t := time.NewTimer(cfg.Timeout)
+        select {
+        case missing := <-nacks:
+            t.Stop()
+            idle = 0
+            if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("NACK <- servidor: retransmitindo %d segmentos: %s", len(missing), fmtSeqs(missing))) }
+            e := ev.event(logger.EventNackReceived)
+            e.Count, e.Range = len(missing), logger.RangeOf(missing)
+            ev.log.Emit(e)
+            re := ev.event(logger.EventRetransmit) // segmentos reenviados para este NACK
+            var sendErr error
+            for _, seq := range missing {
+                if seq >= meta.Total { continue }
+                if sendErr = send(seq); sendErr != nil { break }
+                re.Count++
+                re.Bytes += uint64(sentBytes)
+            }
+            if re.Count > 0 { ev.log.Emit(re) }
+            if sendErr != nil { return abort(sendErr) }
+        case <-halt:
+            t.Stop()
+            return outcome()
+        case <-cfg.Cancel:
+            t.Stop()
+            return ErrCancelled
+        case <-t.C:
+            idle++
+            if idle > cfg.Retries { return timeoutf("nenhuma confirmação após o EOF") }
+            if cb.OnLog != nil { cb.OnLog(fmt.Sprintf("WARN: sem confirmação; reenviando EOF (%d/%d)", idle, cfg.Retries)) }
+            if err := sm.write(protocol.CtrlEOF(meta.Session)); err != nil { return err }
+        }
+    }
+}
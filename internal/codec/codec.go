@@ -0,0 +1,180 @@
+// Package codec comprime e descomprime segmentos DATA isoladamente (cada
+// segmento é um fluxo DEFLATE ou gzip completo), escolhe o codec de cada
+// arquivo e estima o tamanho comprimido por amostragem.
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"udp/internal/protocol"
+	"udp/internal/segfile"
+)
+
+// Supported são os codecs implementados, em ordem de preferência.
+var Supported = []protocol.Codec{protocol.CodecDeflate, protocol.CodecGzip}
+
+// All é o conjunto de todos os codecs implementados.
+var All = protocol.Codecs(Supported...)
+
+// MinSaving é a economia mínima (fração do tamanho original) para que o
+// servidor comprima um arquivo.
+const MinSaving = 0.05
+
+// segmentos comprimidos por Sample
+const sampleSegments = 64
+
+// extensões de formatos já comprimidos, enviados sem compressão
+var compressedExt = map[string]bool{
+	".gz": true, ".tgz": true, ".zip": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true, ".heic": true,
+	".mp3": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".mkv": true, ".webm": true, ".mov": true, ".avi": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".jar": true, ".apk": true,
+}
+
+// Parse interpreta uma lista de codecs separados por vírgula ("deflate,gzip");
+// "none" ou texto vazio resultam no conjunto vazio (sem compressão).
+func Parse(spec string) (protocol.CodecSet, error) {
+	var set protocol.CodecSet
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "none" {
+			continue
+		}
+		found := false
+		for _, c := range Supported {
+			if c.String() == name {
+				set |= protocol.Codecs(c)
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("codec desconhecido %q (use deflate, gzip ou none)", name)
+		}
+	}
+	return set, nil
+}
+
+// Choose escolhe o codec de name entre os oferecidos pelo cliente: o primeiro
+// de Supported que ele aceita, ou CodecNone para formatos já comprimidos.
+func Choose(offered protocol.CodecSet, name string) protocol.Codec {
+	if compressedExt[strings.ToLower(filepath.Ext(name))] {
+		return protocol.CodecNone
+	}
+	for _, c := range Supported {
+		if offered.Has(c) {
+			return c
+		}
+	}
+	return protocol.CodecNone
+}
+
+// compressores reutilizáveis (flate.NewWriter aloca centenas de KiB)
+var (
+	deflatePool = sync.Pool{New: func() any { w, _ := flate.NewWriter(nil, flate.BestSpeed); return w }}
+	gzipPool    = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed); return w }}
+	inflatePool = sync.Pool{New: func() any { return flate.NewReader(bytes.NewReader(nil)) }}
+)
+
+// Compress comprime src com c e retorna um novo slice.
+func Compress(c protocol.Codec, src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(src))
+	switch c {
+	case protocol.CodecDeflate:
+		w := deflatePool.Get().(*flate.Writer)
+		defer deflatePool.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(src); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case protocol.CodecGzip:
+		w := gzipPool.Get().(*gzip.Writer)
+		defer gzipPool.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(src); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("codec não suportado: %v", c)
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrSize indica um segmento que não descomprime para o tamanho esperado.
+var ErrSize = errors.New("segmento descomprimido com tamanho inesperado")
+
+// Decompress descomprime src com c; o resultado deve ter exatamente n bytes
+// (a leitura para em n+1, limitando segmentos maliciosos).
+func Decompress(c protocol.Codec, src []byte, n int) ([]byte, error) {
+	var r io.Reader
+	switch c {
+	case protocol.CodecDeflate:
+		fr := inflatePool.Get().(io.ReadCloser)
+		defer inflatePool.Put(fr)
+		if err := fr.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+			return nil, err
+		}
+		r = fr
+	case protocol.CodecGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	default:
+		return nil, fmt.Errorf("codec não suportado: %v", c)
+	}
+	out := make([]byte, n+1)
+	got, err := io.ReadFull(r, out)
+	switch {
+	case err == nil || got != n:
+		return nil, ErrSize
+	case err != io.ErrUnexpectedEOF && err != io.EOF:
+		return nil, err
+	}
+	return out[:n], nil
+}
+
+// Sample comprime até sampleSegments segmentos de src com c, espalhados pelo
+// arquivo, e estima o total de bytes de payload DATA (cada segmento
+// comprimido ou, se não diminuir, original) pela economia da amostra, sem
+// comprimir o arquivo inteiro. Arquivos de até sampleSegments segmentos são
+// medidos por completo.
+func Sample(src *segfile.Source, c protocol.Codec) (int64, error) {
+	buf := make([]byte, src.Chunk())
+	n := min(src.Total(), sampleSegments)
+	var raw, wire int64
+	for i := range n {
+		seq := uint32(uint64(i) * uint64(src.Total()) / uint64(n))
+		chunk, err := src.ReadChunk(seq, buf)
+		if err != nil {
+			return 0, err
+		}
+		z, err := Compress(c, chunk)
+		if err != nil {
+			return 0, err
+		}
+		raw += int64(len(chunk))
+		wire += int64(min(len(z), len(chunk)))
+	}
+	if raw == 0 || raw == src.Size() {
+		return wire, nil
+	}
+	return int64(float64(src.Size()) * float64(wire) / float64(raw)), nil
+}
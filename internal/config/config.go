@@ -14,8 +14,13 @@ import (
 
 // Constantes do protocolo
 const (
-	ProtocolVersion = 1
-	ChunkSize       = 1024 // bytes por segmento de dados (evitar fragmentação com MTU típica)
+	ProtocolVersion    = 3         // versão mais recente falada (HELLO)
+	MinProtocolVersion = 1         // versão mais antiga ainda aceita (v1 original, sem sessão)
+	ChunkSize          = 1024      // bytes por segmento de dados (evitar fragmentação com MTU típica)
+	MinChunkSize       = 256       // menor segmento negociável no REQ
+	MaxChunkSize       = 60 * 1024 // maior segmento negociável (loopback / jumbo frames)
+	MaxDatagramSize    = 65507     // maior payload UDP sobre IPv4
+	MaxFECData         = 128       // maior bloco FEC (segmentos de dados por bloco)
 
 	// Rede / MTU
 	MTUDefault        = 1500
@@ -38,6 +43,18 @@ var (
 	DefaultTimeout = 2 * time.Second
 	DefaultRetries = 5
 
+	// Tempo sem atividade após o qual o servidor descarta uma sessão
+	SessionIdleTimeout = 60 * time.Second
+
+	// Controle de congestionamento: intervalo entre feedbacks (FBK) do cliente
+	// e tempo sem feedback após o qual o servidor interrompe o envio
+	FeedbackInterval = 20 * time.Millisecond
+	FeedbackTimeout  = 10 * time.Second
+
+	// Espera sugerida ao cliente quando o servidor recusa um pedido por
+	// capacidade (ERR BUSY); o cliente a usa como piso do seu backoff
+	BusyRetryAfter = 2 * time.Second
+
 	// Parâmetros de teste (simulação de perda)
 	DefaultDropRate = 0.0
 )
@@ -81,6 +98,14 @@ type ServerSettings struct {
 	Host         string `json:"host"`
 	Port         string `json:"port"`
 	BaseDir      string `json:"base_dir"`
+	UploadDir    string `json:"upload_dir"`      // diretório que recebe envios (PUT); vazio desabilita
+	UploadQuota  int64  `json:"upload_quota_mb"` // cota de upload em MB (0 = sem limite)
+	MaxClients   int    `json:"max_clients"`     // clientes (endereços) com transferência em uso (0 = sem limite)
+	MaxTransfers int    `json:"max_transfers"`   // transferências simultâneas (0 = sem limite)
+	MaxPerIP     int    `json:"max_per_ip"`      // transferências simultâneas por IP (0 = sem limite)
+	RateGlobal   string `json:"rate_global"`     // limite de banda global ("10M"; vazio = sem limite)
+	RateClient   string `json:"rate_client"`     // limite de banda por IP de cliente
+	RateSubnets  string `json:"rate_subnets"`    // limites por sub-rede ("10.0.0.0/8=5M,...")
 	WindowWidth  int    `json:"window_width"`
 	WindowHeight int    `json:"window_height"`
 }
@@ -100,13 +125,15 @@ type ClientConfig struct {
 
 // representa a configuração do servidor
 type ServerConfig struct {
-	Host       string `json:"host"`
-	Port       int    `json:"port"`
-	BaseDir    string `json:"base_dir"`
-	ChunkSize  int    `json:"chunk_size"`
-	BufferSize int    `json:"buffer_size"`
-	MaxClients int    `json:"max_clients"`
-	LogLevel   string `json:"log_level"`
+	Host         string `json:"host"`
+	Port         int    `json:"port"`
+	BaseDir      string `json:"base_dir"`
+	ChunkSize    int    `json:"chunk_size"`
+	BufferSize   int    `json:"buffer_size"`
+	MaxClients   int    `json:"max_clients"`
+	MaxTransfers int    `json:"max_transfers"`
+	MaxPerIP     int    `json:"max_per_ip"`
+	LogLevel     string `json:"log_level"`
 }
 
 // representa os parâmetros da UI do cliente
@@ -349,7 +376,6 @@ func ValidateDropRate(rate string) error {
 	return nil
 }
 
-
 // valida o timeout
 func ValidateTimeout(timeout string) error {
 	if strings.TrimSpace(timeout) == "" {
@@ -405,7 +431,6 @@ func ValidateAll(params ValidationParams) []error {
 		errors = append(errors, err)
 	}
 
-
 	if err := ValidateTimeout(params.Timeout); err != nil {
 		errors = append(errors, err)
 	}
@@ -414,7 +439,6 @@ func ValidateAll(params ValidationParams) []error {
 		errors = append(errors, err)
 	}
 
-
 	return errors
 }
 
@@ -0,0 +1,280 @@
+// Package congestion implementa o controle de congestionamento e de taxa do
+// envio de segmentos: janela AIMD (slow start + congestion avoidance) em
+// datagramas, com espaçamento (pacing) de srtt/cwnd entre envios, alimentada
+// pelo feedback periódico do receptor (FBK) com contagens de recebidos e
+// perdidos e um eco para estimar o RTT.
+package congestion
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"udp/internal/config"
+)
+
+const (
+	initialCwnd = 32.0    // janela inicial (datagramas)
+	minCwnd     = 4.0     // janela mínima
+	maxCwnd     = 65536.0 // janela máxima
+	pacingGain  = 1.25    // taxa de pacing acima de cwnd/srtt para a janela ser o limitante
+	burstSlack  = time.Millisecond
+	ringSize    = 4096 // registros de envio guardados para amostrar RTT
+)
+
+// Erros devolvidos por Wait.
+var (
+	ErrStalled = errors.New("receptor sem feedback")
+	ErrStopped = errors.New("envio interrompido")
+)
+
+// Stats é uma fotografia do estado do controlador.
+type Stats struct {
+	Cwnd      float64       // janela de congestionamento (datagramas)
+	Ssthresh  float64       // limiar de slow start (datagramas)
+	SRTT      time.Duration // RTT suavizado
+	RTTVar    time.Duration // variação do RTT
+	Inflight  int64         // datagramas enviados e ainda não confirmados
+	SendRate  float64       // taxa de envio medida (bytes/s)
+	Delivered uint64        // datagramas recebidos segundo o feedback
+	Lost      uint64        // datagramas perdidos segundo o feedback
+}
+
+// LossRatio é a fração dos datagramas relatados no feedback que se perderam
+// (0 sem feedback).
+func (s Stats) LossRatio() float64 {
+	if s.Delivered+s.Lost == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(s.Delivered+s.Lost)
+}
+
+// registro de envio para amostragem de RTT
+type sendRecord struct {
+	seq uint32
+	at  time.Time
+}
+
+// Controller controla a janela e o ritmo de envio de uma sessão. É seguro
+// para uso concorrente (envio inicial e retransmissões compartilham a janela).
+type Controller struct {
+	mu       sync.Mutex
+	cwnd     float64
+	ssthresh float64
+	srtt     time.Duration
+	rttvar   time.Duration
+	hasRTT   bool
+
+	sent      uint64 // datagramas enviados
+	delivered uint64 // datagramas recebidos segundo o feedback
+	lost      uint64 // datagramas perdidos segundo o feedback
+	forgiven  uint64 // datagramas dados como perdidos por RTO
+	lastRecv  uint32 // último contador bruto de recebidos (FBK)
+	lastLost  uint32 // último contador bruto de perdidos (FBK)
+
+	lastCut      time.Time // último corte multiplicativo
+	lastProgress time.Time // último feedback com progresso
+	lastFeedback time.Time // último feedback recebido
+	nextSend     time.Time // instante liberado para o próximo envio (pacing)
+
+	ring [ringSize]sendRecord
+	wake chan struct{} // sinalizado a cada feedback
+
+	rateBytes uint64    // bytes enviados na janela de medição
+	rateStart time.Time // início da janela de medição
+	rate      float64   // taxa medida (EWMA, bytes/s)
+
+	now func() time.Time // relógio (time.Now; substituído nos testes)
+}
+
+// New cria um controlador com janela inicial e RTT ainda desconhecido.
+func New() *Controller { return newController(time.Now) }
+
+// cria o controlador de New com o relógio clock.
+func newController(clock func() time.Time) *Controller {
+	now := clock()
+	return &Controller{
+		cwnd:         initialCwnd,
+		ssthresh:     maxCwnd,
+		srtt:         100 * time.Millisecond,
+		rttvar:       50 * time.Millisecond,
+		lastProgress: now,
+		lastFeedback: now,
+		nextSend:     now,
+		rateStart:    now,
+		wake:         make(chan struct{}, 1),
+		now:          clock,
+	}
+}
+
+// datagramas em trânsito (enviados - recebidos - perdidos)
+func (c *Controller) inflight() int64 {
+	n := int64(c.sent) - int64(c.delivered) - int64(c.lost) - int64(c.forgiven)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// timeout de retransmissão (RFC 6298), limitado a [200ms, 2s]
+func (c *Controller) rto() time.Duration {
+	rto := c.srtt + 4*c.rttvar
+	return min(max(rto, 200*time.Millisecond), 2*time.Second)
+}
+
+// intervalo entre envios para a taxa cwnd/srtt (com ganho de pacing)
+func (c *Controller) gap() time.Duration {
+	return time.Duration(float64(c.srtt) / (c.cwnd * pacingGain))
+}
+
+// reduz a janela à metade (perda) ou ao mínimo (timeout)
+func (c *Controller) cut(timeout bool, now time.Time) {
+	c.ssthresh = max(c.cwnd/2, minCwnd)
+	if timeout {
+		c.cwnd = minCwnd
+	} else {
+		c.cwnd = c.ssthresh
+	}
+	c.lastCut = now
+}
+
+// Wait bloqueia até que a janela e o pacing permitam enviar mais um
+// datagrama. Retorna ErrStalled se o receptor ficar sem enviar feedback por
+// config.FeedbackTimeout e ErrStopped se stop for fechado.
+func (c *Controller) Wait(stop <-chan struct{}) error {
+	for {
+		c.mu.Lock()
+		now := c.now()
+		if now.Sub(c.lastFeedback) > config.FeedbackTimeout {
+			c.mu.Unlock()
+			return ErrStalled
+		}
+		inflight := c.inflight()
+		// janela cheia sem progresso por um RTO: considera o que está em trânsito perdido
+		if float64(inflight) >= c.cwnd && now.Sub(c.lastProgress) > c.rto() {
+			c.forgiven += uint64(inflight)
+			inflight = 0
+			c.cut(true, now)
+			c.lastProgress = now
+		}
+		var sleep time.Duration
+		if float64(inflight) < c.cwnd {
+			if !now.Add(burstSlack).Before(c.nextSend) {
+				if c.nextSend.Before(now.Add(-burstSlack)) {
+					c.nextSend = now // sem crédito acumulado após ociosidade
+				}
+				c.nextSend = c.nextSend.Add(c.gap())
+				c.mu.Unlock()
+				return nil
+			}
+			sleep = c.nextSend.Sub(now)
+		} else {
+			sleep = c.rto() / 4
+		}
+		c.mu.Unlock()
+
+		t := time.NewTimer(sleep)
+		select {
+		case <-c.wake:
+		case <-t.C:
+		case <-stop:
+			t.Stop()
+			return ErrStopped
+		}
+		t.Stop()
+	}
+}
+
+// OnSent registra o envio do datagrama seq com n bytes.
+func (c *Controller) OnSent(seq uint32, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.sent++
+	c.ring[seq%ringSize] = sendRecord{seq: seq, at: now}
+	c.rateBytes += uint64(n)
+	if el := now.Sub(c.rateStart); el >= 250*time.Millisecond {
+		sample := float64(c.rateBytes) / el.Seconds()
+		if c.rate == 0 {
+			c.rate = sample
+		} else {
+			c.rate = 0.75*c.rate + 0.25*sample
+		}
+		c.rateBytes = 0
+		c.rateStart = now
+	}
+}
+
+// OnSentUntracked registra o envio de um datagrama de n bytes que o receptor
+// não ecoa no feedback (ex.: paridade FEC), sem amostragem de RTT.
+func (c *Controller) OnSentUntracked(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent++
+	c.rateBytes += uint64(n)
+}
+
+// OnFeedback processa um FBK do receptor: received e lost são contadores
+// cumulativos de datagramas recebidos e perdidos; echoSeq é o último
+// segmento recebido e delay o tempo entre recebê-lo e enviar o feedback.
+func (c *Controller) OnFeedback(received, lost, echoSeq uint32, delay time.Duration) {
+	c.mu.Lock()
+	now := c.now()
+	dRecv := received - c.lastRecv // aritmética uint32 tolera a volta do contador
+	dLost := lost - c.lastLost
+	// FBK atrasado (reordenado na rede): contadores anteriores aos já vistos
+	if int32(dRecv) < 0 || int32(dLost) < 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.lastRecv, c.lastLost = received, lost
+	c.delivered += uint64(dRecv)
+	c.lost += uint64(dLost)
+	c.lastFeedback = now
+
+	if rec := c.ring[echoSeq%ringSize]; dRecv > 0 && rec.seq == echoSeq && !rec.at.IsZero() {
+		if sample := now.Sub(rec.at) - delay; sample > 0 {
+			if !c.hasRTT {
+				c.srtt, c.rttvar, c.hasRTT = sample, sample/2, true
+			} else {
+				diff := c.srtt - sample
+				if diff < 0 {
+					diff = -diff
+				}
+				c.rttvar = (3*c.rttvar + diff) / 4
+				c.srtt = (7*c.srtt + sample) / 8
+			}
+		}
+	}
+
+	switch {
+	case dLost > 0:
+		// no máximo um corte por RTT: perdas da mesma rajada contam como um evento
+		if now.Sub(c.lastCut) > c.srtt {
+			c.cut(false, now)
+		}
+	case dRecv > 0:
+		if c.cwnd < c.ssthresh {
+			c.cwnd += float64(dRecv) // slow start
+		} else {
+			c.cwnd += float64(dRecv) / c.cwnd // congestion avoidance
+		}
+		c.cwnd = min(c.cwnd, maxCwnd)
+	}
+	if dRecv > 0 || dLost > 0 {
+		c.lastProgress = now
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stats retorna o estado atual do controlador.
+func (c *Controller) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Cwnd: c.cwnd, Ssthresh: c.ssthresh, SRTT: c.srtt, RTTVar: c.rttvar, Inflight: c.inflight(), SendRate: c.rate, Delivered: c.delivered, Lost: c.lost}
+}
@@ -0,0 +1,217 @@
+package congestion
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"udp/internal/config"
+)
+
+// relógio manual para Wait e OnFeedback
+type fakeClock struct{ t time.Time }
+
+func (f *fakeClock) now() time.Time          { return f.t }
+func (f *fakeClock) advance(d time.Duration) { f.t = f.t.Add(d) }
+
+// controlador com relógio manual parado no instante inicial
+func newTest() (*Controller, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return newController(clock.now), clock
+}
+
+// canal já fechado: Wait devolve ErrStopped em vez de bloquear
+func stopped() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// Um FBK sem perdas aumenta a janela: um datagrama por recebido no slow
+// start e 1/cwnd por recebido depois do ssthresh.
+func TestAdditiveIncrease(t *testing.T) {
+	tests := []struct {
+		name     string
+		ssthresh float64
+		received uint32
+		want     float64
+	}{
+		{"slow start", maxCwnd, 10, initialCwnd + 10},
+		{"congestion avoidance", 16, 32, initialCwnd + 1},
+		{"sem recebidos", 16, 0, initialCwnd},
+	}
+	for _, tt := range tests {
+		c, _ := newTest()
+		c.ssthresh = tt.ssthresh
+		c.OnFeedback(tt.received, 0, 0, 0)
+		if got := c.Stats().Cwnd; math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: cwnd %.3f, esperado %.3f", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Perdas cortam a janela à metade, no máximo uma vez por RTT.
+func TestMultiplicativeDecrease(t *testing.T) {
+	c, clock := newTest()
+	clock.advance(time.Second) // fora do RTT do instante inicial
+	c.OnFeedback(20, 4, 0, 0)
+	st := c.Stats()
+	if st.Cwnd != initialCwnd/2 || st.Ssthresh != initialCwnd/2 {
+		t.Fatalf("após perda: cwnd %.1f ssthresh %.1f, esperado %.1f", st.Cwnd, st.Ssthresh, initialCwnd/2)
+	}
+	c.OnFeedback(30, 6, 0, 0) // mesma rajada: sem novo corte
+	if got := c.Stats().Cwnd; got != initialCwnd/2 {
+		t.Fatalf("segunda perda no mesmo RTT: cwnd %.1f, esperado %.1f", got, initialCwnd/2)
+	}
+	clock.advance(time.Second)
+	c.OnFeedback(40, 8, 0, 0)
+	if got := c.Stats().Cwnd; got != initialCwnd/4 {
+		t.Fatalf("perda no RTT seguinte: cwnd %.1f, esperado %.1f", got, initialCwnd/4)
+	}
+	for range 10 {
+		clock.advance(time.Second)
+		st = c.Stats()
+		c.OnFeedback(uint32(st.Delivered)+1, uint32(st.Lost)+1, 0, 0)
+	}
+	if got := c.Stats().Cwnd; got != minCwnd {
+		t.Fatalf("perdas repetidas: cwnd %.1f, esperado o mínimo %.1f", got, minCwnd)
+	}
+}
+
+// Um FBK com contadores cumulativos menores que os já vistos (reordenado
+// na rede) é ignorado; a volta do contador uint32 não é retrocesso.
+func TestFeedbackBackwards(t *testing.T) {
+	c, clock := newTest()
+	clock.advance(time.Second)
+	c.OnFeedback(100, 10, 0, 0)
+	before := c.Stats()
+	for _, fb := range []struct{ received, lost uint32 }{{90, 10}, {100, 9}, {50, 2}} {
+		c.OnFeedback(fb.received, fb.lost, 0, 0)
+		if got := c.Stats(); got != before {
+			t.Fatalf("FBK %d/%d retroativo alterou o estado: %+v, esperado %+v", fb.received, fb.lost, got, before)
+		}
+	}
+
+	c, _ = newTest()
+	c.lastRecv = math.MaxUint32 - 1
+	c.OnFeedback(3, 0, 0, 0) // 5 recebidos após a volta
+	if got := c.Stats().Delivered; got != 5 {
+		t.Fatalf("volta do contador: %d recebidos, esperado 5", got)
+	}
+}
+
+// Wait espaça os envios de srtt/(cwnd*pacingGain), com folga de burstSlack,
+// e espera por feedback com a janela cheia.
+func TestWaitPacing(t *testing.T) {
+	c, clock := newTest()
+	gap := c.gap()
+	if want := time.Duration(float64(100*time.Millisecond) / (initialCwnd * pacingGain)); gap != want {
+		t.Fatalf("intervalo %v, esperado %v", gap, want)
+	}
+	if err := c.Wait(nil); err != nil {
+		t.Fatalf("primeiro envio: %v", err)
+	}
+	c.OnSent(0, 1000)
+	if err := c.Wait(stopped()); err != ErrStopped {
+		t.Fatalf("envio antes do intervalo: erro %v, esperado %v", err, ErrStopped)
+	}
+	clock.advance(gap - burstSlack) // dentro da folga
+	if err := c.Wait(nil); err != nil {
+		t.Fatalf("envio após o intervalo: %v", err)
+	}
+	c.OnSent(1, 1000)
+
+	// janela cheia: só o feedback libera o próximo envio
+	for seq := uint32(2); seq < initialCwnd; seq++ {
+		clock.advance(gap)
+		c.OnSent(seq, 1000)
+	}
+	clock.advance(time.Second / 10)
+	if err := c.Wait(stopped()); err != ErrStopped {
+		t.Fatalf("janela cheia: erro %v, esperado %v", err, ErrStopped)
+	}
+	c.OnFeedback(1, 0, 0, 0)
+	if err := c.Wait(nil); err != nil {
+		t.Fatalf("após o feedback: %v", err)
+	}
+
+	// janela cheia sem progresso por um RTO: o que está em trânsito é dado
+	// como perdido e a janela vai ao mínimo
+	c.OnSent(initialCwnd, 1000)
+	for seq := uint32(initialCwnd + 1); c.Stats().Inflight < int64(c.Stats().Cwnd); seq++ {
+		c.OnSent(seq, 1000)
+	}
+	clock.advance(c.rto() + time.Millisecond)
+	if err := c.Wait(nil); err != nil {
+		t.Fatalf("após o RTO: %v", err)
+	}
+	if st := c.Stats(); st.Cwnd != minCwnd || st.Inflight != 0 {
+		t.Fatalf("após o RTO: cwnd %.1f em trânsito %d, esperado %.1f e 0", st.Cwnd, st.Inflight, minCwnd)
+	}
+}
+
+// Sem feedback por config.FeedbackTimeout, Wait desiste com ErrStalled.
+func TestWaitStalled(t *testing.T) {
+	c, clock := newTest()
+	clock.advance(config.FeedbackTimeout)
+	if err := c.Wait(nil); err != nil {
+		t.Fatalf("no limite do prazo: %v", err)
+	}
+	c.OnFeedback(1, 0, 0, 0) // renova o prazo
+	clock.advance(config.FeedbackTimeout + time.Millisecond)
+	if err := c.Wait(nil); err != ErrStalled {
+		t.Fatalf("sem feedback: erro %v, esperado %v", err, ErrStalled)
+	}
+}
+
+// O Reporter conta lacunas como perdas e envia o FBK a cada
+// config.FeedbackInterval ou feedbackEvery datagramas; Flush só envia se
+// algo chegou desde o último.
+func TestReporter(t *testing.T) {
+	r := NewReporter(7)
+	if _, ok := r.Poll(); !ok {
+		t.Fatal("primeiro Poll sem FBK")
+	}
+	if _, ok := r.Poll(); ok {
+		t.Fatal("FBK antes do intervalo sem datagramas")
+	}
+	if _, ok := r.Flush(); ok {
+		t.Fatal("Flush sem datagramas novos")
+	}
+	r.OnData(2) // 0 e 1 perdidos
+	r.OnData(5) // 3 e 4 perdidos
+	r.OnData(4) // atrasado: não conta de novo
+	r.OnParity(1)
+	r.OnLost(3)
+	r.OnRecovered(2)
+	fb, ok := r.Flush()
+	if !ok {
+		t.Fatal("Flush com datagramas novos sem FBK")
+	}
+	if fb.Session != 7 || fb.Received != 4 || fb.Lost != 2+2+1+3 || fb.Highest != 5 || fb.EchoSeq != 4 || fb.Recovered != 2 {
+		t.Fatalf("FBK %+v", fb)
+	}
+	for range feedbackEvery - 1 {
+		r.OnData(6)
+	}
+	if _, ok := r.Poll(); ok {
+		t.Fatalf("FBK com %d datagramas antes do intervalo", feedbackEvery-1)
+	}
+	r.OnData(6)
+	if _, ok := r.Poll(); !ok {
+		t.Fatalf("sem FBK após %d datagramas", feedbackEvery)
+	}
+	r.sentAt = time.Now().Add(-config.FeedbackInterval)
+	if _, ok := r.Poll(); !ok {
+		t.Fatal("sem FBK após o intervalo")
+	}
+
+	var none *Reporter
+	none.OnData(1)
+	if _, ok := none.Poll(); ok {
+		t.Fatal("Reporter nil enviou FBK")
+	}
+	if _, ok := NewReporter(0).Poll(); ok {
+		t.Fatal("Reporter sem sessão enviou FBK")
+	}
+}
@@ -0,0 +1,126 @@
+package congestion
+
+import (
+	"time"
+
+	"udp/internal/config"
+	"udp/internal/protocol"
+)
+
+// datagramas recebidos que antecipam o próximo FBK, mesmo antes de
+// config.FeedbackInterval (mantém a janela do emissor avançando em taxas altas)
+const feedbackEvery = 64
+
+// Reporter acumula o que o receptor observa de uma sessão e monta o FBK
+// periódico que alimenta o Controller do emissor. Métodos de um Reporter nil
+// não fazem nada. Não é seguro para uso concorrente.
+type Reporter struct {
+	session    uint32    // sessão relatada
+	received   uint32    // datagramas DATA válidos recebidos (inclui duplicados e paridades)
+	lost       uint32    // datagramas dados como perdidos (lacunas e retransmissões não entregues)
+	highest    uint32    // maior sequência recebida
+	seen       bool      // se algum DATA já foi recebido
+	lastSeq    uint32    // último segmento recebido (eco para o RTT)
+	lastAt     time.Time // chegada de lastSeq
+	pending    int       // datagramas desde o último FBK
+	sentAt     time.Time // envio do último FBK
+	parityHi   uint32    // maior sequência de paridade FEC recebida
+	paritySeen bool      // se alguma paridade já foi recebida
+	recovered  uint32    // segmentos reconstruídos por FEC
+}
+
+// NewReporter cria o relatório de recepção da sessão.
+func NewReporter(session uint32) *Reporter { return &Reporter{session: session} }
+
+// OnData registra a chegada de um DATA válido; saltos acima da maior
+// sequência vista contam os segmentos pulados como perdidos.
+func (f *Reporter) OnData(seq uint32) {
+	if f == nil {
+		return
+	}
+	f.received++
+	if !f.seen {
+		f.lost += seq // envio inicial começa em 0
+		f.highest, f.seen = seq, true
+	} else if seq > f.highest {
+		f.lost += seq - f.highest - 1
+		f.highest = seq
+	}
+	f.lastSeq, f.lastAt = seq, time.Now()
+	f.pending++
+}
+
+// OnParity registra a chegada de uma paridade FEC; como nos dados, saltos na
+// sequência de paridades contam como perdas (o emissor as conta como enviadas).
+func (f *Reporter) OnParity(seq uint32) {
+	if f == nil {
+		return
+	}
+	f.received++
+	if !f.paritySeen {
+		f.lost += seq
+		f.parityHi, f.paritySeen = seq, true
+	} else if seq > f.parityHi {
+		f.lost += seq - f.parityHi - 1
+		f.parityHi = seq
+	}
+	f.pending++
+}
+
+// OnLost registra n segmentos pedidos por NACK que não chegaram no round.
+func (f *Reporter) OnLost(n int) {
+	if f == nil || n <= 0 {
+		return
+	}
+	f.lost += uint32(n)
+	f.pending++
+}
+
+// Counts retorna os contadores cumulativos de datagramas recebidos e perdidos.
+func (f *Reporter) Counts() (received, lost uint32) {
+	if f == nil {
+		return 0, 0
+	}
+	return f.received, f.lost
+}
+
+// OnRecovered registra n segmentos reconstruídos por FEC.
+func (f *Reporter) OnRecovered(n int) {
+	if f == nil {
+		return
+	}
+	f.recovered += uint32(n)
+}
+
+// Poll retorna o FBK a enviar se já passou config.FeedbackInterval desde o
+// último (ou feedbackEvery datagramas chegaram); ok é false caso contrário.
+func (f *Reporter) Poll() (fb protocol.Feedback, ok bool) {
+	if f == nil || f.session == 0 {
+		return fb, false
+	}
+	if f.pending < feedbackEvery && time.Since(f.sentAt) < config.FeedbackInterval {
+		return fb, false
+	}
+	return f.report(), true
+}
+
+// Flush retorna o FBK a enviar se algum datagrama chegou desde o último,
+// sem esperar o intervalo: usado quando a fila de recepção esvazia, para o
+// emissor limitado pela janela não aguardar config.FeedbackInterval.
+func (f *Reporter) Flush() (fb protocol.Feedback, ok bool) {
+	if f == nil || f.session == 0 || f.pending == 0 {
+		return fb, false
+	}
+	return f.report(), true
+}
+
+// monta o FBK com os contadores atuais e reinicia o intervalo.
+func (f *Reporter) report() protocol.Feedback {
+	fb := protocol.Feedback{Session: f.session, Received: f.received, Lost: f.lost, Highest: f.highest, EchoSeq: f.lastSeq, Recovered: f.recovered}
+	if !f.lastAt.IsZero() {
+		fb.DelayMicros = uint32(time.Since(f.lastAt).Microseconds())
+	}
+	f.pending = 0
+	f.sentAt = time.Now()
+	return fb
+}
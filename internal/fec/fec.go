@@ -0,0 +1,253 @@
+// Package fec implementa um código Reed–Solomon sistemático sobre GF(2^8)
+// para correção antecipada de perdas: para cada bloco de N segmentos de dados
+// são gerados K segmentos de paridade, e quaisquer N dos N+K segmentos do
+// bloco bastam para reconstruir os dados. A matriz de paridade é de Cauchy,
+// o que garante que toda submatriz quadrada da matriz de codificação seja
+// inversível.
+package fec
+
+import "errors"
+
+// MaxShards é o limite de segmentos (dados + paridade) por bloco em GF(2^8).
+const MaxShards = 256
+
+// tabelas de exponencial/logaritmo para o polinômio x^8+x^4+x^3+x^2+1 (0x11d)
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte { return gfExp[255-int(gfLog[a])] }
+
+// dst ^= c * src, byte a byte
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	if c == 1 {
+		for i, v := range src {
+			dst[i] ^= v
+		}
+		return
+	}
+	lc := int(gfLog[c])
+	for i, v := range src {
+		if v != 0 {
+			dst[i] ^= gfExp[lc+int(gfLog[v])]
+		}
+	}
+}
+
+// Code codifica blocos de N segmentos de dados em K segmentos de paridade.
+type Code struct {
+	n, k   int
+	parity [][]byte // matriz de Cauchy K x N: parity[i][j] = 1/(x_i + y_j)
+}
+
+// New cria um código com n segmentos de dados e k de paridade por bloco.
+func New(n, k int) (*Code, error) {
+	if n <= 0 || k <= 0 || n+k > MaxShards {
+		return nil, errors.New("parâmetros de FEC inválidos")
+	}
+	c := &Code{n: n, k: k, parity: make([][]byte, k)}
+	for i := range c.parity {
+		c.parity[i] = make([]byte, n)
+		for j := range c.parity[i] {
+			c.parity[i][j] = gfInv(byte(n+i) ^ byte(j)) // x_i = n+i, y_j = j (todos distintos)
+		}
+	}
+	return c, nil
+}
+
+// DataShards retorna N.
+func (c *Code) DataShards() int { return c.n }
+
+// ParityShards retorna K.
+func (c *Code) ParityShards() int { return c.k }
+
+// Encode calcula os K segmentos de paridade de data (N segmentos de mesmo
+// tamanho) em parity, que deve ter K fatias desse tamanho.
+func (c *Code) Encode(data, parity [][]byte) error {
+	if len(data) != c.n || len(parity) != c.k {
+		return errors.New("quantidade de segmentos incompatível")
+	}
+	size := len(data[0])
+	for _, p := range parity {
+		if len(p) != size {
+			return errors.New("segmentos de tamanhos diferentes")
+		}
+		clear(p)
+	}
+	for j, d := range data {
+		if len(d) != size {
+			return errors.New("segmentos de tamanhos diferentes")
+		}
+		for i, p := range parity {
+			mulAdd(p, d, c.parity[i][j])
+		}
+	}
+	return nil
+}
+
+// linha r da matriz de codificação [I; C]
+func (c *Code) row(r int) []byte {
+	if r >= c.n {
+		return c.parity[r-c.n]
+	}
+	row := make([]byte, c.n)
+	row[r] = 1
+	return row
+}
+
+// Reconstruct preenche os segmentos de dados ausentes (nil) de shards, que
+// tem N+K posições (dados seguidos de paridade), desde que ao menos N
+// estejam presentes. Retorna os índices reconstruídos.
+func (c *Code) Reconstruct(shards [][]byte) ([]int, error) {
+	if len(shards) != c.n+c.k {
+		return nil, errors.New("quantidade de segmentos incompatível")
+	}
+	var missing []int
+	for j := 0; j < c.n; j++ {
+		if shards[j] == nil {
+			missing = append(missing, j)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	// escolhe N linhas presentes (dados primeiro) e inverte a submatriz
+	rows := make([]int, 0, c.n)
+	size := -1
+	for r := 0; r < c.n+c.k && len(rows) < c.n; r++ {
+		if shards[r] != nil {
+			if size >= 0 && len(shards[r]) != size {
+				return nil, errors.New("segmentos de tamanhos diferentes")
+			}
+			size = len(shards[r])
+			rows = append(rows, r)
+		}
+	}
+	if len(rows) < c.n {
+		return nil, errors.New("segmentos insuficientes para reconstruir o bloco")
+	}
+	m := make([][]byte, c.n)
+	for i, r := range rows {
+		m[i] = append([]byte(nil), c.row(r)...)
+	}
+	inv, err := invert(m)
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range missing {
+		out := make([]byte, size)
+		for i, r := range rows {
+			mulAdd(out, shards[r], inv[j][i])
+		}
+		shards[j] = out
+	}
+	return missing, nil
+}
+
+// inverte a matriz quadrada m (Gauss-Jordan em GF(2^8)); m é destruída.
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		p := col
+		for p < n && m[p][col] == 0 {
+			p++
+		}
+		if p == n {
+			return nil, errors.New("matriz singular")
+		}
+		m[col], m[p] = m[p], m[col]
+		inv[col], inv[p] = inv[p], inv[col]
+		if f := gfInv(m[col][col]); f != 1 {
+			for j := 0; j < n; j++ {
+				m[col][j] = gfMul(m[col][j], f)
+				inv[col][j] = gfMul(inv[col][j], f)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if f := m[r][col]; r != col && f != 0 {
+				mulAdd(m[r], m[col], f)
+				mulAdd(inv[r], inv[col], f)
+			}
+		}
+	}
+	return inv, nil
+}
+
+// Encoder acumula os segmentos de dados de um bloco e gera sua paridade.
+// Segmentos menores que size (último do arquivo) e posições não preenchidas
+// de um bloco final incompleto contam como zeros.
+type Encoder struct {
+	code   *Code
+	data   [][]byte // segmentos do bloco atual (completados com zeros)
+	parity [][]byte // paridade do último bloco fechado
+	filled int      // segmentos já adicionados ao bloco atual
+}
+
+// NewEncoder cria um codificador de blocos de n segmentos de size bytes com
+// k segmentos de paridade.
+func NewEncoder(n, k, size int) (*Encoder, error) {
+	code, err := New(n, k)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encoder{code: code, data: make([][]byte, n), parity: make([][]byte, k)}
+	for i := range e.data {
+		e.data[i] = make([]byte, size)
+	}
+	for i := range e.parity {
+		e.parity[i] = make([]byte, size)
+	}
+	return e, nil
+}
+
+// Add copia o próximo segmento do bloco e informa se o bloco ficou completo.
+func (e *Encoder) Add(seg []byte) bool {
+	d := e.data[e.filled]
+	clear(d[copy(d, seg):])
+	e.filled++
+	return e.filled == len(e.data)
+}
+
+// Flush calcula a paridade do bloco atual e inicia um novo bloco. As fatias
+// retornadas são reutilizadas no próximo Flush.
+func (e *Encoder) Flush() ([][]byte, error) {
+	for _, d := range e.data[e.filled:] {
+		clear(d)
+	}
+	e.filled = 0
+	if err := e.code.Encode(e.data, e.parity); err != nil {
+		return nil, err
+	}
+	return e.parity, nil
+}
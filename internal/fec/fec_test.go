@@ -0,0 +1,120 @@
+package fec
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// Para cada combinação de até K segmentos perdidos num bloco (dados e
+// paridade), Reconstruct devolve exatamente os dados originais e informa os
+// índices de dados que reconstruiu.
+func TestReconstructAllLosses(t *testing.T) {
+	tests := []struct{ n, k, size int }{
+		{1, 1, 7},
+		{4, 2, 64},
+		{8, 3, 33},
+		{10, 1, 100},
+		{5, 5, 16},
+		{16, 4, 128},
+	}
+	for _, tt := range tests {
+		code, err := New(tt.n, tt.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rng := rand.New(rand.NewPCG(uint64(tt.n), uint64(tt.k)))
+		data := make([][]byte, tt.n)
+		for i := range data {
+			data[i] = make([]byte, tt.size)
+			for j := range data[i] {
+				data[i][j] = byte(rng.UintN(256))
+			}
+		}
+		parity := make([][]byte, tt.k)
+		for i := range parity {
+			parity[i] = make([]byte, tt.size)
+		}
+		if err := code.Encode(data, parity); err != nil {
+			t.Fatal(err)
+		}
+		all := append(slices.Clone(data), parity...)
+		combos := 0
+		for mask := uint32(1); mask < 1<<(tt.n+tt.k); mask++ {
+			if bits.OnesCount32(mask) > tt.k {
+				continue
+			}
+			combos++
+			shards := slices.Clone(all)
+			var want []int
+			for i := range shards {
+				if mask&(1<<i) != 0 {
+					shards[i] = nil
+					if i < tt.n {
+						want = append(want, i)
+					}
+				}
+			}
+			got, err := code.Reconstruct(shards)
+			if err != nil {
+				t.Fatalf("n=%d k=%d perdas=%b: %v", tt.n, tt.k, mask, err)
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("n=%d k=%d perdas=%b: reconstruídos %v, esperado %v", tt.n, tt.k, mask, got, want)
+			}
+			for i := range data {
+				if !bytes.Equal(shards[i], data[i]) {
+					t.Fatalf("n=%d k=%d perdas=%b: segmento %d difere do original", tt.n, tt.k, mask, i)
+				}
+			}
+		}
+		t.Logf("n=%d k=%d: %d combinações de perdas", tt.n, tt.k, combos)
+	}
+}
+
+// Com mais de K perdas o bloco não se recupera.
+func TestReconstructTooManyLosses(t *testing.T) {
+	code, err := New(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := make([][]byte, 6)
+	for i := range shards {
+		shards[i] = make([]byte, 8)
+	}
+	shards[0], shards[2], shards[5] = nil, nil, nil
+	if _, err := code.Reconstruct(shards); err == nil {
+		t.Fatal("Reconstruct com K+1 perdas não falhou")
+	}
+}
+
+// O Encoder completa com zeros o último segmento curto e o bloco final
+// incompleto; a paridade recupera o segmento curto com zeros à direita.
+func TestEncoderPartialBlock(t *testing.T) {
+	const n, k, size = 4, 2, 16
+	enc, err := NewEncoder(n, k, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segs := [][]byte{bytes.Repeat([]byte{0xA5}, size), []byte("curto")}
+	for _, s := range segs {
+		if enc.Add(s) {
+			t.Fatal("bloco completo antes de n segmentos")
+		}
+	}
+	parity, err := enc.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	short := make([]byte, size)
+	copy(short, segs[1])
+	shards := [][]byte{segs[0], nil, make([]byte, size), make([]byte, size), parity[0], parity[1]}
+	if _, err := enc.code.Reconstruct(shards); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(shards[1], short) {
+		t.Fatalf("segmento curto reconstruído %q, esperado %q", shards[1], short)
+	}
+}
@@ -0,0 +1,352 @@
+package impair
+
+import (
+	"container/heap"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// datagrama com o endereço do outro lado (nil no socket conectado)
+type packet struct {
+	b    []byte
+	addr net.Addr
+	err  error // falha da leitura (só no sentido de recepção)
+}
+
+// agendado para at; seq desempata os de mesmo horário na ordem de chegada
+type scheduled struct {
+	packet
+	at  time.Time
+	seq uint64
+}
+
+type queue []scheduled
+
+func (q queue) Len() int { return len(q) }
+func (q queue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q queue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x any)   { *q = append(*q, x.(scheduled)) }
+func (q *queue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// scheduler entrega os datagramas atrasados no horário de cada um, numa
+// goroutine iniciada no primeiro agendamento.
+type scheduler struct {
+	deliver func(packet)
+	mu      sync.Mutex
+	q       queue
+	seq     uint64
+	wake    chan struct{}
+	done    chan struct{}
+	started bool
+}
+
+func newScheduler(deliver func(packet), done chan struct{}) *scheduler {
+	return &scheduler{deliver: deliver, wake: make(chan struct{}, 1), done: done}
+}
+
+// agenda p para daqui a after; b precisa ser uma cópia própria
+func (s *scheduler) push(p packet, after time.Duration) {
+	s.mu.Lock()
+	s.seq++
+	heap.Push(&s.q, scheduled{packet: p, at: time.Now().Add(after), seq: s.seq})
+	if !s.started {
+		s.started = true
+		go s.run()
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) run() {
+	t := time.NewTimer(time.Hour)
+	defer t.Stop()
+	for {
+		s.mu.Lock()
+		var due []packet
+		now := time.Now()
+		for len(s.q) > 0 && !s.q[0].at.After(now) {
+			due = append(due, heap.Pop(&s.q).(scheduled).packet)
+		}
+		wait := time.Hour
+		if len(s.q) > 0 {
+			wait = s.q[0].at.Sub(now)
+		}
+		s.mu.Unlock()
+		for _, p := range due {
+			s.deliver(p)
+		}
+		t.Reset(wait)
+		select {
+		case <-t.C:
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// pipe aplica o modelo de um sentido aos datagramas e os entrega na hora
+// (sem atraso sorteado) ou pelo scheduler.
+type pipe struct {
+	m     *model
+	out   func(packet) error
+	sched *scheduler
+}
+
+func newPipe(m *model, out func(packet) error, done chan struct{}) *pipe {
+	return &pipe{m: m, out: out, sched: newScheduler(func(p packet) { out(p) }, done)}
+}
+
+// passa b pelo modelo; com copy, b é copiado antes de ser agendado (o
+// chamador reutiliza o buffer). Retorna a falha da primeira entrega imediata.
+func (p *pipe) send(b []byte, addr net.Addr, copy bool) (err error) {
+	for _, d := range p.m.apply(b) {
+		if d.after <= 0 {
+			if e := p.out(packet{b: d.b, addr: addr}); err == nil {
+				err = e
+			}
+			continue
+		}
+		if copy {
+			d.b = append([]byte(nil), d.b...)
+		}
+		p.sched.push(packet{b: d.b, addr: addr}, d.after)
+	}
+	return err
+}
+
+// receiver lê o socket numa goroutine e enfileira os datagramas que
+// sobrevivem ao modelo, com os atrasos sorteados, para as leituras.
+type receiver struct {
+	in       chan packet
+	done     chan struct{}
+	deadline atomic.Pointer[time.Time]
+}
+
+func newReceiver(m *model, read func([]byte) (int, net.Addr, error), done chan struct{}) *receiver {
+	r := &receiver{in: make(chan packet, 1024), done: done}
+	p := newPipe(m, r.put, done)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := read(buf)
+			if err != nil {
+				r.put(packet{err: err})
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			p.send(append([]byte(nil), buf[:n]...), addr, false)
+		}
+	}()
+	return r
+}
+
+// entrega à leitura (bloqueia como um socket cheio até a próxima leitura)
+func (r *receiver) put(p packet) error {
+	select {
+	case r.in <- p:
+	case <-r.done:
+	}
+	return nil
+}
+
+// próximo datagrama, respeitando o prazo de SetReadDeadline
+func (r *receiver) next() (packet, error) {
+	var timeout <-chan time.Time
+	if d := r.deadline.Load(); d != nil && !d.IsZero() {
+		wait := time.Until(*d)
+		if wait <= 0 {
+			return packet{}, os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case p := <-r.in:
+		return p, p.err
+	case <-timeout:
+		return packet{}, os.ErrDeadlineExceeded
+	case <-r.done:
+		return packet{}, net.ErrClosed
+	}
+}
+
+func (r *receiver) setDeadline(t time.Time) { r.deadline.Store(&t) }
+
+// PacketConn degrada o tráfego de um net.PacketConn (o socket do servidor).
+type PacketConn struct {
+	net.PacketConn
+	send      *pipe
+	recv      *receiver
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// WrapPacketConn passa a degradar o tráfego de pc conforme imp.
+func (imp *Impairer) WrapPacketConn(pc net.PacketConn) *PacketConn {
+	c := &PacketConn{PacketConn: pc, done: make(chan struct{})}
+	if imp.send != nil {
+		c.send = newPipe(imp.send, func(p packet) error {
+			_, err := pc.WriteTo(p.b, p.addr)
+			return err
+		}, c.done)
+	}
+	if imp.recv != nil {
+		c.recv = newReceiver(imp.recv, pc.ReadFrom, c.done)
+	}
+	return c
+}
+
+// ReadFrom lê o próximo datagrama que sobreviveu à degradação.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.recv == nil {
+		return c.PacketConn.ReadFrom(b)
+	}
+	p, err := c.recv.next()
+	if err != nil {
+		return 0, nil, err
+	}
+	return copy(b, p.b), p.addr, nil
+}
+
+// WriteTo envia b a addr pela degradação; datagramas descartados ou
+// atrasados contam como enviados.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.send == nil {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	if err := c.send.send(b, addr, true); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetDeadline vale para as leituras degradadas e para as escritas.
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	if c.recv == nil {
+		return c.PacketConn.SetDeadline(t)
+	}
+	c.recv.setDeadline(t)
+	return c.PacketConn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline limita a espera de ReadFrom.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	if c.recv == nil {
+		return c.PacketConn.SetReadDeadline(t)
+	}
+	c.recv.setDeadline(t)
+	return nil
+}
+
+// Close fecha o socket e descarta os datagramas ainda atrasados.
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.PacketConn.Close()
+}
+
+// Unwrap retorna o net.PacketConn degradado.
+func (c *PacketConn) Unwrap() net.PacketConn { return c.PacketConn }
+
+// Conn degrada o tráfego de um socket UDP conectado (o socket do cliente).
+type Conn struct {
+	net.Conn
+	send      *pipe
+	recv      *receiver
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// WrapConn passa a degradar o tráfego de nc conforme imp.
+func (imp *Impairer) WrapConn(nc net.Conn) *Conn {
+	c := &Conn{Conn: nc, done: make(chan struct{})}
+	if imp.send != nil {
+		c.send = newPipe(imp.send, func(p packet) error {
+			_, err := nc.Write(p.b)
+			return err
+		}, c.done)
+	}
+	if imp.recv != nil {
+		c.recv = newReceiver(imp.recv, func(b []byte) (int, net.Addr, error) {
+			n, err := nc.Read(b)
+			return n, nil, err
+		}, c.done)
+	}
+	return c
+}
+
+// Read lê o próximo datagrama que sobreviveu à degradação.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.recv == nil {
+		return c.Conn.Read(b)
+	}
+	p, err := c.recv.next()
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, p.b), nil
+}
+
+// Write envia b pela degradação; datagramas descartados ou atrasados contam
+// como enviados.
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.send == nil {
+		return c.Conn.Write(b)
+	}
+	if err := c.send.send(b, nil, true); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetDeadline vale para as leituras degradadas e para as escritas.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if c.recv == nil {
+		return c.Conn.SetDeadline(t)
+	}
+	c.recv.setDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline limita a espera de Read.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.recv == nil {
+		return c.Conn.SetReadDeadline(t)
+	}
+	c.recv.setDeadline(t)
+	return nil
+}
+
+// Close fecha o socket e descarta os datagramas ainda atrasados.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// Unwrap retorna o net.Conn degradado.
+func (c *Conn) Unwrap() net.Conn { return c.Conn }
@@ -0,0 +1,454 @@
+// Package impair degrada de propósito o tráfego de um socket UDP, para testar
+// a recuperação do protocolo: perda de segmentos em rajadas (modelo de
+// Gilbert–Elliott), perda repetida do mesmo segmento, perda de mensagens de
+// controle por tipo, duplicação, reordenação, atraso com variação e
+// corrupção do payload (que o CRC32 detecta).
+//
+// As decisões vêm de um gerador pseudoaleatório com semente, um por sentido:
+// a mesma semente diante da mesma sequência de datagramas produz sempre as
+// mesmas degradações. Os wrappers ficam por fora do modo cifrado (como a
+// captura), de modo que enxergam o tipo de cada datagrama.
+package impair
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"udp/internal/protocol"
+)
+
+// Dir seleciona os sentidos degradados.
+type Dir uint8
+
+// Sentidos.
+const (
+	Send Dir = 1 << iota // datagramas enviados pelo socket
+	Recv                 // datagramas recebidos pelo socket
+	Both = Send | Recv
+)
+
+func (d Dir) String() string {
+	switch d {
+	case Send:
+		return "send"
+	case Recv:
+		return "recv"
+	}
+	return "both"
+}
+
+// valores usados quando o campo correspondente é zero
+const (
+	defaultBurstExit    = 0.25
+	defaultBurstLoss    = 1.0
+	defaultReorderDelay = 10 * time.Millisecond
+)
+
+// Config descreve as degradações. Probabilidades vão de 0 a 1 e valem por
+// datagrama; zero desliga a degradação correspondente.
+type Config struct {
+	Loss         float64            // perda de DATA fora das rajadas (sem Burst: perda uniforme)
+	Burst        float64            // chance de começar uma rajada a cada DATA (p do Gilbert–Elliott)
+	BurstExit    float64            // chance de a rajada terminar a cada DATA (r; 0 = 0.25)
+	BurstLoss    float64            // perda de DATA durante a rajada (0 = 1, todos)
+	Repeat       int                // um DATA perdido perde também as Repeat retransmissões seguintes do mesmo segmento
+	Ctrl         map[string]float64 // perda por tipo de controle (protocol.Type*)
+	Dup          float64            // duplicação (a cópia tem atraso próprio)
+	Reorder      float64            // atraso extra de ReorderDelay, que deixa os seguintes passarem à frente
+	ReorderDelay time.Duration      // atraso dos reordenados (0 = 10ms)
+	Delay        time.Duration      // atraso de todos os datagramas
+	Jitter       time.Duration      // variação uniforme do atraso em ±Jitter (também reordena)
+	Corrupt      float64            // inverte um bit do payload de um DATA
+	Dir          Dir                // sentidos degradados (0 = Both)
+	Seed         uint64             // semente dos sorteios
+}
+
+// Enabled informa se alguma degradação está ativa.
+func (c Config) Enabled() bool {
+	for _, p := range c.Ctrl {
+		if p > 0 {
+			return true
+		}
+	}
+	return c.Loss > 0 || c.Burst > 0 || c.Dup > 0 || c.Reorder > 0 || c.Delay > 0 || c.Jitter > 0 || c.Corrupt > 0
+}
+
+// com os valores padrão nos campos zerados
+func (c Config) normalized() Config {
+	if c.Burst > 0 && c.BurstExit <= 0 {
+		c.BurstExit = defaultBurstExit
+	}
+	if c.Burst > 0 && c.BurstLoss <= 0 {
+		c.BurstLoss = defaultBurstLoss
+	}
+	if c.ReorderDelay <= 0 {
+		c.ReorderDelay = defaultReorderDelay
+	}
+	if c.Dir == 0 {
+		c.Dir = Both
+	}
+	return c
+}
+
+// Validate confere os intervalos dos campos.
+func (c Config) Validate() error {
+	probs := map[string]float64{"loss": c.Loss, "burst": c.Burst, "burst-exit": c.BurstExit, "burst-loss": c.BurstLoss,
+		"dup": c.Dup, "reorder": c.Reorder, "corrupt": c.Corrupt}
+	for t, p := range c.Ctrl {
+		if _, ok := protocol.CtrlTypeCodes()[t]; !ok {
+			return fmt.Errorf("tipo de controle desconhecido %q", t)
+		}
+		probs[strings.ToLower(t)] = p
+	}
+	for k, p := range probs {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("%s=%g fora de 0..1", k, p)
+		}
+	}
+	if c.Repeat < 0 || c.Delay < 0 || c.Jitter < 0 || c.ReorderDelay < 0 {
+		return fmt.Errorf("repeat, delay, jitter e reorder-delay não podem ser negativos")
+	}
+	if c.Dir&^Both != 0 {
+		return fmt.Errorf("sentido inválido %d", c.Dir)
+	}
+	return nil
+}
+
+// Parse interpreta as degradações no formato chave=valor separado por
+// vírgula, por exemplo "loss=1%,burst=0.02,repeat=2,dup=0.01,delay=20ms,
+// jitter=5ms,meta=0.5,eof=1,dir=recv". Chaves: loss, burst, burst-exit,
+// burst-loss, repeat, ctrl (todos os tipos de controle), o nome de um tipo
+// de controle (req, meta, eof, nack...), dup, reorder, reorder-delay, delay,
+// jitter, corrupt e dir (send, recv ou both). Probabilidades aceitam "%".
+// A semente não faz parte do texto.
+func Parse(spec string) (Config, error) {
+	var c Config
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("degradação inválida %q (use chave=valor)", item)
+		}
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		var err error
+		switch k {
+		case "loss":
+			c.Loss, err = parseProb(v)
+		case "burst":
+			c.Burst, err = parseProb(v)
+		case "burst-exit":
+			c.BurstExit, err = parseProb(v)
+		case "burst-loss":
+			c.BurstLoss, err = parseProb(v)
+		case "repeat":
+			c.Repeat, err = strconv.Atoi(v)
+		case "dup":
+			c.Dup, err = parseProb(v)
+		case "reorder":
+			c.Reorder, err = parseProb(v)
+		case "reorder-delay":
+			c.ReorderDelay, err = time.ParseDuration(v)
+		case "delay":
+			c.Delay, err = time.ParseDuration(v)
+		case "jitter":
+			c.Jitter, err = time.ParseDuration(v)
+		case "corrupt":
+			c.Corrupt, err = parseProb(v)
+		case "dir":
+			switch strings.ToLower(v) {
+			case "send":
+				c.Dir = Send
+			case "recv":
+				c.Dir = Recv
+			case "both":
+				c.Dir = Both
+			default:
+				err = fmt.Errorf("use send, recv ou both")
+			}
+		case "ctrl":
+			var p float64
+			if p, err = parseProb(v); err == nil {
+				for t := range protocol.CtrlTypeCodes() {
+					c.setCtrl(t, p)
+				}
+			}
+		default:
+			t := strings.ToUpper(k)
+			if _, known := protocol.CtrlTypeCodes()[t]; !known {
+				return Config{}, fmt.Errorf("degradação desconhecida %q", k)
+			}
+			var p float64
+			if p, err = parseProb(v); err == nil {
+				c.setCtrl(t, p)
+			}
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("valor inválido em %q: %v", item, err)
+		}
+	}
+	return c, c.Validate()
+}
+
+func (c *Config) setCtrl(typ string, p float64) {
+	if c.Ctrl == nil {
+		c.Ctrl = map[string]float64{}
+	}
+	c.Ctrl[typ] = p
+}
+
+// probabilidade em 0..1 ou porcentagem ("2%")
+func parseProb(s string) (float64, error) {
+	div := 1.0
+	if t, ok := strings.CutSuffix(s, "%"); ok {
+		s, div = strings.TrimSpace(t), 100
+	}
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return p / div, nil
+}
+
+// String formata a configuração no formato de Parse (sem a semente).
+func (c Config) String() string {
+	if !c.Enabled() {
+		return "sem degradação"
+	}
+	var parts []string
+	prob := func(k string, p float64) {
+		if p > 0 {
+			parts = append(parts, k+"="+strconv.FormatFloat(p, 'g', -1, 64))
+		}
+	}
+	dur := func(k string, d time.Duration) {
+		if d > 0 {
+			parts = append(parts, k+"="+d.String())
+		}
+	}
+	prob("loss", c.Loss)
+	prob("burst", c.Burst)
+	prob("burst-exit", c.BurstExit)
+	prob("burst-loss", c.BurstLoss)
+	if c.Repeat > 0 {
+		parts = append(parts, "repeat="+strconv.Itoa(c.Repeat))
+	}
+	types := make([]string, 0, len(c.Ctrl))
+	for t := range c.Ctrl {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		prob(strings.ToLower(t), c.Ctrl[t])
+	}
+	prob("dup", c.Dup)
+	prob("reorder", c.Reorder)
+	if c.Reorder > 0 {
+		dur("reorder-delay", c.ReorderDelay)
+	}
+	dur("delay", c.Delay)
+	dur("jitter", c.Jitter)
+	prob("corrupt", c.Corrupt)
+	if c.Dir != 0 && c.Dir != Both {
+		parts = append(parts, "dir="+c.Dir.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// Stats conta as degradações aplicadas num sentido.
+type Stats struct {
+	Datagrams  uint64 // datagramas avaliados
+	Lost       uint64 // DATA descartados (Repeated incluídos)
+	Repeated   uint64 // retransmissões descartadas por Repeat
+	CtrlLost   uint64 // mensagens de controle descartadas
+	Duplicated uint64 // cópias extras
+	Reordered  uint64 // atrasados em ReorderDelay
+	Corrupted  uint64 // DATA com um bit invertido
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("datagramas=%d perdidos=%d (repetidos=%d) controle=%d duplicados=%d reordenados=%d corrompidos=%d",
+		s.Datagrams, s.Lost, s.Repeated, s.CtrlLost, s.Duplicated, s.Reordered, s.Corrupted)
+}
+
+// contadores de um sentido
+type counters struct {
+	datagrams, lost, repeated, ctrlLost, duplicated, reordered, corrupted atomic.Uint64
+}
+
+func (s Stats) add(t Stats) Stats {
+	return Stats{Datagrams: s.Datagrams + t.Datagrams, Lost: s.Lost + t.Lost, Repeated: s.Repeated + t.Repeated, CtrlLost: s.CtrlLost + t.CtrlLost,
+		Duplicated: s.Duplicated + t.Duplicated, Reordered: s.Reordered + t.Reordered, Corrupted: s.Corrupted + t.Corrupted}
+}
+
+func (c *counters) stats() Stats {
+	return Stats{Datagrams: c.datagrams.Load(), Lost: c.lost.Load(), Repeated: c.repeated.Load(), CtrlLost: c.ctrlLost.Load(),
+		Duplicated: c.duplicated.Load(), Reordered: c.reordered.Load(), Corrupted: c.corrupted.Load()}
+}
+
+// segmento DATA (perdas repetidas)
+type segment struct{ session, seq uint32 }
+
+// estado dos sorteios de um sentido
+type model struct {
+	cfg     Config
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	bad     bool            // em rajada (estado ruim do Gilbert–Elliott)
+	pending map[segment]int // retransmissões que ainda serão perdidas
+	n       counters
+}
+
+func newModel(cfg Config, stream uint64) *model {
+	return &model{cfg: cfg, rnd: rand.New(rand.NewPCG(cfg.Seed, stream)), pending: map[segment]int{}}
+}
+
+// entrega de um datagrama (ou de uma cópia) depois de after
+type delivery struct {
+	b     []byte
+	after time.Duration
+}
+
+// decide o destino de b: nenhuma entrega (perdido), uma ou duas (duplicado).
+// b não é alterado; a corrupção trabalha numa cópia.
+func (m *model) apply(b []byte) []delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.n.datagrams.Add(1)
+	h, err := protocol.UnpackHeader(b)
+	isData := err == nil
+	if isData && m.lose(h) {
+		return nil
+	}
+	if !isData && protocol.IsCtrl(b) && len(m.cfg.Ctrl) > 0 {
+		if typ, _, err := protocol.DecodeCtrl(b); err == nil && m.chance(m.cfg.Ctrl[typ]) {
+			m.n.ctrlLost.Add(1)
+			return nil
+		}
+	}
+	if isData && h.Size > 0 && len(b) > h.Len() && m.chance(m.cfg.Corrupt) {
+		b = append([]byte(nil), b...)
+		bit := m.rnd.IntN((len(b) - h.Len()) * 8)
+		b[h.Len()+bit/8] ^= 1 << (bit % 8)
+		m.n.corrupted.Add(1)
+	}
+	out := []delivery{{b, m.delay()}}
+	if m.chance(m.cfg.Dup) {
+		out = append(out, delivery{b, m.delay()})
+		m.n.duplicated.Add(1)
+	}
+	return out
+}
+
+// sorteia a perda de um DATA: primeiro as repetições pendentes do segmento,
+// depois a transição de estado e a perda do estado atual
+func (m *model) lose(h protocol.DataHeader) bool {
+	key := segment{h.Session, h.Seq}
+	parity := h.Flags&protocol.DataFlagParity != 0
+	if left := m.pending[key]; left > 0 && !parity {
+		if left == 1 {
+			delete(m.pending, key)
+		} else {
+			m.pending[key] = left - 1
+		}
+		m.n.lost.Add(1)
+		m.n.repeated.Add(1)
+		return true
+	}
+	p := m.cfg.Loss
+	if m.cfg.Burst > 0 {
+		if m.bad {
+			m.bad = !m.chance(m.cfg.BurstExit)
+		} else {
+			m.bad = m.chance(m.cfg.Burst)
+		}
+		if m.bad {
+			p = m.cfg.BurstLoss
+		}
+	}
+	if !m.chance(p) {
+		return false
+	}
+	// paridades nunca são retransmitidas
+	if m.cfg.Repeat > 0 && !parity {
+		m.pending[key] = m.cfg.Repeat
+	}
+	m.n.lost.Add(1)
+	return true
+}
+
+// atraso de uma entrega: Delay ± Jitter, mais ReorderDelay se sorteado
+func (m *model) delay() time.Duration {
+	d := m.cfg.Delay
+	if m.cfg.Jitter > 0 {
+		d += time.Duration(m.rnd.Int64N(2*int64(m.cfg.Jitter)+1)) - m.cfg.Jitter
+	}
+	if m.chance(m.cfg.Reorder) {
+		d += m.cfg.ReorderDelay
+		m.n.reordered.Add(1)
+	}
+	return max(d, 0)
+}
+
+// sorteio com probabilidade p (sem consumir o gerador quando p é 0)
+func (m *model) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	return p >= 1 || m.rnd.Float64() < p
+}
+
+// Impairer aplica uma Config aos sockets que envolve. Os sockets envolvidos
+// pelo mesmo Impairer compartilham os sorteios e os contadores.
+type Impairer struct {
+	cfg        Config
+	send, recv *model // nil = sentido não degradado
+}
+
+// New cria um Impairer com cfg (Validate é responsabilidade de quem chama).
+func New(cfg Config) *Impairer {
+	cfg = cfg.normalized()
+	imp := &Impairer{cfg: cfg}
+	if cfg.Dir&Send != 0 {
+		imp.send = newModel(cfg, uint64(Send))
+	}
+	if cfg.Dir&Recv != 0 {
+		imp.recv = newModel(cfg, uint64(Recv))
+	}
+	return imp
+}
+
+// Config retorna a configuração em uso (padrões aplicados).
+func (imp *Impairer) Config() Config { return imp.cfg }
+
+// Stats retorna as degradações aplicadas no sentido d (Both soma os dois).
+func (imp *Impairer) Stats(d Dir) Stats {
+	var s Stats
+	if imp.send != nil && d&Send != 0 {
+		s = s.add(imp.send.n.stats())
+	}
+	if imp.recv != nil && d&Recv != 0 {
+		s = s.add(imp.recv.n.stats())
+	}
+	return s
+}
+
+// Summary descreve as degradações aplicadas em cada sentido ativo, para log.
+func (imp *Impairer) Summary() string {
+	var parts []string
+	if imp.send != nil {
+		parts = append(parts, "enviados: "+imp.Stats(Send).String())
+	}
+	if imp.recv != nil {
+		parts = append(parts, "recebidos: "+imp.Stats(Recv).String())
+	}
+	return strings.Join(parts, "; ")
+}
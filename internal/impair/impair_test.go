@@ -0,0 +1,148 @@
+package impair
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"udp/internal/protocol"
+)
+
+// DATA do segmento seq da sessão com um payload de 32 bytes
+func data(session, seq uint32, flags byte) []byte {
+	payload := make([]byte, 32)
+	payload[0] = byte(seq)
+	h := protocol.DataHeader{Flags: flags, Session: session, Seq: seq, Total: 1000, Size: uint16(len(payload)), CRC32: protocol.CRC32(payload)}
+	return append(protocol.PackHeader(h), payload...)
+}
+
+// modelo de um sentido com os padrões de cfg aplicados
+func testModel(cfg Config) *model {
+	return newModel(cfg.normalized(), uint64(Send))
+}
+
+// passa trace pelo modelo e retorna as entregas de cada datagrama
+func run(m *model, trace [][]byte) [][]delivery {
+	out := make([][]delivery, len(trace))
+	for i, b := range trace {
+		out[i] = m.apply(b)
+	}
+	return out
+}
+
+// A mesma semente diante da mesma sequência de datagramas decide as mesmas
+// perdas, duplicações, reordenações e corrupções, com os mesmos Stats; outra
+// semente decide outras.
+func TestSeedDeterministic(t *testing.T) {
+	var trace [][]byte
+	for round := range 3 {
+		for seq := range uint32(300) {
+			trace = append(trace, data(1, seq, 0))
+			if seq%50 == 0 {
+				trace = append(trace, protocol.CtrlNACK(1, []uint32{seq}), protocol.CtrlEOF(1))
+			}
+		}
+		trace = append(trace, data(1, uint32(round), protocol.DataFlagParity))
+	}
+	cfg := Config{Loss: 0.05, Burst: 0.02, Repeat: 1, Ctrl: map[string]float64{protocol.TypeNACK: 0.3},
+		Dup: 0.05, Reorder: 0.05, Jitter: 2 * time.Millisecond, Corrupt: 0.05, Seed: 42}
+
+	a, b := testModel(cfg), testModel(cfg)
+	got, want := run(a, trace), run(b, trace)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("mesma semente, entregas diferentes")
+	}
+	st := a.n.stats()
+	if st != b.n.stats() {
+		t.Fatalf("mesma semente: Stats %+v e %+v", st, b.n.stats())
+	}
+	if st.Datagrams != uint64(len(trace)) || st.Lost == 0 || st.Repeated == 0 || st.CtrlLost == 0 ||
+		st.Duplicated == 0 || st.Reordered == 0 || st.Corrupted == 0 {
+		t.Fatalf("Stats %+v: alguma degradação não foi exercitada", st)
+	}
+
+	cfg.Seed = 43
+	if other := run(testModel(cfg), trace); reflect.DeepEqual(other, got) {
+		t.Fatal("sementes diferentes, entregas iguais")
+	}
+}
+
+// tamanho médio das sequências de DATA perdidos e fração perdida de n
+// segmentos distintos
+func lossRuns(m *model, n int) (meanRun, rate float64) {
+	var runs, lost, cur int
+	for seq := range uint32(n) {
+		if len(m.apply(data(1, seq, 0))) == 0 {
+			lost++
+			cur++
+			continue
+		}
+		if cur > 0 {
+			runs++
+			cur = 0
+		}
+	}
+	if cur > 0 {
+		runs++
+	}
+	return float64(lost) / float64(runs), float64(lost) / float64(n)
+}
+
+// No Gilbert–Elliott as perdas vêm em rajadas de 1/BurstExit segmentos em
+// média, com taxa Burst/(Burst+BurstExit); a perda uniforme de mesma taxa
+// quase não forma sequências.
+func TestBurstLoss(t *testing.T) {
+	const n = 50000
+	burst := testModel(Config{Burst: 0.05, Seed: 7}) // BurstExit 0.25, BurstLoss 1
+	mean, rate := lossRuns(burst, n)
+	if mean < 3.5 || mean > 4.5 {
+		t.Errorf("rajada média de %.2f segmentos, esperado perto de 4", mean)
+	}
+	if want := 0.05 / (0.05 + defaultBurstExit); rate < want*0.85 || rate > want*1.15 {
+		t.Errorf("taxa de perda %.3f, esperado perto de %.3f", rate, want)
+	}
+
+	uniform := testModel(Config{Loss: rate, Seed: 7})
+	if mean, _ := lossRuns(uniform, n); mean > 1.5 {
+		t.Errorf("perda uniforme com sequência média de %.2f segmentos", mean)
+	}
+
+	// BurstLoss < 1: dentro da rajada só parte dos segmentos se perde
+	partial := testModel(Config{Burst: 0.05, BurstLoss: 0.5, Seed: 7})
+	if _, r := lossRuns(partial, n); r > rate*0.65 || r < rate*0.35 {
+		t.Errorf("burst-loss=0.5: taxa %.3f, esperado perto de %.3f", r, rate/2)
+	}
+}
+
+// Um DATA perdido com Repeat perde também as Repeat retransmissões
+// seguintes do mesmo segmento, e só delas: outras sessões, outros segmentos
+// e paridades com o mesmo seq passam, e uma paridade perdida não se repete.
+func TestRepeatLoss(t *testing.T) {
+	m := testModel(Config{Loss: 1, Repeat: 2})
+	if len(m.apply(data(1, 5, 0))) != 0 {
+		t.Fatal("DATA com loss=1 entregue")
+	}
+	m.apply(data(1, 7, protocol.DataFlagParity))
+	m.cfg.Loss = 0
+	steps := []struct {
+		name string
+		b    []byte
+		lost bool
+	}{
+		{"paridade do mesmo seq", data(1, 5, protocol.DataFlagParity), false},
+		{"outra sessão", data(2, 5, 0), false},
+		{"outro segmento", data(1, 6, 0), false},
+		{"primeira retransmissão", data(1, 5, 0), true},
+		{"segunda retransmissão", data(1, 5, 0), true},
+		{"terceira retransmissão", data(1, 5, 0), false},
+		{"após paridade perdida", data(1, 7, 0), false},
+	}
+	for _, s := range steps {
+		if lost := len(m.apply(s.b)) == 0; lost != s.lost {
+			t.Fatalf("%s: perdido %v, esperado %v", s.name, lost, s.lost)
+		}
+	}
+	if st := m.n.stats(); st.Lost != 4 || st.Repeated != 2 {
+		t.Fatalf("Stats %+v, esperado 4 perdidos e 2 repetidos", st)
+	}
+}
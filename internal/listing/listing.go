@@ -0,0 +1,252 @@
+// Package listing monta as páginas do LST a partir do diretório base do
+// servidor: valida o subdiretório pedido no LIST, percorre um nível ou a
+// árvore inteira em ordem lexical a partir do cursor e preenche tamanho,
+// mtime e o SHA-256 já em cache (sem ler os arquivos).
+package listing
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"udp/internal/protocol"
+	"udp/internal/segfile"
+)
+
+// Erros devolvidos por Read (as mensagens vão ao cliente no ERR).
+var (
+	ErrInvalidPath  = errors.New("caminho inválido")
+	ErrNotFound     = errors.New("diretório não encontrado")
+	ErrNotDir       = errors.New("não é um diretório")
+	ErrUnreadable   = errors.New("diretório ilegível")
+	ErrPageTooSmall = errors.New("LIST pequeno demais para a página")
+)
+
+// interrompe o percurso ao completar a página
+var errFull = errors.New("lst cheio")
+
+// Read monta uma página da listagem pedida em req: o subdiretório req.Path de
+// base (vazio = a própria base), de um nível ou, com ListFlagRecursive, em
+// toda a profundidade, a partir da entrada seguinte a req.Cursor. A página
+// leva até req.PageSize entradas (0 = sem limite) e cabe em maxBytes no LST;
+// se nem a primeira entrada couber, devolve ErrPageTooSmall. next é o cursor
+// da página seguinte ("" se esta é a última).
+//
+// Com cursor, o percurso recomeça no diretório do cursor e sobe pelos seus
+// ancestrais até req.Path, sem repassar as entradas anteriores.
+func Read(base string, req protocol.List, maxBytes int) (entries []protocol.Entry, next string, err error) {
+	clean := filepath.Clean(filepath.FromSlash(strings.TrimSpace(req.Path)))
+	if clean != "." && !filepath.IsLocal(clean) {
+		return nil, "", ErrInvalidPath
+	}
+	root := filepath.Join(base, clean)
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, "", ErrNotFound
+	}
+	if !st.IsDir() {
+		return nil, "", ErrNotDir
+	}
+	p := &page{base: base, recursive: req.Flags&protocol.ListFlagRecursive != 0, size: req.PageSize, maxBytes: maxBytes, used: protocol.LstHeaderSize}
+	if req.Cursor == "" {
+		err = p.dir(root, "")
+	} else {
+		err = p.resume(root, clean, req.Cursor)
+	}
+	if err != nil && err != errFull {
+		return nil, "", err
+	}
+	return p.entries, p.next, nil
+}
+
+// estado de uma página em montagem
+type page struct {
+	base      string
+	recursive bool
+	size      int // máximo de entradas (0 = sem limite)
+	maxBytes  int // tamanho máximo do LST (0 = sem limite)
+	used      int
+	entries   []protocol.Entry
+	next      string
+}
+
+// acrescenta as entradas de dir posteriores a after ("" = todas), descendo
+// nos subdiretórios se recursivo; subdiretórios ilegíveis têm o conteúdo omitido.
+func (p *page) dir(dir, after string) error {
+	list, err := readDir(dir)
+	if err != nil {
+		return ErrUnreadable
+	}
+	i := sort.Search(len(list), func(i int) bool { return list[i].Name() > after })
+	for _, d := range list[i:] {
+		path := filepath.Join(dir, d.Name())
+		if err := p.add(path, d); err != nil {
+			return err
+		}
+		if p.recursive && d.IsDir() {
+			if err := p.dir(path, ""); err == errFull || err == ErrPageTooSmall {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// continua o percurso após cursor (caminho relativo a base): primeiro o
+// conteúdo do próprio cursor, se for um diretório, depois as entradas
+// seguintes de cada diretório entre o do cursor e root (clean relativo a base).
+func (p *page) resume(root, clean, cursor string) error {
+	rel := cursor
+	if clean != "." {
+		prefix := filepath.ToSlash(clean) + "/"
+		if !strings.HasPrefix(cursor, prefix) {
+			return ErrInvalidPath
+		}
+		rel = cursor[len(prefix):]
+	}
+	parts := strings.Split(rel, "/")
+	for _, c := range parts {
+		if c == "" || c == "." || c == ".." || strings.ContainsRune(c, filepath.Separator) {
+			return ErrInvalidPath
+		}
+	}
+	first := 0
+	if p.recursive {
+		first = len(parts) - 1
+		cur := filepath.Join(root, filepath.FromSlash(rel))
+		if st, err := os.Lstat(cur); err == nil && st.IsDir() {
+			if err := p.dir(cur, ""); err == errFull || err == ErrPageTooSmall {
+				return err
+			}
+		}
+	}
+	for i := first; i >= 0; i-- {
+		dir := filepath.Join(root, filepath.FromSlash(strings.Join(parts[:i], "/")))
+		// um diretório do caminho do cursor removido entre as páginas só
+		// encerra a listagem se for o próprio root
+		err := p.dir(dir, parts[i])
+		if err == errFull || err == ErrPageTooSmall || err != nil && i == 0 {
+			return err
+		}
+	}
+	return nil
+}
+
+// acrescenta a entrada de path à página ou, se ela não couber, encerra a
+// página com errFull.
+func (p *page) add(path string, d fs.DirEntry) error {
+	e, ok := entry(p.base, path, d)
+	if !ok {
+		return nil
+	}
+	// cada entrada pode ser a última da página e virar também o cursor
+	n := protocol.LstEntrySize(e)
+	if p.maxBytes > 0 && p.used+n+len(e.Name) > p.maxBytes || p.size > 0 && len(p.entries) >= p.size {
+		if len(p.entries) == 0 {
+			return ErrPageTooSmall
+		}
+		p.next = p.entries[len(p.entries)-1].Name
+		return errFull
+	}
+	p.used += n
+	p.entries = append(p.entries, e)
+	return nil
+}
+
+// Diretórios grandes são listados em muitas páginas; a listagem ordenada
+// fica em cache, validada pelo mtime do diretório, para que cada página não
+// releia e reordene o diretório inteiro.
+const (
+	minCachedEntries = 256
+	maxCachedDirs    = 32
+)
+
+type cachedDir struct {
+	mod  time.Time
+	list []fs.DirEntry
+	used time.Time
+}
+
+var dirCache = struct {
+	sync.Mutex
+	m map[string]*cachedDir
+}{m: make(map[string]*cachedDir)}
+
+// entradas de dir em ordem de nome, do cache se o diretório não mudou.
+func readDir(dir string) ([]fs.DirEntry, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirCache.Lock()
+	if c, ok := dirCache.m[dir]; ok && c.mod.Equal(st.ModTime()) {
+		c.used = time.Now()
+		dirCache.Unlock()
+		return c.list, nil
+	}
+	dirCache.Unlock()
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) < minCachedEntries {
+		return list, nil
+	}
+	dirCache.Lock()
+	defer dirCache.Unlock()
+	if len(dirCache.m) >= maxCachedDirs {
+		var oldest string
+		for k, c := range dirCache.m {
+			if oldest == "" || c.used.Before(dirCache.m[oldest].used) {
+				oldest = k
+			}
+		}
+		delete(dirCache.m, oldest)
+	}
+	dirCache.m[dir] = &cachedDir{mod: st.ModTime(), list: list, used: time.Now()}
+	return list, nil
+}
+
+// caminho de path relativo a base, separado por '/' ("" se não for possível).
+func relName(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// monta a entrada de path; links simbólicos são descritos pelo destino (sem
+// percorrer diretórios apontados por eles).
+func entry(base, path string, d fs.DirEntry) (protocol.Entry, bool) {
+	info, err := d.Info()
+	if err != nil {
+		return protocol.Entry{}, false
+	}
+	if d.Type()&fs.ModeSymlink != 0 {
+		if info, err = os.Stat(path); err != nil {
+			return protocol.Entry{}, false
+		}
+	}
+	name := relName(base, path)
+	if name == "" {
+		return protocol.Entry{}, false
+	}
+	e := protocol.Entry{Name: name, ModTime: info.ModTime()}
+	switch {
+	case info.IsDir():
+		e.Kind = protocol.EntryDir
+	case info.Mode().IsRegular():
+		e.Kind = protocol.EntryFile
+		e.Size = info.Size()
+		e.SHA256, _ = segfile.CachedHash(path, info)
+	default:
+		return protocol.Entry{}, false // dispositivos, pipes, sockets
+	}
+	return e, true
+}
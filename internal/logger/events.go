@@ -0,0 +1,192 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// tipo de um evento de transferência
+type EventType string
+
+const (
+	EventSessionStart EventType = "session_start" // sessão criada (servidor: pedido admitido; cliente: META recebido)
+	EventRejected     EventType = "rejected"      // pedido recusado antes de criar sessão (ERR: ocupado, caminho inválido...)
+	EventMetaSent     EventType = "meta_sent"     // META enviado (servidor; aceite do PUT inclusive)
+	EventSegmentsSent EventType = "segments_sent" // faixa de segmentos do envio inicial (range, count, bytes)
+	EventNackSent     EventType = "nack_sent"     // round de NACK do cliente (round, count pedidos, missing no total)
+	EventNackReceived EventType = "nack_received" // NACK recebido (count faltantes, range do menor ao maior)
+	EventRetransmit   EventType = "retransmit"    // segmentos reenviados (quem envia) ou recuperados (quem recebe) por NACK
+	EventEOFSent      EventType = "eof_sent"      // EOF enviado ao fim do envio inicial
+	EventEOFReceived  EventType = "eof_received"  // EOF recebido
+	EventCompleted    EventType = "completed"     // transferência concluída
+	EventFailed       EventType = "failed"        // transferência interrompida ou recusada (reason, code)
+)
+
+// faixa de números de sequência, inclusiva
+type SeqRange struct {
+	First uint32 `json:"first"`
+	Last  uint32 `json:"last"`
+}
+
+// representa um evento de transferência, gravado como uma linha JSON. Os
+// campos vazios são omitidos; Session, Peer e Path identificam a transferência
+// para reconstruí-la a partir do fluxo de eventos.
+type Event struct {
+	Time          time.Time `json:"ts"`
+	Source        string    `json:"source"` // "server" ou "client" (preenchido por EventLog)
+	Type          EventType `json:"event"`
+	Session       string    `json:"session,omitempty"`             // identificador da sessão em hexadecimal (SessionID)
+	Peer          string    `json:"peer,omitempty"`                // endereço do outro lado (ip:porta)
+	Direction     string    `json:"direction,omitempty"`           // "download" ou "upload"
+	Path          string    `json:"path,omitempty"`                // caminho pedido (download) ou nome remoto (upload)
+	Size          int64     `json:"size,omitempty"`                // tamanho do arquivo
+	Total         uint32    `json:"total,omitempty"`               // total de segmentos
+	Chunk         int       `json:"chunk,omitempty"`               // bytes por segmento
+	Codec         string    `json:"codec,omitempty"`               // compressão negociada
+	EstimatedWire int64     `json:"estimated_wire_size,omitempty"` // estimativa (META) dos bytes de payload após a compressão
+	FEC           string    `json:"fec,omitempty"`                 // dados:paridade por bloco
+	Range         *SeqRange `json:"range,omitempty"`               // faixa de segmentos do evento
+	Count         int       `json:"count,omitempty"`               // segmentos do evento
+	Missing       int       `json:"missing,omitempty"`             // segmentos ainda faltando
+	Round         int       `json:"round,omitempty"`               // round de NACK
+	Bytes         uint64    `json:"bytes,omitempty"`               // bytes do evento (ou da transferência, no fim)
+	Duration      float64   `json:"duration_ms,omitempty"`
+	Loss          float64   `json:"loss,omitempty"` // fração de datagramas perdidos
+	Resumed       bool      `json:"resumed,omitempty"`
+	Code          uint16    `json:"code,omitempty"`   // código do ERR (protocol.ErrCode)
+	Reason        string    `json:"reason,omitempty"` // motivo da falha
+}
+
+// formata um identificador de sessão como nos logs de texto (%08x)
+func SessionID(id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%08x", id)
+}
+
+// faixa do menor ao maior número de sequência de seqs (nil se vazia)
+func RangeOf(seqs []uint32) *SeqRange {
+	if len(seqs) == 0 {
+		return nil
+	}
+	r := SeqRange{First: seqs[0], Last: seqs[0]}
+	for _, seq := range seqs {
+		r.First, r.Last = min(r.First, seq), max(r.Last, seq)
+	}
+	return &r
+}
+
+// converte uma duração em milissegundos (campo duration_ms)
+func Millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// grava eventos como JSON lines (um objeto por linha) num io.Writer; é
+// seguro para uso concorrente e um *EventLog nil descarta os eventos
+type EventLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	source string
+}
+
+// cria um log de eventos que escreve em w, marcando-os com source
+// ("server" ou "client")
+func NewEventLog(w io.Writer, source string) *EventLog {
+	return &EventLog{w: w, source: source}
+}
+
+// abre um log de eventos em path ("-" = saída padrão), rotacionando o
+// arquivo ao passar de maxSize bytes (0 = sem rotação) e mantendo até
+// backups arquivos antigos
+func OpenEventLog(path, source string, maxSize int64, backups int) (*EventLog, error) {
+	if path == "-" {
+		return NewEventLog(os.Stdout, source), nil
+	}
+	f, err := NewRotatingFile(path, maxSize, backups)
+	if err != nil {
+		return nil, err
+	}
+	l := NewEventLog(f, source)
+	l.closer = f
+	return l, nil
+}
+
+// grava e; Time e Source são preenchidos se vierem vazios
+func (l *EventLog) Emit(e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Source == "" {
+		e.Source = l.source
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(b, '\n'))
+}
+
+// fecha o arquivo do log, se foi aberto por OpenEventLog
+func (l *EventLog) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closer.Close()
+}
+
+// segmentos por evento segments_sent quando SegmentBatcher.Every é 0
+const DefaultSegmentBatch = 1024
+
+// agrupa segmentos enviados em faixas contíguas e emite em Log um evento
+// segments_sent (cópia de Base com Range, Count e Bytes) a cada Every
+// segmentos, ao pular uma sequência ou em Flush
+type SegmentBatcher struct {
+	Log   *EventLog
+	Base  Event
+	Every uint32
+
+	first, next uint32 // faixa em andamento: [first, next)
+	bytes       uint64 // bytes enviados na faixa
+}
+
+// acrescenta o segmento seq, enviado em n bytes
+func (b *SegmentBatcher) Add(seq uint32, n int) {
+	if seq != b.next {
+		b.Flush()
+		b.first = seq
+	}
+	b.next = seq + 1
+	b.bytes += uint64(n)
+	every := b.Every
+	if every == 0 {
+		every = DefaultSegmentBatch
+	}
+	if b.next-b.first >= every {
+		b.Flush()
+	}
+}
+
+// emite a faixa em andamento, se houver
+func (b *SegmentBatcher) Flush() {
+	if b.next > b.first {
+		e := b.Base
+		e.Type = EventSegmentsSent
+		e.Range = &SeqRange{First: b.first, Last: b.next - 1}
+		e.Count, e.Bytes = int(b.next-b.first), b.bytes
+		b.Log.Emit(e)
+	}
+	b.first, b.bytes = b.next, 0
+}
@@ -40,6 +40,24 @@ func (l LogLevel) String() string {
 	}
 }
 
+// converte o nome de um nível (debug, info, warn, error, fatal; sem
+// distinção de maiúsculas) no LogLevel correspondente
+func ParseLevel(name string) (LogLevel, error) {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "DEBUG":
+		return DEBUG, nil
+	case "INFO":
+		return INFO, nil
+	case "WARN", "WARNING":
+		return WARN, nil
+	case "ERROR":
+		return ERROR, nil
+	case "FATAL":
+		return FATAL, nil
+	}
+	return INFO, fmt.Errorf("nível de log desconhecido: %q", name)
+}
+
 // retorna a cor ANSI para o nível de log
 func (l LogLevel) Color() string {
 	switch l {
@@ -162,6 +180,11 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 	l.output.Write([]byte(logLine))
 }
 
+// escreve uma mensagem no nível informado (sem encerrar o programa em FATAL)
+func (l *Logger) Logf(level LogLevel, format string, args ...interface{}) {
+	l.log(level, format, args...)
+}
+
 // escreve uma mensagem de debug
 func (l *Logger) Debug(format string, args ...interface{}) {
 	l.log(DEBUG, format, args...)
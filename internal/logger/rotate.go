@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// arquivo de log que rotaciona ao atingir um tamanho máximo: path vira
+// path.1, path.1 vira path.2 e assim por diante, descartando o mais antigo
+// além de backups
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64 // tamanho que dispara a rotação (0 = nunca rotaciona)
+	backups int   // arquivos antigos mantidos (0 = só o atual)
+	file    *os.File
+	size    int64
+	grace   int64 // bytes tolerados além de maxSize após uma rotação que falhou
+}
+
+// abre (ou cria) path para acréscimo, rotacionando-o ao passar de maxSize bytes
+func NewRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	r := &RotatingFile{path: path, maxSize: max(maxSize, 0), backups: max(backups, 0)}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// abre o arquivo atual, continuando do tamanho que já tem
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, st.Size()
+	return nil
+}
+
+// escreve p no arquivo atual, rotacionando antes se p o faria passar do
+// limite (uma escrita nunca é dividida entre dois arquivos)
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rerr error
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize+r.grace {
+		if rerr = r.rotate(); rerr != nil {
+			if r.file == nil {
+				return 0, rerr
+			}
+			// o log segue no arquivo atual; o erro é devolvido uma vez e a
+			// próxima tentativa fica para depois de mais maxSize bytes
+			r.grace = r.size
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	if err == nil {
+		err = rerr
+	}
+	return n, err
+}
+
+// fecha o arquivo atual, desloca os antigos e abre um novo vazio; se o
+// deslocamento falhar, reabre o atual para acréscimo e devolve o erro
+func (r *RotatingFile) rotate() error {
+	err := r.file.Close()
+	r.file = nil
+	if err == nil {
+		err = r.shift()
+	}
+	if oerr := r.open(); oerr != nil {
+		return errors.Join(err, oerr)
+	}
+	if err == nil {
+		r.grace = 0
+	}
+	return err
+}
+
+// renomeia path para path.1 (e os antigos para o número seguinte), ou o
+// remove sem backups
+func (r *RotatingFile) shift() error {
+	if r.backups == 0 {
+		os.Remove(r.path)
+		return nil
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups))
+	for i := r.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	return os.Rename(r.path, r.path+".1")
+}
+
+// fecha o arquivo atual
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
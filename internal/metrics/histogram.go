@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+)
+
+// limites padrão das distribuições por transferência
+var (
+	// DurationBuckets são os limites (segundos) da duração de transferências
+	DurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+	// LossBuckets são os limites da razão de perda (0..1) de transferências
+	LossBuckets = []float64{0, 0.001, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1}
+)
+
+// distribuição de observações em faixas fixas (histograma cumulativo)
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64 // limites superiores das faixas, crescentes
+	counts []uint64  // observações por faixa; a última é +Inf
+	sum    float64
+	count  uint64
+}
+
+// representa o estado de um histograma
+type HistogramSnapshot struct {
+	Bounds []float64 `json:"bounds"` // limites superiores das faixas
+	Counts []uint64  `json:"counts"` // observações <= cada limite (cumulativas), seguidas do total (+Inf)
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// cria um histograma com os limites bounds
+func NewHistogram(bounds []float64) *Histogram {
+	b := append([]float64(nil), bounds...)
+	sort.Float64s(b)
+	return &Histogram{bounds: b, counts: make([]uint64, len(b)+1)}
+}
+
+// registra uma observação
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v) // primeira faixa com limite >= v
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// retorna uma cópia do histograma com contagens cumulativas
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HistogramSnapshot{Bounds: append([]float64(nil), h.bounds...), Counts: make([]uint64, len(h.counts)), Sum: h.sum, Count: h.count}
+	var acc uint64
+	for i, c := range h.counts {
+		acc += c
+		s.Counts[i] = acc
+	}
+	return s
+}
@@ -177,6 +177,15 @@ type ServerMetrics struct {
 	TotalBytesSent    uint64 `json:"total_bytes_sent"`
 	TotalSegmentsSent uint64 `json:"total_segments_sent"`
 
+	// Contadores de recuperação, envios dos clientes e admissão
+	TotalParitySent    uint64  `json:"total_parity_sent"`    // paridades FEC enviadas
+	TotalFECRecovered  uint64  `json:"total_fec_recovered"`  // segmentos reconstruídos por FEC nos clientes
+	TotalBytesReceived uint64  `json:"total_bytes_received"` // bytes gravados de envios dos clientes (PUT)
+	TotalUploads       uint64  `json:"total_uploads"`        // envios concluídos e verificados
+	TotalBusy          uint64  `json:"total_busy"`           // pedidos recusados por ocupação (ERR BUSY)
+	RejectedDatagrams  uint64  `json:"rejected_datagrams"`   // datagramas descartados pelo modo cifrado
+	SendRate           float64 `json:"send_rate"`            // taxa de envio agregada atual (bytes/s)
+
 	// Contadores de erro
 	TotalErrors          uint64 `json:"total_errors"`
 	TotalTimeouts        uint64 `json:"total_timeouts"`
@@ -194,6 +203,11 @@ type ServerMetrics struct {
 	// Histórico de conexões
 	ConnectionHistory []ConnectionPoint `json:"connection_history"`
 
+	// Distribuições por transferência (compartilhadas com as cópias de GetSnapshot)
+	DownloadDuration *Histogram `json:"-"` // duração dos downloads (segundos)
+	UploadDuration   *Histogram `json:"-"` // duração dos envios dos clientes (segundos)
+	LossRatio        *Histogram `json:"-"` // razão de perda dos downloads (perdidos / enviados)
+
 	// Mutex para proteção
 	mu sync.RWMutex
 }
@@ -209,6 +223,9 @@ func NewServerMetrics() *ServerMetrics {
 	return &ServerMetrics{
 		StartTime:         time.Now(),
 		ConnectionHistory: make([]ConnectionPoint, 0),
+		DownloadDuration:  NewHistogram(DurationBuckets),
+		UploadDuration:    NewHistogram(DurationBuckets),
+		LossRatio:         NewHistogram(LossBuckets),
 	}
 }
 
@@ -266,6 +283,53 @@ func (m *ServerMetrics) AddNack() {
 	atomic.AddUint64(&m.TotalNacksReceived, 1)
 }
 
+// adiciona paridades FEC enviadas
+func (m *ServerMetrics) AddParitySent(segments uint64) {
+	atomic.AddUint64(&m.TotalParitySent, segments)
+}
+
+// adiciona segmentos reconstruídos por FEC nos clientes
+func (m *ServerMetrics) AddFECRecovered(segments uint64) {
+	atomic.AddUint64(&m.TotalFECRecovered, segments)
+}
+
+// adiciona bytes recebidos de envios dos clientes
+func (m *ServerMetrics) AddBytesReceived(bytes uint64) {
+	atomic.AddUint64(&m.TotalBytesReceived, bytes)
+}
+
+// adiciona um envio de cliente concluído
+func (m *ServerMetrics) AddUpload() {
+	atomic.AddUint64(&m.TotalUploads, 1)
+}
+
+// adiciona um pedido recusado por ocupação
+func (m *ServerMetrics) AddBusy() {
+	atomic.AddUint64(&m.TotalBusy, 1)
+}
+
+// atualiza os valores instantâneos lidos de outras fontes (socket cifrado e
+// controle de congestionamento)
+func (m *ServerMetrics) SetGauges(rejected uint64, sendRate float64) {
+	atomic.StoreUint64(&m.RejectedDatagrams, rejected)
+	m.mu.Lock()
+	m.SendRate = sendRate
+	m.mu.Unlock()
+}
+
+// registra uma transferência encerrada: duração e, nos downloads, a razão de
+// perda (negativa = desconhecida)
+func (m *ServerMetrics) ObserveTransfer(upload bool, d time.Duration, loss float64) {
+	if upload {
+		m.UploadDuration.Observe(d.Seconds())
+	} else {
+		m.DownloadDuration.Observe(d.Seconds())
+	}
+	if loss >= 0 {
+		m.LossRatio.Observe(loss)
+	}
+}
+
 // registra o número atual de conexões
 func (m *ServerMetrics) recordConnectionCount(count int64) {
 	m.mu.Lock()
@@ -294,6 +358,13 @@ func (m *ServerMetrics) GetSnapshot() ServerMetrics {
 		ActiveConnections:    atomic.LoadInt64(&m.ActiveConnections),
 		TotalBytesSent:       atomic.LoadUint64(&m.TotalBytesSent),
 		TotalSegmentsSent:    atomic.LoadUint64(&m.TotalSegmentsSent),
+		TotalParitySent:      atomic.LoadUint64(&m.TotalParitySent),
+		TotalFECRecovered:    atomic.LoadUint64(&m.TotalFECRecovered),
+		TotalBytesReceived:   atomic.LoadUint64(&m.TotalBytesReceived),
+		TotalUploads:         atomic.LoadUint64(&m.TotalUploads),
+		TotalBusy:            atomic.LoadUint64(&m.TotalBusy),
+		RejectedDatagrams:    atomic.LoadUint64(&m.RejectedDatagrams),
+		SendRate:             m.SendRate,
 		TotalErrors:          atomic.LoadUint64(&m.TotalErrors),
 		TotalTimeouts:        atomic.LoadUint64(&m.TotalTimeouts),
 		TotalRetransmissions: atomic.LoadUint64(&m.TotalRetransmissions),
@@ -303,6 +374,9 @@ func (m *ServerMetrics) GetSnapshot() ServerMetrics {
 		AverageConnections:   m.calculateAverageConnections(),
 		PeakConnections:      atomic.LoadInt64(&m.PeakConnections),
 		ConnectionHistory:    append([]ConnectionPoint(nil), m.ConnectionHistory...),
+		DownloadDuration:     m.DownloadDuration,
+		UploadDuration:       m.UploadDuration,
+		LossRatio:            m.LossRatio,
 	}
 }
 
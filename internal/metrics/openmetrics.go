@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// tipo MIME do formato texto OpenMetrics
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// escreve famílias de métricas no formato texto OpenMetrics
+type expositor struct {
+	w *bufio.Writer
+}
+
+// cabeçalho de uma família: TYPE, UNIT (se houver) e HELP
+func (e *expositor) family(name, typ, unit, help string) {
+	fmt.Fprintf(e.w, "# TYPE %s %s\n", name, typ)
+	if unit != "" {
+		fmt.Fprintf(e.w, "# UNIT %s %s\n", name, unit)
+	}
+	fmt.Fprintf(e.w, "# HELP %s %s\n", name, help)
+}
+
+// família counter com uma amostra (name_total)
+func (e *expositor) counter(name, unit, help string, v uint64) {
+	e.family(name, "counter", unit, help)
+	fmt.Fprintf(e.w, "%s_total %d\n", name, v)
+}
+
+// família gauge com uma amostra
+func (e *expositor) gauge(name, unit, help string, v float64) {
+	e.family(name, "gauge", unit, help)
+	fmt.Fprintf(e.w, "%s %s\n", name, formatFloat(v))
+}
+
+// família histogram com uma série por conjunto de rótulos (labels no formato
+// `chave="valor"`, vazio = sem rótulos)
+func (e *expositor) histogram(name, unit, help string, series map[string]HistogramSnapshot, order ...string) {
+	e.family(name, "histogram", unit, help)
+	for _, labels := range order {
+		h := series[labels]
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		for i, b := range h.Bounds {
+			fmt.Fprintf(e.w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(b), h.Counts[i])
+		}
+		fmt.Fprintf(e.w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.Count)
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(e.w, "%s_count%s %d\n", name, labels, h.Count)
+		fmt.Fprintf(e.w, "%s_sum%s %s\n", name, labels, formatFloat(h.Sum))
+	}
+}
+
+// formata um número como o OpenMetrics espera (+Inf, -Inf, NaN)
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escreve as métricas do servidor no formato texto OpenMetrics, terminando
+// com "# EOF"
+func (m *ServerMetrics) WriteOpenMetrics(w io.Writer) error {
+	e := &expositor{w: bufio.NewWriter(w)}
+	e.counter("udp_server_sent_bytes", "bytes", "Bytes sent to clients, headers included.", atomic.LoadUint64(&m.TotalBytesSent))
+	e.counter("udp_server_segments_sent", "", "Initial DATA segments sent.", atomic.LoadUint64(&m.TotalSegmentsSent))
+	e.counter("udp_server_parity_sent", "", "FEC parity segments sent.", atomic.LoadUint64(&m.TotalParitySent))
+	e.counter("udp_server_nacks_received", "", "NACK datagrams received.", atomic.LoadUint64(&m.TotalNacksReceived))
+	e.counter("udp_server_retransmissions", "", "Segments retransmitted on NACK.", atomic.LoadUint64(&m.TotalRetransmissions))
+	e.counter("udp_server_fec_recovered", "", "Segments rebuilt from FEC parity by clients (reported in FBK).", atomic.LoadUint64(&m.TotalFECRecovered))
+	e.counter("udp_server_received_bytes", "bytes", "Segment bytes written from client uploads.", atomic.LoadUint64(&m.TotalBytesReceived))
+	e.counter("udp_server_uploads", "", "Client uploads completed and verified.", atomic.LoadUint64(&m.TotalUploads))
+	e.counter("udp_server_transfers", "", "Transfers (downloads and uploads) started.", atomic.LoadUint64(&m.TotalConnections))
+	e.counter("udp_server_busy_rejections", "", "Requests refused with ERR BUSY (admission limits or shutdown).", atomic.LoadUint64(&m.TotalBusy))
+	e.counter("udp_server_errors", "", "Requests refused with ERR.", atomic.LoadUint64(&m.TotalErrors))
+	e.counter("udp_server_timeouts", "", "Transfers aborted for lack of client feedback.", atomic.LoadUint64(&m.TotalTimeouts))
+	e.counter("udp_server_rejected_datagrams", "", "Datagrams dropped by encrypted mode (plain, forged or replayed).", atomic.LoadUint64(&m.RejectedDatagrams))
+	m.mu.RLock()
+	rate := m.SendRate
+	m.mu.RUnlock()
+	e.gauge("udp_server_active_sessions", "", "Transfers in progress.", float64(atomic.LoadInt64(&m.ActiveConnections)))
+	e.gauge("udp_server_peak_sessions", "", "Most transfers in progress at once.", float64(atomic.LoadInt64(&m.PeakConnections)))
+	e.gauge("udp_server_send_rate_bytes_per_second", "", "Aggregate send rate of the sessions.", rate)
+	e.gauge("udp_server_uptime_seconds", "seconds", "Time since the server started.", time.Since(m.StartTime).Seconds())
+	e.histogram("udp_server_transfer_duration_seconds", "seconds", "Duration of finished transfers, from request to the client's last datagram.",
+		map[string]HistogramSnapshot{`direction="download"`: m.DownloadDuration.Snapshot(), `direction="upload"`: m.UploadDuration.Snapshot()},
+		`direction="download"`, `direction="upload"`)
+	e.histogram("udp_server_transfer_loss_ratio", "ratio", "Fraction of datagrams lost per finished download, from client feedback.",
+		map[string]HistogramSnapshot{"": m.LossRatio.Snapshot()}, "")
+	fmt.Fprintln(e.w, "# EOF")
+	return e.w.Flush()
+}
+
+// expõe m por HTTP no formato OpenMetrics; refresh, se não for nil, é
+// chamado antes de cada coleta para atualizar os valores instantâneos
+func Handler(m *ServerMetrics, refresh func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if refresh != nil {
+			refresh()
+		}
+		w.Header().Set("Content-Type", ContentType)
+		_ = m.WriteOpenMetrics(w)
+	})
+}
@@ -0,0 +1,11 @@
+//go:build darwin
+
+package pmtu
+
+import "syscall"
+
+const ipDontFrag = 28 // IP_DONTFRAG (netinet/in.h)
+
+func setDF(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, ipDontFrag, 1)
+}
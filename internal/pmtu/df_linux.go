@@ -0,0 +1,15 @@
+//go:build linux
+
+package pmtu
+
+import "syscall"
+
+// IP_PMTUDISC_DO: nunca fragmenta; envios acima do MTU conhecido falham com EMSGSIZE.
+func setDF(fd uintptr) error {
+	err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	if err != nil {
+		// socket IPv6
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	}
+	return nil
+}
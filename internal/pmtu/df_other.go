@@ -0,0 +1,7 @@
+//go:build !linux && !windows && !darwin
+
+package pmtu
+
+// sem DF a sonda ainda funciona, mas datagramas fragmentados e remontados
+// pelo caminho também contam como entregues.
+func setDF(fd uintptr) error { return ErrUnsupported }
@@ -0,0 +1,11 @@
+//go:build windows
+
+package pmtu
+
+import "syscall"
+
+const ipDontFragment = 14 // IP_DONTFRAGMENT (ws2ipdef.h)
+
+func setDF(fd uintptr) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, ipDontFragment, 1)
+}
@@ -0,0 +1,46 @@
+// Package pmtu dá suporte à descoberta de MTU do caminho: marca sockets UDP
+// com DF (não fragmentar), para que datagramas maiores que o MTU sejam
+// descartados em vez de fragmentados, e define os tamanhos sondados.
+package pmtu
+
+import (
+	"errors"
+	"net"
+
+	"udp/internal/config"
+	"udp/internal/protocol"
+)
+
+// ErrUnsupported indica que o sistema não permite marcar DF no socket.
+var ErrUnsupported = errors.New("DF não suportado neste sistema")
+
+// Ladder são os tamanhos de datagrama sondados na primeira rodada, do maior
+// para o menor: limite do UDP/IPv4, loopback, jumbo frames (9000) e MTUs
+// Ethernet/PPPoE/túneis comuns, menos os cabeçalhos IP+UDP.
+var Ladder = []int{
+	config.MaxDatagramSize,
+	config.MaxChunkSize + protocol.HeaderSize(),
+	32768,
+	16384,
+	9000 - ipUDPOverhead, // jumbo frames
+	4096,
+	config.MTUDefault - ipUDPOverhead,    // Ethernet
+	1492 - ipUDPOverhead,                 // PPPoE
+	1280 - 40 - config.UDPHeaderOverhead, // MTU mínimo do IPv6
+}
+
+// cabeçalhos IPv4 + UDP
+const ipUDPOverhead = config.IPHeaderOverhead + config.UDPHeaderOverhead
+
+// SetDontFragment marca o socket para enviar datagramas com DF.
+func SetDontFragment(c *net.UDPConn) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) { serr = setDF(fd) }); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Layouts da v1 (LegacyVersion), o protocolo original sem sessão: DATA de 18
+// bytes sem flags e controles sem token nem sessão. Só REQ, META, ERR, EOF e
+// NACK são decodificados; a listagem e os tipos posteriores exigem a v2.
+
+// tamanho em bytes do cabeçalho DATA v1.
+const dataHeaderSizeV1 = 2 + 1 + 1 + 4 + 4 + 2 + 4
+
+// IsLegacy informa se o datagrama (DATA ou controle) usa o layout v1.
+func IsLegacy(b []byte) bool {
+	return len(b) >= 3 && b[0] == 'U' && (b[1] == dataMagic[1] || b[1] == ctrlMagic1) && b[2] == layoutV1
+}
+
+func packHeaderV1(h DataHeader) []byte {
+	buf := make([]byte, dataHeaderSizeV1)
+	buf[0], buf[1], buf[2] = dataMagic[0], dataMagic[1], layoutV1 // flags (buf[3]) sempre 0
+	binary.BigEndian.PutUint32(buf[4:8], h.Seq)
+	binary.BigEndian.PutUint32(buf[8:12], h.Total)
+	binary.BigEndian.PutUint16(buf[12:14], h.Size)
+	binary.BigEndian.PutUint32(buf[14:18], h.CRC32)
+	return buf
+}
+
+// decodifica o cabeçalho v1 (magic e tamanho já conferidos).
+func unpackHeaderV1(b []byte) DataHeader {
+	return DataHeader{
+		Version: LegacyVersion,
+		Seq:     binary.BigEndian.Uint32(b[4:8]),
+		Total:   binary.BigEndian.Uint32(b[8:12]),
+		Size:    binary.BigEndian.Uint16(b[12:14]),
+		CRC32:   binary.BigEndian.Uint32(b[14:18]),
+	}
+}
+
+func packREQv1(path string) []byte {
+	return append(ctrlHeaderV(ctrlTypeREQ, layoutV1, len(path)), path...)
+}
+
+func packMETAv1(m Meta) []byte {
+	fn := []byte(m.Filename)
+	payload := make([]byte, 4+8+2+2+len(fn)+32)
+	binary.BigEndian.PutUint32(payload[0:4], m.Total)
+	binary.BigEndian.PutUint64(payload[4:12], uint64(m.Size))
+	binary.BigEndian.PutUint16(payload[12:14], uint16(m.Chunk))
+	binary.BigEndian.PutUint16(payload[14:16], uint16(len(fn)))
+	copy(payload[16:], fn)
+	copy(payload[16+len(fn):], parseHexSha(m.SHA256))
+	return append(ctrlHeaderV(ctrlTypeMETA, layoutV1, len(payload)), payload...)
+}
+
+// CtrlLegacyERR monta um ERR v1 (sem token nem espera sugerida).
+func CtrlLegacyERR(code ErrCode, msg string) []byte {
+	payload := make([]byte, 2+2+len(msg))
+	binary.BigEndian.PutUint16(payload[0:2], uint16(code))
+	binary.BigEndian.PutUint16(payload[2:4], uint16(len(msg)))
+	copy(payload[4:], msg)
+	return append(ctrlHeaderV(ctrlTypeERR, layoutV1, len(payload)), payload...)
+}
+
+// CtrlLegacyEOF monta um EOF v1 (vazio).
+func CtrlLegacyEOF() []byte { return ctrlHeaderV(ctrlTypeEOF, layoutV1, 0) }
+
+// CtrlLegacyNACK monta um NACK v1 (sem sessão).
+func CtrlLegacyNACK(missing []uint32) []byte {
+	payload := make([]byte, 2+4*len(missing))
+	binary.BigEndian.PutUint16(payload[0:2], uint16(len(missing)))
+	for i, seq := range missing { binary.BigEndian.PutUint32(payload[2+4*i:], seq) }
+	return append(ctrlHeaderV(ctrlTypeNACK, layoutV1, len(payload)), payload...)
+}
+
+// decodifica o payload p de um controle v1 do tipo t.
+func decodeCtrlV1(t byte, p []byte) (typ string, v any, err error) {
+	switch t {
+	case ctrlTypeREQ:
+		return TypeREQ, Req{Path: string(p), Version: LegacyVersion}, nil
+	case ctrlTypeMETA:
+		m, e := unpackMETAv1(p); return TypeMETA, m, e
+	case ctrlTypeERR:
+		if len(p) < 4 { return TypeERR, ErrMsg{}, errors.New("ERR curto") }
+		ml := int(binary.BigEndian.Uint16(p[2:4]))
+		if len(p) < 4+ml { return TypeERR, ErrMsg{}, errors.New("ERR curto 2") }
+		return TypeERR, ErrMsg{Code: ErrCode(binary.BigEndian.Uint16(p[0:2])), Message: string(p[4 : 4+ml])}, nil
+	case ctrlTypeEOF:
+		return TypeEOF, EOFMsg{}, nil
+	case ctrlTypeNACK:
+		if len(p) < 2 { return TypeNACK, Nack{}, errors.New("NACK curto") }
+		n := int(binary.BigEndian.Uint16(p[0:2]))
+		if len(p) < 2+4*n { return TypeNACK, Nack{}, errors.New("NACK curto 2") }
+		m := make([]uint32, n)
+		for i := range m { m[i] = binary.BigEndian.Uint32(p[2+4*i:]) }
+		return TypeNACK, Nack{Missing: m}, nil
+	}
+	return "", nil, errors.New("tipo ctrl desconhecido")
+}
+
+func unpackMETAv1(p []byte) (Meta, error) {
+	if len(p) < 4+8+2+2+32 { return Meta{}, errors.New("META curto") }
+	m := Meta{Version: LegacyVersion}
+	m.Total = binary.BigEndian.Uint32(p[0:4])
+	m.Size = int64(binary.BigEndian.Uint64(p[4:12]))
+	m.Chunk = int(binary.BigEndian.Uint16(p[12:14]))
+	m.EstimatedCompressedSize = m.Size
+	fnLen := int(binary.BigEndian.Uint16(p[14:16]))
+	if len(p) < 16+fnLen+32 { return Meta{}, errors.New("META curto 2") }
+	m.Filename = string(p[16 : 16+fnLen])
+	m.SHA256 = fmtHash(p[16+fnLen : 16+fnLen+32])
+	return m, nil
+}
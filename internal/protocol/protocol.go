@@ -13,77 +13,248 @@ import (
 	"encoding/binary"
 	"errors"
 	"hash/crc32"
+	"math"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"udp/internal/config"
 )
 
 // Parâmetros do protocolo são definidos em internal/config (ChunkSize, ProtocolVersion).
 
+// Versões de cabeçalho: o byte de versão de cada datagrama indica a versão do
+// protocolo que definiu o layout daquela mensagem, não a versão negociada.
+// A v1 é o protocolo original, sem sessão (DATA de 18 bytes, REQ só com o
+// caminho); seus DATA, REQ, META, ERR, EOF e NACK continuam decodificados
+// (legacy.go) e ela é falada com quem não negocia: o servidor atende REQs v1
+// sem sessão e o cliente recorre a ela quando o HELLO fica sem resposta.
+// Mensagens inalteradas desde a v2 continuam com 2; as introduzidas ou
+// alteradas depois levam a versão nova e só são enviadas a quem a negociou
+// (HELLO/HELLOACK).
+const (
+	layoutV1 = 1 // protocolo original: sem sessão nem token
+	layoutV2 = 2 // sessão no DATA e nos controles (REQ..DONE)
+	layoutV3 = 3 // HELLO/HELLOACK; REQ e META com compressão
+)
+
+// LegacyVersion é a versão do protocolo original (v1), sem sessão: REQ,
+// META, DATA, ERR, EOF e NACK de uma transferência são associados apenas
+// pelo endereço do cliente.
+const LegacyVersion = layoutV1
+
+// CompressionVersion é a primeira versão com os campos de compressão no REQ
+// (codecs aceitos) e no META (codec e tamanho comprimido estimado).
+const CompressionVersion = layoutV3
+
+// ErrUnsupportedVersion indica um datagrama com versão de cabeçalho fora de
+// [config.MinProtocolVersion, config.ProtocolVersion].
+var ErrUnsupportedVersion = errors.New("versão de protocolo não suportada")
+
+// informa se a versão de cabeçalho v é decodificável.
+func supportedVersion(v byte) bool {
+	return v >= config.MinProtocolVersion && v <= config.ProtocolVersion
+}
+
 // DATA header layout (network byte order):
-// magic(2)='UD', version(1)=1, flags(1)=0, seq(4), total(4), size(2), crc32(4)
+// magic(2)='UD', version(1)=2, flags(1), session(4), seq(4), total(4), size(2), crc32(4)
+// v1: magic(2)='UD', version(1)=1, flags(1)=0, seq(4), total(4), size(2), crc32(4)
+//
+// Com DataFlagCompressed o payload é o segmento comprimido com o codec do
+// META (size e crc32 referem-se aos bytes comprimidos); segmentos que não
+// diminuem vão sem a flag, como antes da compressão.
+//
+// Com DataFlagParity o datagrama é um segmento de paridade FEC: seq vale
+// bloco*K + j (j-ésima paridade do bloco de N segmentos iniciado em bloco*N)
+// e o payload tem sempre chunk bytes (o último segmento do arquivo entra no
+// cálculo completado com zeros, assim como os segmentos além do total num
+// bloco final incompleto).
 var (
 	dataMagic = [2]byte{'U', 'D'} // dataMagic contém a assinatura do cabeçalho de dados
 )
 
 // representa o cabeçalho binário de um segmento de dados.
 type DataHeader struct {
+	Version int    // Version é o layout do cabeçalho (LegacyVersion = v1, sem flags nem sessão; demais = v2)
+	Flags   byte   // Flags do segmento (DataFlag*)
+	Session uint32 // Session é o identificador da sessão atribuído pelo servidor no META
 	Seq   uint32 // Seq é o índice do segmento (inicia em 0)
 	Total uint32 // Total é a quantidade total de segmentos do arquivo
 	Size  uint16 // Size é o tamanho do payload em bytes
 	CRC32 uint32 // CRC32 é o checksum do payload (IEEE)
 }
 
+// Flags do cabeçalho DATA.
+const (
+	DataFlagParity     = 0x01 // segmento de paridade FEC
+	DataFlagCompressed = 0x02 // payload comprimido com o codec do META
+)
+
 // define o tamanho em bytes do cabeçalho binário.
-const dataHeaderSize = 2 + 1 + 1 + 4 + 4 + 2 + 4
+const dataHeaderSize = 2 + 1 + 1 + 4 + 4 + 4 + 2 + 4
 
 // Serializa um DataHeader para o formato binário de rede (big-endian).
 func PackHeader(h DataHeader) []byte {
+	if h.Version == LegacyVersion { return packHeaderV1(h) }
 	buf := make([]byte, dataHeaderSize) // buf armazena o cabeçalho serializado
 	// magic
 	buf[0] = dataMagic[0]
 	buf[1] = dataMagic[1]
-	// version
-	buf[2] = byte(config.ProtocolVersion)
+	// version (layout do DATA inalterado desde a v2, que acrescentou a sessão)
+	buf[2] = layoutV2
 	// flags
-	buf[3] = 0
-	binary.BigEndian.PutUint32(buf[4:8], h.Seq)
-	binary.BigEndian.PutUint32(buf[8:12], h.Total)
-	binary.BigEndian.PutUint16(buf[12:14], h.Size)
-	binary.BigEndian.PutUint32(buf[14:18], h.CRC32)
+	buf[3] = h.Flags
+	binary.BigEndian.PutUint32(buf[4:8], h.Session)
+	binary.BigEndian.PutUint32(buf[8:12], h.Seq)
+	binary.BigEndian.PutUint32(buf[12:16], h.Total)
+	binary.BigEndian.PutUint16(buf[16:18], h.Size)
+	binary.BigEndian.PutUint32(buf[18:22], h.CRC32)
 	return buf
 }
 
 // Desserializa o cabeçalho binário em um DataHeader.
 func UnpackHeader(b []byte) (DataHeader, error) {
-	if len(b) < dataHeaderSize {
+	if len(b) < dataHeaderSizeV1 {
 		return DataHeader{}, errors.New("buffer curto para header")
 	}
-	if b[0] != dataMagic[0] || b[1] != dataMagic[1] || b[2] != byte(config.ProtocolVersion) {
+	if b[0] != dataMagic[0] || b[1] != dataMagic[1] {
 		return DataHeader{}, errors.New("header inválido")
 	}
-	h := DataHeader{}                                // h recebe campos extraídos
-	h.Seq = binary.BigEndian.Uint32(b[4:8])         // sequência
-	h.Total = binary.BigEndian.Uint32(b[8:12])      // total de segmentos
-	h.Size = binary.BigEndian.Uint16(b[12:14])      // tamanho do payload
-	h.CRC32 = binary.BigEndian.Uint32(b[14:18])     // checksum CRC32 do payload
+	if !supportedVersion(b[2]) { return DataHeader{}, ErrUnsupportedVersion }
+	if b[2] == layoutV1 { return unpackHeaderV1(b), nil }
+	if len(b) < dataHeaderSize {
+		return DataHeader{}, errors.New("buffer curto para header")
+	}
+	h := DataHeader{Version: int(b[2]), Flags: b[3]} // h recebe campos extraídos
+	h.Session = binary.BigEndian.Uint32(b[4:8])     // sessão
+	h.Seq = binary.BigEndian.Uint32(b[8:12])        // sequência
+	h.Total = binary.BigEndian.Uint32(b[12:16])     // total de segmentos
+	h.Size = binary.BigEndian.Uint16(b[16:18])      // tamanho do payload
+	h.CRC32 = binary.BigEndian.Uint32(b[18:22])     // checksum CRC32 do payload
 	return h, nil
 }
 
-// Retorna o tamanho em bytes do cabeçalho DATA.
+// Retorna o tamanho em bytes do cabeçalho DATA (layout atual; o de um
+// cabeçalho recebido é h.Len()).
 func HeaderSize() int { return dataHeaderSize }
 
+// Len retorna o tamanho em bytes do cabeçalho h no datagrama; o payload
+// começa logo após ele.
+func (h DataHeader) Len() int {
+	if h.Version == LegacyVersion { return dataHeaderSizeV1 }
+	return dataHeaderSize
+}
+
+// NegotiateChunk escolhe o tamanho de segmento para um cliente que aceita
+// datagramas de até maxDatagram bytes (0 = config.ChunkSize), limitado a
+// [config.MinChunkSize, serverMax].
+func NegotiateChunk(maxDatagram, serverMax int) int {
+	if maxDatagram <= 0 { return min(config.ChunkSize, serverMax) }
+	chunk := min(maxDatagram-dataHeaderSize, serverMax, config.MaxChunkSize)
+	return max(chunk, config.MinChunkSize)
+}
+
 // Controle binário:
-// Header UC v1 (big-endian): magic(2)='UC', version(1)=1, type(1), length(2), payload(variable)
-// type: 1=REQ, 2=META, 3=ERR, 4=EOF, 5=NACK
+// Header UC (big-endian): magic(2)='UC', version(1), type(1), length(2), payload(variable)
+// v1 (version 1, sem sessão nem token; só REQ..NACK são aceitos): REQ = path;
+// META = total(u32) | size(u64) | chunk(u16) | fnLen(u16) | filename | sha256(32);
+// ERR = code(u16) | msgLen(u16) | msg; EOF vazio; NACK = count(u16) | count * seq(u32)
+// type: 1=REQ, 2=META, 3=ERR, 4=EOF, 5=NACK, 6=LIST, 7=LST, 8=FBK, 9=PROBE, 10=PROBEACK, 11=PUT, 12=DONE (version 2);
+// 13=HELLO, 14=HELLOACK (version 3); REQ e META também têm layout version 3, com compressão
 // Payloads:
-// - REQ: path UTF-8 (length bytes)
-// - META: total(u32) | size(u64) | chunk(u16) | fnLen(u16) | filename(fnLen) | sha256(32 bytes)
-// - ERR: code(u16=1) | msgLen(u16) | msg(msgLen)
-// - EOF: empty
-// - NACK: count(u16) | count * seq(u32)
+// - REQ: token(u32) | flags(u8) | maxDatagram(u16) | fecData(u8) | fecParity(u8) | [v3: codecs(u8, CodecSet)] | path UTF-8 (restante)
+// - META: session(u32) | token(u32) | total(u32) | size(u64) | chunk(u16) | fecData(u8) | fecParity(u8) | [v3: codec(u8) | estimatedCompressedSize(u64)] | fnLen(u16) | filename(fnLen) | sha256(32 bytes)
+// - ERR: code(u16, ErrCode*) | token(u32) | msgLen(u16) | msg(msgLen) | [retryAfter(u32, ms), só ErrCodeBusy]
+// - EOF: session(u32)
+// - NACK: session(u32) | count(u16) | count * seq(u32)
+// - FBK: session(u32) | received(u32) | lost(u32) | highest(u32) | echoSeq(u32) | delayMicros(u32) | recovered(u32)
+// - PROBE: token(u32) | size(u16) | preenchimento
+// - PROBEACK: token(u32) | size(u16) | preenchimento até o datagrama ter size bytes
+// - PUT: token(u32) | size(u64) | maxDatagram(u16) | nameLen(u16) | name(nameLen) | sha256(32 bytes)
+// - DONE: session(u32) | status(u8) | msgLen(u16) | msg(msgLen)
+// - LIST: token(u32) | flags(u8) | pageSize(u16) | pathLen(u16) | path(pathLen) | cursorLen(u16) | cursor(cursorLen)
+// - LST: token(u32) | count(u16) | count * entrada | nextLen(u16) | next(nextLen)
+//   entrada: kind(u8) | eflags(u8) | size(u64) | mtime(i64, ns Unix) | nameLen(u16) | name(nameLen) | [sha256(32 bytes) se eflags&0x01]
+// - HELLO: token(u32) | minVersion(u8) | maxVersion(u8) | features(u32, Feature*)
+// - HELLOACK: token(u32) | version(u8) | features(u32, Feature*)
+//
+// O token é escolhido pelo cliente a cada REQ e ecoado no META/ERR, permitindo
+// associar a resposta ao pedido quando um mesmo socket faz vários REQs. A sessão
+// é atribuída pelo servidor no META e identifica a transferência em DATA/EOF/NACK,
+// independentemente do endereço de origem do cliente.
+//
+// Flags do REQ: bit 0 (ReqFlagResume) pede apenas o META; o servidor não faz
+// o envio inicial nem manda EOF e o cliente solicita por NACK somente os
+// segmentos que ainda não possui (retomada de download).
+//
+// Tamanho de segmento: o cliente informa no REQ o maior datagrama que aceita
+// receber (maxDatagram, 0 = padrão do servidor) e o servidor escolhe o chunk
+// com NegotiateChunk, informando-o no META.
+//
+// FEC: o cliente pode pedir no REQ fecData (N) e fecParity (K) para receber,
+// no envio inicial, K segmentos de paridade Reed–Solomon a cada N de dados;
+// o servidor confirma (ou ajusta/desliga, com 0) no META. Retransmissões por
+// NACK não levam paridade.
+//
+// PROBE/PROBEACK descobrem o MTU do caminho servidor→cliente: o cliente pede
+// respostas de vários tamanhos e o servidor as envia com DF (não fragmentar);
+// o maior PROBEACK que chega define o maxDatagram do REQ. Para não servir de
+// amplificador, o servidor só responde se size <= MaxProbeAmplification vezes
+// o tamanho do PROBE recebido.
+//
+// FBK é o feedback periódico do cliente para o controle de congestionamento:
+// contadores cumulativos de datagramas DATA recebidos e perdidos (lacunas de
+// sequência), maior sequência vista e o último segmento recebido com o tempo
+// decorrido desde sua chegada, para o servidor estimar o RTT.
+//
+// PUT (envio cliente→servidor) inverte os papéis: o cliente anuncia nome,
+// tamanho e SHA-256 do arquivo e o maior datagrama que o servidor pode
+// receber; o servidor recusa com ERR (sem diretório de upload, nome inválido,
+// arquivo existente, cota) ou aceita com um META que atribui a sessão e o
+// chunk. O cliente então envia DATA e EOF, o servidor responde com FBK e NACK
+// e, com todos os segmentos gravados e o SHA-256 conferido, confirma com
+// DONE (status 0) ou informa a falha (status DoneFailed e mensagem).
+//
+// LIST pede o conteúdo de um subdiretório do diretório base (path vazio = a
+// raiz), de um nível ou, com ListFlagRecursive, de toda a árvore abaixo dele.
+// O LST ecoa o token e traz, em ordem lexical de caminho, uma entrada por
+// arquivo ou diretório com o caminho relativo ao diretório base (separado por
+// '/'), tamanho, mtime e, se já estiver em cache no servidor, o SHA-256.
+// A listagem é paginada: cada LST leva até pageSize entradas (0 = quantas
+// couberem em MaxLstSize) e, se houver mais, o token de continuação next,
+// que o cliente devolve como cursor no LIST seguinte; next vazio encerra a
+// listagem. O servidor não guarda estado entre as páginas: o cursor é o
+// caminho da última entrada enviada e a página seguinte começa logo após
+// ele na ordem do percurso. Falhas (caminho inválido ou inexistente) vêm em ERR.
+// Como o PROBE, o LIST é preenchido (até MinListSize) para que o LST não
+// passe de MaxProbeAmplification vezes o pedido; um LIST menor recebe uma
+// página menor, ou ERR se nem uma entrada couber.
+//
+// O código do ERR (ErrCode*) classifica a falha para o cliente decidir se
+// repete o pedido (ErrCode.Temporary) sem depender da mensagem, que é apenas
+// descritiva. Servidores anteriores ao registro enviam sempre ErrCodeGeneric.
+// Recusas por capacidade (ErrCodeBusy, "BUSY") trazem após a mensagem a espera
+// sugerida em ms, que o cliente usa como piso do backoff antes de repetir.
+//
+// HELLO negocia a versão e os recursos antes dos pedidos: o cliente informa
+// o intervalo de versões que fala e os recursos (Feature*) que suporta; o
+// servidor responde com HELLOACK trazendo a maior versão em comum e a
+// interseção dos recursos, ou com ERR ErrCodeUnsupportedVersion se os
+// intervalos não se cruzam. Servidores v1 descartam o HELLO (versão de
+// cabeçalho desconhecida); sem HELLOACK o cliente segue na v1, sem recursos
+// negociáveis. O layout do HELLO/HELLOACK é fixo nas versões futuras,
+// para que a negociação seja sempre entendida.
+//
+// Compressão: um cliente que negociou a v3 com FeatureCompression envia o
+// REQ v3 com os codecs que aceita; o servidor escolhe um por arquivo (ou
+// CodecNone para formatos já comprimidos ou que não diminuem) e responde com
+// o META v3, que traz o codec e uma estimativa por amostra do total de bytes
+// de payload após a compressão (o arquivo não é comprimido por inteiro antes
+// do META, e o total real pode diferir); size e sha256 continuam descrevendo
+// o conteúdo original. Cada segmento é comprimido isoladamente, de modo que
+// NACK, retomada e FEC (cuja paridade é calculada sobre os segmentos
+// originais) seguem por segmento.
 
 const (
 	TypeREQ  = "REQ"
@@ -93,8 +264,172 @@ const (
 	TypeNACK = "NACK"
 	TypeLIST = "LIST" // pedido de listagem de arquivos
 	TypeLST  = "LST"  // resposta com lista de arquivos
+	TypeFBK  = "FBK"  // feedback do receptor (controle de congestionamento)
+	TypePROBE    = "PROBE"    // sonda de MTU do caminho
+	TypePROBEACK = "PROBEACK" // resposta à sonda, com o tamanho pedido
+	TypePUT      = "PUT"      // anúncio de envio cliente→servidor
+	TypeDONE     = "DONE"     // resultado de um envio (PUT)
+	TypeHELLO    = "HELLO"    // negociação de versão e recursos
+	TypeHELLOACK = "HELLOACK" // versão e recursos escolhidos pelo servidor
+)
+
+// Flags do REQ.
+const (
+	ReqFlagResume = 0x01 // retomada: sem envio inicial, apenas NACKs
+)
+
+// Flags do LIST.
+const (
+	ListFlagRecursive = 0x01 // inclui os subdiretórios em toda a profundidade
+)
+
+// Tipos de entrada do LST.
+const (
+	EntryFile = 0
+	EntryDir  = 1
 )
 
+// flags de cada entrada do LST
+const entryFlagSHA = 0x01 // SHA-256 presente
+
+// MaxLstSize limita o datagrama LST (uma página), mantendo-o, com o envelope
+// do modo cifrado, abaixo da MTU típica.
+const MaxLstSize = 1400
+
+// Status do DONE.
+const (
+	DoneOK     = 0 // arquivo gravado e SHA-256 conferido
+	DoneFailed = 1 // envio não concluído (ver mensagem)
+)
+
+// ErrCode é o código de falha do ERR.
+type ErrCode uint16
+
+// Códigos do ERR.
+const (
+	ErrCodeGeneric            ErrCode = 1 // sem classificação (servidores anteriores ao registro)
+	ErrCodeNotFound           ErrCode = 2 // arquivo ou diretório inexistente
+	ErrCodeIsDirectory        ErrCode = 3 // REQ de um diretório
+	ErrCodePermission         ErrCode = 4 // sem permissão no servidor (inclui upload desabilitado)
+	ErrCodePathRejected       ErrCode = 5 // caminho inválido, fora do diretório base, não diretório ou LIST curto demais (LIST) ou destino existente (PUT)
+	ErrCodeBusy               ErrCode = 6 // limite de clientes atingido ou servidor encerrando
+	ErrCodeUnsupportedVersion ErrCode = 7 // versão de protocolo não suportada
+	ErrCodeQuotaExceeded      ErrCode = 8 // cota de upload excedida, disco cheio ou arquivo grande demais
+	ErrCodeInternal           ErrCode = 9 // falha de E/S ou interna do servidor
+)
+
+// String descreve o código.
+func (c ErrCode) String() string {
+	switch c {
+	case ErrCodeGeneric:
+		return "erro"
+	case ErrCodeNotFound:
+		return "arquivo não encontrado"
+	case ErrCodeIsDirectory:
+		return "é um diretório"
+	case ErrCodePermission:
+		return "permissão negada"
+	case ErrCodePathRejected:
+		return "caminho recusado"
+	case ErrCodeBusy:
+		return "servidor ocupado"
+	case ErrCodeUnsupportedVersion:
+		return "versão de protocolo não suportada"
+	case ErrCodeQuotaExceeded:
+		return "cota excedida"
+	case ErrCodeInternal:
+		return "erro interno do servidor"
+	}
+	return "código " + strconv.Itoa(int(c))
+}
+
+// Temporary informa se o mesmo pedido pode ser atendido se repetido mais
+// tarde (servidor ocupado ou falha interna); com os demais, repetir o pedido
+// falharia de novo.
+func (c ErrCode) Temporary() bool { return c == ErrCodeBusy || c == ErrCodeInternal }
+
+// Features são os bits de recursos opcionais anunciados no HELLO/HELLOACK.
+type Features uint32
+
+// Recursos negociáveis.
+const (
+	FeatureFEC         Features = 1 << 0 // paridade Reed–Solomon no envio inicial
+	FeatureEncryption  Features = 1 << 1 // modo cifrado (PSK) ativo na conexão
+	FeatureCompression Features = 1 << 2 // compressão do conteúdo do arquivo
+	FeatureLargeChunks Features = 1 << 3 // segmentos acima de config.ChunkSize (maxDatagram/PROBE)
+)
+
+// Has informa se todos os recursos de f2 estão em f.
+func (f Features) Has(f2 Features) bool { return f&f2 == f2 }
+
+// String lista os recursos separados por vírgula ("-" se nenhum).
+func (f Features) String() string {
+	var names []string
+	for _, n := range []struct {
+		f    Features
+		name string
+	}{{FeatureFEC, "fec"}, {FeatureEncryption, "cifra"}, {FeatureCompression, "compressão"}, {FeatureLargeChunks, "chunks-grandes"}} {
+		if f.Has(n.f) { names = append(names, n.name) }
+	}
+	if len(names) == 0 { return "-" }
+	return strings.Join(names, ",")
+}
+
+// NegotiateVersion escolhe a maior versão em comum entre [minV, maxV] e as
+// versões suportadas por este pacote; ok é false se não houver nenhuma.
+func NegotiateVersion(minV, maxV int) (v int, ok bool) {
+	v = min(maxV, config.ProtocolVersion)
+	return v, v >= max(minV, config.MinProtocolVersion)
+}
+
+// Codec identifica um algoritmo de compressão dos segmentos.
+type Codec uint8
+
+// Codecs de compressão.
+const (
+	CodecNone    Codec = 0 // segmentos sem compressão
+	CodecDeflate Codec = 1 // DEFLATE (RFC 1951) por segmento
+	CodecGzip    Codec = 2 // gzip (RFC 1952) por segmento
+)
+
+// String retorna o nome do codec.
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecDeflate:
+		return "deflate"
+	case CodecGzip:
+		return "gzip"
+	}
+	return "codec " + strconv.Itoa(int(c))
+}
+
+// CodecSet é um conjunto de codecs (bit 1<<Codec), anunciado no REQ v3.
+type CodecSet uint8
+
+// Codecs monta o conjunto com os codecs cs.
+func Codecs(cs ...Codec) CodecSet {
+	var s CodecSet
+	for _, c := range cs { s |= 1 << c }
+	return s
+}
+
+// Has informa se c está no conjunto.
+func (s CodecSet) Has(c Codec) bool { return c < 8 && s&(1<<c) != 0 }
+
+// MaxProbeAmplification limita a razão entre a resposta (PROBEACK, LST) e o
+// pedido (PROBE, LIST) que a originou.
+const MaxProbeAmplification = 3
+
+// MinListSize é o tamanho a que o cliente preenche o LIST para receber um LST
+// de até MaxLstSize bytes sem exceder MaxProbeAmplification.
+const MinListSize = (MaxLstSize + MaxProbeAmplification - 1) / MaxProbeAmplification
+
+// MaxNackEntries limita as sequências por datagrama NACK, mantendo-o abaixo
+// da MTU típica; listas maiores são enviadas em vários NACKs.
+const MaxNackEntries = 256
+
 const (
 	ctrlMagic0 = 'U'
 	ctrlMagic1 = 'C'
@@ -108,71 +443,242 @@ const (
 	ctrlTypeNACK = 5
 	ctrlTypeLIST = 6
 	ctrlTypeLST  = 7
+	ctrlTypeFBK  = 8
+	ctrlTypePROBE    = 9
+	ctrlTypePROBEACK = 10
+	ctrlTypePUT      = 11
+	ctrlTypeDONE     = 12
+	ctrlTypeHELLO    = 13
+	ctrlTypeHELLOACK = 14
 )
 
-type Req struct { Path string }
+type Req struct {
+	Token       uint32 // Token identifica o pedido no cliente (ecoado no META/ERR)
+	Flags       byte   // Flags do pedido (ReqFlag*)
+	MaxDatagram int    // MaxDatagram é o maior datagrama DATA aceito (0 = padrão do servidor)
+	FECData     int    // FECData é o N pedido para FEC (0 = sem FEC)
+	FECParity   int    // FECParity é o K pedido para FEC
+	Codecs      CodecSet // Codecs aceitos para o arquivo (só no layout v3)
+	Path        string
+	Version     int    // Version é o layout do REQ (LegacyVersion só com Path; 3 com Codecs; 0 = 2)
+}
 
 type Meta struct {
+	Session  uint32 // Session é o identificador atribuído pelo servidor
+	Token    uint32 // Token é o token do REQ que originou a sessão
 	Filename string
 	Total    uint32
 	Size     int64
 	SHA256   string // 64 hex chars; empacotado/decodificado como 32 bytes binários
 	Chunk    int
+	FECData   int // FECData é o N de segmentos de dados por bloco FEC (0 = sem FEC)
+	FECParity int // FECParity é o K de segmentos de paridade por bloco FEC
+	Codec                   Codec // Codec dos segmentos DATA com DataFlagCompressed (só no layout v3)
+	EstimatedCompressedSize int64 // EstimatedCompressedSize é a estimativa por amostra do total de bytes de payload após a compressão (= Size sem compressão); só informativo, o total real pode diferir
+	Version                 int   // Version é o layout do META (LegacyVersion sem sessão, token e FEC; 3 com Codec; 0 = 2), igual ao do REQ
+}
+
+type ErrMsg struct {
+	Code    ErrCode // Código do erro (ErrCode*)
+	Token   uint32 // Token do REQ ao qual o erro se refere (0 se não aplicável)
+	Message string
+	RetryAfter time.Duration // RetryAfter é a espera sugerida antes de repetir o pedido (ErrCodeBusy; 0 = não informada)
+}
+
+type EOFMsg struct { Session uint32 }
+
+type Nack struct {
+	Session uint32
+	Missing []uint32
+}
+
+// Feedback é o relatório periódico do receptor sobre a sessão.
+type Feedback struct {
+	Session     uint32
+	Received    uint32 // Received conta os datagramas DATA recebidos (cumulativo)
+	Lost        uint32 // Lost conta os datagramas dados como perdidos (cumulativo)
+	Highest     uint32 // Highest é a maior sequência recebida
+	EchoSeq     uint32 // EchoSeq é o último segmento recebido
+	DelayMicros uint32 // DelayMicros é o tempo entre receber EchoSeq e enviar o FBK
+	Recovered   uint32 // Recovered conta os segmentos reconstruídos por FEC (cumulativo)
 }
 
-type ErrMsg struct { Message string }
+// Probe pede ao servidor um PROBEACK de Size bytes.
+type Probe struct {
+	Token uint32 // Token identifica a sonda no cliente
+	Size  int    // Size é o tamanho total do PROBEACK pedido
+}
 
-type EOFMsg struct{}
+// Put anuncia um arquivo que o cliente quer enviar ao servidor.
+type Put struct {
+	Token       uint32 // Token identifica o pedido no cliente (ecoado no META/ERR)
+	Size        int64  // Size é o tamanho do arquivo em bytes
+	MaxDatagram int    // MaxDatagram é o maior datagrama DATA a enviar (0 = padrão do servidor)
+	Name        string // Name é o nome do arquivo no diretório de upload
+	SHA256      string // 64 hex chars; empacotado/decodificado como 32 bytes binários
+}
 
-type Nack struct { Missing []uint32 }
+// Done informa ao cliente o resultado de um envio.
+type Done struct {
+	Session uint32
+	Status  byte   // Status é DoneOK ou DoneFailed
+	Message string // Message descreve a falha (vazia em DoneOK)
+}
+
+// List pede uma página da listagem de um diretório do servidor.
+type List struct {
+	Token    uint32 // Token identifica o pedido no cliente (ecoado no LST/ERR)
+	Flags    byte   // Flags do pedido (ListFlag*)
+	PageSize int    // PageSize é o máximo de entradas na página (0 = quantas couberem)
+	Path     string // Path é o subdiretório relativo ao diretório base ("" = raiz)
+	Cursor   string // Cursor é o Next do LST anterior ("" = primeira página)
+}
+
+// Entry descreve um arquivo ou diretório do LST.
+type Entry struct {
+	Name    string    // Name é o caminho relativo ao diretório base, separado por '/'
+	Kind    byte      // Kind é EntryFile ou EntryDir
+	Size    int64     // Size é o tamanho em bytes (0 para diretórios)
+	ModTime time.Time // ModTime é a data de modificação
+	SHA256  string    // SHA256 em hex, se já calculado pelo servidor ("" caso contrário)
+}
 
-type List struct{}
+// IsDir informa se a entrada é um diretório.
+func (e Entry) IsDir() bool { return e.Kind == EntryDir }
+
+// Hello abre a negociação de versão e recursos.
+type Hello struct {
+	Token      uint32   // Token identifica a negociação no cliente (ecoado no HELLOACK/ERR)
+	MinVersion int      // MinVersion é a versão mais antiga que o cliente fala
+	MaxVersion int      // MaxVersion é a versão mais recente que o cliente fala
+	Features   Features // Features são os recursos suportados pelo cliente
+}
+
+// HelloAck é a resposta do servidor ao HELLO.
+type HelloAck struct {
+	Token    uint32   // Token do HELLO respondido
+	Version  int      // Version é a versão escolhida
+	Features Features // Features são os recursos em comum
+}
+
+// Lst é uma página da listagem, em resposta a um LIST.
+type Lst struct {
+	Token   uint32  // Token do LIST respondido
+	Entries []Entry
+	Next    string  // Next é o cursor da próxima página ("" = última página)
+}
+
+// Names retorna os caminhos das entradas que são arquivos.
+func (l Lst) Names() []string {
+	names := make([]string, 0, len(l.Entries))
+	for _, e := range l.Entries { if !e.IsDir() { names = append(names, e.Name) } }
+	return names
+}
+
+// LstEntrySize retorna quantos bytes a entrada ocupa no LST.
+func LstEntrySize(e Entry) int {
+	n := 1 + 1 + 8 + 8 + 2 + len(e.Name)
+	if e.SHA256 != "" { n += 32 }
+	return n
+}
 
-type Lst struct { Names []string } // apenas nomes (UTF-8)
+// LstHeaderSize é o tamanho do LST sem entradas e sem o token de continuação
+// (cabeçalho de controle incluído).
+const LstHeaderSize = ctrlHeaderSize + 4 + 2 + 2
+
+// tamanho do cabeçalho de controle
+const ctrlHeaderSize = 2 + 1 + 1 + 2
+
+// versão do layout de cada tipo de controle (ausente = layoutV2).
+var ctrlLayout = map[byte]byte{ctrlTypeHELLO: layoutV3, ctrlTypeHELLOACK: layoutV3}
 
 func ctrlHeader(t byte, payloadLen int) []byte {
-	b := make([]byte, 2+1+1+2)
-	b[0] = ctrlMagic0; b[1] = ctrlMagic1; b[2] = byte(config.ProtocolVersion); b[3] = t
+	return ctrlHeaderV(t, max(ctrlLayout[t], layoutV2), payloadLen)
+}
+
+// cabeçalho de controle com o layout version (tipos com mais de um layout).
+func ctrlHeaderV(t, version byte, payloadLen int) []byte {
+	b := make([]byte, ctrlHeaderSize)
+	b[0] = ctrlMagic0; b[1] = ctrlMagic1; b[2] = version; b[3] = t
 	binary.BigEndian.PutUint16(b[4:6], uint16(payloadLen))
 	return b
 }
 
-func packREQ(path string) []byte {
-	p := []byte(path)
-	h := ctrlHeader(ctrlTypeREQ, len(p))
-	return append(h, p...)
+func packREQ(r Req) []byte {
+	if r.Version == LegacyVersion { return packREQv1(r.Path) }
+	p := []byte(r.Path)
+	v3 := r.Version >= layoutV3
+	off := 9
+	if v3 { off++ }
+	payload := make([]byte, off+len(p))
+	binary.BigEndian.PutUint32(payload[0:4], r.Token)
+	payload[4] = r.Flags
+	binary.BigEndian.PutUint16(payload[5:7], uint16(r.MaxDatagram))
+	payload[7], payload[8] = byte(r.FECData), byte(r.FECParity)
+	if v3 { payload[9] = byte(r.Codecs) }
+	copy(payload[off:], p)
+	h := ctrlHeaderV(ctrlTypeREQ, layoutOf(r.Version), len(payload))
+	return append(h, payload...)
 }
 
 func packMETA(m Meta) []byte {
+	if m.Version == LegacyVersion { return packMETAv1(m) }
 	fn := []byte(m.Filename)
 	sha := parseHexSha(m.SHA256) // 32 bytes
-	payload := make([]byte, 4+8+2+2+len(fn)+32)
-	binary.BigEndian.PutUint32(payload[0:4], m.Total)
-	binary.BigEndian.PutUint64(payload[4:12], uint64(m.Size))
-	binary.BigEndian.PutUint16(payload[12:14], uint16(m.Chunk))
-	binary.BigEndian.PutUint16(payload[14:16], uint16(len(fn)))
-	copy(payload[16:16+len(fn)], fn)
-	copy(payload[16+len(fn):], sha)
-	h := ctrlHeader(ctrlTypeMETA, len(payload))
+	v3 := m.Version >= layoutV3
+	off := 24
+	if v3 { off += 1 + 8 }
+	payload := make([]byte, off+2+len(fn)+32)
+	binary.BigEndian.PutUint32(payload[0:4], m.Session)
+	binary.BigEndian.PutUint32(payload[4:8], m.Token)
+	binary.BigEndian.PutUint32(payload[8:12], m.Total)
+	binary.BigEndian.PutUint64(payload[12:20], uint64(m.Size))
+	binary.BigEndian.PutUint16(payload[20:22], uint16(m.Chunk))
+	payload[22], payload[23] = byte(m.FECData), byte(m.FECParity)
+	if v3 {
+		payload[24] = byte(m.Codec)
+		binary.BigEndian.PutUint64(payload[25:33], uint64(m.EstimatedCompressedSize))
+	}
+	binary.BigEndian.PutUint16(payload[off:off+2], uint16(len(fn)))
+	copy(payload[off+2:off+2+len(fn)], fn)
+	copy(payload[off+2+len(fn):], sha)
+	h := ctrlHeaderV(ctrlTypeMETA, layoutOf(m.Version), len(payload))
 	return append(h, payload...)
 }
 
-func packERR(msg string) []byte {
-	b := []byte(msg)
-	payload := make([]byte, 2+2+len(b))
-	binary.BigEndian.PutUint16(payload[0:2], 1)
-	binary.BigEndian.PutUint16(payload[2:4], uint16(len(b)))
-	copy(payload[4:], b)
+// layout de REQ/META para a versão negociada v (0 = v2).
+func layoutOf(v int) byte {
+	if v >= layoutV3 { return layoutV3 }
+	return layoutV2
+}
+
+func packERR(e ErrMsg) []byte {
+	b := []byte(e.Message)
+	payload := make([]byte, 2+4+2+len(b))
+	binary.BigEndian.PutUint16(payload[0:2], uint16(e.Code))
+	binary.BigEndian.PutUint32(payload[2:6], e.Token)
+	binary.BigEndian.PutUint16(payload[6:8], uint16(len(b)))
+	copy(payload[8:], b)
+	if e.RetryAfter > 0 {
+		// sufixo opcional: decodificadores anteriores ignoram bytes após msg
+		payload = binary.BigEndian.AppendUint32(payload, uint32(min(e.RetryAfter.Milliseconds(), math.MaxUint32)))
+	}
 	h := ctrlHeader(ctrlTypeERR, len(payload))
 	return append(h, payload...)
 }
 
-func packEOF() []byte { return ctrlHeader(ctrlTypeEOF, 0) }
+func packEOF(session uint32) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload[0:4], session)
+	h := ctrlHeader(ctrlTypeEOF, len(payload))
+	return append(h, payload...)
+}
 
-func packNACK(missing []uint32) []byte {
-	payload := make([]byte, 2+4*len(missing))
-	binary.BigEndian.PutUint16(payload[0:2], uint16(len(missing)))
-	off := 2
+func packNACK(session uint32, missing []uint32) []byte {
+	payload := make([]byte, 4+2+4*len(missing))
+	binary.BigEndian.PutUint32(payload[0:4], session)
+	binary.BigEndian.PutUint16(payload[4:6], uint16(len(missing)))
+	off := 6
 	for _, s := range missing {
 		binary.BigEndian.PutUint32(payload[off:off+4], s); off += 4
 	}
@@ -180,117 +686,351 @@ func packNACK(missing []uint32) []byte {
 	return append(h, payload...)
 }
 
-func packLIST() []byte { return ctrlHeader(ctrlTypeLIST, 0) }
+func packFBK(f Feedback) []byte {
+	payload := make([]byte, 7*4)
+	binary.BigEndian.PutUint32(payload[0:4], f.Session)
+	binary.BigEndian.PutUint32(payload[4:8], f.Received)
+	binary.BigEndian.PutUint32(payload[8:12], f.Lost)
+	binary.BigEndian.PutUint32(payload[12:16], f.Highest)
+	binary.BigEndian.PutUint32(payload[16:20], f.EchoSeq)
+	binary.BigEndian.PutUint32(payload[20:24], f.DelayMicros)
+	binary.BigEndian.PutUint32(payload[24:28], f.Recovered)
+	h := ctrlHeader(ctrlTypeFBK, len(payload))
+	return append(h, payload...)
+}
+
+// PROBE (pad bytes de preenchimento) e PROBEACK (preenchido até size bytes) compartilham o layout.
+func packProbe(t byte, token uint32, size, total int) []byte {
+	plen := max(total-ctrlHeaderSize, 6)
+	payload := make([]byte, plen)
+	binary.BigEndian.PutUint32(payload[0:4], token)
+	binary.BigEndian.PutUint16(payload[4:6], uint16(size))
+	h := ctrlHeader(t, len(payload))
+	return append(h, payload...)
+}
+
+func packPUT(p Put) []byte {
+	name := []byte(p.Name)
+	payload := make([]byte, 4+8+2+2+len(name)+32)
+	binary.BigEndian.PutUint32(payload[0:4], p.Token)
+	binary.BigEndian.PutUint64(payload[4:12], uint64(p.Size))
+	binary.BigEndian.PutUint16(payload[12:14], uint16(p.MaxDatagram))
+	binary.BigEndian.PutUint16(payload[14:16], uint16(len(name)))
+	copy(payload[16:16+len(name)], name)
+	copy(payload[16+len(name):], parseHexSha(p.SHA256))
+	h := ctrlHeader(ctrlTypePUT, len(payload))
+	return append(h, payload...)
+}
+
+func packDONE(d Done) []byte {
+	msg := []byte(d.Message)
+	payload := make([]byte, 4+1+2+len(msg))
+	binary.BigEndian.PutUint32(payload[0:4], d.Session)
+	payload[4] = d.Status
+	binary.BigEndian.PutUint16(payload[5:7], uint16(len(msg)))
+	copy(payload[7:], msg)
+	h := ctrlHeader(ctrlTypeDONE, len(payload))
+	return append(h, payload...)
+}
+
+func packLIST(l List) []byte {
+	// preenchido com zeros até MinListSize; decodificadores ignoram o excesso
+	payload := make([]byte, max(4+1+2+2+len(l.Path)+2+len(l.Cursor), MinListSize-ctrlHeaderSize))
+	binary.BigEndian.PutUint32(payload[0:4], l.Token)
+	payload[4] = l.Flags
+	binary.BigEndian.PutUint16(payload[5:7], uint16(l.PageSize))
+	binary.BigEndian.PutUint16(payload[7:9], uint16(len(l.Path)))
+	off := 9 + copy(payload[9:], l.Path)
+	binary.BigEndian.PutUint16(payload[off:off+2], uint16(len(l.Cursor)))
+	copy(payload[off+2:], l.Cursor)
+	h := ctrlHeader(ctrlTypeLIST, len(payload))
+	return append(h, payload...)
+}
 
-func packLST(names []string) []byte {
-	count := len(names)
-	plen := 2
-	for _, n := range names { plen += 2 + len([]byte(n)) }
+func packLST(l Lst) []byte {
+	plen := 4 + 2 + 2 + len(l.Next)
+	for _, e := range l.Entries { plen += LstEntrySize(e) }
 	payload := make([]byte, plen)
-	binary.BigEndian.PutUint16(payload[0:2], uint16(count))
-	off := 2
-	for _, n := range names {
-		b := []byte(n)
-		binary.BigEndian.PutUint16(payload[off:off+2], uint16(len(b)))
-		off += 2
-		copy(payload[off:off+len(b)], b)
-		off += len(b)
+	binary.BigEndian.PutUint32(payload[0:4], l.Token)
+	binary.BigEndian.PutUint16(payload[4:6], uint16(len(l.Entries)))
+	off := 6
+	for _, e := range l.Entries {
+		payload[off] = e.Kind
+		if e.SHA256 != "" { payload[off+1] |= entryFlagSHA }
+		binary.BigEndian.PutUint64(payload[off+2:off+10], uint64(e.Size))
+		var mtime int64
+		if !e.ModTime.IsZero() { mtime = e.ModTime.UnixNano() }
+		binary.BigEndian.PutUint64(payload[off+10:off+18], uint64(mtime))
+		binary.BigEndian.PutUint16(payload[off+18:off+20], uint16(len(e.Name)))
+		off += 20
+		off += copy(payload[off:], e.Name)
+		if e.SHA256 != "" { off += copy(payload[off:], parseHexSha(e.SHA256)) }
 	}
+	binary.BigEndian.PutUint16(payload[off:off+2], uint16(len(l.Next)))
+	copy(payload[off+2:], l.Next)
 	h := ctrlHeader(ctrlTypeLST, len(payload))
 	return append(h, payload...)
 }
 
-func parseCtrl(b []byte) (t byte, payload []byte, err error) {
-	if len(b) < 6 || b[0] != ctrlMagic0 || b[1] != ctrlMagic1 || b[2] != byte(config.ProtocolVersion) {
-		return 0, nil, errors.New("ctrl header inválido")
+func packHELLO(h Hello) []byte {
+	payload := make([]byte, 4+1+1+4)
+	binary.BigEndian.PutUint32(payload[0:4], h.Token)
+	payload[4], payload[5] = byte(h.MinVersion), byte(h.MaxVersion)
+	binary.BigEndian.PutUint32(payload[6:10], uint32(h.Features))
+	return append(ctrlHeader(ctrlTypeHELLO, len(payload)), payload...)
+}
+
+func packHELLOACK(a HelloAck) []byte {
+	payload := make([]byte, 4+1+4)
+	binary.BigEndian.PutUint32(payload[0:4], a.Token)
+	payload[4] = byte(a.Version)
+	binary.BigEndian.PutUint32(payload[5:9], uint32(a.Features))
+	return append(ctrlHeader(ctrlTypeHELLOACK, len(payload)), payload...)
+}
+
+func parseCtrl(b []byte) (t, version byte, payload []byte, err error) {
+	if len(b) < 6 || b[0] != ctrlMagic0 || b[1] != ctrlMagic1 {
+		return 0, 0, nil, errors.New("ctrl header inválido")
 	}
+	if !supportedVersion(b[2]) { return 0, 0, nil, ErrUnsupportedVersion }
 	t = b[3]
+	// um tipo só existe a partir da versão que o definiu; da v1 só REQ..NACK
+	since := max(ctrlLayout[t], layoutV2)
+	if t <= ctrlTypeNACK { since = layoutV1 }
+	if b[2] < since { return 0, 0, nil, errors.New("ctrl header inválido") }
 	l := int(binary.BigEndian.Uint16(b[4:6]))
-	if len(b) < 6+l { return 0, nil, errors.New("ctrl payload curto") }
-	return t, b[6 : 6+l], nil
-}
-
-func unpackREQ(p []byte) (Req, error) { return Req{Path: string(p)}, nil }
-
-func unpackMETA(p []byte) (Meta, error) {
-	if len(p) < 4+8+2+2+32 { return Meta{}, errors.New("META curto") }
-	m := Meta{}
-	m.Total = binary.BigEndian.Uint32(p[0:4])
-	m.Size = int64(binary.BigEndian.Uint64(p[4:12]))
-	m.Chunk = int(binary.BigEndian.Uint16(p[12:14]))
-	fnLen := int(binary.BigEndian.Uint16(p[14:16]))
-	if len(p) < 16+fnLen+32 { return Meta{}, errors.New("META curto 2") }
-	m.Filename = string(p[16 : 16+fnLen])
-	m.SHA256 = fmtHash(p[16+fnLen : 16+fnLen+32])
+	if len(b) < 6+l { return 0, 0, nil, errors.New("ctrl payload curto") }
+	return t, b[2], b[6 : 6+l], nil
+}
+
+func unpackREQ(p []byte, version byte) (Req, error) {
+	off := 9
+	if version >= layoutV3 { off++ }
+	if len(p) < off { return Req{}, errors.New("REQ curto") }
+	r := Req{Token: binary.BigEndian.Uint32(p[0:4]), Flags: p[4], MaxDatagram: int(binary.BigEndian.Uint16(p[5:7])),
+		FECData: int(p[7]), FECParity: int(p[8]), Path: string(p[off:]), Version: int(version)}
+	if version >= layoutV3 { r.Codecs = CodecSet(p[9]) }
+	return r, nil
+}
+
+func unpackMETA(p []byte, version byte) (Meta, error) {
+	off := 24
+	if version >= layoutV3 { off += 1 + 8 }
+	if len(p) < off+2+32 { return Meta{}, errors.New("META curto") }
+	m := Meta{Version: int(version)}
+	m.Session = binary.BigEndian.Uint32(p[0:4])
+	m.Token = binary.BigEndian.Uint32(p[4:8])
+	m.Total = binary.BigEndian.Uint32(p[8:12])
+	m.Size = int64(binary.BigEndian.Uint64(p[12:20]))
+	m.Chunk = int(binary.BigEndian.Uint16(p[20:22]))
+	m.FECData, m.FECParity = int(p[22]), int(p[23])
+	m.EstimatedCompressedSize = m.Size
+	if version >= layoutV3 {
+		m.Codec = Codec(p[24])
+		m.EstimatedCompressedSize = int64(binary.BigEndian.Uint64(p[25:33]))
+	}
+	fnLen := int(binary.BigEndian.Uint16(p[off : off+2]))
+	off += 2
+	if len(p) < off+fnLen+32 { return Meta{}, errors.New("META curto 2") }
+	m.Filename = string(p[off : off+fnLen])
+	m.SHA256 = fmtHash(p[off+fnLen : off+fnLen+32])
 	return m, nil
 }
 
 func unpackERR(p []byte) (ErrMsg, error) {
-	if len(p) < 4 { return ErrMsg{}, errors.New("ERR curto") }
-	ml := int(binary.BigEndian.Uint16(p[2:4]))
-	if len(p) < 4+ml { return ErrMsg{}, errors.New("ERR curto 2") }
-	return ErrMsg{Message: string(p[4 : 4+ml])}, nil
+	if len(p) < 8 { return ErrMsg{}, errors.New("ERR curto") }
+	ml := int(binary.BigEndian.Uint16(p[6:8]))
+	if len(p) < 8+ml { return ErrMsg{}, errors.New("ERR curto 2") }
+	e := ErrMsg{Code: ErrCode(binary.BigEndian.Uint16(p[0:2])), Token: binary.BigEndian.Uint32(p[2:6]), Message: string(p[8 : 8+ml])}
+	if len(p) >= 8+ml+4 { e.RetryAfter = time.Duration(binary.BigEndian.Uint32(p[8+ml:])) * time.Millisecond }
+	return e, nil
 }
 
-func unpackEOF([]byte) (EOFMsg, error) { return EOFMsg{}, nil }
+func unpackEOF(p []byte) (EOFMsg, error) {
+	if len(p) < 4 { return EOFMsg{}, errors.New("EOF curto") }
+	return EOFMsg{Session: binary.BigEndian.Uint32(p[0:4])}, nil
+}
 
 func unpackNACK(p []byte) (Nack, error) {
-	if len(p) < 2 { return Nack{}, errors.New("NACK curto") }
-	n := int(binary.BigEndian.Uint16(p[0:2]))
-	if len(p) < 2+4*n { return Nack{}, errors.New("NACK curto 2") }
+	if len(p) < 6 { return Nack{}, errors.New("NACK curto") }
+	n := int(binary.BigEndian.Uint16(p[4:6]))
+	if len(p) < 6+4*n { return Nack{}, errors.New("NACK curto 2") }
 	m := make([]uint32, n)
-	off := 2
+	off := 6
 	for i := 0; i < n; i++ { m[i] = binary.BigEndian.Uint32(p[off : off+4]); off += 4 }
-	return Nack{Missing: m}, nil
+	return Nack{Session: binary.BigEndian.Uint32(p[0:4]), Missing: m}, nil
+}
+
+func unpackFBK(p []byte) (Feedback, error) {
+	if len(p) < 7*4 { return Feedback{}, errors.New("FBK curto") }
+	return Feedback{
+		Session:     binary.BigEndian.Uint32(p[0:4]),
+		Received:    binary.BigEndian.Uint32(p[4:8]),
+		Lost:        binary.BigEndian.Uint32(p[8:12]),
+		Highest:     binary.BigEndian.Uint32(p[12:16]),
+		EchoSeq:     binary.BigEndian.Uint32(p[16:20]),
+		DelayMicros: binary.BigEndian.Uint32(p[20:24]),
+		Recovered:   binary.BigEndian.Uint32(p[24:28]),
+	}, nil
+}
+
+func unpackProbe(p []byte) (Probe, error) {
+	if len(p) < 6 { return Probe{}, errors.New("PROBE curto") }
+	return Probe{Token: binary.BigEndian.Uint32(p[0:4]), Size: int(binary.BigEndian.Uint16(p[4:6]))}, nil
+}
+
+func unpackPUT(p []byte) (Put, error) {
+	if len(p) < 4+8+2+2+32 { return Put{}, errors.New("PUT curto") }
+	n := int(binary.BigEndian.Uint16(p[14:16]))
+	if len(p) < 16+n+32 { return Put{}, errors.New("PUT curto 2") }
+	return Put{Token: binary.BigEndian.Uint32(p[0:4]), Size: int64(binary.BigEndian.Uint64(p[4:12])),
+		MaxDatagram: int(binary.BigEndian.Uint16(p[12:14])), Name: string(p[16 : 16+n]), SHA256: fmtHash(p[16+n : 16+n+32])}, nil
+}
+
+func unpackDONE(p []byte) (Done, error) {
+	if len(p) < 7 { return Done{}, errors.New("DONE curto") }
+	ml := int(binary.BigEndian.Uint16(p[5:7]))
+	if len(p) < 7+ml { return Done{}, errors.New("DONE curto 2") }
+	return Done{Session: binary.BigEndian.Uint32(p[0:4]), Status: p[4], Message: string(p[7 : 7+ml])}, nil
+}
+
+func unpackLIST(p []byte) (List, error) {
+	if len(p) < 11 { return List{}, errors.New("LIST curto") }
+	n := int(binary.BigEndian.Uint16(p[7:9]))
+	if len(p) < 9+n+2 { return List{}, errors.New("LIST curto 2") }
+	l := List{Token: binary.BigEndian.Uint32(p[0:4]), Flags: p[4], PageSize: int(binary.BigEndian.Uint16(p[5:7])), Path: string(p[9 : 9+n])}
+	off := 9 + n
+	cl := int(binary.BigEndian.Uint16(p[off : off+2]))
+	if len(p) < off+2+cl { return List{}, errors.New("LIST curto 3") }
+	l.Cursor = string(p[off+2 : off+2+cl])
+	return l, nil
+}
+
+func unpackHELLO(p []byte) (Hello, error) {
+	if len(p) < 10 { return Hello{}, errors.New("HELLO curto") }
+	return Hello{Token: binary.BigEndian.Uint32(p[0:4]), MinVersion: int(p[4]), MaxVersion: int(p[5]),
+		Features: Features(binary.BigEndian.Uint32(p[6:10]))}, nil
+}
+
+func unpackHELLOACK(p []byte) (HelloAck, error) {
+	if len(p) < 9 { return HelloAck{}, errors.New("HELLOACK curto") }
+	return HelloAck{Token: binary.BigEndian.Uint32(p[0:4]), Version: int(p[4]), Features: Features(binary.BigEndian.Uint32(p[5:9]))}, nil
 }
 
 func unpackLST(p []byte) (Lst, error) {
-	if len(p) < 2 { return Lst{}, errors.New("LST curto") }
-	n := int(binary.BigEndian.Uint16(p[0:2]))
-	off := 2
-	names := make([]string, 0, n)
+	if len(p) < 6 { return Lst{}, errors.New("LST curto") }
+	l := Lst{Token: binary.BigEndian.Uint32(p[0:4])}
+	n := int(binary.BigEndian.Uint16(p[4:6]))
+	off := 6
+	l.Entries = make([]Entry, 0, n)
 	for i := 0; i < n; i++ {
-		if len(p) < off+2 { return Lst{}, errors.New("LST curto 2") }
-		l := int(binary.BigEndian.Uint16(p[off : off+2])); off += 2
-		if len(p) < off+l { return Lst{}, errors.New("LST curto 3") }
-		names = append(names, string(p[off:off+l]))
-		off += l
+		if len(p) < off+20 { return Lst{}, errors.New("LST curto 2") }
+		e := Entry{Kind: p[off], Size: int64(binary.BigEndian.Uint64(p[off+2 : off+10]))}
+		if mtime := int64(binary.BigEndian.Uint64(p[off+10 : off+18])); mtime != 0 { e.ModTime = time.Unix(0, mtime) }
+		hasSHA := p[off+1]&entryFlagSHA != 0
+		nl := int(binary.BigEndian.Uint16(p[off+18 : off+20])); off += 20
+		if len(p) < off+nl { return Lst{}, errors.New("LST curto 3") }
+		e.Name = string(p[off : off+nl]); off += nl
+		if hasSHA {
+			if len(p) < off+32 { return Lst{}, errors.New("LST curto 4") }
+			e.SHA256 = fmtHash(p[off : off+32]); off += 32
+		}
+		l.Entries = append(l.Entries, e)
 	}
-	return Lst{Names: names}, nil
+	if len(p) < off+2 { return Lst{}, errors.New("LST curto 5") }
+	nl := int(binary.BigEndian.Uint16(p[off : off+2]))
+	if len(p) < off+2+nl { return Lst{}, errors.New("LST curto 6") }
+	l.Next = string(p[off+2 : off+2+nl])
+	return l, nil
 }
 
 // Funções públicas para empacotar mensagens de controle.
-func CtrlREQ(path string) []byte              { return packREQ(path) }
-func CtrlMETA(m Meta) []byte                  { return packMETA(m) }
-func CtrlERR(msg string) []byte               { return packERR(msg) }
-func CtrlEOF() []byte                         { return packEOF() }
-func CtrlNACK(missing []uint32) []byte        { return packNACK(missing) }
-func CtrlLIST() []byte                        { return packLIST() }
-func CtrlLST(names []string) []byte           { return packLST(names) }
+func CtrlREQ(r Req) []byte                             { return packREQ(r) }
+func CtrlMETA(m Meta) []byte                           { return packMETA(m) }
+func CtrlERR(token uint32, code ErrCode, msg string) []byte { return packERR(ErrMsg{Code: code, Token: token, Message: msg}) }
+// CtrlBUSY recusa um pedido por falta de capacidade (ERR ErrCodeBusy) com a espera sugerida.
+func CtrlBUSY(token uint32, msg string, retryAfter time.Duration) []byte {
+	return packERR(ErrMsg{Code: ErrCodeBusy, Token: token, Message: msg, RetryAfter: retryAfter})
+}
+func CtrlEOF(session uint32) []byte                    { return packEOF(session) }
+func CtrlNACK(session uint32, missing []uint32) []byte { return packNACK(session, missing) }
+func CtrlLIST(l List) []byte                  { return packLIST(l) }
+func CtrlLST(l Lst) []byte                    { return packLST(l) }
+func CtrlFBK(f Feedback) []byte               { return packFBK(f) }
+func CtrlPUT(p Put) []byte                    { return packPUT(p) }
+func CtrlDONE(d Done) []byte                  { return packDONE(d) }
+func CtrlHELLO(h Hello) []byte                { return packHELLO(h) }
+func CtrlHELLOACK(a HelloAck) []byte          { return packHELLOACK(a) }
+
+// CtrlPROBE monta uma sonda pedindo um PROBEACK de size bytes; o próprio PROBE
+// é preenchido até size/MaxProbeAmplification bytes.
+func CtrlPROBE(token uint32, size int) []byte {
+	return packProbe(ctrlTypePROBE, token, size, (size+MaxProbeAmplification-1)/MaxProbeAmplification)
+}
+
+// CtrlPROBEACK monta a resposta à sonda com exatamente p.Size bytes.
+func CtrlPROBEACK(p Probe) []byte { return packProbe(ctrlTypePROBEACK, p.Token, p.Size, p.Size) }
+
+// CtrlHeaderSize retorna o tamanho em bytes do cabeçalho de controle.
+func CtrlHeaderSize() int { return ctrlHeaderSize }
+
+// CtrlTypeCodes retorna o byte de tipo de cada mensagem de controle, pelo
+// nome (Type*); usado para gerar o dissector do Wireshark (internal/wireshark).
+func CtrlTypeCodes() map[string]byte {
+	return map[string]byte{
+		TypeREQ: ctrlTypeREQ, TypeMETA: ctrlTypeMETA, TypeERR: ctrlTypeERR, TypeEOF: ctrlTypeEOF,
+		TypeNACK: ctrlTypeNACK, TypeLIST: ctrlTypeLIST, TypeLST: ctrlTypeLST, TypeFBK: ctrlTypeFBK,
+		TypePROBE: ctrlTypePROBE, TypePROBEACK: ctrlTypePROBEACK, TypePUT: ctrlTypePUT, TypeDONE: ctrlTypeDONE,
+		TypeHELLO: ctrlTypeHELLO, TypeHELLOACK: ctrlTypeHELLOACK,
+	}
+}
 
 // Decodifica e informa o tipo como string amigável.
 func DecodeCtrl(b []byte) (typ string, v any, err error) {
-	t, p, e := parseCtrl(b); if e != nil { return "", nil, e }
+	t, ver, p, e := parseCtrl(b); if e != nil { return "", nil, e }
+	if ver == layoutV1 { return decodeCtrlV1(t, p) }
 	switch t {
 	case ctrlTypeREQ:
-		q, e := unpackREQ(p); return TypeREQ, q, e
+		q, e := unpackREQ(p, ver); return TypeREQ, q, e
 	case ctrlTypeMETA:
-		m, e := unpackMETA(p); return TypeMETA, m, e
+		m, e := unpackMETA(p, ver); return TypeMETA, m, e
 	case ctrlTypeERR:
 		e2, e := unpackERR(p); return TypeERR, e2, e
 	case ctrlTypeEOF:
-		_, e := unpackEOF(p); return TypeEOF, EOFMsg{}, e
+		eof, e := unpackEOF(p); return TypeEOF, eof, e
 case ctrlTypeNACK:
 	nk, e := unpackNACK(p); return TypeNACK, nk, e
 case ctrlTypeLIST:
-	return TypeLIST, List{}, nil
+	l, e := unpackLIST(p); return TypeLIST, l, e
 case ctrlTypeLST:
 	lst, e := unpackLST(p); return TypeLST, lst, e
+	case ctrlTypeFBK:
+		fb, e := unpackFBK(p); return TypeFBK, fb, e
+	case ctrlTypePROBE:
+		pr, e := unpackProbe(p); return TypePROBE, pr, e
+	case ctrlTypePROBEACK:
+		pr, e := unpackProbe(p); return TypePROBEACK, pr, e
+	case ctrlTypePUT:
+		pu, e := unpackPUT(p); return TypePUT, pu, e
+	case ctrlTypeDONE:
+		d, e := unpackDONE(p); return TypeDONE, d, e
+	case ctrlTypeHELLO:
+		h, e := unpackHELLO(p); return TypeHELLO, h, e
+	case ctrlTypeHELLOACK:
+		a, e := unpackHELLOACK(p); return TypeHELLOACK, a, e
 	default:
 		return "", nil, errors.New("tipo ctrl desconhecido")
 	}
 }
 
+// NegotiateFEC ajusta o FEC pedido no REQ aos limites do servidor: N em
+// [1, config.MaxFECData], K em [1, N]; devolve 0, 0 se não foi pedido.
+func NegotiateFEC(n, k int) (int, int) {
+	if n <= 0 || k <= 0 { return 0, 0 }
+	n = min(n, config.MaxFECData)
+	return n, min(k, n)
+}
+
 // Calcula o checksum IEEE do payload.
 func CRC32(data []byte) uint32 {
 	return crc32.ChecksumIEEE(data)
@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// bytes de um datagrama escrito em hexadecimal (espaços ignorados)
+func fixture(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(string(bytes.ReplaceAll([]byte(s), []byte(" "), nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+const fixtureSHA = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+// DATA v1 de 18 bytes, como enviado pelo servidor original: decodifica com
+// Version = LegacyVersion, sem sessão nem flags, e o payload começa em Len().
+func TestUnpackHeaderV1(t *testing.T) {
+	b := fixture(t, "5544 01 00 00000007 00000064 0005 3610a686 68656c6c6f")
+	h, err := UnpackHeader(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DataHeader{Version: LegacyVersion, Seq: 7, Total: 100, Size: 5, CRC32: 0x3610a686}
+	if h != want {
+		t.Fatalf("UnpackHeader: %+v, esperado %+v", h, want)
+	}
+	if h.Len() != 18 {
+		t.Fatalf("Len: %d, esperado 18", h.Len())
+	}
+	if p := b[h.Len() : h.Len()+int(h.Size)]; CRC32(p) != h.CRC32 {
+		t.Fatalf("payload %q não confere com o CRC", p)
+	}
+	if got := PackHeader(h); !bytes.Equal(got, b[:18]) {
+		t.Fatalf("PackHeader: %x, esperado %x", got, b[:18])
+	}
+	if _, err := UnpackHeader(b[:17]); err == nil {
+		t.Fatal("DATA v1 de 17 bytes aceito")
+	}
+	// o layout atual continua valendo para as versões posteriores
+	cur := DataHeader{Session: 0xdeadbeef, Seq: 7, Total: 100, Size: 5, CRC32: 1}
+	got, err := UnpackHeader(PackHeader(cur))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Len() != HeaderSize() || got.Session != cur.Session || got.Version == LegacyVersion {
+		t.Fatalf("cabeçalho atual decodificado como %+v", got)
+	}
+}
+
+// Controles v1 (REQ, META, ERR, EOF, NACK) nos layouts do protocolo
+// original, sem token nem sessão.
+func TestDecodeCtrlV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		hex     string
+		typ     string
+		want    any
+		wantErr bool
+	}{
+		{"REQ", "5543 01 01 0009 6469722f612e62696e", TypeREQ, Req{Path: "dir/a.bin", Version: LegacyVersion}, false},
+		{"META", "5543 01 02 0035 00000003 0000000000000bb8 0400 0005 612e62696e " + fixtureSHA, TypeMETA,
+			Meta{Filename: "a.bin", Total: 3, Size: 3000, EstimatedCompressedSize: 3000, SHA256: fixtureSHA, Chunk: 1024, Version: LegacyVersion}, false},
+		{"ERR", "5543 01 03 0009 0001 0005 6e616f2068", TypeERR, ErrMsg{Code: 1, Message: "nao h"}, false},
+		{"EOF", "5543 01 04 0000", TypeEOF, EOFMsg{}, false},
+		{"NACK", "5543 01 05 000a 0002 00000001 ee6b2800", TypeNACK, Nack{Missing: []uint32{1, 4000000000}}, false},
+		{"NACK curto", "5543 01 05 0006 0002 00000001", TypeNACK, nil, true},
+		{"META curto", "5543 01 02 0004 00000003", TypeMETA, nil, true},
+		{"LIST v1", "5543 01 0b 0000", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fixture(t, tt.hex)
+			if !IsLegacy(b) {
+				t.Fatal("IsLegacy: false")
+			}
+			typ, v, err := DecodeCtrl(b)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("DecodeCtrl: %s %+v, esperado erro", typ, v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != tt.typ || !reflect.DeepEqual(v, tt.want) {
+				t.Fatalf("DecodeCtrl: %s %+v, esperado %s %+v", typ, v, tt.typ, tt.want)
+			}
+		})
+	}
+}
+
+// Os controles v1 montados pelo pacote são os bytes do protocolo original.
+func TestPackV1(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		hex  string
+	}{
+		{"REQ", CtrlREQ(Req{Token: 9, Path: "dir/a.bin", Version: LegacyVersion}), "5543 01 01 0009 6469722f612e62696e"},
+		{"META", CtrlMETA(Meta{Session: 9, Filename: "a.bin", Total: 3, Size: 3000, SHA256: fixtureSHA, Chunk: 1024, Version: LegacyVersion}),
+			"5543 01 02 0035 00000003 0000000000000bb8 0400 0005 612e62696e " + fixtureSHA},
+		{"ERR", CtrlLegacyERR(1, "nao h"), "5543 01 03 0009 0001 0005 6e616f2068"},
+		{"EOF", CtrlLegacyEOF(), "5543 01 04 0000"},
+		{"NACK", CtrlLegacyNACK([]uint32{1, 4000000000}), "5543 01 05 000a 0002 00000001 ee6b2800"},
+	}
+	for _, tt := range tests {
+		if want := fixture(t, tt.hex); !bytes.Equal(tt.got, want) {
+			t.Errorf("%s: %x, esperado %x", tt.name, tt.got, want)
+		}
+	}
+}
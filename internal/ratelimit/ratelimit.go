@@ -0,0 +1,385 @@
+// Package ratelimit limita a banda de envio do servidor com baldes de fichas
+// (token buckets): um global, um por IP de cliente e um por sub-rede
+// configurada, ajustáveis em execução. Cada datagrama consome fichas de todos
+// os baldes que se aplicam ao destino; quem não tem fichas espera a reposição.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// duração da rajada aceita por um balde cheio, e o mínimo dela em bytes
+// (um datagrama grande sempre cabe)
+const (
+	burstWindow = 100 * time.Millisecond
+	minBurst    = 64 * 1024
+)
+
+// ErrStopped é devolvido por Wait quando stop fecha durante a espera.
+var ErrStopped = errors.New("espera de banda interrompida")
+
+// Rule limita o agregado dos clientes de uma sub-rede (um endereço único é
+// um prefixo /32 ou /128).
+type Rule struct {
+	Prefix netip.Prefix // sub-rede
+	Rate   float64      // bytes/s
+}
+
+// Config descreve os limites de banda; taxas em bytes/s, 0 = sem limite.
+type Config struct {
+	Global    float64 // agregado de todos os envios
+	PerClient float64 // cada IP de cliente separadamente
+	Subnets   []Rule  // agregado por sub-rede (vale a regra mais específica)
+}
+
+// Enabled informa se algum limite está ativo.
+func (c Config) Enabled() bool { return c.Global > 0 || c.PerClient > 0 || len(c.Subnets) > 0 }
+
+func (c Config) String() string {
+	if !c.Enabled() {
+		return "sem limite"
+	}
+	var parts []string
+	if c.Global > 0 {
+		parts = append(parts, "global="+FormatRate(c.Global))
+	}
+	if c.PerClient > 0 {
+		parts = append(parts, "por cliente="+FormatRate(c.PerClient))
+	}
+	for _, r := range c.Subnets {
+		parts = append(parts, r.Prefix.String()+"="+FormatRate(r.Rate))
+	}
+	return strings.Join(parts, " ")
+}
+
+// BucketStats é o estado de um balde.
+type BucketStats struct {
+	Name    string        // "global", "cliente <ip>" ou "sub-rede <prefixo>"
+	Rate    float64       // taxa configurada (bytes/s)
+	Tokens  float64       // fichas disponíveis (negativo = dívida de envios reservados)
+	Waiting int           // envios aguardando fichas agora
+	Delayed uint64        // envios que precisaram esperar
+	Wait    time.Duration // tempo total de espera dos envios
+}
+
+// Throttled informa se o balde está segurando envios (sem fichas).
+func (b BucketStats) Throttled() bool { return b.Waiting > 0 || b.Tokens < 0 }
+
+// Bucket é um balde de fichas seguro para uso concorrente. As esperas são
+// reservas: cada envio desconta suas fichas na chegada (o saldo pode ficar
+// negativo) e dorme o tempo de reposição da dívida, preservando a ordem.
+type Bucket struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	used    time.Time // último Wait (para descarte de baldes ociosos)
+	waiting int
+	delayed uint64
+	wait    time.Duration
+}
+
+// NewBucket cria um balde cheio com a taxa rate (bytes/s).
+func NewBucket(rate float64) *Bucket {
+	b := &Bucket{last: time.Now()}
+	b.SetRate(rate)
+	b.tokens = b.burst
+	return b
+}
+
+// SetRate troca a taxa (bytes/s; <= 0 = sem limite), mantendo o saldo até a
+// nova capacidade de rajada.
+func (b *Bucket) SetRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.rate = max(rate, 0)
+	b.burst = max(b.rate*burstWindow.Seconds(), minBurst)
+	b.tokens = min(b.tokens, b.burst)
+}
+
+// repõe as fichas acumuladas desde a última atualização (com b.mu adquirido).
+func (b *Bucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens = min(b.tokens+b.rate*now.Sub(b.last).Seconds(), b.burst)
+	}
+	b.last = now
+}
+
+// Wait consome n fichas, esperando a reposição se faltarem. Fechar stop
+// interrompe a espera com ErrStopped (as fichas continuam consumidas).
+func (b *Bucket) Wait(n int, stop <-chan struct{}) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.used = now
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	d := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.waiting++
+	b.delayed++
+	b.wait += d
+	b.mu.Unlock()
+	defer func() { b.mu.Lock(); b.waiting--; b.mu.Unlock() }()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-stop:
+		return ErrStopped
+	}
+}
+
+// Stats retorna o estado do balde com o nome name.
+func (b *Bucket) Stats(name string) BucketStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return BucketStats{Name: name, Rate: b.rate, Tokens: b.tokens, Waiting: b.waiting, Delayed: b.delayed, Wait: b.wait}
+}
+
+// ocioso: cheio e sem uso há mais de d
+func (b *Bucket) idle(d time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.waiting == 0 && b.tokens >= b.burst && time.Since(b.used) > d
+}
+
+// Limiter aplica uma Config: o balde global, os por cliente (criados no
+// primeiro envio a cada IP) e os por sub-rede.
+type Limiter struct {
+	mu      sync.Mutex
+	cfg     Config
+	global  *Bucket
+	clients map[netip.Addr]*Bucket
+	subnets map[netip.Prefix]*Bucket
+}
+
+// New cria um limitador com cfg.
+func New(cfg Config) *Limiter {
+	l := &Limiter{clients: map[netip.Addr]*Bucket{}, subnets: map[netip.Prefix]*Bucket{}}
+	l.Configure(cfg)
+	return l
+}
+
+// Configure troca os limites; os baldes existentes mantêm o saldo e os envios
+// seguintes já seguem as novas taxas.
+func (l *Limiter) Configure(cfg Config) {
+	cfg.Subnets = append([]Rule(nil), cfg.Subnets...)
+	for i := range cfg.Subnets {
+		cfg.Subnets[i].Prefix = cfg.Subnets[i].Prefix.Masked()
+	}
+	// mais específica primeiro: a primeira regra que contém o IP vale
+	sort.SliceStable(cfg.Subnets, func(i, j int) bool { return cfg.Subnets[i].Prefix.Bits() > cfg.Subnets[j].Prefix.Bits() })
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+	if l.global == nil {
+		l.global = NewBucket(cfg.Global)
+	} else {
+		l.global.SetRate(cfg.Global)
+	}
+	for _, b := range l.clients {
+		b.SetRate(cfg.PerClient)
+	}
+	keep := map[netip.Prefix]bool{}
+	for _, r := range cfg.Subnets {
+		keep[r.Prefix] = true
+		if b := l.subnets[r.Prefix]; b != nil {
+			b.SetRate(r.Rate)
+		} else {
+			l.subnets[r.Prefix] = NewBucket(r.Rate)
+		}
+	}
+	for p := range l.subnets {
+		if !keep[p] {
+			delete(l.subnets, p)
+		}
+	}
+}
+
+// Config retorna os limites em vigor.
+func (l *Limiter) Config() Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.cfg
+	c.Subnets = append([]Rule(nil), c.Subnets...)
+	return c
+}
+
+// baldes que limitam envios para ip, do mais específico ao global
+func (l *Limiter) buckets(ip netip.Addr) []*Bucket {
+	ip = ip.Unmap()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var bs []*Bucket
+	for _, r := range l.cfg.Subnets {
+		if r.Prefix.Contains(ip) {
+			bs = append(bs, l.subnets[r.Prefix])
+			break
+		}
+	}
+	if l.cfg.PerClient > 0 {
+		b := l.clients[ip]
+		if b == nil {
+			b = NewBucket(l.cfg.PerClient)
+			l.clients[ip] = b
+		}
+		bs = append(bs, b)
+	}
+	if l.cfg.Global > 0 {
+		bs = append(bs, l.global)
+	}
+	return bs
+}
+
+// Wait consome n bytes de banda de todos os baldes que se aplicam a ip,
+// esperando a reposição de cada um. Fechar stop interrompe com ErrStopped.
+func (l *Limiter) Wait(ip netip.Addr, n int, stop <-chan struct{}) error {
+	for _, b := range l.buckets(ip) {
+		if err := b.Wait(n, stop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune descarta os baldes de clientes ociosos há mais de idle (cheios).
+func (l *Limiter) Prune(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ip, b := range l.clients {
+		if b.idle(idle) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// Stats retorna o estado dos baldes ativos: global, sub-redes e clientes.
+func (l *Limiter) Stats() []BucketStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var st []BucketStats
+	if l.cfg.Global > 0 {
+		st = append(st, l.global.Stats("global"))
+	}
+	for _, r := range l.cfg.Subnets {
+		st = append(st, l.subnets[r.Prefix].Stats("sub-rede "+r.Prefix.String()))
+	}
+	ips := make([]netip.Addr, 0, len(l.clients))
+	for ip := range l.clients {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool { return ips[i].Less(ips[j]) })
+	for _, ip := range ips {
+		st = append(st, l.clients[ip].Stats("cliente "+ip.String()))
+	}
+	return st
+}
+
+// ParseRate interpreta uma taxa em bytes/s com sufixo opcional K, M ou G
+// (múltiplos de 1024), seguido opcionalmente de "B" e "/s": "512K", "10MB/s",
+// "1.5M". "0" ou texto vazio = sem limite.
+func ParseRate(s string) (float64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(t, "/S")
+	t = strings.TrimSuffix(t, "B")
+	if t == "" {
+		return 0, nil
+	}
+	mult := 1.0
+	switch t[len(t)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		t = t[:len(t)-1]
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+	if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("taxa inválida %q (ex.: 512K, 10M, 1.5G)", s)
+	}
+	return v * mult, nil
+}
+
+// ParseRules interpreta limites por sub-rede separados por vírgula, no
+// formato prefixo=taxa ("10.0.0.0/8=5M,192.168.1.7=1M"); um endereço sem
+// prefixo vale só para ele.
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		net, rate, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("limite de sub-rede inválido %q (use prefixo=taxa)", item)
+		}
+		net = strings.TrimSpace(net)
+		p, err := netip.ParsePrefix(net)
+		if err != nil {
+			a, aerr := netip.ParseAddr(net)
+			if aerr != nil {
+				return nil, fmt.Errorf("sub-rede inválida %q", net)
+			}
+			a = a.Unmap()
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		r, err := ParseRate(rate)
+		if err != nil {
+			return nil, err
+		}
+		if r <= 0 {
+			return nil, fmt.Errorf("limite de sub-rede %q precisa de taxa maior que zero", item)
+		}
+		rules = append(rules, Rule{Prefix: p.Masked(), Rate: r})
+	}
+	return rules, nil
+}
+
+// FormatRules é o inverso de ParseRules.
+func FormatRules(rules []Rule) string {
+	parts := make([]string, len(rules))
+	for i, r := range rules {
+		parts[i] = r.Prefix.String() + "=" + strconv.FormatFloat(r.Rate/1024, 'f', -1, 64) + "K"
+	}
+	return strings.Join(parts, ",")
+}
+
+// FormatRate formata uma taxa em bytes/s para exibição ("1.5 MiB/s").
+func FormatRate(r float64) string {
+	switch {
+	case r <= 0:
+		return "sem limite"
+	case r >= 1<<30:
+		return fmt.Sprintf("%.1f GiB/s", r/(1<<30))
+	case r >= 1<<20:
+		return fmt.Sprintf("%.1f MiB/s", r/(1<<20))
+	case r >= 1<<10:
+		return fmt.Sprintf("%.1f KiB/s", r/(1<<10))
+	}
+	return fmt.Sprintf("%.0f B/s", r)
+}
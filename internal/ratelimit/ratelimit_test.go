@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"512", 512},
+		{"512K", 512 << 10},
+		{"512k", 512 << 10},
+		{" 3 k ", 3 << 10},
+		{"10M", 10 << 20},
+		{"10MB/s", 10 << 20},
+		{"1.5M", 1.5 * (1 << 20)},
+		{"2G", 2 << 30},
+		{"2gb", 2 << 30},
+		{"100B/s", 100},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil {
+			t.Errorf("ParseRate(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, esperado %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRateMalformed(t *testing.T) {
+	for _, in := range []string{"abc", "K", "M/s", "-1M", "1KK", "10MiB", "1,5M", "NaN", "inf", "1e400", "5T"} {
+		if got, err := ParseRate(in); err == nil {
+			t.Errorf("ParseRate(%q) = %v, esperado erro", in, got)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(" 10.1.2.3/8=5M, 192.168.1.7=1M,,::ffff:172.16.0.1=512K,2001:db8::/32=1G ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{netip.MustParsePrefix("10.0.0.0/8"), 5 << 20},
+		{netip.MustParsePrefix("192.168.1.7/32"), 1 << 20},
+		{netip.MustParsePrefix("172.16.0.1/32"), 512 << 10},
+		{netip.MustParsePrefix("2001:db8::/32"), 1 << 30},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("ParseRules: %v, esperado %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("regra %d = %v, esperado %v", i, rules[i], want[i])
+		}
+	}
+	back, err := ParseRules(FormatRules(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		if back[i] != want[i] {
+			t.Errorf("FormatRules/ParseRules: regra %d = %v, esperado %v", i, back[i], want[i])
+		}
+	}
+}
+
+func TestParseRulesMalformed(t *testing.T) {
+	for _, in := range []string{
+		"10.0.0.0/8",        // sem taxa
+		"rede=1M",           // sub-rede inválida
+		"10.0.0.0/33=1M",    // prefixo fora do intervalo
+		"10.0.0.0/8=0",      // taxa zero
+		"10.0.0.0/8=",       // taxa vazia
+		"10.0.0.0/8=rápido", // taxa inválida
+		"10.0.0.1=1M,x=2M",  // um item inválido invalida a lista
+	} {
+		if got, err := ParseRules(in); err == nil {
+			t.Errorf("ParseRules(%q) = %v, esperado erro", in, got)
+		}
+	}
+}
+
+// A regra mais específica que contém o IP vale, independentemente da ordem
+// em que as regras foram configuradas; IPs fora das regras só têm os
+// baldes por cliente e global.
+func TestRulePrecedence(t *testing.T) {
+	rules, err := ParseRules("10.0.0.0/8=1M,10.1.2.3=3M,10.1.0.0/16=2M,::/0=4M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(Config{Subnets: rules})
+	tests := []struct {
+		ip   string
+		want string // sub-rede aplicada ("" = nenhuma)
+	}{
+		{"10.1.2.3", "10.1.2.3/32"},
+		{"::ffff:10.1.2.3", "10.1.2.3/32"},
+		{"10.1.2.4", "10.1.0.0/16"},
+		{"10.1.255.255", "10.1.0.0/16"},
+		{"10.2.0.1", "10.0.0.0/8"},
+		{"192.168.0.1", ""},
+		{"2001:db8::1", "::/0"},
+	}
+	for _, tt := range tests {
+		bs := l.buckets(netip.MustParseAddr(tt.ip))
+		if tt.want == "" {
+			if len(bs) != 0 {
+				t.Errorf("%s: %d baldes, esperado nenhum", tt.ip, len(bs))
+			}
+			continue
+		}
+		if len(bs) != 1 || bs[0] != l.subnets[netip.MustParsePrefix(tt.want)] {
+			t.Errorf("%s: baldes %v, esperado o de %s", tt.ip, bs, tt.want)
+		}
+	}
+}
+
+// Um balde esvaziado repõe rate fichas por segundo de ociosidade, até a
+// capacidade de rajada, e um envio após a reposição não espera.
+func TestRefillAfterIdle(t *testing.T) {
+	const rate = 1 << 20
+	burst := max(rate*burstWindow.Seconds(), minBurst)
+	tests := []struct {
+		idle time.Duration
+		want float64
+	}{
+		{0, 0},
+		{10 * time.Millisecond, rate * 0.010},
+		{50 * time.Millisecond, rate * 0.050},
+		{time.Second, burst},
+		{time.Hour, burst},
+	}
+	for _, tt := range tests {
+		b := NewBucket(rate)
+		b.mu.Lock()
+		b.tokens, b.last = 0, time.Now().Add(-tt.idle)
+		b.mu.Unlock()
+		got := b.Stats("teste").Tokens
+		if math.Abs(got-tt.want) > rate*0.005 {
+			t.Errorf("ocioso %v: %.0f fichas, esperado %.0f", tt.idle, got, tt.want)
+		}
+		if got > burst {
+			t.Errorf("ocioso %v: %.0f fichas acima da rajada %.0f", tt.idle, got, burst)
+		}
+	}
+
+	b := NewBucket(rate)
+	b.mu.Lock()
+	b.tokens, b.last = -rate, time.Now().Add(-2*time.Second) // dívida de 1 s, quitada pela ociosidade
+	b.mu.Unlock()
+	start := time.Now()
+	if err := b.Wait(1024, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("Wait após ociosidade esperou %v", d)
+	}
+}
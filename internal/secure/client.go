@@ -0,0 +1,101 @@
+package secure
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ClientConn envolve o socket conectado do cliente depois do handshake:
+// Write cifra cada datagrama e Read entrega apenas datagramas autênticos e
+// inéditos do servidor, já decifrados.
+type ClientConn struct {
+	net.Conn
+	keys    *keys
+	rbuf    []byte // buffer de leitura (Read não é chamado concorrentemente)
+	dropped atomic.Uint64
+}
+
+// Client executa o handshake com o servidor sobre c (até attempts envios do
+// INIT, aguardando timeout por cada RESP) e retorna a conexão cifrada.
+func Client(c net.Conn, psk []byte, timeout time.Duration, attempts int) (*ClientConn, error) {
+	if len(psk) < MinPSKSize {
+		return nil, ErrShortPSK
+	}
+	if attempts <= 0 {
+		attempts = 3
+	}
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	auth := authKey(psk)
+	cr := make([]byte, randSize)
+	if _, err := rand.Read(cr); err != nil {
+		return nil, err
+	}
+	hello := packInit(auth, cr)
+	buf := make([]byte, 65535)
+	defer c.SetReadDeadline(time.Time{})
+	for try := 0; try < attempts; try++ {
+		if _, err := c.Write(hello); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		_ = c.SetReadDeadline(deadline)
+		for time.Now().Before(deadline) {
+			n, err := c.Read(buf)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				// erros transitórios (ex.: ICMP port unreachable): aguarda o prazo
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			id, sr, ok := parseResp(auth, buf[:n], cr)
+			if !ok {
+				continue
+			}
+			k, err := deriveKeys(psk, cr, sr, id, false)
+			if err != nil {
+				return nil, err
+			}
+			return &ClientConn{Conn: c, keys: k, rbuf: buf}, nil
+		}
+	}
+	return nil, ErrHandshake
+}
+
+// Dropped retorna quantos datagramas foram descartados por não serem
+// envelopes autênticos e inéditos.
+func (c *ClientConn) Dropped() uint64 { return c.dropped.Load() }
+
+// Read lê o próximo datagrama autêntico, decifrado em p.
+func (c *ClientConn) Read(p []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(c.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		out, err := c.keys.open(p[:0], c.rbuf[:n])
+		if err != nil {
+			c.dropped.Add(1) // inclui RESPs repetidos do handshake
+			continue
+		}
+		if len(out) > cap(p) {
+			return copy(p, out), nil // p sem capacidade: Open alocou outro buffer
+		}
+		return len(out), nil
+	}
+}
+
+// Write cifra p e o envia ao servidor.
+func (c *ClientConn) Write(p []byte) (int, error) {
+	if _, err := c.Conn.Write(c.keys.seal(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
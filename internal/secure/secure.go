@@ -0,0 +1,282 @@
+// Package secure implementa o modo cifrado opcional: cada datagrama do
+// protocolo (DATA 'UD' ou controle 'UC') é encapsulado num envelope 'US'
+// cifrado e autenticado com AES-256-GCM. As chaves de cada sessão são
+// derivadas (HKDF-SHA256) de uma chave pré-compartilhada (PSK) e de dois
+// valores aleatórios trocados num handshake autenticado por HMAC. O nonce é
+// o identificador da sessão seguido do número de sequência do envelope, e
+// uma janela deslizante descarta envelopes repetidos.
+//
+// Formato do envelope (big-endian):
+//
+//	magic 'US' (2) | versão (1) | tipo (1) | sessão (4) | sequência (8) | payload
+//
+// Tipos: 1 = INIT (cliente -> servidor: aleatório do cliente + HMAC),
+// 2 = RESP (servidor -> cliente: aleatório do servidor + HMAC) e
+// 3 = DATA (datagrama do protocolo cifrado; o cabeçalho é o dado associado).
+package secure
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+)
+
+const (
+	version    = 1
+	headerSize = 16
+	tagSize    = 16
+	randSize   = 32
+	macSize    = sha256.Size
+	keySize    = 32
+
+	typeInit = 1
+	typeResp = 2
+	typeData = 3
+
+	// Overhead é o acréscimo de um envelope DATA ao datagrama original.
+	Overhead = headerSize + tagSize
+	// MinPSKSize é o tamanho mínimo aceito para a chave pré-compartilhada.
+	MinPSKSize = 16
+
+	replayWindow = 8192 // envelopes aceitos fora de ordem atrás do mais recente
+)
+
+var magic = [2]byte{'U', 'S'}
+
+// Erros do modo cifrado.
+var (
+	ErrShortPSK  = errors.New("chave pré-compartilhada curta demais (mínimo 16 bytes)")
+	ErrHandshake = errors.New("handshake sem resposta válida (PSK divergente ou servidor sem modo cifrado)")
+	errInvalid   = errors.New("envelope inválido")
+	errReplay    = errors.New("envelope repetido")
+)
+
+// LoadPSK lê a chave pré-compartilhada de um arquivo. Conteúdo hexadecimal
+// (ex.: gerado com `openssl rand -hex 32`) é decodificado; qualquer outro
+// conteúdo é usado como está, sem espaços nas extremidades.
+func LoadPSK(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	psk := bytes.TrimSpace(raw)
+	if dec, err := hex.DecodeString(string(psk)); err == nil {
+		psk = dec
+	}
+	if len(psk) < MinPSKSize {
+		return nil, ErrShortPSK
+	}
+	return psk, nil
+}
+
+// IsEnvelope indica se o datagrama é um envelope do modo cifrado.
+func IsEnvelope(b []byte) bool {
+	return len(b) >= headerSize && b[0] == magic[0] && b[1] == magic[1]
+}
+
+// cabeçalho de envelope
+func header(typ byte, id uint32, seq uint64) []byte {
+	h := make([]byte, headerSize, headerSize+randSize+macSize)
+	h[0], h[1], h[2], h[3] = magic[0], magic[1], version, typ
+	binary.BigEndian.PutUint32(h[4:8], id)
+	binary.BigEndian.PutUint64(h[8:16], seq)
+	return h
+}
+
+// decodifica o cabeçalho de um envelope
+func parseHeader(b []byte) (typ byte, id uint32, seq uint64, err error) {
+	if !IsEnvelope(b) || b[2] != version {
+		return 0, 0, 0, errInvalid
+	}
+	return b[3], binary.BigEndian.Uint32(b[4:8]), binary.BigEndian.Uint64(b[8:16]), nil
+}
+
+// chave que autentica o handshake (ninguém sem a PSK produz INIT/RESP válidos)
+func authKey(psk []byte) []byte {
+	k, _ := hkdf.Key(sha256.New, psk, nil, "udp secure v1 auth", keySize)
+	return k
+}
+
+// HMAC-SHA256 das partes concatenadas
+func mac(key []byte, parts ...[]byte) []byte {
+	m := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		m.Write(p)
+	}
+	return m.Sum(nil)
+}
+
+// monta o INIT com o aleatório do cliente
+func packInit(auth, clientRand []byte) []byte {
+	b := append(header(typeInit, 0, 0), clientRand...)
+	return append(b, mac(auth, b)...)
+}
+
+// valida um INIT e retorna o aleatório do cliente
+func parseInit(auth, b []byte) ([]byte, bool) {
+	if len(b) != headerSize+randSize+macSize {
+		return nil, false
+	}
+	body := b[:headerSize+randSize]
+	return b[headerSize : headerSize+randSize], hmac.Equal(b[len(body):], mac(auth, body))
+}
+
+// monta o RESP: sessão atribuída e aleatório do servidor, autenticados junto
+// com o aleatório do cliente (amarra a resposta ao INIT)
+func packResp(auth []byte, id uint32, clientRand, serverRand []byte) []byte {
+	b := append(header(typeResp, id, 0), serverRand...)
+	return append(b, mac(auth, b, clientRand)...)
+}
+
+// valida um RESP para o INIT com clientRand
+func parseResp(auth, b, clientRand []byte) (id uint32, serverRand []byte, ok bool) {
+	if len(b) != headerSize+randSize+macSize {
+		return 0, nil, false
+	}
+	typ, id, _, err := parseHeader(b)
+	if err != nil || typ != typeResp || id == 0 {
+		return 0, nil, false
+	}
+	body := b[:headerSize+randSize]
+	return id, b[headerSize : headerSize+randSize], hmac.Equal(b[len(body):], mac(auth, body, clientRand))
+}
+
+// keys guarda as chaves de uma sessão cifrada, uma por sentido, o contador
+// de envio e a janela anti-repetição do sentido de recepção.
+type keys struct {
+	id     uint32
+	send   cipher.AEAD
+	recv   cipher.AEAD
+	mu     sync.Mutex // proteção de seq e da janela
+	seq    uint64     // próximo número de sequência de envio
+	replay window
+}
+
+// deriva as chaves da sessão id a partir da PSK e dos dois aleatórios.
+func deriveKeys(psk, clientRand, serverRand []byte, id uint32, server bool) (*keys, error) {
+	salt := append(append([]byte(nil), clientRand...), serverRand...)
+	c2s, err := newAEAD(psk, salt, "udp secure v1 c2s")
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := newAEAD(psk, salt, "udp secure v1 s2c")
+	if err != nil {
+		return nil, err
+	}
+	if server {
+		return &keys{id: id, send: s2c, recv: c2s}, nil
+	}
+	return &keys{id: id, send: c2s, recv: s2c}, nil
+}
+
+// AES-256-GCM com chave derivada por HKDF-SHA256
+func newAEAD(psk, salt []byte, info string) (cipher.AEAD, error) {
+	k, err := hkdf.Key(sha256.New, psk, salt, info, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce de 12 bytes: sessão (4) + sequência do envelope (8)
+func nonce(id uint32, seq uint64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint32(n[0:4], id)
+	binary.BigEndian.PutUint64(n[4:12], seq)
+	return n
+}
+
+// cifra o datagrama p num envelope DATA.
+func (k *keys) seal(p []byte) []byte {
+	k.mu.Lock()
+	seq := k.seq
+	k.seq++
+	k.mu.Unlock()
+	h := header(typeData, k.id, seq)
+	out := make([]byte, headerSize, headerSize+len(p)+tagSize)
+	copy(out, h)
+	return k.send.Seal(out, nonce(k.id, seq), p, h)
+}
+
+// autentica e decifra um envelope DATA em dst, descartando repetições.
+func (k *keys) open(dst, b []byte) ([]byte, error) {
+	typ, id, seq, err := parseHeader(b)
+	if err != nil || typ != typeData || id != k.id || len(b) < headerSize+tagSize {
+		return nil, errInvalid
+	}
+	k.mu.Lock()
+	seen := k.replay.seen(seq)
+	k.mu.Unlock()
+	if seen {
+		return nil, errReplay
+	}
+	p, err := k.recv.Open(dst, nonce(id, seq), b[headerSize:], b[:headerSize])
+	if err != nil {
+		return nil, errInvalid
+	}
+	// só envelopes autênticos avançam a janela
+	k.mu.Lock()
+	ok := k.replay.accept(seq)
+	k.mu.Unlock()
+	if !ok {
+		return nil, errReplay
+	}
+	return p, nil
+}
+
+// window é uma janela deslizante de sequências já aceitas (como no IPsec):
+// aceita cada sequência uma única vez e recusa as anteriores à janela.
+type window struct {
+	top  uint64 // maior sequência aceita
+	bits [replayWindow / 64]uint64
+}
+
+func (w *window) bit(seq uint64) (int, uint64) {
+	i := seq % replayWindow
+	return int(i / 64), 1 << (i % 64)
+}
+
+// indica se seq já foi aceita ou está antes da janela.
+func (w *window) seen(seq uint64) bool {
+	if seq > w.top {
+		return false
+	}
+	if w.top-seq >= replayWindow {
+		return true
+	}
+	i, m := w.bit(seq)
+	return w.bits[i]&m != 0
+}
+
+// marca seq como aceita, deslizando a janela; false se já vista.
+func (w *window) accept(seq uint64) bool {
+	if w.seen(seq) {
+		return false
+	}
+	if seq > w.top {
+		if seq-w.top >= replayWindow {
+			clear(w.bits[:])
+		} else {
+			for s := w.top + 1; s < seq; s++ {
+				i, m := w.bit(s)
+				w.bits[i] &^= m
+			}
+		}
+		w.top = seq
+	}
+	i, m := w.bit(seq)
+	w.bits[i] |= m
+	return true
+}
@@ -0,0 +1,188 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	testPSK  = []byte("chave de teste com 32 bytes!!!!!")
+	otherPSK = []byte("outra chave de teste, 32 bytes!!")
+)
+
+// servidor cifrado de eco em loopback; devolve o endereço e o ServerConn
+func echoServer(t *testing.T, psk []byte) (net.Addr, *ServerConn) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(pc, psk)
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := s.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			s.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc.LocalAddr(), s
+}
+
+// Handshake completo sobre UDP: com a mesma PSK os datagramas vão e voltam
+// decifrados; com PSK divergente o servidor descarta o INIT e o cliente
+// desiste com ErrHandshake.
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverPSK []byte
+		clientPSK []byte
+		wantErr   error
+	}{
+		{"mesma PSK", testPSK, testPSK, nil},
+		{"PSK divergente", testPSK, otherPSK, ErrHandshake},
+		{"PSK curta", testPSK, []byte("curta"), ErrShortPSK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, srv := echoServer(t, tt.serverPSK)
+			uc, err := net.Dial("udp", addr.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer uc.Close()
+			c, err := Client(uc, tt.clientPSK, 200*time.Millisecond, 2)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Client: erro %v, esperado %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if tt.wantErr == ErrHandshake && srv.Dropped() == 0 {
+					t.Error("INIT com PSK divergente não foi descartado pelo servidor")
+				}
+				return
+			}
+			msg := []byte("UC datagrama de teste")
+			if _, err := c.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 2048)
+			n, err := c.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], msg) {
+				t.Fatalf("eco %q, esperado %q", buf[:n], msg)
+			}
+		})
+	}
+}
+
+// par de chaves de uma mesma sessão, do lado do cliente e do servidor
+func sessionKeys(t *testing.T) (client, server *keys) {
+	t.Helper()
+	cr, sr := bytes.Repeat([]byte{1}, randSize), bytes.Repeat([]byte{2}, randSize)
+	client, err := deriveKeys(testPSK, cr, sr, 0x1234, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = deriveKeys(testPSK, cr, sr, 0x1234, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+// Abertura de envelopes DATA: autenticação do cabeçalho e do conteúdo e
+// janela anti-repetição.
+func TestOpen(t *testing.T) {
+	msg := []byte("UD segmento de dados")
+	tests := []struct {
+		name string
+		// prepara as chaves e devolve o envelope a ser aberto pelo servidor
+		envelope func(t *testing.T, c, s *keys) []byte
+		wantErr  error
+	}{
+		{"autêntico", func(t *testing.T, c, s *keys) []byte { return c.seal(msg) }, nil},
+		{"tag adulterada", func(t *testing.T, c, s *keys) []byte {
+			b := c.seal(msg)
+			b[len(b)-1] ^= 0x01
+			return b
+		}, errInvalid},
+		{"conteúdo adulterado", func(t *testing.T, c, s *keys) []byte {
+			b := c.seal(msg)
+			b[headerSize] ^= 0x80
+			return b
+		}, errInvalid},
+		{"sequência adulterada", func(t *testing.T, c, s *keys) []byte {
+			b := c.seal(msg)
+			b[headerSize-1] ^= 0x01
+			return b
+		}, errInvalid},
+		{"sessão errada", func(t *testing.T, c, s *keys) []byte {
+			c.id++
+			return c.seal(msg)
+		}, errInvalid},
+		{"sentido trocado", func(t *testing.T, c, s *keys) []byte { return s.seal(msg) }, errInvalid},
+		{"repetido", func(t *testing.T, c, s *keys) []byte {
+			b := c.seal(msg)
+			if _, err := s.open(nil, b); err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}, errReplay},
+		{"fora de ordem dentro da janela", func(t *testing.T, c, s *keys) []byte {
+			old := c.seal(msg)
+			c.seq = replayWindow - 1
+			if _, err := s.open(nil, c.seal(msg)); err != nil {
+				t.Fatal(err)
+			}
+			return old
+		}, nil},
+		{"antes da janela", func(t *testing.T, c, s *keys) []byte {
+			old := c.seal(msg)
+			c.seq = replayWindow
+			if _, err := s.open(nil, c.seal(msg)); err != nil {
+				t.Fatal(err)
+			}
+			return old
+		}, errReplay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, s := sessionKeys(t)
+			p, err := s.open(nil, tt.envelope(t, c, s))
+			if err != tt.wantErr {
+				t.Fatalf("open: erro %v, esperado %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(p, msg) {
+				t.Fatalf("decifrado %q, esperado %q", p, msg)
+			}
+		})
+	}
+}
+
+// Um envelope adulterado não avança a janela: o autêntico com a mesma
+// sequência ainda é aceito depois dele.
+func TestForgeryDoesNotAdvanceWindow(t *testing.T) {
+	c, s := sessionKeys(t)
+	b := c.seal([]byte("UC"))
+	forged := bytes.Clone(b)
+	forged[len(forged)-1] ^= 0x01
+	if _, err := s.open(nil, forged); err != errInvalid {
+		t.Fatalf("forjado: erro %v, esperado %v", err, errInvalid)
+	}
+	if _, err := s.open(nil, b); err != nil {
+		t.Fatalf("autêntico após o forjado: %v", err)
+	}
+}
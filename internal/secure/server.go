@@ -0,0 +1,206 @@
+package secure
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"udp/internal/config"
+)
+
+const (
+	maxSessions      = 4096             // sessões cifradas simultâneas no servidor
+	handshakeTimeout = 10 * time.Second // prazo para a sessão ser confirmada pelo cliente
+	purgeInterval    = time.Second
+)
+
+// ErrNoSession indica que não há sessão cifrada confirmada para o destino.
+var ErrNoSession = errors.New("destino sem sessão cifrada")
+
+// sessão cifrada do lado do servidor
+type serverSession struct {
+	keys       *keys
+	clientRand [randSize]byte
+	resp       []byte   // RESP enviado (reenviado se o INIT se repetir)
+	addr       net.Addr // último endereço autenticado do cliente
+	confirmed  bool     // o cliente já provou possuir as chaves
+	lastSeen   time.Time
+}
+
+// ServerConn envolve o socket do servidor: responde aos handshakes,
+// entrega em ReadFrom apenas datagramas autênticos e inéditos (já decifrados)
+// e cifra em WriteTo com a sessão confirmada mais recente do destino.
+// Datagramas sem envelope, forjados ou repetidos são descartados e contados.
+type ServerConn struct {
+	net.PacketConn
+	psk     []byte
+	auth    []byte
+	mu      sync.Mutex // proteção dos mapas de sessões
+	byID    map[uint32]*serverSession
+	byAddr  map[string]*serverSession
+	byInit  map[[randSize]byte]*serverSession
+	purged  time.Time
+	rbuf    []byte // buffer de leitura (ReadFrom não é chamado concorrentemente)
+	dropped atomic.Uint64
+}
+
+// NewServer ativa o modo cifrado sobre o socket pc com a chave psk.
+func NewServer(pc net.PacketConn, psk []byte) (*ServerConn, error) {
+	if len(psk) < MinPSKSize {
+		return nil, ErrShortPSK
+	}
+	return &ServerConn{
+		PacketConn: pc,
+		psk:        psk,
+		auth:       authKey(psk),
+		byID:       make(map[uint32]*serverSession),
+		byAddr:     make(map[string]*serverSession),
+		byInit:     make(map[[randSize]byte]*serverSession),
+		rbuf:       make([]byte, config.MaxDatagramSize),
+	}, nil
+}
+
+// Dropped retorna quantos datagramas foram descartados por não serem
+// envelopes autênticos e inéditos.
+func (s *ServerConn) Dropped() uint64 { return s.dropped.Load() }
+
+// ReadFrom lê o próximo datagrama autêntico, decifrado em p. Handshakes são
+// respondidos internamente.
+func (s *ServerConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := s.PacketConn.ReadFrom(s.rbuf)
+		if err != nil {
+			return 0, addr, err
+		}
+		b := s.rbuf[:n]
+		typ, id, _, err := parseHeader(b)
+		if err != nil {
+			s.dropped.Add(1)
+			continue
+		}
+		switch typ {
+		case typeInit:
+			if !s.handshake(b, addr) {
+				s.dropped.Add(1)
+			}
+		case typeData:
+			s.mu.Lock()
+			ss := s.byID[id]
+			s.mu.Unlock()
+			if ss == nil {
+				s.dropped.Add(1)
+				continue
+			}
+			out, err := ss.keys.open(p[:0], b)
+			if err != nil {
+				s.dropped.Add(1)
+				continue
+			}
+			s.confirm(ss, addr)
+			if len(out) > cap(p) {
+				return copy(p, out), addr, nil // p sem capacidade: Open alocou outro buffer
+			}
+			return len(out), addr, nil
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// WriteTo cifra p para a sessão confirmada de addr.
+func (s *ServerConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	s.mu.Lock()
+	ss := s.byAddr[addr.String()]
+	s.mu.Unlock()
+	if ss == nil {
+		return 0, ErrNoSession
+	}
+	if _, err := s.PacketConn.WriteTo(ss.keys.seal(p), addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// responde a um INIT válido, criando a sessão (ou reenviando o RESP se o
+// mesmo INIT se repetir).
+func (s *ServerConn) handshake(b []byte, addr net.Addr) bool {
+	cr, ok := parseInit(s.auth, b)
+	if !ok {
+		return false
+	}
+	var key [randSize]byte
+	copy(key[:], cr)
+	s.mu.Lock()
+	s.purgeLocked(time.Now())
+	ss := s.byInit[key]
+	if ss == nil {
+		if len(s.byID) >= maxSessions {
+			s.mu.Unlock()
+			return false
+		}
+		sr := make([]byte, randSize)
+		_, _ = rand.Read(sr)
+		var id uint32
+		for id == 0 || s.byID[id] != nil {
+			var r [4]byte
+			_, _ = rand.Read(r[:])
+			id = binary.BigEndian.Uint32(r[:])
+		}
+		k, err := deriveKeys(s.psk, cr, sr, id, true)
+		if err != nil {
+			s.mu.Unlock()
+			return false
+		}
+		ss = &serverSession{keys: k, clientRand: key, resp: packResp(s.auth, id, cr, sr), lastSeen: time.Now()}
+		s.byID[id] = ss
+		s.byInit[key] = ss
+	}
+	resp := ss.resp
+	s.mu.Unlock()
+	_, _ = s.PacketConn.WriteTo(resp, addr)
+	return true
+}
+
+// marca a sessão como confirmada e associa o endereço de origem a ela
+// (acompanha trocas de endereço, como no rebind de NAT).
+func (s *ServerConn) confirm(ss *serverSession, addr net.Addr) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss.confirmed, ss.lastSeen = true, now
+	if ss.addr == nil || ss.addr.String() != addr.String() {
+		if ss.addr != nil && s.byAddr[ss.addr.String()] == ss {
+			delete(s.byAddr, ss.addr.String())
+		}
+		ss.addr = addr
+	}
+	s.byAddr[addr.String()] = ss
+	s.purgeLocked(now)
+}
+
+// remove sessões não confirmadas após handshakeTimeout e sessões ociosas
+// após config.SessionIdleTimeout (no máximo uma varredura por segundo).
+func (s *ServerConn) purgeLocked(now time.Time) {
+	if now.Sub(s.purged) < purgeInterval {
+		return
+	}
+	s.purged = now
+	for id, ss := range s.byID {
+		limit := config.SessionIdleTimeout
+		if !ss.confirmed {
+			limit = handshakeTimeout
+		}
+		if now.Sub(ss.lastSeen) <= limit {
+			continue
+		}
+		delete(s.byID, id)
+		delete(s.byInit, ss.clientRand)
+		if ss.addr != nil && s.byAddr[ss.addr.String()] == ss {
+			delete(s.byAddr, ss.addr.String())
+		}
+	}
+}
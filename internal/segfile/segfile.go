@@ -0,0 +1,403 @@
+// Package segfile dá acesso segmentado a arquivos em disco sem carregá-los
+// inteiros na memória: leitura de segmentos sob demanda (ReadAt) e cálculo
+// de SHA-256 em passagem streaming, com cache por caminho+mtime+tamanho.
+package segfile
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrIsDir é devolvido por Open quando path é um diretório.
+var ErrIsDir = errors.New("é diretório")
+
+// ErrTooLarge indica um arquivo com mais segmentos do que cabem no contador
+// de 32 bits do protocolo.
+var ErrTooLarge = errors.New("arquivo grande demais para o chunk")
+
+// Segments retorna quantos segmentos de chunk bytes compõem size bytes, ou
+// ErrTooLarge se a contagem não couber em uint32.
+func Segments(size int64, chunk int) (uint32, error) {
+	n := size / int64(chunk)
+	if size%int64(chunk) != 0 {
+		n++
+	}
+	if n > math.MaxUint32 {
+		return 0, ErrTooLarge
+	}
+	return uint32(n), nil
+}
+
+// Source lê segmentos de tamanho fixo de um arquivo aberto.
+type Source struct {
+	f     *os.File // arquivo de origem (ReadAt é seguro para uso concorrente)
+	size  int64    // tamanho do arquivo em bytes
+	chunk int      // tamanho de cada segmento
+	total uint32   // quantidade de segmentos
+}
+
+// Open abre path para leitura segmentada com segmentos de chunk bytes.
+func Open(path string, chunk int) (*Source, os.FileInfo, error) {
+	if chunk <= 0 {
+		return nil, nil, errors.New("tamanho de segmento inválido")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if st.IsDir() {
+		f.Close()
+		return nil, nil, ErrIsDir
+	}
+	total, err := Segments(st.Size(), chunk)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &Source{f: f, size: st.Size(), chunk: chunk, total: total}, st, nil
+}
+
+// Total retorna a quantidade de segmentos do arquivo.
+func (s *Source) Total() uint32 { return s.total }
+
+// Size retorna o tamanho do arquivo em bytes.
+func (s *Source) Size() int64 { return s.size }
+
+// Chunk retorna o tamanho nominal dos segmentos.
+func (s *Source) Chunk() int { return s.chunk }
+
+// ReadChunk lê o segmento seq em buf (que deve ter ao menos Chunk() bytes)
+// e retorna a fatia preenchida. O último segmento pode ser menor.
+func (s *Source) ReadChunk(seq uint32, buf []byte) ([]byte, error) {
+	if seq >= s.total {
+		return nil, errors.New("segmento fora do arquivo")
+	}
+	off := int64(seq) * int64(s.chunk)
+	n := s.chunk
+	if rem := s.size - off; rem < int64(n) {
+		n = int(rem)
+	}
+	if len(buf) < n {
+		return nil, errors.New("buffer menor que o segmento")
+	}
+	if _, err := s.f.ReadAt(buf[:n], off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Close fecha o arquivo de origem.
+func (s *Source) Close() error { return s.f.Close() }
+
+// entrada do cache de hashes
+type hashEntry struct {
+	mtime time.Time
+	size  int64
+	sha   string
+}
+
+var (
+	hashMu    sync.Mutex               // proteção do cache
+	hashCache = map[string]hashEntry{} // caminho absoluto -> hash da última versão vista
+)
+
+// HashFile retorna o SHA-256 (hex minúsculo) do arquivo em path, lendo-o em
+// passagem streaming. O resultado fica em cache enquanto mtime e tamanho
+// (informados por st) não mudarem.
+func HashFile(path string, st os.FileInfo) (string, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		key = path
+	}
+	if sha, ok := CachedHash(path, st); ok {
+		return sha, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sha := hex.EncodeToString(h.Sum(nil))
+	hashMu.Lock()
+	hashCache[key] = hashEntry{mtime: st.ModTime(), size: st.Size(), sha: sha}
+	hashMu.Unlock()
+	return sha, nil
+}
+
+// CachedHash retorna o SHA-256 de path se já estiver em cache para a versão
+// descrita por st (mesmo mtime e tamanho), sem ler o arquivo.
+func CachedHash(path string, st os.FileInfo) (string, bool) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		key = path
+	}
+	hashMu.Lock()
+	defer hashMu.Unlock()
+	e, ok := hashCache[key]
+	if !ok || e.size != st.Size() || !e.mtime.Equal(st.ModTime()) {
+		return "", false
+	}
+	return e.sha, true
+}
+
+// Bitmap registra quais segmentos já foram recebidos (1 bit por segmento).
+type Bitmap struct {
+	bits  []uint64 // palavras de 64 segmentos
+	n     uint32   // quantidade de segmentos
+	count uint32   // quantidade de bits marcados
+}
+
+// NewBitmap cria um bitmap vazio para n segmentos.
+func NewBitmap(n uint32) *Bitmap {
+	return &Bitmap{bits: make([]uint64, (uint64(n)+63)/64), n: n}
+}
+
+// Set marca o segmento i e retorna true se ele ainda não estava marcado.
+func (b *Bitmap) Set(i uint32) bool {
+	if i >= b.n {
+		return false
+	}
+	w, m := i/64, uint64(1)<<(i%64)
+	if b.bits[w]&m != 0 {
+		return false
+	}
+	b.bits[w] |= m
+	b.count++
+	return true
+}
+
+// Has informa se o segmento i está marcado.
+func (b *Bitmap) Has(i uint32) bool {
+	return i < b.n && b.bits[i/64]&(uint64(1)<<(i%64)) != 0
+}
+
+// Len retorna a quantidade de segmentos representados.
+func (b *Bitmap) Len() uint32 { return b.n }
+
+// Count retorna a quantidade de segmentos marcados.
+func (b *Bitmap) Count() uint32 { return b.count }
+
+// Complete informa se todos os segmentos estão marcados.
+func (b *Bitmap) Complete() bool { return b.count == b.n }
+
+// Missing retorna, em ordem crescente, os segmentos não marcados.
+func (b *Bitmap) Missing() []uint32 {
+	missing := make([]uint32, 0, b.n-b.count)
+	for w, word := range b.bits {
+		if word == ^uint64(0) {
+			continue
+		}
+		for j := uint32(0); j < 64; j++ {
+			i := uint32(w)*64 + j
+			if i >= b.n {
+				break
+			}
+			if word&(uint64(1)<<j) == 0 {
+				missing = append(missing, i)
+			}
+		}
+	}
+	return missing
+}
+
+// Bytes serializa o bitmap (palavras de 64 bits em little-endian) para
+// persistência.
+func (b *Bitmap) Bytes() []byte {
+	out := make([]byte, 8*len(b.bits))
+	for i, w := range b.bits {
+		binary.LittleEndian.PutUint64(out[i*8:], w)
+	}
+	return out
+}
+
+// BitmapFromBytes reconstrói um bitmap de n segmentos serializado por Bytes.
+func BitmapFromBytes(n uint32, data []byte) (*Bitmap, error) {
+	b := NewBitmap(n)
+	if len(data) != 8*len(b.bits) {
+		return nil, errors.New("bitmap com tamanho incompatível")
+	}
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(data[i*8:])
+		b.count += uint32(bits.OnesCount64(b.bits[i]))
+	}
+	if r := n % 64; r != 0 && len(b.bits) > 0 && b.bits[len(b.bits)-1]>>r != 0 {
+		return nil, errors.New("bitmap com bits além do total")
+	}
+	return b, nil
+}
+
+// Sink grava segmentos recebidos diretamente em suas posições de um arquivo
+// temporário pré-alocado ao lado do destino, sem mantê-los em memória.
+type Sink struct {
+	f     *os.File // arquivo temporário (dest + ".part")
+	path  string   // caminho do arquivo temporário
+	size  int64    // tamanho final esperado
+	chunk int      // tamanho nominal dos segmentos
+	recv  *Bitmap  // segmentos já gravados
+}
+
+// PartPath retorna o caminho do arquivo temporário usado para dest.
+func PartPath(dest string) string { return dest + ".part" }
+
+// Create cria (ou trunca) o arquivo temporário de dest, pré-alocado com size
+// bytes, para receber segmentos de chunk bytes.
+func Create(dest string, size int64, chunk int) (*Sink, error) {
+	if chunk <= 0 || size < 0 {
+		return nil, errors.New("parâmetros de segmentação inválidos")
+	}
+	total, err := Segments(size, chunk)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return nil, err
+	}
+	path := PartPath(dest)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &Sink{f: f, path: path, size: size, chunk: chunk, recv: NewBitmap(total)}, nil
+}
+
+// Reopen reabre o arquivo temporário existente de dest para continuar uma
+// recepção interrompida; recv indica os segmentos já gravados nele.
+func Reopen(dest string, size int64, chunk int, recv *Bitmap) (*Sink, error) {
+	if chunk <= 0 || size < 0 {
+		return nil, errors.New("parâmetros de segmentação inválidos")
+	}
+	total, err := Segments(size, chunk)
+	if err != nil {
+		return nil, err
+	}
+	if recv.Len() != total {
+		return nil, errors.New("bitmap incompatível com o arquivo")
+	}
+	path := PartPath(dest)
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil || st.Size() != size {
+		f.Close()
+		return nil, errors.New("arquivo parcial com tamanho inesperado")
+	}
+	return &Sink{f: f, path: path, size: size, chunk: chunk, recv: recv}, nil
+}
+
+// SegmentLen retorna o tamanho esperado do segmento seq.
+func (s *Sink) SegmentLen(seq uint32) int { return s.expectedLen(seq) }
+
+// expectedLen retorna o tamanho esperado do segmento seq.
+func (s *Sink) expectedLen(seq uint32) int {
+	off := int64(seq) * int64(s.chunk)
+	if rem := s.size - off; rem < int64(s.chunk) {
+		return int(rem)
+	}
+	return s.chunk
+}
+
+// WriteChunk grava o segmento seq na sua posição. Retorna false (sem erro) se
+// o segmento já havia sido gravado.
+func (s *Sink) WriteChunk(seq uint32, data []byte) (bool, error) {
+	if seq >= s.recv.Len() {
+		return false, errors.New("segmento fora do arquivo")
+	}
+	if s.recv.Has(seq) {
+		return false, nil
+	}
+	if len(data) != s.expectedLen(seq) {
+		return false, errors.New("tamanho de segmento inesperado")
+	}
+	if _, err := s.f.WriteAt(data, int64(seq)*int64(s.chunk)); err != nil {
+		return false, err
+	}
+	s.recv.Set(seq)
+	return true, nil
+}
+
+// ReadChunk lê de volta em buf o segmento seq já gravado e retorna a fatia
+// preenchida.
+func (s *Sink) ReadChunk(seq uint32, buf []byte) ([]byte, error) {
+	if !s.recv.Has(seq) {
+		return nil, errors.New("segmento ainda não gravado")
+	}
+	n := s.expectedLen(seq)
+	if len(buf) < n {
+		return nil, errors.New("buffer menor que o segmento")
+	}
+	if _, err := s.f.ReadAt(buf[:n], int64(seq)*int64(s.chunk)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Written retorna quantos bytes do arquivo já foram gravados.
+func (s *Sink) Written() int64 {
+	n := int64(s.recv.Count()) * int64(s.chunk)
+	if last := s.recv.Len(); last > 0 && s.recv.Has(last-1) {
+		n -= int64(s.chunk - s.expectedLen(last-1))
+	}
+	return n
+}
+
+// Received retorna o bitmap de segmentos gravados.
+func (s *Sink) Received() *Bitmap { return s.recv }
+
+// Hash relê o arquivo temporário e retorna seu SHA-256 (hex minúsculo).
+func (s *Sink) Hash() (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(s.f, 0, s.size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sync força a gravação em disco dos segmentos já escritos.
+func (s *Sink) Sync() error { return s.f.Sync() }
+
+// Close fecha o arquivo temporário mantendo-o em disco (para retomada).
+func (s *Sink) Close() error { return s.f.Close() }
+
+// Commit sincroniza e fecha o arquivo temporário e o renomeia atomicamente
+// para final.
+func (s *Sink) Commit(final string) error {
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(s.path, final)
+}
+
+// Abort fecha e remove o arquivo temporário.
+func (s *Sink) Abort() {
+	s.f.Close()
+	os.Remove(s.path)
+}
@@ -0,0 +1,99 @@
+package segfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// O bitmap serializado por Bytes volta igual por BitmapFromBytes, inclusive
+// nas bordas das palavras de 64 segmentos.
+func TestBitmapBytes(t *testing.T) {
+	for _, n := range []uint32{0, 1, 63, 64, 65, 200} {
+		b := NewBitmap(n)
+		for i := uint32(0); i < n; i += 3 {
+			b.Set(i)
+		}
+		if n > 0 {
+			b.Set(n - 1)
+		}
+		got, err := BitmapFromBytes(n, b.Bytes())
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if got.Len() != n || got.Count() != b.Count() || !reflect.DeepEqual(got.Missing(), b.Missing()) {
+			t.Fatalf("n=%d: %d de %d marcados, esperado %d de %d", n, got.Count(), got.Len(), b.Count(), n)
+		}
+		for i := range n {
+			if got.Has(i) != b.Has(i) {
+				t.Fatalf("n=%d: segmento %d marcado %v, esperado %v", n, i, got.Has(i), b.Has(i))
+			}
+		}
+	}
+}
+
+// Bits além do total, ou uma serialização de outro tamanho, são recusados.
+func TestBitmapFromBytesInvalid(t *testing.T) {
+	full := NewBitmap(128)
+	full.Set(65)
+	tests := []struct {
+		name string
+		n    uint32
+		data []byte
+	}{
+		{"bit além do total", 65, full.Bytes()[:16]},
+		{"último bit da palavra", 100, func() []byte { d := make([]byte, 16); d[15] = 0x80; return d }()},
+		{"curto", 65, full.Bytes()[:8]},
+		{"longo", 64, full.Bytes()},
+	}
+	for _, tt := range tests {
+		if _, err := BitmapFromBytes(tt.n, tt.data); err == nil {
+			t.Errorf("%s: aceito", tt.name)
+		}
+	}
+}
+
+// Reopen continua um arquivo parcial com os segmentos já gravados; um .part
+// de outro tamanho, ou um bitmap de outra contagem, é recusado.
+func TestReopen(t *testing.T) {
+	const chunk, size = 100, 250
+	dest := filepath.Join(t.TempDir(), "saida.bin")
+	s, err := Create(dest, size, chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := bytes.Repeat([]byte{7}, chunk)
+	if _, err := s.WriteChunk(1, seg); err != nil {
+		t.Fatal(err)
+	}
+	recv := s.Received()
+	s.Close()
+
+	r, err := Reopen(dest, size, chunk, recv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.ReadChunk(1, make([]byte, chunk)); err != nil || !bytes.Equal(got, seg) {
+		t.Fatalf("segmento gravado antes de reabrir: %v", err)
+	}
+	if ok, err := r.WriteChunk(1, seg); ok || err != nil {
+		t.Fatalf("segmento repetido gravado de novo: %v %v", ok, err)
+	}
+	r.Close()
+
+	if _, err := Reopen(dest, size, chunk, NewBitmap(4)); err == nil {
+		t.Fatal("bitmap de 4 segmentos aceito para 3")
+	}
+	if err := os.Truncate(PartPath(dest), size-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reopen(dest, size, chunk, recv); err == nil {
+		t.Fatal(".part com tamanho errado aceito")
+	}
+	os.Remove(PartPath(dest))
+	if _, err := Reopen(dest, size, chunk, recv); err == nil {
+		t.Fatal(".part ausente aceito")
+	}
+}
@@ -0,0 +1,47 @@
+package serverudp
+
+import (
+    "fmt"
+    "net"
+
+    "udp/internal/logger"
+    "udp/internal/protocol"
+)
+
+// evento da sessão com identificação, cliente, sentido e caminho preenchidos.
+func (s *session) event(typ logger.EventType) logger.Event {
+    return logger.Event{Type: typ, Session: logger.SessionID(s.id), Peer: s.peer().String(), Direction: s.dir, Path: s.path}
+}
+
+// indica se a falha da sessão já foi registrada.
+func (s *session) isFailed() bool {
+    s.mu.Lock(); defer s.mu.Unlock()
+    return s.failed
+}
+
+// registra no log de eventos, uma única vez, a falha da sessão; code é o
+// código do ERR enviado ao cliente (0 se nenhum).
+func (s *Server) fail(sess *session, code protocol.ErrCode, reason string) {
+    sess.mu.Lock()
+    done := sess.failed
+    sess.failed = true
+    sess.mu.Unlock()
+    if done { return }
+    e := sess.event(logger.EventFailed)
+    e.Code, e.Reason = uint16(code), reason
+    s.events.Emit(e)
+}
+
+// registra um pedido recusado antes de ter sessão (ERR ao REQ/PUT).
+func (s *Server) reject(addr *net.UDPAddr, path, dir string, err error) {
+    s.events.Emit(logger.Event{Type: logger.EventRejected, Peer: addr.String(), Direction: dir, Path: path, Code: uint16(errCode(err)), Reason: err.Error()})
+}
+
+// evento meta_sent com a descrição do arquivo enviada no META.
+func metaEvent(sess *session, meta protocol.Meta, resumed bool) logger.Event {
+    e := sess.event(logger.EventMetaSent)
+    e.Size, e.Total, e.Chunk, e.Resumed = meta.Size, meta.Total, meta.Chunk, resumed
+    if meta.Codec != protocol.CodecNone { e.Codec, e.EstimatedWire = meta.Codec.String(), meta.EstimatedCompressedSize }
+    if meta.FECData > 0 { e.FEC = fmt.Sprintf("%d:%d", meta.FECData, meta.FECParity) }
+    return e
+}
@@ -0,0 +1,73 @@
+package serverudp
+
+import (
+    "errors"
+    "net"
+    "time"
+
+    "udp/internal/protocol"
+)
+
+// Caminho legado: clientes v1 (protocol.LegacyVersion) não negociam, não
+// têm token nem sessão e não enviam FBK. O REQ v1 ganha uma sessão interna,
+// com as mesmas regras de admissão, banda e expiração das demais, mas META,
+// DATA, EOF e NACK vão no layout v1 e a transferência é identificada pelo
+// endereço do cliente (token 0 na chave de deduplicação), como no servidor
+// original. Sem FBK não há controle de congestionamento: os segmentos saem a
+// intervalos fixos de legacyInterval.
+
+// intervalo entre os segmentos de uma transferência v1 (o do servidor original)
+const legacyInterval = time.Millisecond
+
+// errStopped interrompe o envio de uma sessão liberada.
+var errStopped = errors.New("sessão encerrada")
+
+// retorna a transferência v1 do endereço addr, ou nil.
+func (s *Server) legacySession(addr *net.UDPAddr) *session {
+    s.mu.Lock(); defer s.mu.Unlock()
+    sess := s.pending[reqKey(addr, 0)]
+    if sess == nil || !sess.legacy || s.sessions[sess.id] == nil { return nil }
+    return sess
+}
+
+// encerra a transferência v1 anterior de addr antes de um REQ v1 novo: sem
+// token, só um REQ do mesmo arquivo durante o envio é uma retentativa (META
+// perdido); um pedido depois do envio, ou de outro arquivo, substitui o
+// anterior, como no servidor original.
+func (s *Server) replaceLegacy(addr *net.UDPAddr, path string) {
+    prev := s.legacySession(addr)
+    if prev == nil { return }
+    prev.mu.Lock(); sending := prev.sending; prev.mu.Unlock()
+    if sending && prev.path == path { return }
+    s.dropSession(prev)
+    if sending { s.fail(prev, 0, "substituída por outro REQ v1 do mesmo endereço") }
+    s.finish(prev)
+    prev.release()
+}
+
+// responde com ERR ao pedido req, no layout v1 se o REQ for v1.
+func (s *Server) replyReq(conn net.PacketConn, addr *net.UDPAddr, req protocol.Req, err error) {
+    if req.Version != protocol.LegacyVersion { s.replyErr(conn, addr, req.Token, err); return }
+    s.stats.AddError()
+    conn.WriteTo(protocol.CtrlLegacyERR(errMessage(err)), addr)
+}
+
+// aguarda a vez do próximo segmento da sessão: a janela de congestionamento,
+// ou legacyInterval nas transferências v1.
+func (s *session) pace() error {
+    if !s.legacy { return s.cc.Wait(s.stop) }
+    t := time.NewTimer(legacyInterval)
+    defer t.Stop()
+    select {
+    case <-t.C:
+        return nil
+    case <-s.stop:
+        return errStopped
+    }
+}
+
+// EOF da sessão, no layout do pedido.
+func (s *session) eof() []byte {
+    if s.legacy { return protocol.CtrlLegacyEOF() }
+    return protocol.CtrlEOF(s.id)
+}
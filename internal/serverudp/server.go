@@ -0,0 +1,304 @@
+package serverudp
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "udp/internal/capture"
+    "udp/internal/config"
+    "udp/internal/impair"
+    "udp/internal/logger"
+    "udp/internal/metrics"
+    "udp/internal/pmtu"
+    "udp/internal/protocol"
+    "udp/internal/ratelimit"
+    "udp/internal/secure"
+    "udp/internal/upload"
+)
+
+// ErrServerClosed é devolvido por Serve depois de Shutdown.
+var ErrServerClosed = errors.New("serverudp: servidor encerrado")
+
+// errShutdown recusa REQ/PUT novos enquanto o servidor encerra.
+var errShutdown error = &busyError{msg: "servidor ocupado: em encerramento", retryAfter: config.BusyRetryAfter}
+
+const (
+    shutdownPoll  = 100 * time.Millisecond // verificação das sessões durante o Shutdown
+    shutdownQuiet = 3 * time.Second        // silêncio do cliente que encerra uma sessão no Shutdown (rounds de NACK incluídos)
+)
+
+// Options configura um Server.
+type Options struct {
+    BaseDir    string       // diretório base dos arquivos servidos (REQ/LIST); "" = "."
+    UploadDir  string       // diretório que recebe os envios (PUT); "" desabilita
+    Quota      int64        // cota de bytes em UploadDir (0 = sem limite)
+    MaxClients int          // limite de clientes (endereços) com sessão em uso (0 = sem limite)
+    MaxTransfers int        // limite de transferências (sessões) em uso simultâneas (0 = sem limite)
+    MaxPerIP   int          // limite de transferências em uso por IP de cliente (0 = sem limite)
+    RetryAfter time.Duration // espera sugerida nas recusas por limite (0 = config.BusyRetryAfter)
+    RateLimit  ratelimit.Config // limites de banda dos envios: DATA, paridade e retransmissões (zero = sem limite)
+    PSK        []byte       // chave pré-compartilhada do modo cifrado (nil = sem cifra)
+    NoCompress bool         // não comprime os arquivos, mesmo para clientes v3 que aceitam
+    Log        func(string) // recebe as linhas de log (nil = descartadas)
+    Events     *logger.EventLog // recebe os eventos estruturados das transferências (nil = descartados)
+    Capture    *capture.Writer  // grava os datagramas do socket, já decifrados, em pcap (nil = sem captura)
+    Impair     *impair.Impairer // degrada o tráfego do socket (nil = rede real)
+}
+
+// Server atende REQ, NACK, FBK, PUT, LIST e PROBE num socket UDP, com
+// sessões, métricas e configuração próprias: um processo pode executar
+// vários servidores independentes.
+type Server struct {
+    log        func(string)          // destino das linhas de log
+    events     *logger.EventLog      // destino dos eventos das transferências (nil = descartados)
+    capture    *capture.Writer       // captura pcap dos datagramas (nil = sem captura)
+    impair     *impair.Impairer      // degradação do tráfego do socket (nil = rede real)
+    psk        []byte                // chave do modo cifrado (nil = sem cifra)
+    noCompress bool                  // compressão desligada (Options.NoCompress)
+    uploads    *upload.Store         // diretório e cota dos envios (PUT)
+    mu         sync.Mutex            // proteção de baseDir/limites/sessions/pending/conn
+    baseDir    string                // diretório base para servir arquivos
+    maxClients int                   // limite de clientes com sessão em uso (0 = sem limite)
+    maxTransfers int                 // limite de sessões em uso (0 = sem limite)
+    maxPerIP   int                   // limite de sessões em uso por IP (0 = sem limite)
+    retryAfter time.Duration         // espera sugerida no ERR BUSY
+    limits     *ratelimit.Limiter    // limites de banda dos envios
+    sessions   map[uint32]*session   // associação sessão -> transferência
+    pending    map[string]*session   // associação endereço+token do REQ -> sessão (REQs repetidos)
+    conn       net.PacketConn        // socket em uso por Serve (cifrado no modo PSK)
+    stats      *metrics.ServerMetrics // contadores e distribuições do servidor (Snapshot, OpenMetrics)
+    closing    atomic.Bool           // Shutdown iniciado: REQ/PUT novos são recusados
+    quit       chan struct{}         // fechado ao encerrar (interrompe o reapLoop)
+    quitOnce   sync.Once
+    handlers   sync.WaitGroup        // loop de leitura e handlers em andamento
+}
+
+// New cria um servidor com a configuração opts; ele só atende depois de Serve.
+func New(opts Options) *Server {
+    s := &Server{
+        log:        opts.Log,
+        events:     opts.Events,
+        capture:    opts.Capture,
+        impair:     opts.Impair,
+        psk:        opts.PSK,
+        noCompress: opts.NoCompress,
+        uploads:    upload.NewStore(),
+        maxClients: max(opts.MaxClients, 0),
+        maxTransfers: max(opts.MaxTransfers, 0),
+        maxPerIP:   max(opts.MaxPerIP, 0),
+        retryAfter: opts.RetryAfter,
+        limits:     ratelimit.New(opts.RateLimit),
+        stats:      metrics.NewServerMetrics(),
+        sessions:   map[uint32]*session{},
+        pending:    map[string]*session{},
+        quit:       make(chan struct{}),
+    }
+    if s.log == nil { s.log = func(string) {} }
+    if s.retryAfter <= 0 { s.retryAfter = config.BusyRetryAfter }
+    s.SetBaseDir(opts.BaseDir)
+    s.uploads.Configure(opts.UploadDir, opts.Quota)
+    return s
+}
+
+// SetBaseDir troca o diretório base dos arquivos servidos ("" = "."); vale
+// para os REQ e LIST seguintes.
+func (s *Server) SetBaseDir(dir string) {
+    if strings.TrimSpace(dir) == "" { dir = "." }
+    s.mu.Lock(); s.baseDir = dir; s.mu.Unlock()
+}
+
+// SetUploadDir troca o diretório que recebe os envios (PUT; vazio desabilita)
+// e a cota de bytes nele (0 = sem limite); vale para os PUT seguintes.
+func (s *Server) SetUploadDir(dir string, quota int64) { s.uploads.Configure(dir, quota) }
+
+// SetMaxClients troca o limite de clientes (endereços) com sessão em uso;
+// REQs e PUTs de clientes novos além dele recebem ERR BUSY. 0 = sem limite.
+func (s *Server) SetMaxClients(n int) { s.mu.Lock(); s.maxClients = max(n, 0); s.mu.Unlock() }
+
+// SetMaxTransfers troca o limite de transferências (REQ/PUT) em uso
+// simultâneas; as excedentes recebem ERR BUSY. 0 = sem limite.
+func (s *Server) SetMaxTransfers(n int) { s.mu.Lock(); s.maxTransfers = max(n, 0); s.mu.Unlock() }
+
+// SetMaxPerIP troca o limite de transferências em uso vindas de um mesmo IP
+// (várias portas contam juntas); as excedentes recebem ERR BUSY. 0 = sem limite.
+func (s *Server) SetMaxPerIP(n int) { s.mu.Lock(); s.maxPerIP = max(n, 0); s.mu.Unlock() }
+
+// SetRateLimit troca os limites de banda dos envios; as transferências em
+// andamento passam a segui-los a partir do próximo datagrama.
+func (s *Server) SetRateLimit(cfg ratelimit.Config) {
+    s.limits.Configure(cfg)
+    s.log("STATUS: limite de banda: " + cfg.String())
+}
+
+// recursos oferecidos na negociação (HELLO).
+func (s *Server) features() protocol.Features {
+    f := protocol.FeatureFEC | protocol.FeatureLargeChunks
+    if len(s.psk) > 0 { f |= protocol.FeatureEncryption }
+    if !s.noCompress { f |= protocol.FeatureCompression }
+    return f
+}
+
+// retorna o diretório base atual.
+func (s *Server) base() string {
+    s.mu.Lock(); defer s.mu.Unlock()
+    return s.baseDir
+}
+
+// Serve atende os datagramas de conn até Shutdown (retorna ErrServerClosed),
+// o cancelamento de ctx (retorna ctx.Err(), encerrando sem esperar as
+// transferências) ou uma falha do socket. O servidor assume conn: ajusta
+// buffers e DF e o fecha ao encerrar. Cada Server atende um único socket.
+func (s *Server) Serve(ctx context.Context, conn *net.UDPConn) error {
+    pc, err := s.prepare(conn)
+    if err != nil { return err }
+    return s.serve(ctx, pc)
+}
+
+// configura o socket (buffers, DF), aplica o envelope do modo cifrado e, por
+// fora dele, a degradação e a captura (que assim grava os datagramas decifrados).
+func (s *Server) prepare(conn *net.UDPConn) (net.PacketConn, error) {
+    // buffers maiores ajudam a suportar múltiplos clientes e bursts
+    _ = conn.SetReadBuffer(config.DefaultReadBuffer)
+    _ = conn.SetWriteBuffer(config.DefaultWriteBuffer)
+    // DF: datagramas acima do MTU são descartados em vez de fragmentados (PROBE/PROBEACK)
+    if err := pmtu.SetDontFragment(conn); err != nil {
+        s.log("WARN: não foi possível ativar DF no socket: " + err.Error())
+    }
+    var pc net.PacketConn = conn
+    if len(s.psk) > 0 {
+        sc, err := secure.NewServer(conn, s.psk)
+        if err != nil { return nil, err }
+        s.log("STATUS: modo cifrado ativo (AES-GCM com chave pré-compartilhada)")
+        pc = sc
+    }
+    if s.impair != nil { pc = s.impair.WrapPacketConn(pc) }
+    if s.capture != nil { pc = capture.WrapPacketConn(pc, s.capture) }
+    return pc, nil
+}
+
+// executa o loop de leitura em pc até o encerramento.
+func (s *Server) serve(ctx context.Context, pc net.PacketConn) error {
+    s.mu.Lock()
+    if s.closing.Load() || s.conn != nil {
+        s.mu.Unlock()
+        _ = pc.Close()
+        if s.closing.Load() { return ErrServerClosed }
+        return errors.New("serverudp: servidor já atende um socket")
+    }
+    s.conn = pc
+    s.handlers.Add(1) // antes de qualquer Shutdown esperar pelos handlers
+    s.mu.Unlock()
+    defer s.handlers.Done()
+    stop := context.AfterFunc(ctx, s.close)
+    defer stop()
+    go s.reapLoop()
+    err := s.packetLoop(pc)
+    switch {
+    case ctx.Err() != nil:
+        return ctx.Err()
+    case s.closing.Load():
+        return ErrServerClosed
+    }
+    s.close()
+    return err
+}
+
+// Shutdown encerra o servidor: recusa REQs e PUTs novos, espera as sessões
+// em uso (envios em andamento e clientes ainda enviando NACK/FBK) terminarem
+// ou ctx expirar, fecha o socket, interrompe o que restou e aguarda os
+// handlers. Retorna ctx.Err() se o prazo venceu antes das sessões.
+func (s *Server) Shutdown(ctx context.Context) error {
+    s.closing.Store(true)
+    tick := time.NewTicker(shutdownPoll)
+    defer tick.Stop()
+    var err error
+    for err == nil && s.busy() {
+        select {
+        case <-ctx.Done():
+            err = ctx.Err()
+        case <-tick.C:
+        }
+    }
+    s.close()
+    s.handlers.Wait()
+    return err
+}
+
+// indica se alguma sessão ainda envia ou recebe datagramas do cliente.
+func (s *Server) busy() bool {
+    s.mu.Lock(); defer s.mu.Unlock()
+    for _, sess := range s.sessions {
+        if sess.activeWithin(shutdownQuiet) { return true }
+    }
+    return false
+}
+
+// fecha o socket e libera todas as sessões, interrompendo os envios.
+func (s *Server) close() {
+    s.closing.Store(true)
+    s.quitOnce.Do(func() { close(s.quit) })
+    s.mu.Lock()
+    conn := s.conn
+    sessions := s.sessions
+    s.sessions = map[uint32]*session{}
+    s.pending = map[string]*session{}
+    s.mu.Unlock()
+    if conn != nil { _ = conn.Close() }
+    for _, sess := range sessions { s.finish(sess); sess.release() }
+}
+
+// Snapshot retorna uma cópia atômica das métricas do servidor, com o estado
+// de congestionamento das sessões ativas.
+func (s *Server) Snapshot() Metrics {
+    st := s.stats
+    m := Metrics{
+        BytesSent: atomic.LoadUint64(&st.TotalBytesSent),
+        SegmentsSent: atomic.LoadUint64(&st.TotalSegmentsSent),
+        NacksReceived: atomic.LoadUint64(&st.TotalNacksReceived),
+        Retransmissions: atomic.LoadUint64(&st.TotalRetransmissions),
+        ParitySent: atomic.LoadUint64(&st.TotalParitySent),
+        FECRecovered: atomic.LoadUint64(&st.TotalFECRecovered),
+        ActiveClients: atomic.LoadInt64(&st.ActiveConnections),
+        BytesReceived: atomic.LoadUint64(&st.TotalBytesReceived),
+        Uploads: atomic.LoadUint64(&st.TotalUploads),
+        Busy: atomic.LoadUint64(&st.TotalBusy),
+        RateLimit: s.limits.Config().String(),
+        Throttle: s.limits.Stats(),
+    }
+    s.mu.Lock()
+    conn := s.conn
+    // captura e degradação envolvem o socket cifrado
+    for {
+        w, ok := conn.(interface{ Unwrap() net.PacketConn })
+        if !ok { break }
+        conn = w.Unwrap()
+    }
+    if sc, ok := conn.(*secure.ServerConn); ok { m.Rejected = sc.Dropped() }
+    sessions := make([]*session, 0, len(s.sessions))
+    for _, sess := range s.sessions { sessions = append(sessions, sess) }
+    s.mu.Unlock()
+    var rtt time.Duration
+    for _, sess := range sessions {
+        st := SessionStats{ID: sess.id, Client: sess.peer().String(), Stats: sess.cc.Stats()}
+        m.Sessions = append(m.Sessions, st)
+        m.SendRate += st.SendRate
+        m.Cwnd += st.Cwnd
+        rtt += st.SRTT
+    }
+    if len(sessions) > 0 { m.RTT = rtt / time.Duration(len(sessions)) }
+    st.SetGauges(m.Rejected, m.SendRate)
+    return m
+}
+
+// MetricsHandler expõe as métricas do servidor por HTTP no formato texto
+// OpenMetrics: contadores, sessões ativas e histogramas de duração e perda
+// das transferências encerradas.
+func (s *Server) MetricsHandler() http.Handler {
+    return metrics.Handler(s.stats, func() { s.Snapshot() }) // Snapshot atualiza os valores instantâneos
+}
@@ -3,25 +3,141 @@
 package serverudp
 
 import (
+    "context"
     "errors"
     "fmt"
-    "io"
+    "io/fs"
+    "math/rand/v2"
     "net"
-    "os"
     "path/filepath"
     "strings"
     "sync"
-    "sync/atomic"
+    "syscall"
     "time"
 
+    "udp/internal/codec"
     "udp/internal/config"
+    "udp/internal/congestion"
+    "udp/internal/fec"
+    "udp/internal/listing"
+    "udp/internal/logger"
     "udp/internal/protocol"
+    "udp/internal/ratelimit"
+    "udp/internal/segfile"
+    "udp/internal/upload"
 )
 
-// representa um arquivo segmentado e seus metadados prontos para envio.
+// representa um arquivo aberto para envio segmentado e seus metadados.
+// Os segmentos são lidos do disco sob demanda, tanto no envio inicial
+// quanto nas retransmissões.
 type fileEntry struct {
-    meta   protocol.Meta // metadados do arquivo
-    chunks [][]byte      // segmentos do arquivo
+    meta protocol.Meta   // metadados do arquivo
+    src  *segfile.Source // leitura de segmentos sob demanda
+}
+
+// lê o segmento seq em buf e monta o datagrama DATA da sessão, com o
+// payload comprimido se o arquivo usa compressão e o segmento diminuir.
+// Retorna também o segmento original (entrada do FEC).
+func (e *fileEntry) packet(session, seq uint32, buf []byte) (pkt, chunk []byte, err error) {
+    chunk, err = e.src.ReadChunk(seq, buf)
+    if err != nil { return nil, nil, err }
+    h := protocol.DataHeader{Version: e.meta.Version, Session: session, Seq: seq, Total: e.meta.Total}
+    payload := chunk
+    if e.meta.Codec != protocol.CodecNone {
+        if z, err := codec.Compress(e.meta.Codec, chunk); err == nil && len(z) < len(chunk) {
+            h.Flags |= protocol.DataFlagCompressed
+            payload = z
+        }
+    }
+    h.Size, h.CRC32 = uint16(len(payload)), protocol.CRC32(payload)
+    return append(protocol.PackHeader(h), payload...), chunk, nil
+}
+
+// monta o datagrama de paridade j do bloco FEC block.
+func (e *fileEntry) parityPacket(session, block uint32, j int, parity []byte) []byte {
+    h := protocol.DataHeader{Flags: protocol.DataFlagParity, Session: session, Seq: block*uint32(e.meta.FECParity) + uint32(j), Total: e.meta.Total, Size: uint16(len(parity)), CRC32: protocol.CRC32(parity)}
+    return append(protocol.PackHeader(h), parity...)
+}
+
+// representa uma transferência em andamento, identificada pelo ID de sessão
+// atribuído no META. O endereço do cliente é atualizado a cada datagrama
+// recebido da sessão, de modo que uma troca de endereço (ex.: rebind de NAT)
+// não interrompe a transferência.
+type session struct {
+    id       uint32       // identificador da sessão (DATA/EOF/NACK)
+    token    uint32       // token do REQ que originou a sessão
+    key      string       // chave endereço+token do REQ original (deduplicação)
+    path     string       // caminho pedido (REQ) ou nome do envio (PUT), para o log de eventos
+    dir      string       // sentido da transferência: "download" ou "upload"
+    entry    *fileEntry   // arquivo sendo transferido
+    mu       sync.Mutex   // proteção de entry/addr/lastSeen/sending/observed/failed
+    addr     *net.UDPAddr // último endereço conhecido do cliente
+    start    time.Time    // admissão do pedido
+    lastSeen time.Time    // último datagrama recebido (ou envio concluído) da sessão
+    sending  bool         // envio inicial em andamento (sessão não expira)
+    cc       *congestion.Controller // janela e ritmo de envio (feedback FBK do cliente)
+    legacy   bool                   // pedido v1: sem sessão no fio nem FBK (legacy.go)
+    stop     chan struct{}          // fechado ao liberar a sessão (interrompe envios)
+    recovered uint32                // último contador de recuperados por FEC informado no FBK
+    up       *upload.Receiver       // recepção de um envio do cliente (PUT); nil em downloads
+    observed bool                   // transferência já registrada nas métricas (finish)
+    failed   bool                   // falha já registrada no log de eventos (fail)
+    stopOnce sync.Once
+}
+
+// retorna o arquivo da sessão, ou nil enquanto ainda está sendo carregado.
+func (s *session) file() *fileEntry {
+    s.mu.Lock(); defer s.mu.Unlock()
+    return s.entry
+}
+
+// marca início/fim do envio inicial.
+func (s *session) setSending(v bool) {
+    s.mu.Lock(); defer s.mu.Unlock()
+    s.sending = v
+    s.lastSeen = time.Now()
+}
+
+// retorna a recepção do envio da sessão, ou nil (download ou PUT ainda não aceito).
+func (s *session) upload() *upload.Receiver {
+    s.mu.Lock(); defer s.mu.Unlock()
+    return s.up
+}
+
+// retorna o endereço atual do cliente da sessão.
+func (s *session) peer() *net.UDPAddr {
+    s.mu.Lock(); defer s.mu.Unlock()
+    return s.addr
+}
+
+// registra atividade da sessão vinda de addr, migrando o endereço se mudou.
+func (s *session) touch(addr *net.UDPAddr, logAppend func(string)) {
+    s.mu.Lock()
+    moved := addr != nil && s.addr.String() != addr.String()
+    old := s.addr
+    if addr != nil { s.addr = addr }
+    s.lastSeen = time.Now()
+    s.mu.Unlock()
+    if moved && logAppend != nil {
+        logAppend(fmt.Sprintf("MIGRATE sessão=%08x %s -> %s", s.id, clientLabel(old), clientLabel(addr)))
+    }
+}
+
+// indica se a sessão está em uso: envio inicial em andamento ou datagrama
+// do cliente há menos de config.FeedbackTimeout (sessões concluídas só
+// expiram depois de config.SessionIdleTimeout, mas não ocupam vaga).
+func (s *session) inUse() bool { return s.activeWithin(config.FeedbackTimeout) }
+
+// indica se a sessão tem envio em andamento ou recebeu datagrama há menos de d.
+func (s *session) activeWithin(d time.Duration) bool {
+    s.mu.Lock(); defer s.mu.Unlock()
+    return s.sending || time.Since(s.lastSeen) < d
+}
+
+// indica se a sessão está ociosa há mais que o limite.
+func (s *session) idle(limit time.Duration) bool {
+    s.mu.Lock(); defer s.mu.Unlock()
+    return !s.sending && time.Since(s.lastSeen) > limit
 }
 
 // agrega estatísticas de execução do servidor.
@@ -30,17 +146,75 @@ type Metrics struct {
     SegmentsSent    uint64 // quantidade de segmentos iniciais enviados
     NacksReceived   uint64 // quantidade de NACKs recebidos
     Retransmissions uint64 // quantidade de segmentos retransmitidos
+    ParitySent      uint64 // quantidade de segmentos de paridade FEC enviados
+    FECRecovered    uint64 // segmentos reconstruídos por FEC nos clientes (via FBK)
+    Rejected        uint64 // datagramas descartados pelo modo cifrado (sem cifra, forjados ou repetidos)
+    BytesReceived   uint64 // bytes de segmentos gravados de envios dos clientes (PUT)
+    Uploads         uint64 // envios concluídos e verificados
+    Busy            uint64 // REQ/PUT recusados com ERR BUSY (limites de admissão ou encerramento)
     ActiveClients   int64  // estimativa de clientes ativos servidos
+    SendRate        float64        // taxa de envio agregada das sessões (bytes/s)
+    Cwnd            float64        // soma das janelas de congestionamento (datagramas)
+    RTT             time.Duration  // RTT suavizado médio das sessões
+    Sessions        []SessionStats // estado do controle de congestionamento por sessão
+    RateLimit       string         // limites de banda em vigor ("sem limite" se nenhum)
+    Throttle        []ratelimit.BucketStats // estado dos baldes de banda (global, sub-redes, clientes)
 }
 
-var (
-    activeMu        sync.Mutex              // proteção ao mapa de transfers
-    activeTransfers = map[string]*fileEntry{} // associação cliente -> arquivo atual
-    mtr             Metrics                 // agregador de métricas do servidor
-    srvConn         *net.UDPConn            // socket UDP do servidor
-    srvRunning      atomic.Bool             // sinalização de estado de execução
-    baseDir         = "."                   // diretório base para servir arquivos
-)
+// estado do controle de congestionamento de uma sessão.
+type SessionStats struct {
+    ID     uint32 // identificador da sessão
+    Client string // endereço atual do cliente
+    congestion.Stats
+}
+
+// busyError recusa um REQ/PUT por falta de capacidade (limites de admissão
+// ou encerramento); o cliente recebe ERR BUSY com a espera sugerida.
+type busyError struct {
+    msg        string
+    retryAfter time.Duration
+}
+
+func (e *busyError) Error() string {
+    return fmt.Sprintf("%s; tente novamente em %v", e.msg, e.retryAfter)
+}
+
+// classifica uma falha ao atender um pedido no código do ERR.
+func errCode(err error) protocol.ErrCode {
+    switch {
+    case errors.As(err, new(*busyError)):
+        return protocol.ErrCodeBusy
+    case errors.Is(err, fs.ErrNotExist), errors.Is(err, listing.ErrNotFound):
+        return protocol.ErrCodeNotFound
+    case errors.Is(err, segfile.ErrIsDir):
+        return protocol.ErrCodeIsDirectory
+    case errors.Is(err, fs.ErrPermission), errors.Is(err, listing.ErrUnreadable), errors.Is(err, upload.ErrDisabled):
+        return protocol.ErrCodePermission
+    case errors.Is(err, listing.ErrInvalidPath), errors.Is(err, listing.ErrNotDir), errors.Is(err, listing.ErrPageTooSmall), errors.Is(err, upload.ErrInvalidName), errors.Is(err, upload.ErrExists):
+        return protocol.ErrCodePathRejected
+    case errors.Is(err, upload.ErrQuota), errors.Is(err, upload.ErrNoSpace), errors.Is(err, segfile.ErrTooLarge):
+        return protocol.ErrCodeQuotaExceeded
+    }
+    return protocol.ErrCodeInternal
+}
+
+// código (errCode) e mensagem do ERR de uma falha. Falhas do sistema de
+// arquivos levam só a descrição do código, sem caminhos do servidor.
+func errMessage(err error) (protocol.ErrCode, string) {
+    code := errCode(err)
+    var pe *fs.PathError
+    if errors.As(err, &pe) || code == protocol.ErrCodeInternal { return code, code.String() }
+    return code, err.Error()
+}
+
+// responde ao pedido token com ERR classificado por errMessage.
+func (s *Server) replyErr(conn net.PacketConn, addr net.Addr, token uint32, err error) {
+    s.stats.AddError()
+    var be *busyError
+    if errors.As(err, &be) { conn.WriteTo(protocol.CtrlBUSY(token, err.Error(), be.retryAfter), addr); return }
+    code, msg := errMessage(err)
+    conn.WriteTo(protocol.CtrlERR(token, code, msg), addr)
+}
 
 // formata representação do cliente para logs
 func clientLabel(addr *net.UDPAddr) string {
@@ -48,143 +222,598 @@ func clientLabel(addr *net.UDPAddr) string {
     return fmt.Sprintf("client=%s:%d", addr.IP.String(), addr.Port)
 }
 
-// Retorna uma cópia atômica das métricas atuais.
-func Snapshot() Metrics { return Metrics{
-    BytesSent: atomic.LoadUint64(&mtr.BytesSent),
-    SegmentsSent: atomic.LoadUint64(&mtr.SegmentsSent),
-    NacksReceived: atomic.LoadUint64(&mtr.NacksReceived),
-    Retransmissions: atomic.LoadUint64(&mtr.Retransmissions),
-    ActiveClients: atomic.LoadInt64(&mtr.ActiveClients),
-} }
+// chave de deduplicação de REQ: o mesmo token vindo do mesmo endereço é uma retentativa.
+func reqKey(addr *net.UDPAddr, token uint32) string {
+    return fmt.Sprintf("%s#%d", addr.String(), token)
+}
 
-// Carrega e segmenta um arquivo do disco, calculando o SHA-256.
-func loadFile(path string) (*fileEntry, error) {
-    st, err := os.Stat(path) // estatísticas do arquivo
-    if err != nil { return nil, err }
-    if st.IsDir() { return nil, errors.New("é diretório") }
-    f, err := os.Open(path) // arquivo de entrada
+// reserva uma sessão para o REQ (addr, token) com identificador aleatório único
+// e não nulo. Se o mesmo REQ já possui sessão, retorna-a com dup=true. Pedidos
+// novos além dos limites de admissão são recusados (*busyError); durante o
+// encerramento nenhuma sessão nova é criada (errShutdown). path e dir
+// identificam a transferência no log de eventos.
+func (s *Server) claimSession(addr *net.UDPAddr, token uint32, path, dir string) (sess *session, dup bool, err error) {
+    s.mu.Lock(); defer s.mu.Unlock()
+    if prev := s.pending[reqKey(addr, token)]; prev != nil && s.sessions[prev.id] != nil {
+        return prev, true, nil
+    }
+    err = errShutdown
+    if !s.closing.Load() { err = s.admit(addr) }
+    if err != nil {
+        s.stats.AddBusy()
+        return nil, false, err
+    }
+    var id uint32
+    for id == 0 || s.sessions[id] != nil { id = rand.Uint32() }
+    sess = &session{id: id, token: token, key: reqKey(addr, token), path: path, dir: dir, addr: addr, start: time.Now(), lastSeen: time.Now(), sending: true, cc: congestion.New(), stop: make(chan struct{})}
+    s.sessions[id] = sess
+    s.pending[sess.key] = sess
+    return sess, false, nil
+}
+
+// verifica os limites de admissão de um pedido novo de addr (com s.mu
+// adquirido). Clientes distintos contam as sessões em uso (um endereço que já
+// tem sessão não ocupa vaga nova); transferências, no total e por IP, contam
+// as sessões enviando ou com o cliente ativo há menos de shutdownQuiet, o
+// silêncio que encerra uma transferência já entregue (inclui rounds de NACK).
+func (s *Server) admit(addr *net.UDPAddr) error {
+    if s.maxClients == 0 && s.maxTransfers == 0 && s.maxPerIP == 0 { return nil }
+    clients := map[string]bool{}
+    transfers, fromIP := 0, 0
+    for _, other := range s.sessions {
+        if !other.inUse() { continue }
+        peer := other.peer()
+        clients[peer.String()] = true
+        if !other.activeWithin(shutdownQuiet) { continue }
+        transfers++
+        if peer.IP.Equal(addr.IP) { fromIP++ }
+    }
+    switch {
+    case s.maxTransfers > 0 && transfers >= s.maxTransfers:
+        return &busyError{msg: fmt.Sprintf("servidor ocupado: limite de %d transferências simultâneas atingido", s.maxTransfers), retryAfter: s.retryAfter}
+    case s.maxPerIP > 0 && fromIP >= s.maxPerIP:
+        return &busyError{msg: fmt.Sprintf("servidor ocupado: limite de %d transferências por IP atingido", s.maxPerIP), retryAfter: s.retryAfter}
+    case s.maxClients > 0 && !clients[addr.String()] && len(clients) >= s.maxClients:
+        return &busyError{msg: fmt.Sprintf("servidor ocupado: limite de %d clientes atingido", s.maxClients), retryAfter: s.retryAfter}
+    }
+    return nil
+}
+
+// aguarda banda para n bytes ao cliente da sessão (limites de ratelimit);
+// falha só se a sessão for liberada durante a espera.
+func (s *Server) throttle(sess *session, n int) error {
+    return s.limits.Wait(sess.peer().AddrPort().Addr(), n, sess.stop)
+}
+
+// registra nas métricas, uma única vez, a transferência de uma sessão que
+// deixou de estar em uso ou foi encerrada: a duração vai do pedido ao último
+// datagrama do cliente (ou ao fim do envio) e, nos downloads, a perda vem do
+// feedback do cliente.
+func (s *Server) finish(sess *session) {
+    entry, up := sess.file(), sess.upload()
+    if entry == nil && up == nil { return }
+    sess.mu.Lock()
+    d, done, sending := sess.lastSeen.Sub(sess.start), sess.observed, sess.sending
+    sess.observed = true
+    sess.mu.Unlock()
+    if done { return }
+    loss := -1.0
+    if entry != nil { loss = sess.cc.Stats().LossRatio() }
+    s.stats.ObserveTransfer(up != nil, d, loss)
+    // envios (PUT) registram o desfecho em receiveFile, com a verificação do arquivo
+    if up != nil { return }
+    if sending { s.fail(sess, 0, "servidor encerrado durante o envio"); return }
+    if sess.isFailed() { return }
+    e := sess.event(logger.EventCompleted)
+    e.Size, e.Total, e.Duration, e.Loss = entry.meta.Size, entry.meta.Total, logger.Millis(d), max(loss, 0)
+    s.events.Emit(e)
+}
+
+// remove a sessão dos mapas ativos.
+func (s *Server) dropSession(sess *session) {
+    s.mu.Lock(); defer s.mu.Unlock()
+    delete(s.sessions, sess.id)
+    delete(s.pending, sess.key)
+}
+
+// interrompe os envios em andamento e libera o arquivo aberto pela sessão.
+func (s *session) release() {
+    s.stopOnce.Do(func() { close(s.stop) })
+    if e := s.file(); e != nil { _ = e.src.Close() }
+}
+
+// busca a sessão pelo identificador.
+func (s *Server) lookupSession(id uint32) *session {
+    s.mu.Lock(); defer s.mu.Unlock()
+    return s.sessions[id]
+}
+
+// remove sessões ociosas há mais de config.SessionIdleTimeout.
+func (s *Server) reapSessions() {
+    s.mu.Lock()
+    var expired []*session
+    var ended []*session // transferências que deixaram de estar em uso
+    for id, sess := range s.sessions {
+        if sess.idle(config.SessionIdleTimeout) {
+            delete(s.sessions, id)
+            expired = append(expired, sess)
+        } else if !sess.inUse() {
+            ended = append(ended, sess)
+        }
+    }
+    for k, sess := range s.pending {
+        if s.sessions[sess.id] == nil { delete(s.pending, k) }
+    }
+    s.mu.Unlock()
+    for _, sess := range ended { s.finish(sess) }
+    for _, sess := range expired {
+        s.finish(sess)
+        sess.release()
+        s.log(fmt.Sprintf("EXPIRE sessão=%08x %s", sess.id, clientLabel(sess.peer())))
+    }
+}
+
+// Abre um arquivo para envio em segmentos de chunk bytes, obtendo o SHA-256 em
+// passagem streaming (ou do cache, se o arquivo não mudou desde o último cálculo).
+func loadFile(path string, chunk int, codecs protocol.CodecSet) (*fileEntry, error) {
+    src, st, err := segfile.Open(path, chunk) // leitura sob demanda
     if err != nil { return nil, err }
-    defer f.Close()
-    var chunks [][]byte // lista de segmentos lidos
-    for {
-    buf := make([]byte, config.ChunkSize) // buffer de leitura
-    n, err := f.Read(buf)                   // bytes lidos neste ciclo
-        if n > 0 { chunks = append(chunks, append([]byte(nil), buf[:n]...)) }
-        if err == io.EOF { break }
-        if err != nil { return nil, err }
+    sha, err := segfile.HashFile(path, st) // hash do arquivo completo (Aplicação)
+    if err != nil { src.Close(); return nil, err }
+    meta := protocol.Meta{Filename: filepath.Base(path), Total: src.Total(), Size: src.Size(), SHA256: sha, Chunk: chunk, EstimatedCompressedSize: src.Size()} // Cabeçalho META (Aplicação)
+    // compressão: só se o codec aceito pelo cliente economizar ao menos codec.MinSaving
+    if c := codec.Choose(codecs, path); c != protocol.CodecNone && src.Size() > 0 {
+        wire, err := codec.Sample(src, c) // estimativa por amostra: o arquivo não é comprimido antes do META
+        if err != nil { src.Close(); return nil, err }
+        if float64(wire) <= float64(src.Size())*(1-codec.MinSaving) { meta.Codec, meta.EstimatedCompressedSize = c, wire }
     }
-    sha := protocol.SHA256FileChunks(chunks) // hash do arquivo por chunks (Aplicação)
-    meta := protocol.Meta{Filename: filepath.Base(path), Total: uint32(len(chunks)), Size: st.Size(), SHA256: sha, Chunk: config.ChunkSize} // Cabeçalho META (Aplicação)
-    return &fileEntry{meta: meta, chunks: chunks}, nil
+    return &fileEntry{meta: meta, src: src}, nil
 }
 
-// Processa uma requisição de arquivo do cliente, enviando META/DATA/EOF.
-func handleREQ(conn *net.UDPConn, addr *net.UDPAddr, req protocol.Req, logAppend func(string)) {
+// Admite uma requisição de arquivo do cliente no loop de leitura (limites e
+// REQs repetidos) e só então dispara o envio numa goroutine: pedidos recusados
+// não criam goroutines.
+func (s *Server) handleREQ(conn net.PacketConn, addr *net.UDPAddr, req protocol.Req) {
+    legacy := req.Version == protocol.LegacyVersion // REQ v1: sem token nem sessão
     // Caminho solicitado relativo ao diretório base
     safe := filepath.Clean(req.Path) // caminho sanitizado
     if safe == "." || safe == ".." || strings.HasPrefix(safe, "..") {
-        b := protocol.CtrlERR("caminho inválido") // payload de erro compacto
-        conn.WriteToUDP(b, addr)
+        s.replyReq(conn, addr, req, listing.ErrInvalidPath)
+        s.reject(addr, req.Path, "download", listing.ErrInvalidPath)
+        return
+    }
+    if legacy { s.replaceLegacy(addr, req.Path) }
+    sess, dup, err := s.claimSession(addr, req.Token, req.Path, "download")
+    if err != nil {
+        s.replyReq(conn, addr, req, err)
+        s.reject(addr, req.Path, "download", err)
+        s.log(fmt.Sprintf("WARN: REQ <- %s recusado: %v", clientLabel(addr), err))
+        return
+    }
+    if dup {
+        // REQ repetido (META perdido): reenvia o META sem reiniciar o envio;
+        // se o arquivo ainda está sendo carregado, o META sairá em seguida.
+        sess.touch(addr, s.log)
+        if entry := sess.file(); entry != nil { conn.WriteTo(protocol.CtrlMETA(entry.meta), addr) }
         return
     }
-    targetPath := filepath.Join(baseDir, safe) // caminho relativo ao diretório base
-    entry, err := loadFile(targetPath)        // arquivo segmentado
+    sess.legacy = legacy
+    if legacy { s.log(fmt.Sprintf("REQ v1 <- %s arquivo=%s (sem sessão; interna %08x)", clientLabel(addr), req.Path, sess.id)) }
+    s.events.Emit(sess.event(logger.EventSessionStart))
+    s.spawn(func() { s.sendFile(conn, addr, req, sess) })
+}
+
+// Envia o arquivo pedido na sessão admitida: META, DATA (com paridade FEC) e EOF.
+func (s *Server) sendFile(conn net.PacketConn, addr *net.UDPAddr, req protocol.Req, sess *session) {
+    defer sess.setSending(false)
+    targetPath := filepath.Join(s.base(), filepath.Clean(req.Path)) // caminho já validado em handleREQ // caminho relativo ao diretório base
+    chunk := protocol.NegotiateChunk(req.MaxDatagram, config.MaxChunkSize) // segmento dentro do datagrama aceito pelo cliente
+    var codecs protocol.CodecSet // codecs aceitos pelo cliente (REQ v3) e oferecidos pelo servidor
+    if req.Version >= protocol.CompressionVersion && !s.noCompress { codecs = req.Codecs }
+    entry, err := loadFile(targetPath, chunk, codecs) // arquivo segmentado
     if err != nil {
-        b := protocol.CtrlERR("arquivo não encontrado")
-        conn.WriteToUDP(b, addr)
+        s.dropSession(sess)
+        s.replyReq(conn, addr, req, err)
+        s.fail(sess, errCode(err), err.Error())
+        s.log(fmt.Sprintf("WARN: REQ <- %s arquivo=%s recusado: %v", clientLabel(addr), req.Path, err))
         return
     }
-    activeMu.Lock(); activeTransfers[addr.String()] = entry; activeMu.Unlock()
-    atomic.AddInt64(&mtr.ActiveClients, 1)
-    defer atomic.AddInt64(&mtr.ActiveClients, -1)
+    entry.meta.Session = sess.id
+    entry.meta.Token = req.Token
+    entry.meta.Version = req.Version // META (e DATA, na v1) no mesmo layout do REQ
+    if !sess.legacy { entry.meta.FECData, entry.meta.FECParity = protocol.NegotiateFEC(req.FECData, req.FECParity) }
+    sess.mu.Lock(); sess.entry = entry; sess.mu.Unlock()
+    s.stats.AddConnection()
+    defer s.stats.RemoveConnection()
 
     // META (controle UC)
-    conn.WriteToUDP(protocol.CtrlMETA(entry.meta), addr)
-    logAppend(fmt.Sprintf("META -> %s total=%d size=%d", clientLabel(addr), entry.meta.Total, entry.meta.Size))
-    for i, chunk := range entry.chunks {
-        h := protocol.DataHeader{Seq: uint32(i), Total: uint32(len(entry.chunks)), Size: uint16(len(chunk)), CRC32: protocol.CRC32(chunk)}
-        pkt := append(protocol.PackHeader(h), chunk...)
-        n, _ := conn.WriteToUDP(pkt, addr)
-        atomic.AddUint64(&mtr.BytesSent, uint64(n))
-        atomic.AddUint64(&mtr.SegmentsSent, 1)
-        time.Sleep(1 * time.Millisecond)
+    conn.WriteTo(protocol.CtrlMETA(entry.meta), sess.peer())
+    s.log(fmt.Sprintf("META -> %s sessão=%08x total=%d size=%d chunk=%d fec=%d:%d codec=%s comprimido_estimado=%d", clientLabel(addr), sess.id, entry.meta.Total, entry.meta.Size, chunk, entry.meta.FECData, entry.meta.FECParity, entry.meta.Codec, entry.meta.EstimatedCompressedSize))
+    s.events.Emit(metaEvent(sess, entry.meta, req.Flags&protocol.ReqFlagResume != 0))
+    if req.Flags&protocol.ReqFlagResume != 0 {
+        // retomada: o cliente pedirá por NACK apenas os segmentos que não possui
+        s.log(fmt.Sprintf("RESUME <- %s sessão=%08x aguardando NACKs", clientLabel(addr), sess.id))
+        return
+    }
+    var enc *fec.Encoder // paridade dos blocos (nil sem FEC)
+    if entry.meta.FECData > 0 {
+        if enc, err = fec.NewEncoder(entry.meta.FECData, entry.meta.FECParity, chunk); err != nil {
+            s.log(fmt.Sprintf("ERRO: FEC sessão=%08x: %v", sess.id, err))
+            s.fail(sess, 0, err.Error())
+            return
+        }
+    }
+    sent := &logger.SegmentBatcher{Log: s.events, Base: sess.event("")} // faixas do evento segments_sent
+    // interrompe o envio: fecha a faixa em andamento e registra a falha
+    abort := func(err error) { sent.Flush(); s.fail(sess, 0, err.Error()) }
+    buf := make([]byte, entry.src.Chunk()) // buffer de leitura reutilizado
+    for i := uint32(0); i < entry.meta.Total; i++ {
+        // janela de congestionamento e pacing no lugar de um intervalo fixo (só na v1)
+        if err := sess.pace(); err != nil {
+            s.log(fmt.Sprintf("ABORT -> %s sessão=%08x seq=%d: %v", clientLabel(sess.peer()), sess.id, i, err))
+            if errors.Is(err, congestion.ErrStalled) { s.stats.AddTimeout() }
+            abort(err)
+            return
+        }
+        pkt, raw, err := entry.packet(sess.id, i, buf)
+        if err != nil {
+            s.log(fmt.Sprintf("ERRO: leitura seq=%d sessão=%08x: %v", i, sess.id, err))
+            abort(err)
+            return
+        }
+        if err := s.throttle(sess, len(pkt)); err != nil { abort(err); return }
+        n, err := conn.WriteTo(pkt, sess.peer())
+        if errors.Is(err, syscall.EMSGSIZE) {
+            // DF ligado: o datagrama excede o MTU conhecido do caminho
+            s.log(fmt.Sprintf("ABORT -> %s sessão=%08x: datagrama de %d bytes excede o MTU (%v)", clientLabel(sess.peer()), sess.id, len(pkt), err))
+            abort(err)
+            return
+        }
+        sess.cc.OnSent(i, n)
+        s.stats.AddBytesSent(uint64(n))
+        s.stats.AddSegmentsSent(1)
+        sent.Add(i, n)
+        // bloco FEC completo (ou fim do arquivo): envia as K paridades
+        if enc != nil && (enc.Add(raw) || i == entry.meta.Total-1) {
+            if err := s.sendParity(conn, sess, entry, enc, i/uint32(entry.meta.FECData)); err != nil { abort(err); return }
+        }
     }
+    sent.Flush()
     // EOF (controle UC)
-    conn.WriteToUDP(protocol.CtrlEOF(), addr)
-    logAppend(fmt.Sprintf("EOF -> %s segmentos=%d", clientLabel(addr), len(entry.chunks)))
+    conn.WriteTo(sess.eof(), sess.peer())
+    s.log(fmt.Sprintf("EOF -> %s sessão=%08x segmentos=%d", clientLabel(sess.peer()), sess.id, entry.meta.Total))
+    e := sess.event(logger.EventEOFSent)
+    e.Total = entry.meta.Total
+    s.events.Emit(e)
+}
+
+// envia as paridades do bloco FEC block, respeitando a janela de congestionamento
+// e os limites de banda; o erro interrompe o envio.
+func (s *Server) sendParity(conn net.PacketConn, sess *session, entry *fileEntry, enc *fec.Encoder, block uint32) error {
+    parity, err := enc.Flush()
+    if err != nil {
+        s.log(fmt.Sprintf("ERRO: FEC bloco=%d sessão=%08x: %v", block, sess.id, err))
+        return err
+    }
+    for j, p := range parity {
+        if err := sess.cc.Wait(sess.stop); err != nil {
+            s.log(fmt.Sprintf("ABORT -> %s sessão=%08x bloco=%d: %v", clientLabel(sess.peer()), sess.id, block, err))
+            if errors.Is(err, congestion.ErrStalled) { s.stats.AddTimeout() }
+            return err
+        }
+        pkt := entry.parityPacket(sess.id, block, j, p)
+        if err := s.throttle(sess, len(pkt)); err != nil { return err }
+        n, _ := conn.WriteTo(pkt, sess.peer())
+        sess.cc.OnSentUntracked(n)
+        s.stats.AddBytesSent(uint64(n))
+        s.stats.AddParitySent(1)
+    }
+    return nil
+}
+
+// Processa o anúncio de envio de um arquivo pelo cliente: admite-o no loop de
+// leitura, como o REQ, e dispara a recepção, que aceita com META (sessão e
+// chunk) se houver diretório de upload, nome válido e cota e recebe os
+// segmentos até confirmar com DONE.
+func (s *Server) handlePUT(conn net.PacketConn, addr *net.UDPAddr, put protocol.Put) {
+    sess, dup, err := s.claimSession(addr, put.Token, put.Name, "upload")
+    if err != nil {
+        s.replyErr(conn, addr, put.Token, err)
+        s.reject(addr, put.Name, "upload", err)
+        s.log(fmt.Sprintf("WARN: PUT <- %s recusado: %v", clientLabel(addr), err))
+        return
+    }
+    if dup {
+        // PUT repetido (META perdido): reenvia o aceite
+        sess.touch(addr, s.log)
+        if up := sess.upload(); up != nil { conn.WriteTo(protocol.CtrlMETA(up.Meta()), addr) }
+        return
+    }
+    s.events.Emit(sess.event(logger.EventSessionStart))
+    s.spawn(func() { s.receiveFile(conn, addr, put, sess) })
+}
+
+// Recebe o envio na sessão admitida: reserva o destino e a cota, aceita com
+// META e grava os segmentos até o DONE.
+func (s *Server) receiveFile(conn net.PacketConn, addr *net.UDPAddr, put protocol.Put, sess *session) {
+    defer sess.setSending(false)
+    chunk := protocol.NegotiateChunk(put.MaxDatagram, config.MaxChunkSize) // segmento dentro do datagrama proposto pelo cliente
+    var total uint32
+    dest, release, err := s.uploads.Reserve(put.Name, put.Size)
+    if err == nil {
+        if total, err = segfile.Segments(put.Size, chunk); err != nil { release() }
+    }
+    if err != nil {
+        s.dropSession(sess)
+        s.replyErr(conn, addr, put.Token, err)
+        s.fail(sess, errCode(err), err.Error())
+        s.log(fmt.Sprintf("PUT <- %s arquivo=%s recusado: %v", clientLabel(addr), put.Name, err))
+        return
+    }
+    defer release()
+    meta := protocol.Meta{Session: sess.id, Token: put.Token, Filename: put.Name, Size: put.Size, SHA256: put.SHA256, Chunk: chunk, Total: total}
+    up, err := upload.NewReceiver(meta, dest, func(b []byte) { conn.WriteTo(b, sess.peer()) })
+    if err != nil {
+        s.dropSession(sess)
+        conn.WriteTo(protocol.CtrlERR(put.Token, protocol.ErrCodeInternal, "diretório de upload indisponível"), addr)
+        s.fail(sess, protocol.ErrCodeInternal, err.Error())
+        s.log(fmt.Sprintf("ERRO: PUT <- %s arquivo=%s: %v", clientLabel(addr), put.Name, err))
+        return
+    }
+    up.OnBytes = func(n int) { s.stats.AddBytesReceived(uint64(n)) }
+    sess.mu.Lock(); sess.up = up; sess.mu.Unlock()
+    s.stats.AddConnection()
+    defer s.stats.RemoveConnection()
+
+    conn.WriteTo(protocol.CtrlMETA(meta), addr)
+    s.log(fmt.Sprintf("PUT <- %s sessão=%08x arquivo=%s total=%d size=%d chunk=%d", clientLabel(addr), sess.id, put.Name, meta.Total, meta.Size, chunk))
+    s.events.Emit(metaEvent(sess, meta, false))
+    start := time.Now()
+    if err := up.Run(sess.stop); err != nil {
+        s.log(fmt.Sprintf("ERRO: PUT sessão=%08x arquivo=%s: %v", sess.id, put.Name, err))
+        if errors.Is(err, upload.ErrNoData) { s.stats.AddTimeout() }
+        s.fail(sess, 0, err.Error())
+        return
+    }
+    s.stats.AddUpload()
+    s.log(fmt.Sprintf("DONE -> %s sessão=%08x arquivo=%s gravado em %v", clientLabel(sess.peer()), sess.id, dest, time.Since(start).Round(time.Millisecond)))
+    e := sess.event(logger.EventCompleted)
+    e.Size, e.Total, e.Bytes, e.Duration = meta.Size, meta.Total, uint64(meta.Size), logger.Millis(time.Since(start))
+    s.events.Emit(e)
 }
 
 // Atende pedidos de retransmissão para segmentos listados como faltantes.
-func handleNACK(conn *net.UDPConn, addr *net.UDPAddr, nack protocol.Nack) {
-    atomic.AddUint64(&mtr.NacksReceived, 1)
-    activeMu.Lock(); entry := activeTransfers[addr.String()]; activeMu.Unlock() // busca do arquivo em andamento
+func (s *Server) handleNACK(conn net.PacketConn, sess *session, nack protocol.Nack) {
+    s.stats.AddNack()
+    entry := sess.file() // arquivo em andamento
     if entry == nil { return }
+    buf := make([]byte, entry.src.Chunk()) // buffer de leitura reutilizado
+    e := sess.event(logger.EventRetransmit) // segmentos reenviados para este NACK
+    defer func() { if e.Count > 0 { s.events.Emit(e) } }()
     for _, seq := range nack.Missing {
-        if int(seq) < len(entry.chunks) {
-            chunk := entry.chunks[seq]                                                                                          // segmento requerido
-            h := protocol.DataHeader{Seq: uint32(seq), Total: uint32(len(entry.chunks)), Size: uint16(len(chunk)), CRC32: protocol.CRC32(chunk)} // cabeçalho de retransmissão
-            pkt := append(protocol.PackHeader(h), chunk...)                                                                      // pacote de retransmissão
-            n, _ := conn.WriteToUDP(pkt, addr)                                                                                   // bytes reenviados
-            atomic.AddUint64(&mtr.BytesSent, uint64(n))
-            atomic.AddUint64(&mtr.Retransmissions, 1)
-            time.Sleep(0) // cedência de escalonamento
+        if seq < entry.meta.Total {
+            // retransmissões compartilham a janela do envio inicial
+            if err := sess.pace(); err != nil { return }
+            pkt, _, err := entry.packet(sess.id, seq, buf) // pacote de retransmissão lido do disco
+            if err != nil { continue }
+            if err := s.throttle(sess, len(pkt)); err != nil { return }
+            n, _ := conn.WriteTo(pkt, sess.peer()) // bytes reenviados
+            sess.cc.OnSent(seq, n)
+            s.stats.AddBytesSent(uint64(n))
+            s.stats.AddRetransmission()
+            e.Count++
+            e.Bytes += uint64(n)
         }
     }
 }
 
 // Decodifica uma mensagem de controle (UC) e delega aos handlers.
-func dispatchCtrl(conn *net.UDPConn, addr *net.UDPAddr, b []byte, logAppend func(string)) {
+func (s *Server) dispatchCtrl(conn net.PacketConn, addr *net.UDPAddr, b []byte) {
     typ, v, err := protocol.DecodeCtrl(b)
     if err != nil { return }
     switch typ {
     case protocol.TypeREQ:
-        r := v.(protocol.Req)
-        go handleREQ(conn, addr, r, logAppend)
+        s.handleREQ(conn, addr, v.(protocol.Req))
     case protocol.TypeNACK:
         n := v.(protocol.Nack)
-    if logAppend != nil { logAppend(fmt.Sprintf("NACK <- %s faltando=%d", clientLabel(addr), len(n.Missing))) }
-        go handleNACK(conn, addr, n)
+        sess := s.lookupSession(n.Session) // roteamento pela sessão, não pelo endereço
+        if protocol.IsLegacy(b) { sess = s.legacySession(addr) } // v1: só o endereço identifica a transferência
+        if sess == nil {
+            s.log(fmt.Sprintf("WARN: NACK <- %s para sessão desconhecida %08x", clientLabel(addr), n.Session))
+            return
+        }
+        sess.touch(addr, s.log)
+        s.log(fmt.Sprintf("NACK <- %s sessão=%08x faltando=%d", clientLabel(addr), n.Session, len(n.Missing)))
+        e := sess.event(logger.EventNackReceived)
+        e.Count, e.Range = len(n.Missing), logger.RangeOf(n.Missing)
+        s.events.Emit(e)
+        s.spawn(func() { s.handleNACK(conn, sess, n) })
+    case protocol.TypeFBK:
+        f := v.(protocol.Feedback)
+        sess := s.lookupSession(f.Session)
+        if sess == nil { return }
+        sess.touch(addr, s.log)
+        sess.mu.Lock()
+        delta := f.Recovered - sess.recovered // cumulativo no cliente
+        if int32(delta) > 0 { sess.recovered = f.Recovered } else { delta = 0 } // FBK reordenado não volta o contador
+        sess.mu.Unlock()
+        if delta > 0 { s.stats.AddFECRecovered(uint64(delta)) }
+        sess.cc.OnFeedback(f.Received, f.Lost, f.EchoSeq, time.Duration(f.DelayMicros)*time.Microsecond)
+    case protocol.TypePUT:
+        s.handlePUT(conn, addr, v.(protocol.Put))
+    case protocol.TypeEOF:
+        // fim do envio inicial de um PUT (ou EOF repetido à espera do DONE)
+        sess := s.lookupSession(v.(protocol.EOFMsg).Session)
+        if sess == nil { return }
+        sess.touch(addr, s.log)
+        if up := sess.upload(); up != nil { up.Deliver(b) }
     case protocol.TypeLIST:
-        // listar arquivos do diretório base (apenas nomes; não recursivo)
-        entries, _ := os.ReadDir(baseDir)
-        names := make([]string, 0)
-        for _, e := range entries { if !e.IsDir() { names = append(names, e.Name()) } }
-        conn.WriteToUDP(protocol.CtrlLST(names), addr)
+        // uma página da listagem de um diretório (ou da árvore) abaixo do diretório base
+        // (fora do loop de leitura); o LST não passa de MaxProbeAmplification vezes o LIST
+        l := v.(protocol.List)
+        limit := min(protocol.MaxLstSize, len(b)*protocol.MaxProbeAmplification)
+        s.spawn(func() {
+            entries, next, err := listing.Read(s.base(), l, limit)
+            if err != nil {
+                s.replyErr(conn, addr, l.Token, err)
+                return
+            }
+            conn.WriteTo(protocol.CtrlLST(protocol.Lst{Token: l.Token, Entries: entries, Next: next}), addr)
+        })
+    case protocol.TypePROBE:
+        // sonda de MTU: responde com o tamanho pedido (com DF), limitado para não amplificar
+        p := v.(protocol.Probe)
+        if p.Size <= len(b)*protocol.MaxProbeAmplification && p.Size <= config.MaxDatagramSize {
+            conn.WriteTo(protocol.CtrlPROBEACK(p), addr)
+        }
+    case protocol.TypeHELLO:
+        s.handleHELLO(conn, addr, v.(protocol.Hello))
     }
 }
 
-// Executa o loop de leitura de datagramas do servidor.
-func packetLoop(conn *net.UDPConn, logAppend func(string)) {
-    defer func() { srvRunning.Store(false); conn.Close() }()
-    buf := make([]byte, 4096) // buffer de recepção
-    for srvRunning.Load() {
-        n, addr, err := conn.ReadFromUDP(buf) // leitura do socket
+// Responde à negociação de versão com a maior versão em comum e a interseção
+// dos recursos, ou com ERR se o cliente não fala nenhuma versão suportada.
+func (s *Server) handleHELLO(conn net.PacketConn, addr *net.UDPAddr, h protocol.Hello) {
+    ver, ok := protocol.NegotiateVersion(h.MinVersion, h.MaxVersion)
+    if !ok {
+        msg := fmt.Sprintf("%s: cliente fala %d..%d, servidor %d..%d", protocol.ErrCodeUnsupportedVersion, h.MinVersion, h.MaxVersion, config.MinProtocolVersion, config.ProtocolVersion)
+        conn.WriteTo(protocol.CtrlERR(h.Token, protocol.ErrCodeUnsupportedVersion, msg), addr)
+        s.log(fmt.Sprintf("WARN: HELLO <- %s recusado: %s", clientLabel(addr), msg))
+        return
+    }
+    feats := h.Features & s.features()
+    conn.WriteTo(protocol.CtrlHELLOACK(protocol.HelloAck{Token: h.Token, Version: ver, Features: feats}), addr)
+    s.log(fmt.Sprintf("HELLO <- %s versões=%d..%d -> v%d recursos=%s", clientLabel(addr), h.MinVersion, h.MaxVersion, ver, feats))
+}
+
+
+// executa f numa goroutine acompanhada pelo Shutdown.
+func (s *Server) spawn(f func()) {
+    s.handlers.Add(1)
+    go func() { defer s.handlers.Done(); f() }()
+}
+
+// Executa o loop de leitura de datagramas do servidor até o socket fechar.
+func (s *Server) packetLoop(conn net.PacketConn) error {
+    buf := make([]byte, config.MaxDatagramSize) // buffer de recepção (PROBEs podem ser grandes)
+    for {
+        n, from, err := conn.ReadFrom(buf) // leitura do socket (já decifrada no modo PSK)
+        if errors.Is(err, net.ErrClosed) || s.closing.Load() && err != nil { return err }
         if err != nil { continue }
+        addr, ok := from.(*net.UDPAddr)
+        if !ok { continue }
         b := append([]byte(nil), buf[:n]...) // cópia do conteúdo recebido
-        if protocol.IsCtrl(b) { dispatchCtrl(conn, addr, b, logAppend) }
+        if protocol.IsCtrl(b) { s.dispatchCtrl(conn, addr, b); continue }
+        // DATA só chega do cliente nos envios (PUT)
+        if h, err := protocol.UnpackHeader(b); err == nil {
+            if sess := s.lookupSession(h.Session); sess != nil {
+                if up := sess.upload(); up != nil { sess.touch(addr, s.log); up.Deliver(b) }
+            }
+        }
     }
 }
 
-// Configura o diretório base de arquivos a serem servidos (default ".").
-func SetBaseDir(dir string) { if strings.TrimSpace(dir) == "" { baseDir = "." } else { baseDir = dir } }
+// Varre periodicamente as sessões ociosas enquanto o servidor executa.
+func (s *Server) reapLoop() {
+    ticker := time.NewTicker(config.SessionIdleTimeout / 4)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            s.reapSessions()
+            s.limits.Prune(config.SessionIdleTimeout)
+        case <-s.quit:
+            return
+        }
+    }
+}
 
-// Inicia o servidor UDP no host/port fornecidos.
+// servidor das funções de pacote (GUI e cli-server)
+var (
+    stdMu   sync.Mutex
+    std     *Server                  // servidor do último Start (nil antes dele)
+    stdOpts = Options{BaseDir: "."}  // configuração aplicada no próximo Start
+)
+
+// Configura o diretório base de arquivos a serem servidos (default "."),
+// também no servidor em execução.
+func SetBaseDir(dir string) {
+    stdMu.Lock(); defer stdMu.Unlock()
+    stdOpts.BaseDir = dir
+    if std != nil { std.SetBaseDir(dir) }
+}
+
+// Configura o diretório que recebe os envios dos clientes (PUT; vazio
+// desabilita) e a cota de bytes nele (0 = sem limite).
+func SetUploadDir(dir string, quota int64) {
+    stdMu.Lock(); defer stdMu.Unlock()
+    stdOpts.UploadDir, stdOpts.Quota = dir, quota
+    if std != nil { std.SetUploadDir(dir, quota) }
+}
+
+// Configura o limite de clientes (endereços) com sessão em uso; REQs e PUTs
+// de clientes novos além dele recebem ERR BUSY. 0 = sem limite.
+func SetMaxClients(n int) {
+    stdMu.Lock(); defer stdMu.Unlock()
+    stdOpts.MaxClients = n
+    if std != nil { std.SetMaxClients(n) }
+}
+
+// Configura o limite de transferências simultâneas no total e por IP de
+// cliente; os pedidos excedentes recebem ERR BUSY. 0 = sem limite.
+func SetMaxTransfers(total, perIP int) {
+    stdMu.Lock(); defer stdMu.Unlock()
+    stdOpts.MaxTransfers, stdOpts.MaxPerIP = total, perIP
+    if std != nil { std.SetMaxTransfers(total); std.SetMaxPerIP(perIP) }
+}
+
+// Configura os limites de banda dos envios (global, por cliente e por
+// sub-rede); valem de imediato para o servidor em execução.
+func SetRateLimit(cfg ratelimit.Config) {
+    stdMu.Lock(); defer stdMu.Unlock()
+    stdOpts.RateLimit = cfg
+    if std != nil { std.SetRateLimit(cfg) }
+}
+
+// Configura a chave pré-compartilhada do modo cifrado (nil desativa), aplicada
+// no próximo Start: só clientes com a mesma chave são atendidos.
+func SetPSK(key []byte) { stdMu.Lock(); stdOpts.PSK = key; stdMu.Unlock() }
+
+// Inicia o servidor UDP no host/port fornecidos, com a configuração dos
+// Set*; sem efeito se já estiver em execução.
 func Start(host string, port int, logAppend func(string)) error {
-	if srvRunning.Load() { return nil }
-	udpAddr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", host, port)) // endereço de escuta
-	conn, err := net.ListenUDP("udp", udpAddr)                                  // socket de escuta UDP
-	if err != nil { return err }
-	// buffers maiores ajudam a suportar múltiplos clientes e bursts
-	_ = conn.SetReadBuffer(config.DefaultReadBuffer)
-	_ = conn.SetWriteBuffer(config.DefaultWriteBuffer)
-	srvConn = conn
-	srvRunning.Store(true)
-	go packetLoop(conn, logAppend)
-	return nil
-}
-
-// Encerra a execução do servidor UDP.
+    stdMu.Lock(); defer stdMu.Unlock()
+    if std != nil && !std.closing.Load() { return nil }
+    udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", host, port)) // endereço de escuta
+    if err != nil { return err }
+    conn, err := net.ListenUDP("udp", udpAddr) // socket de escuta UDP
+    if err != nil { return err }
+    opts := stdOpts
+    opts.Log = logAppend
+    srv := New(opts)
+    pc, err := srv.prepare(conn)
+    if err != nil { conn.Close(); return err }
+    std = srv
+    go func() {
+        if err := srv.serve(context.Background(), pc); err != nil && !errors.Is(err, ErrServerClosed) {
+            srv.log("ERRO: servidor encerrado: " + err.Error())
+        }
+    }()
+    return nil
+}
+
+// Encerra a execução do servidor UDP imediatamente, interrompendo as
+// transferências em andamento (veja Server.Shutdown para um encerramento
+// gradual).
 func Stop() {
-    srvRunning.Store(false)
-    if srvConn != nil { _ = srvConn.Close() }
+    stdMu.Lock(); srv := std; stdMu.Unlock()
+    if srv != nil { srv.close() }
+}
+
+// Retorna uma cópia atômica das métricas do servidor de Start (as do último,
+// depois de Stop), com o estado de congestionamento das sessões ativas.
+func Snapshot() Metrics {
+    stdMu.Lock(); srv := std; stdMu.Unlock()
+    if srv == nil { return Metrics{} }
+    return srv.Snapshot()
 }
@@ -0,0 +1,6 @@
+//go:build !linux && !windows && !darwin
+
+package upload
+
+// sem consulta de espaço livre, só a cota limita os envios.
+func freeSpace(dir string) (int64, bool) { return 0, false }
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package upload
+
+import (
+	"math"
+	"syscall"
+)
+
+// bytes livres para o usuário no sistema de arquivos de dir.
+func freeSpace(dir string) (int64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return int64(min(uint64(st.Bavail)*uint64(st.Bsize), math.MaxInt64)), true
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package upload
+
+import (
+	"math"
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// bytes livres para o usuário no volume de dir.
+func freeSpace(dir string) (int64, bool) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false
+	}
+	var avail uint64
+	if r, _, _ := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), 0, 0); r == 0 {
+		return 0, false
+	}
+	return int64(min(avail, math.MaxInt64)), true
+}
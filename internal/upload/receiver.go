@@ -0,0 +1,204 @@
+package upload
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"udp/internal/config"
+	"udp/internal/congestion"
+	"udp/internal/protocol"
+	"udp/internal/segfile"
+)
+
+const (
+	quietGap     = 200 * time.Millisecond // sem DATA por este tempo: pede os faltantes
+	roundGap     = 500 * time.Millisecond // intervalo mínimo entre rounds de NACK
+	maxFruitless = 10                     // rounds seguidos sem recuperar nada antes de desistir
+	nackWindow   = 16 * protocol.MaxNackEntries
+)
+
+// Erros devolvidos por Run.
+var (
+	ErrStopped   = errors.New("envio interrompido pelo servidor")
+	ErrNoData    = errors.New("cliente sem enviar dados")
+	ErrGaveUp    = errors.New("esgotados os rounds de NACK; arquivo incompleto")
+	ErrIntegrity = errors.New("sha256 divergente")
+)
+
+// Receiver recebe os segmentos de um envio num arquivo temporário, pede os
+// faltantes por NACK, relata a recepção por FBK e, com o arquivo completo e o
+// SHA-256 conferido, move-o para o destino e confirma com DONE.
+type Receiver struct {
+	meta    protocol.Meta
+	dest    string
+	sink    *segfile.Sink
+	rep     *congestion.Reporter
+	in      chan []byte
+	highest uint32       // maior segmento recebido
+	send    func([]byte) // envia um datagrama de controle ao cliente (endereço atual)
+	mu      sync.Mutex   // proteção de done
+	done    []byte       // DONE enviado ao terminar (reenviado se o EOF se repetir)
+
+	// OnBytes, se definido, é chamado com o tamanho de cada segmento novo gravado.
+	OnBytes func(n int)
+}
+
+// NewReceiver prepara a recepção do arquivo descrito por meta (sessão, chunk,
+// tamanho e SHA-256 aceitos) em dest; send envia datagramas ao cliente.
+func NewReceiver(meta protocol.Meta, dest string, send func([]byte)) (*Receiver, error) {
+	sink, err := segfile.Create(dest, meta.Size, meta.Chunk)
+	if err != nil {
+		return nil, err
+	}
+	return &Receiver{meta: meta, dest: dest, sink: sink, rep: congestion.NewReporter(meta.Session), in: make(chan []byte, 8192), send: send}, nil
+}
+
+// Meta retorna o META com que o envio foi aceito.
+func (r *Receiver) Meta() protocol.Meta { return r.meta }
+
+// Deliver entrega um datagrama da sessão (DATA ou EOF). Depois do término,
+// um EOF repetido recebe de novo o DONE.
+func (r *Receiver) Deliver(b []byte) {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+	if done != nil {
+		if protocol.IsCtrl(b) {
+			r.send(done)
+		}
+		return
+	}
+	select {
+	case r.in <- b:
+	default: // fila cheia: descarta como perda de rede (recuperável via NACK)
+	}
+}
+
+// Run recebe até completar o arquivo, falhar ou stop ser fechado, e envia o
+// DONE com o resultado.
+func (r *Receiver) Run(stop <-chan struct{}) error {
+	tick := time.NewTicker(config.FeedbackInterval)
+	defer tick.Stop()
+	var (
+		eof       bool         // EOF recebido: todo o arquivo já foi enviado uma vez
+		last      = time.Now() // último datagrama recebido
+		nextRound time.Time    // próximo round de NACK permitido
+		lastCount uint32       // segmentos gravados no último round
+		fruitless int          // rounds seguidos sem recuperação
+	)
+	for !r.sink.Received().Complete() {
+		select {
+		case b := <-r.in:
+			if r.process(b) {
+				eof = true
+			}
+			last = time.Now()
+		case <-tick.C:
+		case <-stop:
+			return r.fail(ErrStopped)
+		}
+		// fila vazia: relata já, sem esperar o intervalo (emissor limitado pela janela)
+		poll := r.rep.Poll
+		if len(r.in) == 0 {
+			poll = r.rep.Flush
+		}
+		if fb, ok := poll(); ok {
+			r.send(protocol.CtrlFBK(fb))
+		}
+		now := time.Now()
+		if now.Sub(last) > config.FeedbackTimeout {
+			return r.fail(ErrNoData)
+		}
+		if now.Sub(last) < quietGap || now.Before(nextRound) {
+			continue
+		}
+		// antes do EOF só as lacunas abaixo do maior segmento recebido
+		// estão perdidas; o restante pode ainda não ter sido enviado
+		missing := r.sink.Received().Missing()
+		if !eof {
+			n := 0
+			for n < len(missing) && missing[n] < r.highest {
+				n++
+			}
+			missing = missing[:n]
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		count := r.sink.Received().Count()
+		if !nextRound.IsZero() && count == lastCount {
+			fruitless++
+		} else {
+			fruitless = 0
+		}
+		if fruitless >= maxFruitless {
+			return r.fail(ErrGaveUp)
+		}
+		lastCount = count
+		missing = missing[:min(len(missing), nackWindow)]
+		for len(missing) > 0 {
+			n := min(len(missing), protocol.MaxNackEntries)
+			r.send(protocol.CtrlNACK(r.meta.Session, missing[:n]))
+			missing = missing[n:]
+		}
+		nextRound = now.Add(roundGap)
+	}
+	return r.finish()
+}
+
+// processa um datagrama da sessão; retorna true se for o EOF.
+func (r *Receiver) process(b []byte) bool {
+	if protocol.IsCtrl(b) {
+		typ, _, err := protocol.DecodeCtrl(b)
+		return err == nil && typ == protocol.TypeEOF
+	}
+	h, err := protocol.UnpackHeader(b)
+	if err != nil || h.Flags&protocol.DataFlagParity != 0 || len(b) < h.Len()+int(h.Size) {
+		return false
+	}
+	payload := b[h.Len() : h.Len()+int(h.Size)]
+	if protocol.CRC32(payload) != h.CRC32 {
+		return false
+	}
+	r.rep.OnData(h.Seq)
+	r.highest = max(r.highest, h.Seq)
+	if isNew, err := r.sink.WriteChunk(h.Seq, payload); err == nil && isNew && r.OnBytes != nil {
+		r.OnBytes(len(payload))
+	}
+	return false
+}
+
+// confere o SHA-256 e move o arquivo para o destino.
+func (r *Receiver) finish() error {
+	sum, err := r.sink.Hash()
+	if err != nil {
+		return r.fail(err)
+	}
+	if sum != r.meta.SHA256 {
+		return r.fail(ErrIntegrity)
+	}
+	if err := r.sink.Commit(r.dest); err != nil {
+		r.sink.Abort()
+		r.setDone(protocol.DoneFailed, err.Error())
+		return err
+	}
+	r.setDone(protocol.DoneOK, "")
+	return nil
+}
+
+// descarta o arquivo temporário e informa a falha ao cliente.
+func (r *Receiver) fail(err error) error {
+	r.sink.Abort()
+	r.setDone(protocol.DoneFailed, err.Error())
+	return err
+}
+
+// registra e envia o DONE.
+func (r *Receiver) setDone(status byte, msg string) {
+	b := protocol.CtrlDONE(protocol.Done{Session: r.meta.Session, Status: status, Message: msg})
+	r.mu.Lock()
+	r.done = b
+	r.mu.Unlock()
+	r.send(b)
+}
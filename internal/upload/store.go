@@ -0,0 +1,136 @@
+// Package upload implementa o lado servidor dos envios cliente→servidor
+// (PUT): o Store decide se um arquivo anunciado pode ser aceito (diretório de
+// upload configurado, nome seguro, arquivo inexistente e cota) e o Receiver
+// recebe seus segmentos com a mesma segmentação, CRC32, NACK e FBK dos
+// downloads, com os papéis de emissor e receptor invertidos.
+package upload
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"udp/internal/segfile"
+)
+
+// Motivos de recusa de um PUT.
+var (
+	ErrDisabled    = errors.New("upload desabilitado no servidor")
+	ErrInvalidName = errors.New("nome de arquivo inválido")
+	ErrExists      = errors.New("arquivo já existe")
+	ErrQuota       = errors.New("cota de upload excedida")
+	ErrNoSpace     = errors.New("espaço insuficiente no servidor")
+)
+
+// Store controla o diretório de upload e a cota de bytes, reservando espaço
+// para os envios em andamento. É seguro para uso concorrente.
+type Store struct {
+	mu       sync.Mutex
+	dir      string           // diretório de upload ("" = upload desabilitado)
+	quota    int64            // limite de bytes no diretório (0 = sem limite)
+	reserved map[string]int64 // destino -> bytes reservados por envios em andamento
+}
+
+// NewStore cria um Store com upload desabilitado.
+func NewStore() *Store {
+	return &Store{reserved: make(map[string]int64)}
+}
+
+// Configure define o diretório de upload ("" desabilita) e a cota em bytes
+// (0 = sem limite).
+func (s *Store) Configure(dir string, quota int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dir, s.quota = strings.TrimSpace(dir), max(quota, 0)
+}
+
+// Dir retorna o diretório de upload ("" se desabilitado).
+func (s *Store) Dir() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dir
+}
+
+// Reserve valida o envio de size bytes para name e reserva o espaço na cota
+// e no disco (o arquivo temporário é pré-alocado, então o espaço livre tem de
+// cobrir size além das reservas em andamento, com ou sem cota).
+// Retorna o caminho de destino e a função que libera a reserva (ao concluir
+// ou abortar o envio).
+func (s *Store) Reserve(name string, size int64) (dest string, release func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		return "", nil, ErrDisabled
+	}
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || size < 0 || !filepath.IsLocal(clean) || strings.HasSuffix(clean, ".part") {
+		return "", nil, ErrInvalidName
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return "", nil, err
+	}
+	dest = filepath.Join(s.dir, clean)
+	if _, busy := s.reserved[dest]; busy {
+		return "", nil, ErrExists
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return "", nil, ErrExists
+	}
+	if s.quota > 0 {
+		used, err := s.usageLocked()
+		if err != nil {
+			return "", nil, err
+		}
+		if used+size > s.quota {
+			return "", nil, ErrQuota
+		}
+	}
+	if free, ok := freeSpace(s.dir); ok {
+		var pending int64
+		for _, n := range s.reserved {
+			pending += n
+		}
+		if size > free-pending {
+			return "", nil, ErrNoSpace
+		}
+	}
+	s.reserved[dest] = size
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.reserved, dest)
+			s.mu.Unlock()
+		})
+	}
+	return dest, release, nil
+}
+
+// bytes ocupados no diretório de upload mais os reservados; os arquivos
+// temporários dos envios em andamento já contam pela reserva.
+func (s *Store) usageLocked() (int64, error) {
+	var used int64
+	for _, n := range s.reserved {
+		used += n
+	}
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if dest, ok := strings.CutSuffix(path, ".part"); ok && segfile.PartPath(dest) == path {
+			if _, busy := s.reserved[dest]; busy {
+				return nil
+			}
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		used += info.Size()
+		return nil
+	})
+	return used, err
+}
@@ -0,0 +1,253 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"udp/internal/protocol"
+)
+
+// Store com upload em um diretório temporário e a cota quota
+func testStore(t *testing.T, quota int64) (*Store, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s := NewStore()
+	s.Configure(dir, quota)
+	return s, dir
+}
+
+// Nomes fora do diretório de upload, vazios ou de arquivos temporários são
+// recusados; um nome já existente, em disco ou reservado, dá ErrExists.
+func TestReserveNames(t *testing.T) {
+	s, dir := testStore(t, 0)
+	if err := os.WriteFile(filepath.Join(dir, "existe.bin"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Reserve("reservado.bin", 10); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		wantErr error
+	}{
+		{"novo.bin", nil},
+		{"sub/dir/novo.bin", nil},
+		{"../x", ErrInvalidName},
+		{"sub/../../x", ErrInvalidName},
+		{filepath.Join(t.TempDir(), "abs.bin"), ErrInvalidName},
+		{"", ErrInvalidName},
+		{"envio.bin.part", ErrInvalidName},
+		{"existe.bin", ErrExists},
+		{"./existe.bin", ErrExists},
+		{"reservado.bin", ErrExists},
+	}
+	for _, tt := range tests {
+		dest, _, err := s.Reserve(tt.name, 10)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Reserve(%q): erro %v, esperado %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			if rel, err := filepath.Rel(dir, dest); err != nil || !filepath.IsLocal(rel) {
+				t.Errorf("Reserve(%q): destino %q fora de %q", tt.name, dest, dir)
+			}
+		}
+	}
+
+	if _, _, err := NewStore().Reserve("novo.bin", 10); !errors.Is(err, ErrDisabled) {
+		t.Errorf("sem diretório: erro %v, esperado %v", err, ErrDisabled)
+	}
+}
+
+// Reservas simultâneas que juntas passam da cota: só uma é aceita, e a
+// liberação devolve o espaço para o envio seguinte.
+func TestReserveQuota(t *testing.T) {
+	s, dir := testStore(t, 1000)
+	if err := os.WriteFile(filepath.Join(dir, "antigo.bin"), make([]byte, 200), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg       sync.WaitGroup
+		errs     [2]error
+		releases [2]func()
+	)
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, releases[i], errs[i] = s.Reserve([]string{"a.bin", "b.bin"}[i], 500)
+		}()
+	}
+	wg.Wait()
+	won := -1
+	for i, err := range errs {
+		switch {
+		case err == nil && won < 0:
+			won = i
+		case !errors.Is(err, ErrQuota):
+			t.Fatalf("reservas simultâneas: erros %v, esperado um nil e um %v", errs, ErrQuota)
+		}
+	}
+	if won < 0 {
+		t.Fatalf("reservas simultâneas: erros %v, esperado um nil e um %v", errs, ErrQuota)
+	}
+	lost := []string{"a.bin", "b.bin"}[1-won]
+
+	releases[won]()
+	releases[won]() // repetir a liberação não libera outra reserva
+	_, release, err := s.Reserve(lost, 500)
+	if err != nil {
+		t.Fatalf("após liberar a reserva: %v", err)
+	}
+	if _, _, err := s.Reserve("c.bin", 301); !errors.Is(err, ErrQuota) {
+		t.Fatalf("acima da cota com a reserva ativa: erro %v, esperado %v", err, ErrQuota)
+	}
+	release()
+	if _, _, err := s.Reserve("c.bin", 800); err != nil {
+		t.Fatalf("cota inteira livre: %v", err)
+	}
+}
+
+// cliente de um envio em loopback: manda os segmentos de content ao
+// Receiver, exceto os de skip, e responde aos NACKs até o DONE
+type uploadClient struct {
+	t       *testing.T
+	conn    net.PacketConn
+	server  net.Addr
+	session uint32
+	chunk   int
+	content []byte
+}
+
+func (c *uploadClient) sendSeq(seq uint32) {
+	payload := c.content[int(seq)*c.chunk : min(int(seq+1)*c.chunk, len(c.content))]
+	total := uint32((len(c.content) + c.chunk - 1) / c.chunk)
+	h := protocol.DataHeader{Session: c.session, Seq: seq, Total: total, Size: uint16(len(payload)), CRC32: protocol.CRC32(payload)}
+	c.conn.WriteTo(append(protocol.PackHeader(h), payload...), c.server)
+}
+
+// envia tudo menos skip e o EOF; retorna o DONE e as sequências pedidas por NACK
+func (c *uploadClient) run(skip map[uint32]bool) (protocol.Done, []uint32) {
+	total := uint32((len(c.content) + c.chunk - 1) / c.chunk)
+	for seq := range total {
+		if !skip[seq] {
+			c.sendSeq(seq)
+		}
+	}
+	c.conn.WriteTo(protocol.CtrlEOF(c.session), c.server)
+	var nacked []uint32
+	buf := make([]byte, 2048)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			c.t.Fatalf("sem DONE do servidor: %v", err)
+		}
+		typ, v, err := protocol.DecodeCtrl(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch typ {
+		case protocol.TypeNACK:
+			for _, seq := range v.(protocol.Nack).Missing {
+				nacked = append(nacked, seq)
+				c.sendSeq(seq)
+			}
+		case protocol.TypeDONE:
+			return v.(protocol.Done), nacked
+		}
+	}
+}
+
+// Um envio com segmentos perdidos completa por NACK sobre UDP em loopback:
+// o Receiver pede exatamente os faltantes, confere o SHA-256, move o arquivo
+// para o destino e confirma com DONE; com SHA-256 divergente, o DONE relata
+// a falha e o destino não é criado.
+func TestReceiverLoopback(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64) // 1024 bytes
+	sum := sha256.Sum256(content)
+	tests := []struct {
+		name       string
+		sha        string
+		wantErr    error
+		wantStatus byte
+	}{
+		{"íntegro", hex.EncodeToString(sum[:]), nil, protocol.DoneOK},
+		{"sha divergente", hex.EncodeToString(make([]byte, 32)), ErrIntegrity, protocol.DoneFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			cli, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+
+			const chunk = 100
+			meta := protocol.Meta{Session: 0x5e55, Size: int64(len(content)), Total: 11, Chunk: chunk, SHA256: tt.sha}
+			dest := filepath.Join(t.TempDir(), "recebido.bin")
+			r, err := NewReceiver(meta, dest, func(b []byte) { srv.WriteTo(b, cli.LocalAddr()) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() {
+				buf := make([]byte, 2048)
+				for {
+					n, _, err := srv.ReadFrom(buf)
+					if err != nil {
+						return
+					}
+					r.Deliver(bytes.Clone(buf[:n]))
+				}
+			}()
+			stop := make(chan struct{})
+			defer close(stop)
+			runErr := make(chan error, 1)
+			go func() { runErr <- r.Run(stop) }()
+
+			c := &uploadClient{t: t, conn: cli, server: srv.LocalAddr(), session: meta.Session, chunk: chunk, content: content}
+			skip := map[uint32]bool{0: true, 4: true, 10: true}
+			done, nacked := c.run(skip)
+			if err := <-runErr; !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run: erro %v, esperado %v", err, tt.wantErr)
+			}
+			if done.Session != meta.Session || done.Status != tt.wantStatus {
+				t.Fatalf("DONE %+v, esperado sessão %x status %d", done, meta.Session, tt.wantStatus)
+			}
+			for _, seq := range nacked {
+				if !skip[seq] {
+					t.Errorf("NACK pediu o segmento %d, já recebido", seq)
+				}
+			}
+			if len(nacked) < len(skip) {
+				t.Errorf("NACK pediu %v, esperado ao menos os perdidos %v", nacked, skip)
+			}
+			got, err := os.ReadFile(dest)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("destino criado apesar da falha")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatal("arquivo recebido difere do enviado")
+			}
+		})
+	}
+}
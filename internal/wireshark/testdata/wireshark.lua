@@ -0,0 +1,159 @@
+-- Simula a parte da API Lua do Wireshark usada pelo dissector (Proto,
+-- ProtoField, DissectorTable, Tvb/TvbRange e TreeItem) para o teste de ida e
+-- volta: carrega o dissector, passa cada amostra pela heurística e imprime o
+-- que foi extraído.
+--
+--   lua wireshark.lua <dissector.lua> <amostras>
+--
+-- Amostras: uma por linha, "<nome> <hex>". Saída:
+--   port <porta registrada>
+--   sample <nome>
+--   heuristic <true|false>
+--   info <coluna Info>
+--   field <abbr> <valor>      (um por campo, na ordem em que foram acrescentados)
+--   end
+
+base = { NONE = 0, DEC = 1, HEX = 2, OCT = 3, DEC_HEX = 4, HEX_DEC = 5 }
+
+local function field_type(ftype)
+	return function(abbr, name, display, valuestring, mask)
+		return { abbr = abbr, ftype = ftype, mask = mask }
+	end
+end
+
+ProtoField = {}
+for _, t in ipairs({ "uint8", "uint16", "uint24", "uint32", "uint64", "string", "bytes", "bool", "none" }) do
+	ProtoField[t] = field_type(t)
+end
+
+local protos = {}
+
+function Proto(name, description)
+	local p = { name = name, description = description, fields = {}, is_proto = true }
+	function p:register_heuristic(list, fn) self.heuristic = fn end
+	protos[#protos + 1] = p
+	return p
+end
+
+local ports = {}
+DissectorTable = {
+	get = function(name)
+		return { add = function(self, port, proto) ports[port] = proto end }
+	end,
+}
+
+-- TvbRange: fatia [off, off+n) dos bytes
+local Range = {}
+Range.__index = Range
+
+function Range:len() return self.n end
+
+function Range:string() return self.data:sub(self.off + 1, self.off + self.n) end
+
+function Range:uint()
+	if self.n < 1 or self.n > 4 then error("uint() com " .. self.n .. " bytes") end
+	local v = 0
+	for i = 1, self.n do v = v * 256 + self.data:byte(self.off + i) end
+	return v
+end
+
+function Range:hex()
+	local out = {}
+	for i = 1, self.n do out[i] = string.format("%02x", self.data:byte(self.off + i)) end
+	return table.concat(out)
+end
+
+-- inteiro sem sinal big-endian de qualquer tamanho em decimal (uint64 não
+-- cabe exatamente no número do Lua 5.1)
+function Range:decimal()
+	local digits = { 0 } -- base 10, menos significativo primeiro
+	for i = 1, self.n do
+		local carry = self.data:byte(self.off + i)
+		for j = 1, #digits do
+			local x = digits[j] * 256 + carry
+			digits[j] = x % 10
+			carry = math.floor(x / 10)
+		end
+		while carry > 0 do
+			digits[#digits + 1] = carry % 10
+			carry = math.floor(carry / 10)
+		end
+	end
+	local out = {}
+	for j = #digits, 1, -1 do out[#out + 1] = string.format("%d", digits[j]) end
+	return table.concat(out)
+end
+
+local Tvb = {}
+Tvb.__index = Tvb
+Tvb.__call = function(self, off, n) return self:range(off, n) end
+
+function Tvb:len() return #self.data end
+
+function Tvb:range(off, n)
+	off = off or 0
+	n = n or (#self.data - off)
+	if off < 0 or n < 0 or off + n > #self.data then
+		error(string.format("range(%d, %d) fora do tvb de %d bytes", off, n, #self.data))
+	end
+	return setmetatable({ data = self.data, off = off, n = n }, Range)
+end
+
+-- valor exibido de um campo, conforme o tipo
+local function value(f, r)
+	local t = f.ftype
+	if t == "uint8" or t == "uint16" or t == "uint24" or t == "uint32" then return string.format("%d", r:uint()) end
+	if t == "uint64" then return r:decimal() end
+	if t == "bool" then
+		if math.floor(r:uint() / f.mask) % 2 == 1 then return "true" end
+		return "false"
+	end
+	if t == "string" then -- controles escapados: uma linha por campo
+		return (r:string():gsub("%c", function(c) return string.format("\\%03d", c:byte()) end))
+	end
+	if t == "bytes" then return r:hex() end
+	return nil
+end
+
+local out -- linhas "field" da amostra atual
+local registered = {} -- campos em proto.fields
+
+local Tree = {}
+Tree.__index = Tree
+
+local function item() return setmetatable({}, Tree) end
+
+function Tree:add(f, r, text)
+	if f.is_proto then return item() end
+	if not registered[f] then error("campo fora de proto.fields: " .. tostring(f.abbr)) end
+	local v = value(f, r)
+	if v ~= nil then out[#out + 1] = "field " .. f.abbr .. " " .. v end
+	return item()
+end
+
+function Tree:append_text(text) end
+function Tree:set_text(text) end
+function Tree:set_generated() end
+
+local dissector_path, samples_path = arg[1], arg[2]
+dofile(dissector_path)
+local proto = protos[1]
+for _, f in ipairs(proto.fields) do registered[f] = true end
+for port, p in pairs(ports) do
+	if p == proto then print("port " .. port) end
+end
+
+for line in io.lines(samples_path) do
+	local name, hex = line:match("^(%S+)%s+(%x*)$")
+	if name then
+		local data = hex:gsub("%x%x", function(h) return string.char(tonumber(h, 16)) end)
+		out = {}
+		local pinfo = { cols = {} }
+		local ok = proto.heuristic(setmetatable({ data = data }, Tvb), pinfo, item())
+		print("sample " .. name)
+		print("heuristic " .. tostring(ok))
+		print("info " .. tostring(pinfo.cols.info))
+		for _, l in ipairs(out) do print(l) end
+		print("end")
+	end
+end
@@ -0,0 +1,110 @@
+// Package wireshark gera o dissector Lua do Wireshark para o protocolo
+// (DATA 'UD', controle 'UC' e cabeçalho dos envelopes cifrados 'US').
+//
+// Os códigos de tipo, flags, tamanhos de cabeçalho, faixa de versões e os
+// nomes de erros, codecs e recursos vêm das definições de internal/protocol
+// e internal/config; o layout de cada mensagem está no modelo
+// dissector.lua.tmpl e é conferido pelo teste, que decodifica as mesmas
+// amostras com o dissector e com internal/protocol. A cópia versionada em
+// scripts/wireshark/udpft.lua é regenerada com go generate.
+package wireshark
+
+//go:generate go run ../../cmd/udp-dump dissector -o ../../scripts/wireshark/udpft.lua
+
+import (
+	"bytes"
+	"cmp"
+	_ "embed"
+	"slices"
+	"strconv"
+	"text/template"
+
+	"udp/internal/config"
+	"udp/internal/protocol"
+)
+
+// Path é o caminho do dissector gerado, relativo à raiz do módulo.
+const Path = "scripts/wireshark/udpft.lua"
+
+//go:embed dissector.lua.tmpl
+var source string
+
+// valor numérico com nome, para as tabelas do dissector
+type named struct {
+	Code int
+	Name string
+}
+
+// dados do modelo
+type spec struct {
+	Port                           int
+	MinVersion, MaxVersion         int
+	LegacyVersion                  int
+	LegacyDataHeaderSize           int
+	CompressionVersion             int
+	DataHeaderSize, CtrlHeaderSize int
+	DataFlagParity                 int
+	DataFlagCompressed             int
+	ReqFlagResume                  int
+	ListFlagRecursive              int
+	CtrlTypes                      []named
+	ErrCodes                       []named
+	Codecs                         []named // código do codec (bit 1<<código no REQ)
+	Features                       []named // índice do bit
+	DoneStatus                     []named
+	EntryKinds                     []named
+}
+
+// Dissector retorna o código Lua do dissector.
+func Dissector() ([]byte, error) {
+	port, err := strconv.Atoi(config.DefaultServerSettings().Port)
+	if err != nil {
+		return nil, err
+	}
+	s := spec{
+		Port:                 port,
+		MinVersion:           config.MinProtocolVersion,
+		MaxVersion:           config.ProtocolVersion,
+		CompressionVersion:   protocol.CompressionVersion,
+		LegacyVersion:        protocol.LegacyVersion,
+		LegacyDataHeaderSize: protocol.DataHeader{Version: protocol.LegacyVersion}.Len(),
+		DataHeaderSize:       protocol.HeaderSize(),
+		CtrlHeaderSize:       protocol.CtrlHeaderSize(),
+		DataFlagParity:       protocol.DataFlagParity,
+		DataFlagCompressed:   protocol.DataFlagCompressed,
+		ReqFlagResume:        protocol.ReqFlagResume,
+		ListFlagRecursive:    protocol.ListFlagRecursive,
+		DoneStatus:           []named{{protocol.DoneOK, "ok"}, {protocol.DoneFailed, "failed"}},
+		EntryKinds:           []named{{protocol.EntryFile, "file"}, {protocol.EntryDir, "dir"}},
+	}
+	for name, code := range protocol.CtrlTypeCodes() {
+		s.CtrlTypes = append(s.CtrlTypes, named{int(code), name})
+	}
+	slices.SortFunc(s.CtrlTypes, func(a, b named) int { return cmp.Compare(a.Code, b.Code) })
+	// só os valores que os String() conhecem (os demais caem no texto genérico)
+	for c := protocol.ErrCode(1); c < 256; c++ {
+		if name := c.String(); name != "código "+strconv.Itoa(int(c)) {
+			s.ErrCodes = append(s.ErrCodes, named{int(c), name})
+		}
+	}
+	for c := protocol.Codec(0); c < 8; c++ {
+		if name := c.String(); name != "codec "+strconv.Itoa(int(c)) {
+			s.Codecs = append(s.Codecs, named{int(c), name})
+		}
+	}
+	for bit := 0; bit < 32; bit++ {
+		if name := protocol.Features(1 << bit).String(); name != "-" {
+			s.Features = append(s.Features, named{bit, name})
+		}
+	}
+
+	tmpl, err := template.New("dissector").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,414 @@
+package wireshark
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"udp/internal/config"
+	"udp/internal/protocol"
+)
+
+// A cópia versionada precisa ser a saída atual do gerador.
+func TestDissectorUpToDate(t *testing.T) {
+	want, err := Dissector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join("..", "..", Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s desatualizado: rode go generate ./internal/wireshark", Path)
+	}
+}
+
+// amostra de datagrama e os campos que o dissector deve extrair dela, na
+// ordem (podem faltar campos entre eles)
+type sample struct {
+	name   string
+	data   []byte
+	info   string   // prefixo esperado da coluna Info ("" = não reconhecido)
+	fields []string // "abbr valor"
+}
+
+const sha = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+// Passa as amostras montadas com internal/protocol pelo dissector (com um
+// simulador da API do Wireshark) e confere os campos com os valores que
+// internal/protocol decodifica dos mesmos bytes.
+func TestDissectorRoundTrip(t *testing.T) {
+	lua := ""
+	for _, name := range []string{"lua", "lua5.4", "lua5.3", "lua5.2", "lua5.1", "luajit"} {
+		if p, err := exec.LookPath(name); err == nil {
+			lua = p
+			break
+		}
+	}
+	if lua == "" {
+		// em CI o dissector tem de ser exercitado: a falta do lua é erro
+		if os.Getenv("CI") != "" {
+			t.Fatal("interpretador lua não encontrado no PATH (obrigatório com CI definido)")
+		}
+		t.Skip("interpretador lua não encontrado no PATH")
+	}
+
+	src, err := Dissector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := buildSamples(t)
+	dir := t.TempDir()
+	dissector := filepath.Join(dir, "udpft.lua")
+	if err := os.WriteFile(dissector, src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var in bytes.Buffer
+	for _, s := range samples {
+		fmt.Fprintf(&in, "%s %x\n", s.name, s.data)
+	}
+	input := filepath.Join(dir, "samples.txt")
+	if err := os.WriteFile(input, in.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(lua, filepath.Join("testdata", "wireshark.lua"), dissector, input).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", lua, err, out)
+	}
+
+	port, results := parseOutput(t, out)
+	if want := config.DefaultServerSettings().Port; port != want {
+		t.Errorf("porta registrada = %q, esperado %q", port, want)
+	}
+	for _, s := range samples {
+		r, ok := results[s.name]
+		if !ok {
+			t.Errorf("%s: sem saída do dissector", s.name)
+			continue
+		}
+		if s.info == "" {
+			if r.heuristic {
+				t.Errorf("%s: reconhecido pela heurística (info %q)", s.name, r.info)
+			}
+			continue
+		}
+		if !r.heuristic {
+			t.Errorf("%s: não reconhecido pela heurística", s.name)
+			continue
+		}
+		if !strings.HasPrefix(r.info, s.info) {
+			t.Errorf("%s: info = %q, esperado prefixo %q", s.name, r.info, s.info)
+		}
+		if missing := subsequence(r.fields, s.fields); missing != "" {
+			t.Errorf("%s: campo %q ausente ou fora de ordem; extraídos:\n  %s", s.name, missing, strings.Join(r.fields, "\n  "))
+		}
+	}
+}
+
+// saída do simulador para uma amostra
+type result struct {
+	heuristic bool
+	info      string
+	fields    []string
+}
+
+func parseOutput(t *testing.T, out []byte) (port string, results map[string]*result) {
+	t.Helper()
+	results = map[string]*result{}
+	var cur *result
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		key, val, _ := strings.Cut(sc.Text(), " ")
+		switch key {
+		case "port":
+			port = val
+		case "sample":
+			cur = &result{}
+			results[val] = cur
+		case "heuristic":
+			cur.heuristic = val == "true"
+		case "info":
+			cur.info = val
+		case "field":
+			cur.fields = append(cur.fields, val)
+		case "end":
+			cur = nil
+		default:
+			t.Fatalf("linha inesperada do simulador: %q", sc.Text())
+		}
+	}
+	return port, results
+}
+
+// retorna o primeiro de want que não aparece em got na ordem ("" se todos)
+func subsequence(got, want []string) string {
+	i := 0
+	for _, w := range want {
+		for i < len(got) && got[i] != w {
+			i++
+		}
+		if i == len(got) {
+			return w
+		}
+		i++
+	}
+	return ""
+}
+
+// monta as amostras com internal/protocol e deriva os campos esperados do
+// que internal/protocol decodifica delas
+func buildSamples(t *testing.T) []sample {
+	t.Helper()
+	payload := []byte("hello")
+	data := func(flags byte) []byte {
+		h := protocol.DataHeader{Flags: flags, Session: 0xdeadbeef, Seq: 7, Total: 100, Size: uint16(len(payload)), CRC32: protocol.CRC32(payload)}
+		return append(protocol.PackHeader(h), payload...)
+	}
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 123, time.UTC)
+	ctrl := []struct {
+		name string
+		data []byte
+	}{
+		{"req-v2", protocol.CtrlREQ(protocol.Req{Token: 0x01020304, MaxDatagram: 1200, FECData: 8, FECParity: 2, Path: "dir/a.bin"})},
+		{"req-v3", protocol.CtrlREQ(protocol.Req{Token: 0xfffffffe, Flags: protocol.ReqFlagResume, Codecs: protocol.Codecs(protocol.CodecDeflate, protocol.CodecGzip), Path: "b c.txt", Version: protocol.CompressionVersion})},
+		{"meta-v2", protocol.CtrlMETA(protocol.Meta{Session: 0x11223344, Token: 0x01020304, Filename: "a.bin", Total: 3, Size: 3000, SHA256: sha, Chunk: 1024})},
+		{"meta-v3", protocol.CtrlMETA(protocol.Meta{Session: 0x55667788, Token: 9, Filename: "big.iso", Total: 4882813, Size: 5_000_000_123, SHA256: sha, Chunk: 1024,
+			FECData: 16, FECParity: 4, Codec: protocol.CodecGzip, EstimatedCompressedSize: 4_294_967_297, Version: protocol.CompressionVersion})},
+		{"err", protocol.CtrlERR(5, protocol.ErrCodeNotFound, "arquivo não encontrado: x")},
+		{"busy", protocol.CtrlBUSY(6, "ocupado", 1500*time.Millisecond)},
+		{"eof", protocol.CtrlEOF(0xcafebabe)},
+		{"nack", protocol.CtrlNACK(0xcafebabe, []uint32{1, 5, 4000000000})},
+		{"list", protocol.CtrlLIST(protocol.List{Token: 12, Flags: protocol.ListFlagRecursive, PageSize: 50, Path: "docs", Cursor: "docs/b"})},
+		{"lst", protocol.CtrlLST(protocol.Lst{Token: 12, Next: "docs/z", Entries: []protocol.Entry{
+			{Name: "docs/sub", Kind: protocol.EntryDir},
+			{Name: "docs/a.txt", Kind: protocol.EntryFile, Size: 1 << 40, ModTime: mtime, SHA256: sha},
+			{Name: "docs/b.txt", Kind: protocol.EntryFile, Size: 10, ModTime: mtime},
+		}})},
+		{"fbk", protocol.CtrlFBK(protocol.Feedback{Session: 1, Received: 100, Lost: 3, Highest: 120, EchoSeq: 119, DelayMicros: 250, Recovered: 2})},
+		{"probe", protocol.CtrlPROBE(77, 1400)},
+		{"probeack", protocol.CtrlPROBEACK(protocol.Probe{Token: 77, Size: 1400})},
+		{"put", protocol.CtrlPUT(protocol.Put{Token: 3, Size: 123456, MaxDatagram: 1472, Name: "up.bin", SHA256: sha})},
+		{"done", protocol.CtrlDONE(protocol.Done{Session: 8, Status: protocol.DoneFailed, Message: "sha divergente"})},
+		{"hello", protocol.CtrlHELLO(protocol.Hello{Token: 4, MinVersion: config.MinProtocolVersion, MaxVersion: config.ProtocolVersion, Features: protocol.FeatureFEC | protocol.FeatureCompression})},
+		{"helloack", protocol.CtrlHELLOACK(protocol.HelloAck{Token: 4, Version: config.ProtocolVersion, Features: protocol.FeatureCompression})},
+		{"req-v1", protocol.CtrlREQ(protocol.Req{Path: "dir/a.bin", Version: protocol.LegacyVersion})},
+		{"meta-v1", protocol.CtrlMETA(protocol.Meta{Filename: "a.bin", Total: 3, Size: 3000, SHA256: sha, Chunk: 1024, Version: protocol.LegacyVersion})},
+		{"err-v1", protocol.CtrlLegacyERR(protocol.ErrCodeNotFound, "arquivo não encontrado: x")},
+		{"eof-v1", protocol.CtrlLegacyEOF()},
+		{"nack-v1", protocol.CtrlLegacyNACK([]uint32{1, 5, 4000000000})},
+	}
+
+	var samples []sample
+	for _, d := range []struct {
+		name, info string
+		flags      byte
+	}{
+		{"data", "DATA s=deadbeef seq=7 total=100 size=5 compressed", protocol.DataFlagCompressed},
+		{"parity", "PARITY s=deadbeef seq=7", protocol.DataFlagParity},
+	} {
+		b := data(d.flags)
+		h, err := protocol.UnpackHeader(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, sample{name: d.name, data: b, info: d.info, fields: []string{
+			"udpft.magic UD", "udpft.version " + u(b[2]),
+			"udpft.data.flags " + u(h.Flags),
+			"udpft.data.flags.parity " + strconv.FormatBool(h.Flags&protocol.DataFlagParity != 0),
+			"udpft.data.flags.compressed " + strconv.FormatBool(h.Flags&protocol.DataFlagCompressed != 0),
+			"udpft.session " + u(h.Session), "udpft.data.seq " + u(h.Seq), "udpft.data.total " + u(h.Total),
+			"udpft.data.size " + u(h.Size), "udpft.data.crc32 " + u(h.CRC32),
+			"udpft.data.payload " + hex.EncodeToString(b[protocol.HeaderSize():]),
+		}})
+	}
+	samples = append(samples,
+		sample{name: "garbage", data: []byte("hello world, not a datagram")},
+		sample{name: "future-version", data: func() []byte { b := protocol.CtrlEOF(1); b[2] = config.ProtocolVersion + 1; return b }()},
+		// v1 original (sem sessão): DATA de 18 bytes; um LIST v1 não existe
+		sample{name: "baseline-data", data: append([]byte{'U', 'D', 1, 0, 0, 0, 0, 7, 0, 0, 0, 100, 0, 5, 0x36, 0x10, 0xa6, 0x86}, payload...), info: "DATA v1 seq=7 total=100 size=5",
+			fields: []string{"udpft.magic UD", "udpft.version 1", "udpft.data.seq 7", "udpft.data.total 100", "udpft.data.size 5", "udpft.data.crc32 907060870",
+				"udpft.data.payload " + hex.EncodeToString(payload)}},
+		sample{name: "baseline-list", data: []byte{'U', 'C', 1, 11, 0, 0}},
+		sample{name: "envelope", data: append([]byte{'U', 'S', 1, 3, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 2}, payload...), info: "US DATA s=00000009",
+			fields: []string{"udpft.magic US", "udpft.envelope.type 3", "udpft.session 9", "udpft.envelope.seq 2", "udpft.envelope.payload " + hex.EncodeToString(payload)}},
+	)
+	for _, c := range ctrl {
+		samples = append(samples, ctrlSample(t, c.name, c.data))
+	}
+
+	// META cortado: o comprimento declarado passa do datagrama
+	meta := protocol.CtrlMETA(protocol.Meta{Session: 1, Filename: "x", SHA256: sha})
+	samples = append(samples, sample{name: "meta-truncated", data: meta[:len(meta)-10], info: "META [truncated]"})
+	// LST com comprimento coerente mas entrada que não cabe
+	lst := protocol.CtrlLST(protocol.Lst{Token: 1, Entries: []protocol.Entry{{Name: "abc", SHA256: sha}}})
+	lst = lst[:len(lst)-20]
+	binary.BigEndian.PutUint16(lst[4:6], uint16(len(lst)-protocol.CtrlHeaderSize()))
+	samples = append(samples, sample{name: "lst-malformed", data: lst, info: "LST [malformed]"})
+	return samples
+}
+
+// amostra de controle com os campos esperados pelo decodificado em Go
+func ctrlSample(t *testing.T, name string, b []byte) sample {
+	t.Helper()
+	typ, v, err := protocol.DecodeCtrl(b)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	s := sample{name: name, data: b, info: typ + " "}
+	f := []string{"udpft.magic UC", "udpft.version " + u(b[2]), "udpft.ctrl.type " + u(protocol.CtrlTypeCodes()[typ]),
+		"udpft.ctrl.length " + strconv.Itoa(len(b)-protocol.CtrlHeaderSize())}
+	add := func(abbr string, val any) {
+		if val != "" { // o dissector omite textos vazios
+			f = append(f, abbr+" "+fmt.Sprint(val))
+		}
+	}
+	if b[2] == protocol.LegacyVersion {
+		// v1: sem sessão nem token, e só os campos do layout original
+		s.info = typ + " v1"
+		switch m := v.(type) {
+		case protocol.Req:
+			add("udpft.req.path", m.Path)
+		case protocol.Meta:
+			add("udpft.meta.total", m.Total)
+			add("udpft.meta.size", m.Size)
+			add("udpft.meta.chunk", m.Chunk)
+			add("udpft.meta.filename", m.Filename)
+			add("udpft.meta.sha256", m.SHA256)
+		case protocol.ErrMsg:
+			add("udpft.err.code", uint16(m.Code))
+			add("udpft.err.message", m.Message)
+			s.info = fmt.Sprintf("ERR v1 code=%d (%s)", m.Code, m.Code)
+		case protocol.EOFMsg:
+		case protocol.Nack:
+			add("udpft.nack.count", len(m.Missing))
+			for _, seq := range m.Missing {
+				add("udpft.nack.seq", seq)
+			}
+		default:
+			t.Fatalf("%s: tipo %s sem layout v1", name, typ)
+		}
+		s.fields = f
+		return s
+	}
+	switch m := v.(type) {
+	case protocol.Req:
+		add("udpft.token", m.Token)
+		add("udpft.req.flags", m.Flags)
+		add("udpft.req.flags.resume", m.Flags&protocol.ReqFlagResume != 0)
+		add("udpft.req.max_datagram", m.MaxDatagram)
+		add("udpft.req.fec_data", m.FECData)
+		add("udpft.req.fec_parity", m.FECParity)
+		if m.Version >= protocol.CompressionVersion {
+			add("udpft.req.codecs", uint8(m.Codecs))
+		}
+		add("udpft.req.path", m.Path)
+	case protocol.Meta:
+		add("udpft.session", m.Session)
+		add("udpft.token", m.Token)
+		add("udpft.meta.total", m.Total)
+		add("udpft.meta.size", m.Size)
+		add("udpft.meta.chunk", m.Chunk)
+		add("udpft.meta.fec_data", m.FECData)
+		add("udpft.meta.fec_parity", m.FECParity)
+		if m.Version >= protocol.CompressionVersion {
+			add("udpft.meta.codec", uint8(m.Codec))
+			add("udpft.meta.estimated_compressed_size", m.EstimatedCompressedSize)
+		}
+		add("udpft.meta.filename", m.Filename)
+		add("udpft.meta.sha256", m.SHA256)
+	case protocol.ErrMsg:
+		add("udpft.err.code", uint16(m.Code))
+		add("udpft.token", m.Token)
+		add("udpft.err.message", m.Message)
+		if m.RetryAfter > 0 {
+			add("udpft.err.retry_after_ms", m.RetryAfter.Milliseconds())
+		}
+		s.info = fmt.Sprintf("ERR token=%08x code=%d (%s)", m.Token, m.Code, m.Code)
+	case protocol.EOFMsg:
+		add("udpft.session", m.Session)
+	case protocol.Nack:
+		add("udpft.session", m.Session)
+		add("udpft.nack.count", len(m.Missing))
+		for _, seq := range m.Missing {
+			add("udpft.nack.seq", seq)
+		}
+	case protocol.Feedback:
+		add("udpft.session", m.Session)
+		add("udpft.fbk.received", m.Received)
+		add("udpft.fbk.lost", m.Lost)
+		add("udpft.fbk.highest", m.Highest)
+		add("udpft.fbk.echo_seq", m.EchoSeq)
+		add("udpft.fbk.delay_us", m.DelayMicros)
+		add("udpft.fbk.recovered", m.Recovered)
+	case protocol.Probe:
+		add("udpft.token", m.Token)
+		add("udpft.probe.size", m.Size)
+	case protocol.Put:
+		add("udpft.token", m.Token)
+		add("udpft.put.size", m.Size)
+		add("udpft.put.max_datagram", m.MaxDatagram)
+		add("udpft.put.name", m.Name)
+		add("udpft.put.sha256", m.SHA256)
+	case protocol.Done:
+		add("udpft.session", m.Session)
+		add("udpft.done.status", m.Status)
+		add("udpft.done.message", m.Message)
+	case protocol.List:
+		add("udpft.token", m.Token)
+		add("udpft.list.flags", m.Flags)
+		add("udpft.list.flags.recursive", m.Flags&protocol.ListFlagRecursive != 0)
+		add("udpft.list.page_size", m.PageSize)
+		add("udpft.list.path", m.Path)
+		add("udpft.list.cursor", m.Cursor)
+	case protocol.Lst:
+		add("udpft.token", m.Token)
+		add("udpft.lst.count", len(m.Entries))
+		for _, e := range m.Entries {
+			add("udpft.lst.kind", e.Kind)
+			flags := 0
+			if e.SHA256 != "" {
+				flags = 1
+			}
+			add("udpft.lst.flags", flags)
+			add("udpft.lst.size", e.Size)
+			var mtime int64
+			if !e.ModTime.IsZero() {
+				mtime = e.ModTime.UnixNano()
+			}
+			add("udpft.lst.mtime_ns", mtime)
+			add("udpft.lst.name", e.Name)
+			if e.SHA256 != "" {
+				add("udpft.lst.sha256", e.SHA256)
+			}
+		}
+		add("udpft.lst.next", m.Next)
+	case protocol.Hello:
+		add("udpft.token", m.Token)
+		add("udpft.hello.min_version", m.MinVersion)
+		add("udpft.hello.max_version", m.MaxVersion)
+		add("udpft.hello.features", uint32(m.Features))
+		s.info = fmt.Sprintf("HELLO token=%08x versions=%d..%d features=%s", m.Token, m.MinVersion, m.MaxVersion, m.Features)
+	case protocol.HelloAck:
+		add("udpft.token", m.Token)
+		add("udpft.hello.version", m.Version)
+		add("udpft.hello.features", uint32(m.Features))
+		s.info = fmt.Sprintf("HELLOACK token=%08x version=%d features=%s", m.Token, m.Version, m.Features)
+	default:
+		t.Fatalf("%s: tipo %s sem campos esperados", name, typ)
+	}
+	s.fields = f
+	return s
+}
+
+// valor sem sinal em decimal
+func u[T uint8 | uint16 | uint32](v T) string { return strconv.FormatUint(uint64(v), 10) }
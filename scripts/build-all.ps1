@@ -24,10 +24,12 @@ function Build-CLI-All {
     $env:GOOS = "windows"; $env:GOARCH = "amd64"
     go build -o "$Dist/cli-server-windows-amd64.exe" ./cmd/cli-server
     go build -o "$Dist/cli-client-windows-amd64.exe" ./cmd/cli-client
+    go build -o "$Dist/udp-dump-windows-amd64.exe" ./cmd/udp-dump
     # Linux
     $env:GOOS = "linux"; $env:GOARCH = "amd64"
     go build -o "$Dist/cli-server-linux-amd64" ./cmd/cli-server
     go build -o "$Dist/cli-client-linux-amd64" ./cmd/cli-client
+    go build -o "$Dist/udp-dump-linux-amd64" ./cmd/udp-dump
 }
 
 function Add-Artifact([string]$Path) {
@@ -54,6 +56,8 @@ try {
     $list += Add-Artifact "$Dist/cli-client-windows-amd64.exe"
     $list += Add-Artifact "$Dist/cli-server-linux-amd64"
     $list += Add-Artifact "$Dist/cli-client-linux-amd64"
+    $list += Add-Artifact "$Dist/udp-dump-windows-amd64.exe"
+    $list += Add-Artifact "$Dist/udp-dump-linux-amd64"
 
     Write-Host "`nArtefatos compilados em $Dist:" -ForegroundColor Cyan
     $list | Where-Object { $_ } | Format-Table -AutoSize
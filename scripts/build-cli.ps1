@@ -8,9 +8,9 @@ New-Item -ItemType Directory -Force -Path $Dist | Out-Null
 $ErrorActionPreference = "Stop"
 
 # Windows amd64
-$env:GOOS = "windows"; $env:GOARCH = "amd64"; go build -o "$Dist/cli-server-windows-amd64.exe" ./cmd/cli-server; go build -o "$Dist/cli-client-windows-amd64.exe" ./cmd/cli-client
+$env:GOOS = "windows"; $env:GOARCH = "amd64"; go build -o "$Dist/cli-server-windows-amd64.exe" ./cmd/cli-server; go build -o "$Dist/cli-client-windows-amd64.exe" ./cmd/cli-client; go build -o "$Dist/udp-dump-windows-amd64.exe" ./cmd/udp-dump
 
 # Linux amd64
-$env:GOOS = "linux"; $env:GOARCH = "amd64"; go build -o "$Dist/cli-server-linux-amd64" ./cmd/cli-server; go build -o "$Dist/cli-client-linux-amd64" ./cmd/cli-client
+$env:GOOS = "linux"; $env:GOARCH = "amd64"; go build -o "$Dist/cli-server-linux-amd64" ./cmd/cli-server; go build -o "$Dist/cli-client-linux-amd64" ./cmd/cli-client; go build -o "$Dist/udp-dump-linux-amd64" ./cmd/udp-dump
 
 Write-Host "Versões CLI compiladas em $Dist"
\ No newline at end of file
@@ -6,9 +6,11 @@ mkdir -p "$DIST"
 # Windows amd64
 GOOS=windows GOARCH=amd64 go build -o "$DIST/cli-server-windows-amd64.exe" ./cmd/cli-server
 GOOS=windows GOARCH=amd64 go build -o "$DIST/cli-client-windows-amd64.exe" ./cmd/cli-client
+GOOS=windows GOARCH=amd64 go build -o "$DIST/udp-dump-windows-amd64.exe" ./cmd/udp-dump
 
 # Linux amd64
 GOOS=linux GOARCH=amd64 go build -o "$DIST/cli-server-linux-amd64" ./cmd/cli-server
 GOOS=linux GOARCH=amd64 go build -o "$DIST/cli-client-linux-amd64" ./cmd/cli-client
+GOOS=linux GOARCH=amd64 go build -o "$DIST/udp-dump-linux-amd64" ./cmd/udp-dump
 
 echo "CLI builds written to $DIST"
\ No newline at end of file
@@ -0,0 +1,532 @@
+-- Dissector do Wireshark para o protocolo de transferência confiável sobre
+-- UDP: datagramas DATA ('UD'), mensagens de controle ('UC') e envelopes do
+-- modo cifrado ('US', só o cabeçalho).
+--
+-- GERADO por "udp-dump dissector" a partir de internal/protocol: não edite à
+-- mão; depois de mudar o protocolo, rode "go generate ./internal/wireshark".
+--
+-- Instalação: copie este arquivo para a pasta de plugins Lua pessoais do
+-- Wireshark (Ajuda > Sobre o Wireshark > Pastas) e recarregue os plugins
+-- (Ctrl+Shift+L). Os datagramas são reconhecidos na porta 19000 e, por
+-- heurística (magic e versão), em qualquer outra porta UDP. Filtro: udpft
+
+local udpft = Proto("udpft", "UDP file transfer")
+
+local PORT = 19000
+local MIN_VERSION = 1
+local MAX_VERSION = 3
+local LEGACY_VERSION = 1 -- v1 original: sem sessão nem token, só DATA e REQ a NACK
+local COMPRESSION_VERSION = 3 -- REQ e META com campos de compressão
+local DATA_HEADER_SIZE = 22
+local LEGACY_DATA_HEADER_SIZE = 18
+local CTRL_HEADER_SIZE = 6
+local ENVELOPE_VERSION = 1
+local ENVELOPE_HEADER_SIZE = 16
+
+local DATA_FLAG_PARITY = 1
+local DATA_FLAG_COMPRESSED = 2
+local REQ_FLAG_RESUME = 1
+local LIST_FLAG_RECURSIVE = 1
+local LST_ENTRY_SHA = 1 -- entrada do LST com SHA-256
+
+local ctrl_types = {
+	[1] = "REQ",
+	[2] = "META",
+	[3] = "ERR",
+	[4] = "EOF",
+	[5] = "NACK",
+	[6] = "LIST",
+	[7] = "LST",
+	[8] = "FBK",
+	[9] = "PROBE",
+	[10] = "PROBEACK",
+	[11] = "PUT",
+	[12] = "DONE",
+	[13] = "HELLO",
+	[14] = "HELLOACK",
+}
+
+local err_codes = {
+	[1] = "erro",
+	[2] = "arquivo não encontrado",
+	[3] = "é um diretório",
+	[4] = "permissão negada",
+	[5] = "caminho recusado",
+	[6] = "servidor ocupado",
+	[7] = "versão de protocolo não suportada",
+	[8] = "cota excedida",
+	[9] = "erro interno do servidor",
+}
+
+local codecs = {
+	[0] = "none",
+	[1] = "deflate",
+	[2] = "gzip",
+}
+
+local features = {
+	[0] = "fec",
+	[1] = "cifra",
+	[2] = "compressão",
+	[3] = "chunks-grandes",
+}
+
+local done_status = {
+	[0] = "ok",
+	[1] = "failed",
+}
+
+local entry_kinds = {
+	[0] = "file",
+	[1] = "dir",
+}
+
+local envelope_types = { [1] = "INIT", [2] = "RESP", [3] = "DATA" }
+
+local F = {
+	magic   = ProtoField.string("udpft.magic", "Magic"),
+	version = ProtoField.uint8("udpft.version", "Version"),
+	session = ProtoField.uint32("udpft.session", "Session", base.HEX),
+	token   = ProtoField.uint32("udpft.token", "Token", base.HEX),
+
+	data_flags      = ProtoField.uint8("udpft.data.flags", "Flags", base.HEX),
+	data_parity     = ProtoField.bool("udpft.data.flags.parity", "FEC parity", 8, nil, DATA_FLAG_PARITY),
+	data_compressed = ProtoField.bool("udpft.data.flags.compressed", "Compressed", 8, nil, DATA_FLAG_COMPRESSED),
+	data_seq        = ProtoField.uint32("udpft.data.seq", "Sequence"),
+	data_total      = ProtoField.uint32("udpft.data.total", "Total segments"),
+	data_size       = ProtoField.uint16("udpft.data.size", "Payload size"),
+	data_crc32      = ProtoField.uint32("udpft.data.crc32", "CRC32", base.HEX),
+	data_payload    = ProtoField.bytes("udpft.data.payload", "Payload"),
+
+	ctrl_type   = ProtoField.uint8("udpft.ctrl.type", "Type", base.DEC, ctrl_types),
+	ctrl_length = ProtoField.uint16("udpft.ctrl.length", "Payload length"),
+
+	req_flags        = ProtoField.uint8("udpft.req.flags", "Flags", base.HEX),
+	req_resume       = ProtoField.bool("udpft.req.flags.resume", "Resume", 8, nil, REQ_FLAG_RESUME),
+	req_max_datagram = ProtoField.uint16("udpft.req.max_datagram", "Max datagram"),
+	req_fec_data     = ProtoField.uint8("udpft.req.fec_data", "FEC data segments"),
+	req_fec_parity   = ProtoField.uint8("udpft.req.fec_parity", "FEC parity segments"),
+	req_codecs       = ProtoField.uint8("udpft.req.codecs", "Accepted codecs", base.HEX),
+	req_path         = ProtoField.string("udpft.req.path", "Path"),
+
+	meta_total           = ProtoField.uint32("udpft.meta.total", "Total segments"),
+	meta_size            = ProtoField.uint64("udpft.meta.size", "File size"),
+	meta_chunk           = ProtoField.uint16("udpft.meta.chunk", "Chunk size"),
+	meta_fec_data        = ProtoField.uint8("udpft.meta.fec_data", "FEC data segments"),
+	meta_fec_parity      = ProtoField.uint8("udpft.meta.fec_parity", "FEC parity segments"),
+	meta_codec           = ProtoField.uint8("udpft.meta.codec", "Codec", base.DEC, codecs),
+	meta_estimated_compressed_size = ProtoField.uint64("udpft.meta.estimated_compressed_size", "Estimated compressed size"),
+	meta_filename        = ProtoField.string("udpft.meta.filename", "File name"),
+	meta_sha256          = ProtoField.bytes("udpft.meta.sha256", "SHA-256"),
+
+	err_code        = ProtoField.uint16("udpft.err.code", "Error code", base.DEC, err_codes),
+	err_message     = ProtoField.string("udpft.err.message", "Message"),
+	err_retry_after = ProtoField.uint32("udpft.err.retry_after_ms", "Retry after (ms)"),
+
+	nack_count = ProtoField.uint16("udpft.nack.count", "Missing segments"),
+	nack_seq   = ProtoField.uint32("udpft.nack.seq", "Missing sequence"),
+
+	fbk_received  = ProtoField.uint32("udpft.fbk.received", "Received"),
+	fbk_lost      = ProtoField.uint32("udpft.fbk.lost", "Lost"),
+	fbk_highest   = ProtoField.uint32("udpft.fbk.highest", "Highest sequence"),
+	fbk_echo      = ProtoField.uint32("udpft.fbk.echo_seq", "Echoed sequence"),
+	fbk_delay     = ProtoField.uint32("udpft.fbk.delay_us", "Echo delay (us)"),
+	fbk_recovered = ProtoField.uint32("udpft.fbk.recovered", "FEC recovered"),
+
+	probe_size = ProtoField.uint16("udpft.probe.size", "Requested size"),
+
+	put_size         = ProtoField.uint64("udpft.put.size", "File size"),
+	put_max_datagram = ProtoField.uint16("udpft.put.max_datagram", "Max datagram"),
+	put_name         = ProtoField.string("udpft.put.name", "Name"),
+	put_sha256       = ProtoField.bytes("udpft.put.sha256", "SHA-256"),
+
+	done_status  = ProtoField.uint8("udpft.done.status", "Status", base.DEC, done_status),
+	done_message = ProtoField.string("udpft.done.message", "Message"),
+
+	list_flags     = ProtoField.uint8("udpft.list.flags", "Flags", base.HEX),
+	list_recursive = ProtoField.bool("udpft.list.flags.recursive", "Recursive", 8, nil, LIST_FLAG_RECURSIVE),
+	list_page_size = ProtoField.uint16("udpft.list.page_size", "Page size"),
+	list_path      = ProtoField.string("udpft.list.path", "Path"),
+	list_cursor    = ProtoField.string("udpft.list.cursor", "Cursor"),
+
+	lst_count  = ProtoField.uint16("udpft.lst.count", "Entries"),
+	lst_entry  = ProtoField.none("udpft.lst.entry", "Entry"),
+	lst_kind   = ProtoField.uint8("udpft.lst.kind", "Kind", base.DEC, entry_kinds),
+	lst_flags  = ProtoField.uint8("udpft.lst.flags", "Flags", base.HEX),
+	lst_size   = ProtoField.uint64("udpft.lst.size", "Size"),
+	lst_mtime  = ProtoField.uint64("udpft.lst.mtime_ns", "Modified (ns since epoch)"),
+	lst_name   = ProtoField.string("udpft.lst.name", "Name"),
+	lst_sha256 = ProtoField.bytes("udpft.lst.sha256", "SHA-256"),
+	lst_next   = ProtoField.string("udpft.lst.next", "Next cursor"),
+
+	hello_min_version = ProtoField.uint8("udpft.hello.min_version", "Min version"),
+	hello_max_version = ProtoField.uint8("udpft.hello.max_version", "Max version"),
+	hello_version     = ProtoField.uint8("udpft.hello.version", "Chosen version"),
+	hello_features    = ProtoField.uint32("udpft.hello.features", "Features", base.HEX),
+
+	env_type    = ProtoField.uint8("udpft.envelope.type", "Envelope type", base.DEC, envelope_types),
+	env_seq     = ProtoField.uint64("udpft.envelope.seq", "Envelope sequence"),
+	env_payload = ProtoField.bytes("udpft.envelope.payload", "Encrypted payload"),
+}
+
+local field_list = {}
+for _, f in pairs(F) do field_list[#field_list + 1] = f end
+udpft.fields = field_list
+
+-- informa se o bit mask está ligado em v (sem operadores de bits: Lua 5.1+)
+local function has_bit(v, mask)
+	return math.floor(v / mask) % 2 == 1
+end
+
+-- nomes dos bits ligados em v, conforme a tabela names (bit -> nome)
+local function bit_names(v, names)
+	local list = {}
+	for bit = 0, 31 do
+		local name = names[bit]
+		if name and has_bit(v, 2 ^ bit) then list[#list + 1] = name end
+	end
+	if #list == 0 then return "-" end
+	return table.concat(list, ",")
+end
+
+-- acrescenta um campo de texto de n bytes em off (nada se vazio)
+local function add_string(tree, field, tvb, off, n)
+	if n > 0 then tree:add(field, tvb(off, n)) end
+	if n > 0 then return tvb(off, n):string() end
+	return ""
+end
+
+-- decodificadores do payload de cada tipo de controle: recebem o payload
+-- em [off, off+len) e retornam o resumo para a coluna Info (nil = curto)
+local parsers = {}
+
+local function ctrl(name) for code, n in pairs(ctrl_types) do if n == name then return code end end end
+
+parsers[ctrl("REQ")] = function(tvb, tree, off, len, version)
+	local fixed = 9
+	if version >= COMPRESSION_VERSION then fixed = 10 end
+	if len < fixed then return nil end
+	tree:add(F.token, tvb(off, 4))
+	local flags = tree:add(F.req_flags, tvb(off + 4, 1))
+	flags:add(F.req_resume, tvb(off + 4, 1))
+	tree:add(F.req_max_datagram, tvb(off + 5, 2))
+	tree:add(F.req_fec_data, tvb(off + 7, 1))
+	tree:add(F.req_fec_parity, tvb(off + 8, 1))
+	if version >= COMPRESSION_VERSION then
+		tree:add(F.req_codecs, tvb(off + 9, 1)):append_text(" (" .. bit_names(tvb(off + 9, 1):uint(), codecs) .. ")")
+	end
+	local path = add_string(tree, F.req_path, tvb, off + fixed, len - fixed)
+	local info = string.format("token=%08x path=%q", tvb(off, 4):uint(), path)
+	if has_bit(tvb(off + 4, 1):uint(), REQ_FLAG_RESUME) then info = info .. " resume" end
+	return info
+end
+
+parsers[ctrl("META")] = function(tvb, tree, off, len, version)
+	local fixed = 24
+	if version >= COMPRESSION_VERSION then fixed = 33 end
+	if len < fixed + 2 + 32 then return nil end
+	local n = tvb(off + fixed, 2):uint()
+	if len < fixed + 2 + n + 32 then return nil end
+	tree:add(F.session, tvb(off, 4))
+	tree:add(F.token, tvb(off + 4, 4))
+	tree:add(F.meta_total, tvb(off + 8, 4))
+	tree:add(F.meta_size, tvb(off + 12, 8))
+	tree:add(F.meta_chunk, tvb(off + 20, 2))
+	tree:add(F.meta_fec_data, tvb(off + 22, 1))
+	tree:add(F.meta_fec_parity, tvb(off + 23, 1))
+	if version >= COMPRESSION_VERSION then
+		tree:add(F.meta_codec, tvb(off + 24, 1))
+		tree:add(F.meta_estimated_compressed_size, tvb(off + 25, 8))
+	end
+	local name = add_string(tree, F.meta_filename, tvb, off + fixed + 2, n)
+	tree:add(F.meta_sha256, tvb(off + fixed + 2 + n, 32))
+	return string.format("s=%08x token=%08x file=%q total=%d", tvb(off, 4):uint(), tvb(off + 4, 4):uint(), name, tvb(off + 8, 4):uint())
+end
+
+parsers[ctrl("ERR")] = function(tvb, tree, off, len)
+	if len < 8 then return nil end
+	local n = tvb(off + 6, 2):uint()
+	if len < 8 + n then return nil end
+	local code = tvb(off, 2):uint()
+	tree:add(F.err_code, tvb(off, 2))
+	tree:add(F.token, tvb(off + 2, 4))
+	local msg = add_string(tree, F.err_message, tvb, off + 8, n)
+	if len >= 8 + n + 4 then tree:add(F.err_retry_after, tvb(off + 8 + n, 4)) end
+	return string.format("token=%08x code=%d (%s) msg=%q", tvb(off + 2, 4):uint(), code, err_codes[code] or "?", msg)
+end
+
+parsers[ctrl("EOF")] = function(tvb, tree, off, len)
+	if len < 4 then return nil end
+	tree:add(F.session, tvb(off, 4))
+	return string.format("s=%08x", tvb(off, 4):uint())
+end
+
+parsers[ctrl("NACK")] = function(tvb, tree, off, len)
+	if len < 6 then return nil end
+	local count = tvb(off + 4, 2):uint()
+	if len < 6 + 4 * count then return nil end
+	tree:add(F.session, tvb(off, 4))
+	tree:add(F.nack_count, tvb(off + 4, 2))
+	for i = 0, count - 1 do tree:add(F.nack_seq, tvb(off + 6 + 4 * i, 4)) end
+	return string.format("s=%08x count=%d", tvb(off, 4):uint(), count)
+end
+
+parsers[ctrl("FBK")] = function(tvb, tree, off, len)
+	if len < 28 then return nil end
+	tree:add(F.session, tvb(off, 4))
+	tree:add(F.fbk_received, tvb(off + 4, 4))
+	tree:add(F.fbk_lost, tvb(off + 8, 4))
+	tree:add(F.fbk_highest, tvb(off + 12, 4))
+	tree:add(F.fbk_echo, tvb(off + 16, 4))
+	tree:add(F.fbk_delay, tvb(off + 20, 4))
+	tree:add(F.fbk_recovered, tvb(off + 24, 4))
+	return string.format("s=%08x received=%d lost=%d", tvb(off, 4):uint(), tvb(off + 4, 4):uint(), tvb(off + 8, 4):uint())
+end
+
+local function probe(tvb, tree, off, len)
+	if len < 6 then return nil end
+	tree:add(F.token, tvb(off, 4))
+	tree:add(F.probe_size, tvb(off + 4, 2))
+	return string.format("token=%08x size=%d", tvb(off, 4):uint(), tvb(off + 4, 2):uint())
+end
+parsers[ctrl("PROBE")] = probe
+parsers[ctrl("PROBEACK")] = probe
+
+parsers[ctrl("PUT")] = function(tvb, tree, off, len)
+	if len < 16 + 32 then return nil end
+	local n = tvb(off + 14, 2):uint()
+	if len < 16 + n + 32 then return nil end
+	tree:add(F.token, tvb(off, 4))
+	tree:add(F.put_size, tvb(off + 4, 8))
+	tree:add(F.put_max_datagram, tvb(off + 12, 2))
+	local name = add_string(tree, F.put_name, tvb, off + 16, n)
+	tree:add(F.put_sha256, tvb(off + 16 + n, 32))
+	return string.format("token=%08x name=%q", tvb(off, 4):uint(), name)
+end
+
+parsers[ctrl("DONE")] = function(tvb, tree, off, len)
+	if len < 7 then return nil end
+	local n = tvb(off + 5, 2):uint()
+	if len < 7 + n then return nil end
+	tree:add(F.session, tvb(off, 4))
+	tree:add(F.done_status, tvb(off + 4, 1))
+	add_string(tree, F.done_message, tvb, off + 7, n)
+	return string.format("s=%08x status=%s", tvb(off, 4):uint(), done_status[tvb(off + 4, 1):uint()] or "?")
+end
+
+parsers[ctrl("LIST")] = function(tvb, tree, off, len)
+	if len < 11 then return nil end
+	local n = tvb(off + 7, 2):uint()
+	if len < 11 + n then return nil end
+	local c = tvb(off + 9 + n, 2):uint()
+	if len < 11 + n + c then return nil end
+	tree:add(F.token, tvb(off, 4))
+	local flags = tree:add(F.list_flags, tvb(off + 4, 1))
+	flags:add(F.list_recursive, tvb(off + 4, 1))
+	tree:add(F.list_page_size, tvb(off + 5, 2))
+	local path = add_string(tree, F.list_path, tvb, off + 9, n)
+	add_string(tree, F.list_cursor, tvb, off + 11 + n, c)
+	return string.format("token=%08x path=%q", tvb(off, 4):uint(), path)
+end
+
+parsers[ctrl("LST")] = function(tvb, tree, off, len)
+	if len < 6 then return nil end
+	local count = tvb(off + 4, 2):uint()
+	tree:add(F.token, tvb(off, 4))
+	tree:add(F.lst_count, tvb(off + 4, 2))
+	local pos, stop = off + 6, off + len
+	for _ = 1, count do
+		if stop - pos < 20 then return nil end
+		local flags, n = tvb(pos + 1, 1):uint(), tvb(pos + 18, 2):uint()
+		local size = 20 + n
+		if has_bit(flags, LST_ENTRY_SHA) then size = size + 32 end
+		if stop - pos < size then return nil end
+		local entry = tree:add(F.lst_entry, tvb(pos, size))
+		entry:add(F.lst_kind, tvb(pos, 1))
+		entry:add(F.lst_flags, tvb(pos + 1, 1))
+		entry:add(F.lst_size, tvb(pos + 2, 8))
+		entry:add(F.lst_mtime, tvb(pos + 10, 8))
+		local name = add_string(entry, F.lst_name, tvb, pos + 20, n)
+		entry:append_text(": " .. name)
+		if has_bit(flags, LST_ENTRY_SHA) then entry:add(F.lst_sha256, tvb(pos + 20 + n, 32)) end
+		pos = pos + size
+	end
+	if stop - pos < 2 then return nil end
+	local n = tvb(pos, 2):uint()
+	if stop - pos < 2 + n then return nil end
+	add_string(tree, F.lst_next, tvb, pos + 2, n)
+	return string.format("token=%08x entries=%d", tvb(off, 4):uint(), count)
+end
+
+parsers[ctrl("HELLO")] = function(tvb, tree, off, len)
+	if len < 10 then return nil end
+	tree:add(F.token, tvb(off, 4))
+	tree:add(F.hello_min_version, tvb(off + 4, 1))
+	tree:add(F.hello_max_version, tvb(off + 5, 1))
+	local f = tvb(off + 6, 4):uint()
+	tree:add(F.hello_features, tvb(off + 6, 4)):append_text(" (" .. bit_names(f, features) .. ")")
+	return string.format("token=%08x versions=%d..%d features=%s", tvb(off, 4):uint(), tvb(off + 4, 1):uint(), tvb(off + 5, 1):uint(), bit_names(f, features))
+end
+
+parsers[ctrl("HELLOACK")] = function(tvb, tree, off, len)
+	if len < 9 then return nil end
+	tree:add(F.token, tvb(off, 4))
+	tree:add(F.hello_version, tvb(off + 4, 1))
+	local f = tvb(off + 5, 4):uint()
+	tree:add(F.hello_features, tvb(off + 5, 4)):append_text(" (" .. bit_names(f, features) .. ")")
+	return string.format("token=%08x version=%d features=%s", tvb(off, 4):uint(), tvb(off + 4, 1):uint(), bit_names(f, features))
+end
+
+-- layouts da v1 (LEGACY_VERSION), sem sessão nem token
+local legacy_parsers = {}
+
+legacy_parsers[ctrl("REQ")] = function(tvb, tree, off, len)
+	local path = add_string(tree, F.req_path, tvb, off, len)
+	return string.format("v1 path=%q", path)
+end
+
+legacy_parsers[ctrl("META")] = function(tvb, tree, off, len)
+	if len < 16 + 32 then return nil end
+	local n = tvb(off + 14, 2):uint()
+	if len < 16 + n + 32 then return nil end
+	tree:add(F.meta_total, tvb(off, 4))
+	tree:add(F.meta_size, tvb(off + 4, 8))
+	tree:add(F.meta_chunk, tvb(off + 12, 2))
+	local name = add_string(tree, F.meta_filename, tvb, off + 16, n)
+	tree:add(F.meta_sha256, tvb(off + 16 + n, 32))
+	return string.format("v1 file=%q total=%d", name, tvb(off, 4):uint())
+end
+
+legacy_parsers[ctrl("ERR")] = function(tvb, tree, off, len)
+	if len < 4 then return nil end
+	local n = tvb(off + 2, 2):uint()
+	if len < 4 + n then return nil end
+	local code = tvb(off, 2):uint()
+	tree:add(F.err_code, tvb(off, 2))
+	local msg = add_string(tree, F.err_message, tvb, off + 4, n)
+	return string.format("v1 code=%d (%s) msg=%q", code, err_codes[code] or "?", msg)
+end
+
+legacy_parsers[ctrl("EOF")] = function()
+	return "v1"
+end
+
+legacy_parsers[ctrl("NACK")] = function(tvb, tree, off, len)
+	if len < 2 then return nil end
+	local count = tvb(off, 2):uint()
+	if len < 2 + 4 * count then return nil end
+	tree:add(F.nack_count, tvb(off, 2))
+	for i = 0, count - 1 do tree:add(F.nack_seq, tvb(off + 2 + 4 * i, 4)) end
+	return string.format("v1 count=%d", count)
+end
+
+local function dissect_data(tvb, pinfo, tree)
+	local t = tree:add(udpft, tvb(0, tvb:len()), "UDP file transfer, DATA")
+	t:add(F.magic, tvb(0, 2))
+	t:add(F.version, tvb(2, 1))
+	local flags = t:add(F.data_flags, tvb(3, 1))
+	flags:add(F.data_parity, tvb(3, 1))
+	flags:add(F.data_compressed, tvb(3, 1))
+	t:add(F.session, tvb(4, 4))
+	t:add(F.data_seq, tvb(8, 4))
+	t:add(F.data_total, tvb(12, 4))
+	t:add(F.data_size, tvb(16, 2))
+	t:add(F.data_crc32, tvb(18, 4))
+	local size = tvb(16, 2):uint()
+	if size > 0 and tvb:len() >= DATA_HEADER_SIZE + size then t:add(F.data_payload, tvb(DATA_HEADER_SIZE, size)) end
+	local kind = "DATA"
+	if has_bit(tvb(3, 1):uint(), DATA_FLAG_PARITY) then kind = "PARITY" end
+	local info = string.format("%s s=%08x seq=%d total=%d size=%d", kind, tvb(4, 4):uint(), tvb(8, 4):uint(), tvb(12, 4):uint(), size)
+	if has_bit(tvb(3, 1):uint(), DATA_FLAG_COMPRESSED) then info = info .. " compressed" end
+	if tvb:len() < DATA_HEADER_SIZE + size then info = info .. " [truncated]" end
+	pinfo.cols.info = info
+	return tvb:len()
+end
+
+local function dissect_data_v1(tvb, pinfo, tree)
+	local t = tree:add(udpft, tvb(0, tvb:len()), "UDP file transfer, DATA v1")
+	t:add(F.magic, tvb(0, 2))
+	t:add(F.version, tvb(2, 1))
+	t:add(F.data_seq, tvb(4, 4))
+	t:add(F.data_total, tvb(8, 4))
+	t:add(F.data_size, tvb(12, 2))
+	t:add(F.data_crc32, tvb(14, 4))
+	local size = tvb(12, 2):uint()
+	if size > 0 and tvb:len() >= LEGACY_DATA_HEADER_SIZE + size then t:add(F.data_payload, tvb(LEGACY_DATA_HEADER_SIZE, size)) end
+	local info = string.format("DATA v1 seq=%d total=%d size=%d", tvb(4, 4):uint(), tvb(8, 4):uint(), size)
+	if tvb:len() < LEGACY_DATA_HEADER_SIZE + size then info = info .. " [truncated]" end
+	pinfo.cols.info = info
+	return tvb:len()
+end
+
+local function dissect_ctrl(tvb, pinfo, tree)
+	local version, typ, len = tvb(2, 1):uint(), tvb(3, 1):uint(), tvb(4, 2):uint()
+	local name = ctrl_types[typ]
+	local t = tree:add(udpft, tvb(0, tvb:len()), "UDP file transfer, " .. name)
+	t:add(F.magic, tvb(0, 2))
+	t:add(F.version, tvb(2, 1))
+	t:add(F.ctrl_type, tvb(3, 1))
+	t:add(F.ctrl_length, tvb(4, 2))
+	local info = name
+	if tvb:len() < CTRL_HEADER_SIZE + len then
+		info = info .. " [truncated]"
+	else
+		local parse = parsers[typ]
+		if version == LEGACY_VERSION then parse = legacy_parsers[typ] end
+		local summary = parse(tvb, t, CTRL_HEADER_SIZE, len, version)
+		if summary then info = info .. " " .. summary else info = info .. " [malformed]" end
+	end
+	t:append_text(": " .. info)
+	pinfo.cols.info = info
+	return tvb:len()
+end
+
+local function dissect_envelope(tvb, pinfo, tree)
+	local typ = tvb(3, 1):uint()
+	local t = tree:add(udpft, tvb(0, tvb:len()), "UDP file transfer, encrypted envelope")
+	t:add(F.magic, tvb(0, 2))
+	t:add(F.version, tvb(2, 1))
+	t:add(F.env_type, tvb(3, 1))
+	t:add(F.session, tvb(4, 4))
+	t:add(F.env_seq, tvb(8, 8))
+	if tvb:len() > ENVELOPE_HEADER_SIZE then t:add(F.env_payload, tvb(ENVELOPE_HEADER_SIZE, tvb:len() - ENVELOPE_HEADER_SIZE)) end
+	pinfo.cols.info = string.format("US %s s=%08x (encrypted)", envelope_types[typ] or "?", tvb(4, 4):uint())
+	return tvb:len()
+end
+
+-- escolhe o decodificador pelo magic, versão e tamanho mínimo (nil = não é
+-- um datagrama do protocolo)
+local function classify(tvb)
+	local n = tvb:len()
+	if n < 4 then return nil end
+	local magic, version = tvb(0, 2):string(), tvb(2, 1):uint()
+	if magic == "US" then
+		if version == ENVELOPE_VERSION and n >= ENVELOPE_HEADER_SIZE and envelope_types[tvb(3, 1):uint()] then return dissect_envelope end
+		return nil
+	end
+	if version < MIN_VERSION or version > MAX_VERSION then return nil end
+	if version == LEGACY_VERSION then
+		if magic == "UD" and n >= LEGACY_DATA_HEADER_SIZE then return dissect_data_v1 end
+		if magic == "UC" and n >= CTRL_HEADER_SIZE and legacy_parsers[tvb(3, 1):uint()] then return dissect_ctrl end
+		return nil
+	end
+	if magic == "UD" and n >= DATA_HEADER_SIZE then return dissect_data end
+	if magic == "UC" and n >= CTRL_HEADER_SIZE and ctrl_types[tvb(3, 1):uint()] then return dissect_ctrl end
+	return nil
+end
+
+function udpft.dissector(tvb, pinfo, tree)
+	local dissect = classify(tvb)
+	if not dissect then return 0 end
+	pinfo.cols.protocol = "UDPFT"
+	return dissect(tvb, pinfo, tree)
+end
+
+local function heuristic(tvb, pinfo, tree)
+	if not classify(tvb) then return false end
+	udpft.dissector(tvb, pinfo, tree)
+	return true
+end
+
+DissectorTable.get("udp.port"):add(PORT, udpft)
+udpft:register_heuristic("udp", heuristic)